@@ -84,11 +84,11 @@ func createLotsOfExperiments(workingDir string, repository repository.Repository
 			exp := project.NewExperiment(param.ValueMap{
 				"learning_rate": param.Float(0.001),
 			})
-			if err := exp.Save(repository); err != nil {
+			if err := exp.Save(context.Background(), repository); err != nil {
 				return fmt.Errorf("Error saving experiment: %w", err)
 			}
 
-			if err := project.CreateHeartbeat(repository, exp.ID, time.Now().Add(-24*time.Hour)); err != nil {
+			if err := project.CreateHeartbeat(context.Background(), repository, exp.ID, time.Now().Add(-24*time.Hour)); err != nil {
 				return fmt.Errorf("Error creating heartbeat: %w", err)
 			}
 
@@ -177,7 +177,7 @@ func BenchmarkReplicateS3(b *testing.B) {
 	require.NoError(b, err)
 
 	// Create repository
-	repository, err := repository.NewS3Repository(bucketName, "root")
+	repository, err := repository.NewS3Repository(bucketName, "root", repository.S3Options{})
 	require.NoError(b, err)
 
 	err = createLotsOfExperiments(workingDir, repository, 5)
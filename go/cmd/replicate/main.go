@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/replicate/replicate/go/pkg/cli"
 	"github.com/replicate/replicate/go/pkg/console"
 )
@@ -11,7 +16,20 @@ func main() {
 		console.Fatal("%s", err)
 	}
 
-	if err = cmd.Execute(); err != nil {
+	// Cancel in-flight repository operations on the first Ctrl-C so partial
+	// files are cleaned up. A second Ctrl-C kills the process as usual.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		console.Info("Interrupted, cleaning up...")
+		signal.Stop(signals)
+		cancel()
+	}()
+
+	if err = cmd.ExecuteContext(ctx); err != nil {
 		console.Fatal("%s", err)
 	}
 }
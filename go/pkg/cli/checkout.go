@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
@@ -29,7 +30,7 @@ func newCheckoutCommand() *cobra.Command {
 		Use:   "checkout <experiment or checkpoint ID>",
 		Short: "Copy files from an experiment or checkpoint into the project directory",
 		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
-			return checkoutCheckpoint(opts, args)
+			return checkoutCheckpoint(cmd.Context(), opts, args)
 		}),
 		Args: cobra.ExactArgs(1),
 	}
@@ -41,7 +42,7 @@ func newCheckoutCommand() *cobra.Command {
 	return cmd
 }
 
-func checkoutCheckpoint(opts checkoutOpts, args []string) error {
+func checkoutCheckpoint(ctx context.Context, opts checkoutOpts, args []string) error {
 	prefix := args[0]
 
 	outputDir := opts.outputDirectory
@@ -57,7 +58,7 @@ func checkoutCheckpoint(opts checkoutOpts, args []string) error {
 	if err != nil {
 		return err
 	}
-	repo, err := getRepository(repositoryURL, projectDir)
+	repo, err := getRepository(ctx, repositoryURL, projectDir)
 	if err != nil {
 		return err
 	}
@@ -81,7 +82,7 @@ func checkoutCheckpoint(opts checkoutOpts, args []string) error {
 	}
 
 	proj := project.NewProject(repo)
-	result, err := proj.CheckpointOrExperimentFromPrefix(prefix)
+	result, err := proj.CheckpointOrExperimentFromPrefix(ctx, prefix)
 	if err != nil {
 		return err
 	}
@@ -146,7 +147,7 @@ func checkoutCheckpoint(opts checkoutOpts, args []string) error {
 	experimentFilesExist := true
 	checkpointFilesExist := true
 
-	if err := repo.GetPathTar(path.Join("experiments", experiment.ID+".tar.gz"), outputDir); err != nil {
+	if err := repo.GetPathTar(ctx, path.Join("experiments", experiment.ID+".tar.gz"), outputDir); err != nil {
 		// Ignore does not exist errors
 		if _, ok := err.(*repository.DoesNotExistError); ok {
 			console.Debug("No experiment data found")
@@ -161,7 +162,7 @@ func checkoutCheckpoint(opts checkoutOpts, args []string) error {
 	// Overlay checkpoint on top of experiment
 	if checkpoint != nil {
 
-		if err := repo.GetPathTar(path.Join("checkpoints", checkpoint.ID+".tar.gz"), outputDir); err != nil {
+		if err := repo.GetPathTar(ctx, path.Join("checkpoints", checkpoint.ID+".tar.gz"), outputDir); err != nil {
 			if _, ok := err.(*repository.DoesNotExistError); ok {
 				console.Debug("No checkpoint data found")
 				checkpointFilesExist = false
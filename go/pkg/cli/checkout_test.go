@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path"
@@ -43,7 +44,7 @@ func TestCheckout(t *testing.T) {
 			},
 		},
 	}
-	require.NoError(t, experiment.Save(repo))
+	require.NoError(t, experiment.Save(context.Background(), repo))
 
 	codeDir, err := files.TempDir("test-checkout-code")
 	require.NoError(t, err)
@@ -55,10 +56,10 @@ func TestCheckout(t *testing.T) {
 	err = ioutil.WriteFile(path.Join(codeDir, rand2), []byte(rand2), 0644)
 	require.NoError(t, err)
 
-	err = repo.PutPathTar(codeDir, "experiments/1eeeeeeeee.tar.gz", rand1)
+	err = repo.PutPathTar(context.Background(), codeDir, "experiments/1eeeeeeeee.tar.gz", rand1)
 	require.NoError(t, err)
 
-	err = repo.PutPathTar(codeDir, "checkpoints/1ccccccccc.tar.gz", rand2)
+	err = repo.PutPathTar(context.Background(), codeDir, "checkpoints/1ccccccccc.tar.gz", rand2)
 	require.NoError(t, err)
 
 	outputDir, err := files.TempDir("test-checkout-output")
@@ -66,7 +67,7 @@ func TestCheckout(t *testing.T) {
 	defer os.RemoveAll(outputDir)
 
 	// checkout to output directory
-	err = checkoutCheckpoint(checkoutOpts{
+	err = checkoutCheckpoint(context.Background(), checkoutOpts{
 		outputDirectory: outputDir,
 		force:           true,
 		repositoryURL:   "file://" + repoDir,
@@ -91,7 +92,7 @@ func TestCheckout(t *testing.T) {
 	defer func() { require.NoError(t, os.Chdir(cwd)) }()
 
 	// checkout to working directory without replicate.yaml
-	err = checkoutCheckpoint(checkoutOpts{
+	err = checkoutCheckpoint(context.Background(), checkoutOpts{
 		outputDirectory: "",
 		force:           true,
 		repositoryURL:   "file://" + repoDir,
@@ -103,7 +104,7 @@ func TestCheckout(t *testing.T) {
 	require.NoError(t, ioutil.WriteFile("replicate.yaml", []byte("repository: file://"+repoDir), 0644))
 
 	// checkout to working directory with replicate.yaml
-	err = checkoutCheckpoint(checkoutOpts{
+	err = checkoutCheckpoint(context.Background(), checkoutOpts{
 		outputDirectory: "",
 		force:           true,
 		repositoryURL:   "",
@@ -1,8 +1,10 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 
 	"github.com/logrusorgru/aurora"
@@ -10,6 +12,7 @@ import (
 
 	"github.com/replicate/replicate/go/pkg/config"
 	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/files"
 	"github.com/replicate/replicate/go/pkg/global"
 	"github.com/replicate/replicate/go/pkg/repository"
 )
@@ -70,11 +73,13 @@ func getProjectDir() (string, error) {
 
 // getRepository returns the project's repository, with caching if needed
 // This is not in repository package so we can do user interface stuff around syncing
-func getRepository(repositoryURL, projectDir string) (repository.Repository, error) {
+func getRepository(ctx context.Context, repositoryURL, projectDir string) (repository.Repository, error) {
 	repo, err := repository.ForURL(repositoryURL)
 	if err != nil {
 		return nil, err
 	}
+	// Records changes to metadata, so caches can fetch only what has changed
+	repo = repository.WithManifest(repo)
 	// projectDir might be "" if you use --repository option
 	if repository.NeedsCaching(repo) && projectDir != "" {
 		console.Info("Fetching new data from %q...", repo.RootURL())
@@ -83,11 +88,105 @@ func getRepository(repositoryURL, projectDir string) (repository.Repository, err
 			return nil, err
 		}
 		cachedRepo := repo.(*repository.CachedRepository)
-		if err := cachedRepo.SyncCache(); err != nil {
+		if err := cachedRepo.SyncCache(ctx); err != nil {
 			return nil, err
 		}
 	}
-	return repo, nil
+	// Wraps the cache, so the cache stays encrypted and can be synced without decrypting
+	return withEncryption(repo, repositoryURL, projectDir)
+}
+
+// getUncachedRepository returns the project's repository without a metadata cache, for
+// commands that need to see exactly what is in the repository
+func getUncachedRepository(repositoryURL, projectDir string) (repository.Repository, error) {
+	repo, err := repository.ForURL(repositoryURL)
+	if err != nil {
+		return nil, err
+	}
+	return withEncryption(repository.WithManifest(repo), repositoryURL, projectDir)
+}
+
+// withEncryption wraps repo in an EncryptedRepository if the project's replicate.yaml has
+// an encryption section for repositoryURL
+func withEncryption(repo repository.Repository, repositoryURL, projectDir string) (repository.Repository, error) {
+	encryption, err := getEncryptionConfig(repositoryURL, projectDir)
+	if err != nil {
+		return nil, err
+	}
+	if encryption == nil {
+		return repo, nil
+	}
+	key, err := repository.LoadEncryptionKey(repository.EncryptionOptions{
+		KeyEnv:    encryption.KeyEnv,
+		KeySecret: encryption.KeySecret,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repository.NewEncryptedRepository(repo, key)
+}
+
+// getEncryptionConfig returns the encryption section of replicate.yaml in projectDir, if
+// the project's repository is repositoryURL. If the project's repository is encrypted, but
+// repositoryURL is a different repository, it returns an error, rather than risk reading or
+// writing an encrypted repository without encryption.
+func getEncryptionConfig(repositoryURL, projectDir string) (*config.EncryptionConfig, error) {
+	if projectDir == "" {
+		return nil, nil
+	}
+	configPath := filepath.Join(projectDir, global.ConfigFilename)
+	exists, err := files.FileExists(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	conf, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Encryption == nil {
+		return nil, nil
+	}
+	same, err := sameRepository(conf.Repository, repositoryURL)
+	if err != nil {
+		return nil, err
+	}
+	if !same {
+		return nil, fmt.Errorf("%s isn't the repository in %s (%s), which is encrypted, so Replicate doesn't know how to encrypt it. To use it without encryption, run Replicate outside of %s.", repositoryURL, configPath, conf.Repository, projectDir)
+	}
+	return conf.Encryption, nil
+}
+
+// sameRepository returns true if two repository URLs point at the same repository, even
+// if they are written differently, e.g. with a trailing slash or with S3 options
+func sameRepository(url1, url2 string) (bool, error) {
+	scheme1, bucket1, root1, err := normalizedRepositoryURL(url1)
+	if err != nil {
+		return false, err
+	}
+	scheme2, bucket2, root2, err := normalizedRepositoryURL(url2)
+	if err != nil {
+		return false, err
+	}
+	return scheme1 == scheme2 && bucket1 == bucket2 && root1 == root2, nil
+}
+
+func normalizedRepositoryURL(repositoryURL string) (scheme repository.Scheme, bucket string, root string, err error) {
+	scheme, bucket, root, err = repository.SplitURL(repositoryURL)
+	if err != nil {
+		return "", "", "", err
+	}
+	if scheme == repository.SchemeDisk {
+		// Relative paths are relative to the working directory
+		root, err = filepath.Abs(root)
+		if err != nil {
+			return "", "", "", fmt.Errorf("Failed to determine absolute directory of '%s': %w", root, err)
+		}
+		return scheme, bucket, root, nil
+	}
+	return scheme, bucket, path.Clean("/" + root), nil
 }
 
 // handlErrors wraps a cobra function, and will print and exit on error
@@ -97,6 +196,10 @@ func getRepository(repositoryURL, projectDir string) (repository.Repository, err
 func handleErrors(f func(cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
 		if err := f(cmd, args); err != nil {
+			// Cancellation errors are wrapped in all sorts of ways, so just report it plainly
+			if cmd.Context().Err() != nil {
+				console.Fatal("Interrupted")
+			}
 			console.Fatal(err.Error())
 		}
 	}
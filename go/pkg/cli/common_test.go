@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/files"
+)
+
+func TestGetEncryptionConfig(t *testing.T) {
+	projectDir, err := files.TempDir("test-encryption-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(projectDir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(projectDir, "replicate.yaml"), []byte(`
+repository: s3://my-bucket/root
+s3:
+  region: us-west-2
+encryption:
+  key_env: REPLICATE_KEY
+`), 0644))
+
+	// The same repository, however it is written
+	for _, url := range []string{
+		"s3://my-bucket/root?region=us-west-2",
+		"s3://my-bucket/root",
+		"s3://my-bucket/root/",
+		"s3://my-bucket//root?region=us-west-2&force_path_style=true",
+	} {
+		encryption, err := getEncryptionConfig(url, projectDir)
+		require.NoError(t, err, url)
+		require.NotNil(t, encryption, url)
+		require.Equal(t, "REPLICATE_KEY", encryption.KeyEnv)
+	}
+
+	// Other repositories aren't used without encryption by mistake
+	for _, url := range []string{
+		"s3://my-bucket/other",
+		"s3://my-bucket",
+		"s3://other-bucket/root",
+		"gs://my-bucket/root",
+	} {
+		_, err := getEncryptionConfig(url, projectDir)
+		require.Error(t, err, url)
+		require.Contains(t, err.Error(), "encrypted")
+	}
+
+	// Relative paths are relative to the working directory
+	require.NoError(t, ioutil.WriteFile(filepath.Join(projectDir, "replicate.yaml"), []byte(`
+repository: file://.replicate/
+encryption:
+  key_env: REPLICATE_KEY
+`), 0644))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	encryption, err := getEncryptionConfig("file://"+filepath.Join(wd, ".replicate"), projectDir)
+	require.NoError(t, err)
+	require.NotNil(t, encryption)
+
+	// Unencrypted projects can use any repository
+	require.NoError(t, ioutil.WriteFile(filepath.Join(projectDir, "replicate.yaml"), []byte("repository: s3://my-bucket/root"), 0644))
+	encryption, err = getEncryptionConfig("s3://other-bucket", projectDir)
+	require.NoError(t, err)
+	require.Nil(t, encryption)
+}
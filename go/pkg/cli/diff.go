@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -39,6 +40,7 @@ func diffCheckpoints(cmd *cobra.Command, args []string) error {
 	// TODO(bfirsh): it probably makes sense to refactor this to diff param.Values instead of strings at some point.
 	// that way we can do interesting stuff like diff JSON structures, using param.Value comparison methods, ShortString, etc.
 
+	ctx := cmd.Context()
 	prefix1 := args[0]
 	prefix2 := args[1]
 
@@ -46,13 +48,13 @@ func diffCheckpoints(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	repo, err := getRepository(repositoryURL, projectDir)
+	repo, err := getRepository(ctx, repositoryURL, projectDir)
 	if err != nil {
 		return err
 	}
 	proj := project.NewProject(repo)
 	au := getAurora()
-	return printDiff(os.Stdout, au, proj, prefix1, prefix2)
+	return printDiff(ctx, os.Stdout, au, proj, prefix1, prefix2)
 }
 
 // TODO: implement this as a thing in console
@@ -64,12 +66,12 @@ func heading(w *tabwriter.Writer, au aurora.Aurora, text string) {
 	fmt.Fprintf(w, "%s\t\t\n", au.Bold(text))
 }
 
-func printDiff(out io.Writer, au aurora.Aurora, proj *project.Project, prefix1 string, prefix2 string) error {
-	exp1, com1, err := loadCheckpoint(proj, prefix1)
+func printDiff(ctx context.Context, out io.Writer, au aurora.Aurora, proj *project.Project, prefix1 string, prefix2 string) error {
+	exp1, com1, err := loadCheckpoint(ctx, proj, prefix1)
 	if err != nil {
 		return err
 	}
-	exp2, com2, err := loadCheckpoint(proj, prefix2)
+	exp2, com2, err := loadCheckpoint(ctx, proj, prefix2)
 	if err != nil {
 		return err
 	}
@@ -173,8 +175,8 @@ func paramMapToStringMap(params param.ValueMap) map[string]string {
 // checkpoint, that is returned. If the prefix matches an experiment, it
 // returns the best checkpoint if a primary metric is defined in config,
 // otherwise the latest checkpoint.
-func loadCheckpoint(proj *project.Project, prefix string) (*project.Experiment, *project.Checkpoint, error) {
-	obj, err := proj.CheckpointOrExperimentFromPrefix(prefix)
+func loadCheckpoint(ctx context.Context, proj *project.Project, prefix string) (*project.Experiment, *project.Checkpoint, error) {
+	obj, err := proj.CheckpointOrExperimentFromPrefix(ctx, prefix)
 	if err != nil {
 		return nil, nil, err
 	}
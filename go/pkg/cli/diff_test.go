@@ -2,6 +2,7 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -25,7 +26,7 @@ func TestDiffSameExperiment(t *testing.T) {
 
 	au := aurora.NewAurora(false)
 	out := new(bytes.Buffer)
-	err = printDiff(out, au, proj, "1e", "3c")
+	err = printDiff(context.Background(), out, au, proj, "1e", "3c")
 	require.NoError(t, err)
 	actual := out.String()
 
@@ -63,7 +64,7 @@ func TestDiffDifferentExperiment(t *testing.T) {
 
 	au := aurora.NewAurora(false)
 	out := new(bytes.Buffer)
-	err = printDiff(out, au, proj, "1e", "4c")
+	err = printDiff(context.Background(), out, au, proj, "1e", "4c")
 	require.NoError(t, err)
 	actual := out.String()
 
@@ -0,0 +1,347 @@
+package cli
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/replicate/go/pkg/concurrency"
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+// Number of tarballs that are read at the same time
+const fsckWorkers = 8
+
+// Categories of problems, in the order they are reported
+const (
+	fsckInvalidMetadata    = "invalid_metadata"
+	fsckMissingTarball     = "missing_tarball"
+	fsckCorruptTarball     = "corrupt_tarball"
+	fsckOrphanedTarball    = "orphaned_tarball"
+	fsckOrphanedCheckpoint = "orphaned_checkpoint"
+	fsckOrphanedHeartbeat  = "orphaned_heartbeat"
+)
+
+var fsckCategories = []struct {
+	name  string
+	title string
+}{
+	{fsckInvalidMetadata, "Invalid metadata"},
+	{fsckMissingTarball, "Missing tarballs"},
+	{fsckCorruptTarball, "Corrupt tarballs"},
+	{fsckOrphanedTarball, "Tarballs without an experiment or checkpoint"},
+	{fsckOrphanedCheckpoint, "Checkpoints without an experiment"},
+	{fsckOrphanedHeartbeat, "Heartbeats without an experiment"},
+}
+
+type fsckProblem struct {
+	Category string `json:"category"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+type fsckReport struct {
+	Repository  string         `json:"repository"`
+	Experiments int            `json:"experiments"`
+	Checkpoints int            `json:"checkpoints"`
+	Tarballs    int            `json:"tarballs"`
+	Problems    []*fsckProblem `json:"problems"`
+}
+
+func (r *fsckReport) add(category, p, format string, v ...interface{}) {
+	r.Problems = append(r.Problems, &fsckProblem{
+		Category: category,
+		Path:     p,
+		Message:  fmt.Sprintf(format, v...),
+	})
+}
+
+type fsckOpts struct {
+	json          bool
+	repositoryURL string
+}
+
+func newFsckCommand() *cobra.Command {
+	var opts fsckOpts
+
+	cmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "Check the repository for missing, orphaned, and corrupt data",
+		Long: `Check the repository for missing, orphaned, and corrupt data.
+
+This reads every experiment, checkpoint, and heartbeat in the repository, and
+reports:
+
+- Metadata that can't be parsed
+- Experiments and checkpoints whose tarballs are missing
+- Tarballs that aren't readable gzipped tar files
+- Tarballs that don't belong to an experiment or checkpoint
+- Checkpoints and heartbeats that don't belong to an experiment
+
+It exits with a non-zero status if any problems are found. Experiments that are
+running might be reported as missing tarballs while they are being uploaded.
+
+If metadata has been lost, 'replicate repair' can rebuild what it can from the
+tarballs that don't belong to anything.
+`,
+		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
+			return fsckCommand(cmd.Context(), opts, os.Stdout)
+		}),
+		Args: cobra.NoArgs,
+	}
+
+	cmd.Flags().BoolVar(&opts.json, "json", false, "Print output in JSON format")
+	addRepositoryURLFlagVar(cmd, &opts.repositoryURL)
+
+	return cmd
+}
+
+func fsckCommand(ctx context.Context, opts fsckOpts, out io.Writer) error {
+	repositoryURL, projectDir, err := getRepositoryURLFromStringOrConfig(opts.repositoryURL)
+	if err != nil {
+		return err
+	}
+	// Not cached, so we see exactly what is in the repository
+	repo, err := getUncachedRepository(repositoryURL, projectDir)
+	if err != nil {
+		return err
+	}
+	console.Info("Checking %s...", repo.RootURL())
+	report, err := fsck(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printFsckReport(out, report)
+	}
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("Found %d problems in %s", len(report.Problems), repo.RootURL())
+	}
+	return nil
+}
+
+// fsck cross-references the metadata in repo against the files in it
+func fsck(ctx context.Context, repo repository.Repository) (*fsckReport, error) {
+	report := &fsckReport{
+		Repository: repo.RootURL(),
+		Problems:   []*fsckProblem{},
+	}
+
+	scan, err := scanRepository(ctx, repo, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tarballs that belong to an experiment or checkpoint
+	referenced := map[string]bool{}
+	// Experiments that have metadata, even if it is invalid, so their other files aren't
+	// reported as orphaned as well
+	experimentIDs := map[string]bool{}
+	// Checkpoints that have been counted, because they can be in their experiment's metadata
+	// and in their own file
+	checkpointIDs := map[string]bool{}
+
+	for _, p := range scan.experimentPaths {
+		id := strings.TrimSuffix(path.Base(p), ".json")
+		experimentIDs[id] = true
+		referenced[(&project.Experiment{ID: id}).StorageTarPath()] = true
+
+		data, err := repo.Get(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := project.ParseExperiment(p, data)
+		if err != nil {
+			report.add(fsckInvalidMetadata, p, "%s", err)
+			continue
+		}
+		if exp.ID != id {
+			report.add(fsckInvalidMetadata, p, "Experiment ID %q does not match the file name", exp.ID)
+			continue
+		}
+		report.Experiments++
+		// Experiments and checkpoints without a path don't save a tarball
+		if exp.Path != "" && !scan.hasTarball(exp.StorageTarPath()) {
+			report.add(fsckMissingTarball, exp.StorageTarPath(), "Experiment %s has no tarball", exp.ID)
+		}
+		for _, chk := range exp.Checkpoints {
+			checkpointIDs[chk.ID] = true
+			report.Checkpoints++
+			referenced[chk.StorageTarPath()] = true
+			if chk.Path != "" && !scan.hasTarball(chk.StorageTarPath()) {
+				report.add(fsckMissingTarball, chk.StorageTarPath(), "Checkpoint %s of experiment %s has no tarball", chk.ID, exp.ID)
+			}
+		}
+	}
+
+	for _, p := range scan.checkpointPaths {
+		id := strings.TrimSuffix(path.Base(p), ".json")
+		experimentID := path.Base(path.Dir(p))
+		referenced[(&project.Checkpoint{ID: id}).StorageTarPath()] = true
+
+		data, err := repo.Get(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		chk, err := project.ParseCheckpoint(p, data)
+		if err != nil {
+			report.add(fsckInvalidMetadata, p, "%s", err)
+			continue
+		}
+		if chk.ID != id {
+			report.add(fsckInvalidMetadata, p, "Checkpoint ID %q does not match the file name", chk.ID)
+			continue
+		}
+		if !experimentIDs[experimentID] {
+			report.add(fsckOrphanedCheckpoint, p, "Experiment %s does not exist", experimentID)
+			continue
+		}
+		if checkpointIDs[chk.ID] {
+			continue
+		}
+		checkpointIDs[chk.ID] = true
+		report.Checkpoints++
+		if chk.Path != "" && !scan.hasTarball(chk.StorageTarPath()) {
+			report.add(fsckMissingTarball, chk.StorageTarPath(), "Checkpoint %s of experiment %s has no tarball", chk.ID, experimentID)
+		}
+	}
+
+	for _, p := range scan.heartbeatPaths {
+		id := strings.TrimSuffix(path.Base(p), ".json")
+		data, err := repo.Get(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := project.ParseHeartbeat(p, data); err != nil {
+			report.add(fsckInvalidMetadata, p, "%s", err)
+			continue
+		}
+		if !experimentIDs[id] {
+			report.add(fsckOrphanedHeartbeat, p, "Experiment %s does not exist", id)
+		}
+	}
+
+	tarballPaths := []string{}
+	for p := range scan.tarballs {
+		tarballPaths = append(tarballPaths, p)
+		if !referenced[p] {
+			report.add(fsckOrphanedTarball, p, "No experiment or checkpoint has this tarball")
+		}
+	}
+	sort.Strings(tarballPaths)
+	if err := checkTarballs(ctx, repo, tarballPaths, report); err != nil {
+		return nil, err
+	}
+
+	order := map[string]int{}
+	for i, category := range fsckCategories {
+		order[category.name] = i
+	}
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		a, b := report.Problems[i], report.Problems[j]
+		if a.Category != b.Category {
+			return order[a.Category] < order[b.Category]
+		}
+		return a.Path < b.Path
+	})
+	return report, nil
+}
+
+// checkTarballs reads every tarball in tarballPaths, adding a problem to report for each one
+// that can't be read
+func checkTarballs(ctx context.Context, repo repository.Repository, tarballPaths []string, report *fsckReport) error {
+	var lock sync.Mutex
+	queue := concurrency.NewWorkerQueue(ctx, fsckWorkers)
+	for _, p := range tarballPaths {
+		// Variables used in closure
+		p := p
+		err := queue.Go(func() error {
+			reader, err := repo.GetReader(queue.Context(), p)
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+			checkErr := checkTarball(reader)
+
+			lock.Lock()
+			defer lock.Unlock()
+			report.Tarballs++
+			if checkErr != nil {
+				report.add(fsckCorruptTarball, p, "%s", checkErr)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return queue.Wait()
+}
+
+// checkTarball returns an error if reader is not a valid gzipped tar file
+func checkTarball(reader io.Reader) error {
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return fmt.Errorf("Not a gzip file: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to read tar file: %w", err)
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return fmt.Errorf("Failed to read tar file: %w", err)
+		}
+	}
+	// Read to the end so the gzip checksum is verified
+	if _, err := io.Copy(ioutil.Discard, gz); err != nil {
+		return fmt.Errorf("Failed to read gzip file: %w", err)
+	}
+	return nil
+}
+
+func printFsckReport(out io.Writer, report *fsckReport) {
+	fmt.Fprintf(out, "Checked %d experiments, %d checkpoints, and %d tarballs in %s\n", report.Experiments, report.Checkpoints, report.Tarballs, report.Repository)
+	for _, category := range fsckCategories {
+		problems := []*fsckProblem{}
+		for _, problem := range report.Problems {
+			if problem.Category == category.name {
+				problems = append(problems, problem)
+			}
+		}
+		if len(problems) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n%s (%d):\n", category.title, len(problems))
+		for _, problem := range problems {
+			fmt.Fprintf(out, "  %s: %s\n", problem.Path, problem.Message)
+		}
+	}
+	if len(report.Problems) == 0 {
+		fmt.Fprintln(out, "\nNo problems found.")
+	}
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestFsck(t *testing.T) {
+	repoDir, err := files.TempDir("test-fsck")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+	sourceDir, err := files.TempDir("test-fsck-source")
+	require.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	require.NoError(t, ioutil.WriteFile(path.Join(sourceDir, "weights"), []byte("1.2kg"), 0644))
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+
+	// A healthy experiment, with a checkpoint without a path
+	exp := &project.Experiment{
+		ID:      "1eeeeeeeee",
+		Created: time.Now(),
+		Path:    ".",
+		Checkpoints: []*project.Checkpoint{
+			{ID: "1ccccccccc", Created: time.Now(), Path: "."},
+			{ID: "1ddddddddd", Created: time.Now()},
+		},
+	}
+	require.NoError(t, exp.Save(ctx, repo))
+	require.NoError(t, repo.PutPathTar(ctx, sourceDir, exp.StorageTarPath(), ""))
+	require.NoError(t, repo.PutPathTar(ctx, sourceDir, exp.Checkpoints[0].StorageTarPath(), ""))
+	require.NoError(t, project.CreateHeartbeat(ctx, repo, exp.ID, time.Now()))
+
+	report, err := fsck(ctx, repo)
+	require.NoError(t, err)
+	require.Empty(t, report.Problems)
+	require.Equal(t, 1, report.Experiments)
+	require.Equal(t, 2, report.Checkpoints)
+	require.Equal(t, 2, report.Tarballs)
+
+	// An experiment whose checkpoint tarball is missing and whose tarball is corrupt
+	exp2 := &project.Experiment{
+		ID:          "2eeeeeeeee",
+		Created:     time.Now(),
+		Path:        ".",
+		Checkpoints: []*project.Checkpoint{{ID: "2ccccccccc", Created: time.Now(), Path: "."}},
+	}
+	require.NoError(t, exp2.Save(ctx, repo))
+	require.NoError(t, repo.Put(ctx, exp2.StorageTarPath(), []byte("not a tarball")))
+	// Orphaned tarball and heartbeat
+	require.NoError(t, repo.PutPathTar(ctx, sourceDir, "checkpoints/3ccccccccc.tar.gz", ""))
+	require.NoError(t, project.CreateHeartbeat(ctx, repo, "3eeeeeeeee", time.Now()))
+	// Invalid metadata
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/4eeeeeeeee.json", []byte("{")))
+
+	report, err = fsck(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, []*fsckProblem{
+		{fsckInvalidMetadata, "metadata/experiments/4eeeeeeeee.json", "Parse error: unexpected end of JSON input"},
+		{fsckMissingTarball, "checkpoints/2ccccccccc.tar.gz", "Checkpoint 2ccccccccc of experiment 2eeeeeeeee has no tarball"},
+		{fsckCorruptTarball, "experiments/2eeeeeeeee.tar.gz", "Not a gzip file: gzip: invalid header"},
+		{fsckOrphanedTarball, "checkpoints/3ccccccccc.tar.gz", "No experiment or checkpoint has this tarball"},
+		{fsckOrphanedHeartbeat, "metadata/heartbeats/3eeeeeeeee.json", "Experiment 3eeeeeeeee does not exist"},
+	}, report.Problems)
+
+	out := new(bytes.Buffer)
+	printFsckReport(out, report)
+	require.Contains(t, out.String(), `Checked 2 experiments, 3 checkpoints, and 4 tarballs in file://`)
+	require.Contains(t, out.String(), `
+Missing tarballs (1):
+  checkpoints/2ccccccccc.tar.gz: Checkpoint 2ccccccccc of experiment 2eeeeeeeee has no tarball
+`)
+
+	// Checkpoints saved in their own files are counted once, even if they are in their
+	// experiment's metadata too
+	require.NoError(t, project.SaveCheckpoint(ctx, repo, exp.ID, exp.Checkpoints[1]))
+	require.NoError(t, project.SaveCheckpoint(ctx, repo, exp.ID, &project.Checkpoint{ID: "1fffffffff", Created: time.Now(), Path: "."}))
+	require.NoError(t, project.SaveCheckpoint(ctx, repo, "5eeeeeeeee", &project.Checkpoint{ID: "5ccccccccc", Created: time.Now()}))
+	report, err = fsck(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Checkpoints)
+	require.Contains(t, report.Problems, &fsckProblem{fsckMissingTarball, "checkpoints/1fffffffff.tar.gz", "Checkpoint 1fffffffff of experiment 1eeeeeeeee has no tarball"})
+	require.Contains(t, report.Problems, &fsckProblem{fsckOrphanedCheckpoint, "metadata/checkpoints/5eeeeeeeee/5ccccccccc.json", "Experiment 5eeeeeeeee does not exist"})
+}
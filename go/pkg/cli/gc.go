@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+type gcOpts struct {
+	dryRun        bool
+	gracePeriod   time.Duration
+	repositoryURL string
+}
+
+func newGCCommand() *cobra.Command {
+	var opts gcOpts
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete tarballs that don't belong to an experiment or checkpoint",
+		Long: `Delete tarballs that don't belong to an experiment or checkpoint.
+
+Tarballs can be left behind in the repository if deleting an experiment fails,
+or if a training script crashes. This finds every tarball under experiments/
+and checkpoints/ that isn't referenced by any experiment's metadata, and
+deletes it.
+
+Tarballs that were modified within the grace period are kept, because they
+might belong to an experiment that is being saved.
+
+If tarballs don't belong to anything because their metadata was lost, run
+'replicate repair' first to rebuild it.
+`,
+		Example: `See what would be deleted, without deleting anything:
+replicate gc --dry-run`,
+		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
+			return gc(cmd.Context(), opts, os.Stdout)
+		}),
+		Args: cobra.NoArgs,
+	}
+
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Print what would be deleted, without deleting anything")
+	cmd.Flags().DurationVar(&opts.gracePeriod, "grace-period", 24*time.Hour, "Keep tarballs that were modified more recently than this")
+	addRepositoryURLFlagVar(cmd, &opts.repositoryURL)
+
+	return cmd
+}
+
+func gc(ctx context.Context, opts gcOpts, out io.Writer) error {
+	repositoryURL, projectDir, err := getRepositoryURLFromStringOrConfig(opts.repositoryURL)
+	if err != nil {
+		return err
+	}
+	// Not cached, so every experiment that is in the repository is seen
+	repo, err := getUncachedRepository(repositoryURL, projectDir)
+	if err != nil {
+		return err
+	}
+	console.Info("Finding unreferenced tarballs in %s...", repo.RootURL())
+	orphans, tooNew, err := findOrphanedTarballs(ctx, repo, time.Now().Add(-opts.gracePeriod))
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	failed := 0
+	for _, orphan := range orphans {
+		if opts.dryRun {
+			fmt.Fprintf(out, "Would delete %s (%s)\n", orphan.Path, formatBytes(orphan.Size))
+			size += orphan.Size
+			continue
+		}
+		fmt.Fprintf(out, "Deleting %s (%s)\n", orphan.Path, formatBytes(orphan.Size))
+		if err := repo.Delete(ctx, orphan.Path); err != nil {
+			console.Warn("Failed to delete %s: %s", orphan.Path, err)
+			failed++
+			continue
+		}
+		size += orphan.Size
+	}
+
+	if tooNew > 0 {
+		console.Info("Kept %d unreferenced tarballs modified in the last %s", tooNew, opts.gracePeriod)
+	}
+	if opts.dryRun {
+		console.Info("Would delete %d tarballs, reclaiming %s", len(orphans), formatBytes(size))
+		return nil
+	}
+	console.Info("Deleted %d tarballs, reclaiming %s", len(orphans)-failed, formatBytes(size))
+	if failed > 0 {
+		return fmt.Errorf("Failed to delete %d tarballs", failed)
+	}
+	return nil
+}
+
+// findOrphanedTarballs returns the tarballs in repo that don't belong to an experiment or
+// checkpoint and were last modified before `before`, and the number of tarballs that don't
+// belong to anything but were modified after `before`
+func findOrphanedTarballs(ctx context.Context, repo repository.Repository, before time.Time) (orphans []repository.ListResult, tooNew int, err error) {
+	scan, err := scanRepository(ctx, repo, true)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	referenced := map[string]bool{}
+	for _, p := range scan.experimentPaths {
+		data, err := repo.Get(ctx, p)
+		if err != nil {
+			return nil, 0, err
+		}
+		exp, err := project.ParseExperiment(p, data)
+		if err != nil {
+			// Deleting anything could delete this experiment's checkpoints
+			return nil, 0, fmt.Errorf("Failed to parse %s, so it isn't possible to tell which tarballs are referenced: %s\n\nFix or delete it, then run this command again. 'replicate fsck' will find any other problems.", p, err)
+		}
+		id := strings.TrimSuffix(path.Base(p), ".json")
+		referenced[(&project.Experiment{ID: id}).StorageTarPath()] = true
+		referenced[exp.StorageTarPath()] = true
+		for _, chk := range exp.Checkpoints {
+			referenced[chk.StorageTarPath()] = true
+		}
+	}
+	// Checkpoints saved in their own files are named after their ID, so they don't need to be read
+	for _, p := range scan.checkpointPaths {
+		id := strings.TrimSuffix(path.Base(p), ".json")
+		referenced[(&project.Checkpoint{ID: id}).StorageTarPath()] = true
+	}
+
+	orphans = []repository.ListResult{}
+	for p, result := range scan.tarballs {
+		if referenced[p] {
+			continue
+		}
+		// Repositories that don't know modification times are treated as if everything is new
+		if result.MTime.IsZero() || result.MTime.After(before) {
+			tooNew++
+			continue
+		}
+		orphans = append(orphans, result)
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Path < orphans[j].Path
+	})
+	return orphans, tooNew, nil
+}
+
+// formatBytes formats a number of bytes with SI units, e.g. 1.5 MB
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
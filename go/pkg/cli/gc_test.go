@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestGC(t *testing.T) {
+	repoDir, err := files.TempDir("test-gc")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+
+	exp := &project.Experiment{
+		ID:          "1eeeeeeeee",
+		Created:     time.Now(),
+		Checkpoints: []*project.Checkpoint{{ID: "1ccccccccc", Created: time.Now()}},
+	}
+	require.NoError(t, exp.Save(ctx, repo))
+	old := time.Now().Add(-48 * time.Hour)
+	for _, p := range []string{
+		"experiments/1eeeeeeeee.tar.gz",
+		"checkpoints/1ccccccccc.tar.gz",
+		"experiments/2eeeeeeeee.tar.gz",
+		"checkpoints/2ccccccccc.tar.gz",
+		"checkpoints/3ccccccccc.tar.gz",
+	} {
+		require.NoError(t, repo.Put(ctx, p, []byte("tarball")))
+		require.NoError(t, os.Chtimes(path.Join(repoDir, p), old, old))
+	}
+	// Referenced by a checkpoint saved in its own file
+	require.NoError(t, project.SaveCheckpoint(ctx, repo, exp.ID, &project.Checkpoint{ID: "5ccccccccc", Created: time.Now()}))
+	require.NoError(t, repo.Put(ctx, "checkpoints/5ccccccccc.tar.gz", []byte("tarball")))
+	require.NoError(t, os.Chtimes(path.Join(repoDir, "checkpoints/5ccccccccc.tar.gz"), old, old))
+	// Recent, so might be being saved
+	require.NoError(t, repo.Put(ctx, "checkpoints/4ccccccccc.tar.gz", []byte("tarball")))
+
+	opts := gcOpts{
+		dryRun:        true,
+		gracePeriod:   24 * time.Hour,
+		repositoryURL: "file://" + repoDir,
+	}
+	out := new(bytes.Buffer)
+	require.NoError(t, gc(ctx, opts, out))
+	require.Equal(t, `Would delete checkpoints/2ccccccccc.tar.gz (7 B)
+Would delete checkpoints/3ccccccccc.tar.gz (7 B)
+Would delete experiments/2eeeeeeeee.tar.gz (7 B)
+`, out.String())
+	exists, err := files.FileExists(path.Join(repoDir, "checkpoints/2ccccccccc.tar.gz"))
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	opts.dryRun = false
+	out = new(bytes.Buffer)
+	require.NoError(t, gc(ctx, opts, out))
+	paths, err := repo.List(ctx, "checkpoints")
+	require.NoError(t, err)
+	require.Equal(t, []string{"checkpoints/1ccccccccc.tar.gz", "checkpoints/4ccccccccc.tar.gz", "checkpoints/5ccccccccc.tar.gz"}, paths)
+	paths, err = repo.List(ctx, "experiments")
+	require.NoError(t, err)
+	require.Equal(t, []string{"experiments/1eeeeeeeee.tar.gz"}, paths)
+
+	// Nothing is deleted if it can't be told what is referenced
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/5eeeeeeeee.json", []byte("{")))
+	err = gc(ctx, opts, new(bytes.Buffer))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "metadata/experiments/5eeeeeeeee.json")
+}
+
+func TestFormatBytes(t *testing.T) {
+	require.Equal(t, "0 B", formatBytes(0))
+	require.Equal(t, "999 B", formatBytes(999))
+	require.Equal(t, "1.0 kB", formatBytes(1000))
+	require.Equal(t, "1.5 MB", formatBytes(1500000))
+	require.Equal(t, "2.0 GB", formatBytes(2000000000))
+}
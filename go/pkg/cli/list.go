@@ -26,8 +26,12 @@ List experiments where the parameter "optimizer" is "adam" and
 the best "accuracy" metric is greater than 0.8:
 $ replicate ls --filter "optimizer = adam" --filter "accuracy > 0.8"
 
-Sort all stopped experiments by the metric "val_loss":
-$ replicate ls --sort "val_loss" --filter "status = stopped"
+Sort all finished experiments by the metric "val_loss":
+$ replicate ls --sort "val_loss" --filter "status = finished"
+
+List experiments that crashed (the status is "running", "finished",
+"crashed", or "unknown" if the experiment stopped without recording why):
+$ replicate ls --filter "status = crashed"
 `,
 	}
 
@@ -56,11 +60,11 @@ func listExperiments(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	repo, err := getRepository(repositoryURL, projectDir)
+	repo, err := getRepository(cmd.Context(), repositoryURL, projectDir)
 	if err != nil {
 		return err
 	}
-	return list.Experiments(repo, format, all, filters, sortKey)
+	return list.Experiments(cmd.Context(), repo, format, all, filters, sortKey)
 }
 
 func addListFormatFlags(cmd *cobra.Command) {
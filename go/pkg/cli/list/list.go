@@ -1,6 +1,7 @@
 package list
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -30,16 +31,16 @@ const valueMaxLength = 20
 const valueTruncate = 5
 
 type ListExperiment struct {
-	ID               string              `json:"id"`
-	Created          time.Time           `json:"created"`
-	Params           param.ValueMap      `json:"params"`
-	Command          string              `json:"command"`
-	NumCheckpoints   int                 `json:"num_checkpoints"`
-	LatestCheckpoint *project.Checkpoint `json:"latest_checkpoint"`
-	BestCheckpoint   *project.Checkpoint `json:"best_checkpoint"`
-	User             string              `json:"user"`
-	Host             string              `json:"host"`
-	Running          bool                `json:"running"`
+	ID               string                   `json:"id"`
+	Created          time.Time                `json:"created"`
+	Params           param.ValueMap           `json:"params"`
+	Command          string                   `json:"command"`
+	NumCheckpoints   int                      `json:"num_checkpoints"`
+	LatestCheckpoint *project.Checkpoint      `json:"latest_checkpoint"`
+	BestCheckpoint   *project.Checkpoint      `json:"best_checkpoint"`
+	User             string                   `json:"user"`
+	Host             string                   `json:"host"`
+	Status           project.ExperimentStatus `json:"status"`
 
 	// exclude config from json output
 	Config *config.Config `json:"-"`
@@ -67,10 +68,7 @@ func (exp *ListExperiment) GetValue(name string) param.Value {
 		return param.String(exp.Command)
 	}
 	if name == "status" {
-		if exp.Running {
-			return param.String("running")
-		}
-		return param.String("stopped")
+		return param.String(string(exp.Status))
 	}
 	if exp.BestCheckpoint != nil {
 		if val, ok := exp.BestCheckpoint.Metrics[name]; ok {
@@ -83,9 +81,9 @@ func (exp *ListExperiment) GetValue(name string) param.Value {
 	return param.None()
 }
 
-func Experiments(repo repository.Repository, format Format, all bool, filters *param.Filters, sorter *param.Sorter) error {
+func Experiments(ctx context.Context, repo repository.Repository, format Format, all bool, filters *param.Filters, sorter *param.Sorter) error {
 	proj := project.NewProject(repo)
-	listExperiments, err := createListExperiments(proj, filters)
+	listExperiments, err := createListExperiments(ctx, proj, filters)
 	if err != nil {
 		return err
 	}
@@ -118,9 +116,9 @@ func outputJSON(experiments []*ListExperiment) error {
 }
 
 // output something like (TODO: this is getting very wide)
-// experiment  started             status   host      user     param-1  latest   step  metric-1  best     step  metric-1
-// 1eeeeee     10 seconds ago      running  10.1.1.1  andreas  100      3cccccc  20    0.02     2cccccc  20    0.01
-// 2eeeeee     about a second ago  stopped  10.1.1.2  andreas  200      4cccccc  5              N/A
+// experiment  started             status    host      user     param-1  latest   step  metric-1  best     step  metric-1
+// 1eeeeee     10 seconds ago      running   10.1.1.1  andreas  100      3cccccc  20    0.02     2cccccc  20    0.01
+// 2eeeeee     about a second ago  finished  10.1.1.2  andreas  200      4cccccc  5              N/A
 func outputTable(experiments []*ListExperiment, all bool) error {
 	if len(experiments) == 0 {
 		fmt.Println("No experiments found")
@@ -185,11 +183,7 @@ func outputTable(experiments []*ListExperiment, all bool) error {
 		fmt.Fprintf(tw, "%s\t", console.FormatTime(exp.Created))
 
 		// status
-		if exp.Running {
-			fmt.Fprint(tw, "running\t")
-		} else {
-			fmt.Fprint(tw, "stopped\t")
-		}
+		fmt.Fprintf(tw, "%s\t", exp.Status)
 
 		if displayHost {
 			fmt.Fprintf(tw, "%s\t", exp.Host)
@@ -323,8 +317,22 @@ func getMetricsToDisplay(experiments []*ListExperiment, all bool) []string {
 	return slices.StringKeys(metricsToDisplay)
 }
 
-func createListExperiments(proj *project.Project, filters *param.Filters) ([]*ListExperiment, error) {
-	experiments, err := proj.Experiments()
+// MatchingExperimentIDs returns the IDs of the experiments in proj that match filters,
+// oldest first
+func MatchingExperimentIDs(ctx context.Context, proj *project.Project, filters *param.Filters) ([]string, error) {
+	listExperiments, err := createListExperiments(ctx, proj, filters)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, exp := range listExperiments {
+		ids = append(ids, exp.ID)
+	}
+	return ids, nil
+}
+
+func createListExperiments(ctx context.Context, proj *project.Project, filters *param.Filters) ([]*ListExperiment, error) {
+	experiments, err := proj.ExperimentSummaries(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -339,14 +347,14 @@ func createListExperiments(proj *project.Project, filters *param.Filters) ([]*Li
 			User:    exp.User,
 			Config:  exp.Config,
 		}
-		running, err := proj.ExperimentIsRunning(exp.ID)
+		status, err := proj.ExperimentStatus(ctx, exp.ID)
 		if err != nil {
 			return nil, err
 		}
-		listExperiment.LatestCheckpoint = exp.LatestCheckpoint()
-		listExperiment.BestCheckpoint = exp.BestCheckpoint()
-		listExperiment.NumCheckpoints = len(exp.Checkpoints)
-		listExperiment.Running = running
+		listExperiment.LatestCheckpoint = exp.LatestCheckpoint
+		listExperiment.BestCheckpoint = exp.BestCheckpoint
+		listExperiment.NumCheckpoints = len(exp.CheckpointIDs)
+		listExperiment.Status = status
 
 		match, err := filters.Matches(listExperiment)
 		if err != nil {
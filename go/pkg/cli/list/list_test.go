@@ -1,6 +1,7 @@
 package list
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -116,11 +117,16 @@ func createTestData(t *testing.T, workingDir string, conf *config.Config) reposi
 		Config: conf,
 	}}
 	for _, exp := range experiments {
-		require.NoError(t, exp.Save(repo))
+		require.NoError(t, exp.Save(context.Background(), repo))
 	}
 
-	require.NoError(t, project.CreateHeartbeat(repo, experiments[0].ID, time.Now().UTC()))
-	require.NoError(t, project.CreateHeartbeat(repo, experiments[1].ID, time.Now().UTC().Add(-1*time.Minute)))
+	require.NoError(t, project.CreateHeartbeat(context.Background(), repo, experiments[0].ID, time.Now().UTC()))
+	finished := &project.Heartbeat{
+		ExperimentID:  experiments[1].ID,
+		LastHeartbeat: time.Now().UTC().Add(-1 * time.Minute),
+		State:         project.StatusFinished,
+	}
+	require.NoError(t, finished.Save(context.Background(), repo))
 
 	return repo
 }
@@ -135,14 +141,14 @@ func TestListOutputTableWithPrimaryMetricOnlyChangedParams(t *testing.T) {
 	repo := createTestData(t, workingDir, conf)
 
 	actual := capturer.CaptureStdout(func() {
-		err = Experiments(repo, FormatTable, false, new(param.Filters), &param.Sorter{Key: "started"})
+		err = Experiments(context.Background(), repo, FormatTable, false, new(param.Filters), &param.Sorter{Key: "started"})
 	})
 	require.NoError(t, err)
 	expected := `
-EXPERIMENT  STARTED             STATUS   HOST      USER     PARAM-1  LATEST CHECKPOINT  METRIC-1  BEST CHECKPOINT    METRIC-1
-3eeeeee     2 minutes ago       stopped  10.1.1.2  ben      200
-2eeeeee     about a minute ago  stopped  10.1.1.2  andreas  200      4cccccc (step 5)
-1eeeeee     about a second ago  running  10.1.1.1  andreas  100      3cccccc (step 20)  0.02      2cccccc (step 20)  0.01
+EXPERIMENT  STARTED             STATUS    HOST      USER     PARAM-1  LATEST CHECKPOINT  METRIC-1  BEST CHECKPOINT    METRIC-1
+3eeeeee     2 minutes ago       unknown   10.1.1.2  ben      200
+2eeeeee     about a minute ago  finished  10.1.1.2  andreas  200      4cccccc (step 5)
+1eeeeee     about a second ago  running   10.1.1.1  andreas  100      3cccccc (step 20)  0.02      2cccccc (step 20)  0.01
 `
 	expected = expected[1:] // strip initial whitespace, added for readability
 	actual = testutil.TrimRightLines(actual)
@@ -158,14 +164,14 @@ func TestListOutputTableWithPrimaryMetricAll(t *testing.T) {
 	repo := createTestData(t, workingDir, conf)
 
 	actual := capturer.CaptureStdout(func() {
-		err = Experiments(repo, FormatTable, true, new(param.Filters), &param.Sorter{Key: "started"})
+		err = Experiments(context.Background(), repo, FormatTable, true, new(param.Filters), &param.Sorter{Key: "started"})
 	})
 	require.NoError(t, err)
 	expected := `
-EXPERIMENT  STARTED             STATUS   HOST      USER     PARAM-1  PARAM-2  PARAM-3  PARAM-4  LATEST CHECKPOINT  METRIC-1  METRIC-2  METRIC-3  BEST CHECKPOINT    METRIC-1  METRIC-2  METRIC-3
-3eeeeee     2 minutes ago       stopped  10.1.1.2  ben      200      hello    hi       null
-2eeeeee     about a minute ago  stopped  10.1.1.2  andreas  200      hello    hi                4cccccc (step 5)                       0.5
-1eeeeee     about a second ago  running  10.1.1.1  andreas  100      hello                      3cccccc (step 20)  0.02      2         null      2cccccc (step 20)  0.01      2
+EXPERIMENT  STARTED             STATUS    HOST      USER     PARAM-1  PARAM-2  PARAM-3  PARAM-4  LATEST CHECKPOINT  METRIC-1  METRIC-2  METRIC-3  BEST CHECKPOINT    METRIC-1  METRIC-2  METRIC-3
+3eeeeee     2 minutes ago       unknown   10.1.1.2  ben      200      hello    hi       null
+2eeeeee     about a minute ago  finished  10.1.1.2  andreas  200      hello    hi                4cccccc (step 5)                       0.5
+1eeeeee     about a second ago  running   10.1.1.1  andreas  100      hello                      3cccccc (step 20)  0.02      2         null      2cccccc (step 20)  0.01      2
 `
 	expected = expected[1:] // strip initial whitespace, added for readability
 	actual = testutil.TrimRightLines(actual)
@@ -184,13 +190,13 @@ func TestListOutputTableFilter(t *testing.T) {
 	sorter := param.NewSorter("started")
 
 	actual := capturer.CaptureStdout(func() {
-		err = Experiments(repo, FormatTable, false, filters, sorter)
+		err = Experiments(context.Background(), repo, FormatTable, false, filters, sorter)
 	})
 	require.NoError(t, err)
 	expected := `
-EXPERIMENT  STARTED             STATUS   HOST      PARAM-1  LATEST CHECKPOINT  METRIC-1  BEST CHECKPOINT    METRIC-1
-2eeeeee     about a minute ago  stopped  10.1.1.2  200      4cccccc (step 5)
-1eeeeee     about a second ago  running  10.1.1.1  100      3cccccc (step 20)  0.02      2cccccc (step 20)  0.01
+EXPERIMENT  STARTED             STATUS    HOST      PARAM-1  LATEST CHECKPOINT  METRIC-1  BEST CHECKPOINT    METRIC-1
+2eeeeee     about a minute ago  finished  10.1.1.2  200      4cccccc (step 5)
+1eeeeee     about a second ago  running   10.1.1.1  100      3cccccc (step 20)  0.02      2cccccc (step 20)  0.01
 `
 	expected = expected[1:] // strip initial whitespace, added for readability
 	actual = testutil.TrimRightLines(actual)
@@ -209,7 +215,7 @@ func TestListOutputTableFilterRunning(t *testing.T) {
 	sorter := param.NewSorter("started")
 
 	actual := capturer.CaptureStdout(func() {
-		err = Experiments(repo, FormatTable, false, filters, sorter)
+		err = Experiments(context.Background(), repo, FormatTable, false, filters, sorter)
 	})
 	require.NoError(t, err)
 	expected := `
@@ -231,14 +237,14 @@ func TestListOutputTableSort(t *testing.T) {
 	sorter := param.NewSorter("started-desc")
 
 	actual := capturer.CaptureStdout(func() {
-		err = Experiments(repo, FormatTable, false, new(param.Filters), sorter)
+		err = Experiments(context.Background(), repo, FormatTable, false, new(param.Filters), sorter)
 	})
 	require.NoError(t, err)
 	expected := `
-EXPERIMENT  STARTED             STATUS   HOST      USER     PARAM-1  LATEST CHECKPOINT  METRIC-1  BEST CHECKPOINT    METRIC-1
-1eeeeee     about a second ago  running  10.1.1.1  andreas  100      3cccccc (step 20)  0.02      2cccccc (step 20)  0.01
-2eeeeee     about a minute ago  stopped  10.1.1.2  andreas  200      4cccccc (step 5)
-3eeeeee     2 minutes ago       stopped  10.1.1.2  ben      200
+EXPERIMENT  STARTED             STATUS    HOST      USER     PARAM-1  LATEST CHECKPOINT  METRIC-1  BEST CHECKPOINT    METRIC-1
+1eeeeee     about a second ago  running   10.1.1.1  andreas  100      3cccccc (step 20)  0.02      2cccccc (step 20)  0.01
+2eeeeee     about a minute ago  finished  10.1.1.2  andreas  200      4cccccc (step 5)
+3eeeeee     2 minutes ago       unknown   10.1.1.2  ben      200
 `
 	expected = expected[1:] // strip initial whitespace, added for readability
 	actual = testutil.TrimRightLines(actual)
@@ -268,9 +274,9 @@ func TestListJSON(t *testing.T) {
 			}),
 		},
 	}
-	require.NoError(t, exp.Save(repository))
+	require.NoError(t, exp.Save(context.Background(), repository))
 	require.NoError(t, err)
-	require.NoError(t, project.CreateHeartbeat(repository, exp.ID, time.Now().UTC().Add(-24*time.Hour)))
+	require.NoError(t, project.CreateHeartbeat(context.Background(), repository, exp.ID, time.Now().UTC().Add(-24*time.Hour)))
 
 	// Experiment still running
 	exp = &project.Experiment{
@@ -286,13 +292,13 @@ func TestListJSON(t *testing.T) {
 			}),
 		},
 	}
-	require.NoError(t, exp.Save(repository))
+	require.NoError(t, exp.Save(context.Background(), repository))
 	require.NoError(t, err)
-	require.NoError(t, project.CreateHeartbeat(repository, exp.ID, time.Now().UTC()))
+	require.NoError(t, project.CreateHeartbeat(context.Background(), repository, exp.ID, time.Now().UTC()))
 
 	// replicate ls
 	actual := capturer.CaptureStdout(func() {
-		err = Experiments(repository, FormatJSON, true, new(param.Filters), &param.Sorter{Key: "started"})
+		err = Experiments(context.Background(), repository, FormatJSON, true, new(param.Filters), &param.Sorter{Key: "started"})
 	})
 	require.NoError(t, err)
 
@@ -304,11 +310,11 @@ func TestListJSON(t *testing.T) {
 	require.Equal(t, "train.py --gamma 1.2", experiments[0].Command)
 	require.Equal(t, 1, experiments[0].NumCheckpoints)
 	require.Equal(t, param.Float(0.987), experiments[0].LatestCheckpoint.Metrics["accuracy"])
-	require.Equal(t, false, experiments[0].Running)
+	require.Equal(t, project.StatusUnknown, experiments[0].Status)
 
 	require.Equal(t, param.Float(0.002), experiments[1].Params["learning_rate"])
 	require.Equal(t, "train.py --gamma 1.5", experiments[1].Command)
 	require.Equal(t, 1, experiments[1].NumCheckpoints)
 	require.Equal(t, param.Float(0.987), experiments[1].LatestCheckpoint.Metrics["accuracy"])
-	require.Equal(t, true, experiments[1].Running)
+	require.Equal(t, project.StatusRunning, experiments[1].Status)
 }
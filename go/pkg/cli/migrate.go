@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/replicate/go/pkg/config"
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/global"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+// How often progress is printed while migrating
+const migrateProgressInterval = 5 * time.Second
+
+type migrateOpts struct {
+	dryRun        bool
+	force         bool
+	updateConfig  bool
+	repositoryURL string
+}
+
+func newRepositoryMigrateCommand() *cobra.Command {
+	var opts migrateOpts
+
+	cmd := &cobra.Command{
+		Use:   "migrate <destination repository URL>",
+		Short: "Copy the repository to another location",
+		Long: `Copy the repository to another location.
+
+Everything in the repository is copied to the destination, which can use a
+different storage backend, then every file is checked against the original.
+If this is interrupted, run it again to carry on where it left off: files that
+have already been copied are skipped. Pass --dry-run to see what would be
+copied first.
+
+The repository being copied isn't changed. Pass --update-config to point
+replicate.yaml at the destination once everything has been copied. Projects
+that still use the old .replicate/storage directory without a replicate.yaml
+get one created.
+
+The destination should be empty, or a previous migration of the same
+repository. Files in the destination that aren't in the repository are
+deleted, so it refuses to copy to a destination that has other files in it
+unless --force is passed.
+`,
+		Example: `Move a project's repository from disk to Google Cloud Storage:
+replicate repository migrate gs://hooli-hotdog-detector --update-config`,
+		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
+			return migrateRepository(cmd.Context(), opts, args[0], os.Stdout)
+		}),
+		Args: cobra.ExactArgs(1),
+	}
+
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Print what would be copied and deleted, without changing anything")
+	cmd.Flags().BoolVarP(&opts.force, "force", "f", false, "Delete files in the destination that aren't in the repository")
+	cmd.Flags().BoolVar(&opts.updateConfig, "update-config", false, "Change the repository in replicate.yaml to the destination once it has been copied")
+	addRepositoryURLFlagVar(cmd, &opts.repositoryURL)
+
+	return cmd
+}
+
+func migrateRepository(ctx context.Context, opts migrateOpts, destURL string, out io.Writer) error {
+	sourceURL, projectDir, err := getRepositoryURLFromStringOrConfig(opts.repositoryURL)
+	if err != nil {
+		return err
+	}
+	if sourceURL == destURL {
+		return fmt.Errorf("The destination is the same as the repository being migrated: %s", destURL)
+	}
+	// Not wrapped in encryption, so encrypted files are copied as they are
+	source, err := repository.ForURL(resolveDiskURL(sourceURL, projectDir))
+	if err != nil {
+		return err
+	}
+	dest, err := repository.ForURL(destURL)
+	if err != nil {
+		return err
+	}
+
+	console.Info("Comparing %s to %s...", source.RootURL(), dest.RootURL())
+	plan, err := repository.Sync(ctx, source, "", dest, "", repository.SyncOptions{DryRun: true})
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		for _, p := range plan.Copied {
+			fmt.Fprintf(out, "Would copy %s\n", p)
+		}
+		for _, p := range plan.Deleted {
+			fmt.Fprintf(out, "Would delete %s\n", p)
+		}
+		console.Info("Would copy %d files (%s) and delete %d files. %d files are already in %s.", len(plan.Copied), formatBytes(plan.BytesCopied), len(plan.Deleted), plan.Unchanged, dest.RootURL())
+		return nil
+	}
+	if len(plan.Deleted) > 0 && !opts.force {
+		return fmt.Errorf("%s has %d files that aren't in %s. Pass --force to delete them, or migrate to an empty location.", dest.RootURL(), len(plan.Deleted), source.RootURL())
+	}
+
+	console.Info("Copying %d files (%s) from %s to %s...", len(plan.Copied), formatBytes(plan.BytesCopied), source.RootURL(), dest.RootURL())
+	lastPrint := time.Now()
+	summary, err := repository.Sync(ctx, source, "", dest, "", repository.SyncOptions{
+		Progress: func(progress repository.SyncProgress) {
+			if time.Since(lastPrint) >= migrateProgressInterval {
+				console.Info("Copied %d/%d files (%s/%s)...", progress.FilesDone, progress.FilesTotal, formatBytes(progress.BytesCopied), formatBytes(progress.BytesTotal))
+				lastPrint = time.Now()
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to copy %s to %s: %w\n\nRun this command again to carry on from where it stopped.", source.RootURL(), dest.RootURL(), err)
+	}
+	console.Info("Copied %d files (%s). %d files were already in %s.", len(summary.Copied), formatBytes(summary.BytesCopied), summary.Unchanged, dest.RootURL())
+
+	console.Info("Verifying %s...", dest.RootURL())
+	mismatched, err := verifyMigration(ctx, source, dest)
+	if err != nil {
+		return err
+	}
+	if len(mismatched) > 0 {
+		for _, p := range mismatched {
+			console.Warn("%s is missing or different in %s", p, dest.RootURL())
+		}
+		return fmt.Errorf("%d files in %s don't match %s. Run this command again to copy them.", len(mismatched), dest.RootURL(), source.RootURL())
+	}
+	console.Info("All %d files match", len(summary.Copied)+summary.Unchanged)
+
+	if opts.updateConfig {
+		if projectDir == "" {
+			return fmt.Errorf("Can't update replicate.yaml, because the project directory couldn't be found")
+		}
+		if err := updateRepositoryInConfig(projectDir, destURL); err != nil {
+			return err
+		}
+		console.Info("Updated %s to use %s", filepath.Join(projectDir, global.ConfigFilename), destURL)
+	} else {
+		console.Info("To use the new repository, change 'repository' in replicate.yaml to %s", destURL)
+	}
+	return nil
+}
+
+// resolveDiskURL makes a relative file:// URL relative to projectDir, instead of the
+// working directory. This is how the deprecated file://.replicate/storage is found.
+func resolveDiskURL(repositoryURL, projectDir string) string {
+	scheme, _, root, err := repository.SplitURL(repositoryURL)
+	if err != nil || scheme != repository.SchemeDisk || filepath.IsAbs(root) || projectDir == "" {
+		return repositoryURL
+	}
+	return "file://" + filepath.Join(projectDir, root)
+}
+
+// listAllFiles returns every file in repo, by path
+func listAllFiles(ctx context.Context, repo repository.Repository) (map[string]repository.ListResult, error) {
+	listed := map[string]repository.ListResult{}
+	results := make(chan repository.ListResult)
+	go repo.ListRecursive(ctx, results, "")
+	for result := range results {
+		if result.Error != nil {
+			return nil, fmt.Errorf("Failed to list %s: %w", repo.RootURL(), result.Error)
+		}
+		listed[result.Path] = result
+	}
+	return listed, nil
+}
+
+// verifyMigration returns the paths in source that are missing from dest or have a different
+// checksum. Where the checksums can't be compared, because the repositories have different
+// types of checksum, the sizes are compared instead.
+func verifyMigration(ctx context.Context, source, dest repository.Repository) ([]string, error) {
+	sourceFiles, err := listAllFiles(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	destFiles, err := listAllFiles(ctx, dest)
+	if err != nil {
+		return nil, err
+	}
+	mismatched := []string{}
+	for p, sourceFile := range sourceFiles {
+		destFile, ok := destFiles[p]
+		if !ok {
+			mismatched = append(mismatched, p)
+			continue
+		}
+		if equal, ok := sourceFile.Checksum.Compare(destFile.Checksum); ok {
+			if !equal {
+				mismatched = append(mismatched, p)
+			}
+		} else if sourceFile.Size != destFile.Size {
+			mismatched = append(mismatched, p)
+		}
+	}
+	sort.Strings(mismatched)
+	return mismatched, nil
+}
+
+var configRepositoryRegexp = regexp.MustCompile(`(?m)^(repository|storage):.*$`)
+
+// updateRepositoryInConfig sets the repository in projectDir's replicate.yaml to
+// repositoryURL, keeping the rest of the file as it is. If there is no replicate.yaml,
+// because the project uses the deprecated .replicate/storage directory, it creates one.
+func updateRepositoryInConfig(projectDir, repositoryURL string) error {
+	configPath := filepath.Join(projectDir, global.ConfigFilename)
+	line := "repository: " + repositoryURL
+	exists, err := files.FileExists(configPath)
+	if err != nil {
+		return err
+	}
+	text := []byte(line + "\n")
+	if exists {
+		text, err = ioutil.ReadFile(configPath)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s: %w", configPath, err)
+		}
+		if configRepositoryRegexp.Match(text) {
+			text = configRepositoryRegexp.ReplaceAllLiteral(text, []byte(line))
+		} else {
+			text = append([]byte(line+"\n"), text...)
+		}
+	}
+	if _, err := config.Parse(text, projectDir); err != nil {
+		return fmt.Errorf("Failed to update %s: %w", configPath, err)
+	}
+	if err := ioutil.WriteFile(configPath, text, 0644); err != nil {
+		return fmt.Errorf("Failed to write %s: %w", configPath, err)
+	}
+	return nil
+}
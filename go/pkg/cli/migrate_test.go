@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/config"
+	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestMigrateRepository(t *testing.T) {
+	projectDir, err := files.TempDir("test-migrate")
+	require.NoError(t, err)
+	defer os.RemoveAll(projectDir)
+	destDir, err := files.TempDir("test-migrate-dest")
+	require.NoError(t, err)
+	defer os.RemoveAll(destDir)
+
+	// A project that uses the deprecated .replicate/storage directory, without replicate.yaml
+	ctx := context.Background()
+	source, err := repository.NewDiskRepository(path.Join(projectDir, ".replicate/storage"))
+	require.NoError(t, err)
+	require.NoError(t, source.Put(ctx, "metadata/experiments/1eeeeeeeee.json", []byte("{}")))
+	require.NoError(t, source.Put(ctx, "experiments/1eeeeeeeee.tar.gz", []byte("tarball")))
+	require.NoError(t, source.Put(ctx, "checkpoints/1ccccccccc.tar.gz", []byte("tarball")))
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(projectDir))
+	defer func() { require.NoError(t, os.Chdir(cwd)) }()
+
+	// Refuses to delete things in the destination that aren't in the repository
+	dest, err := repository.NewDiskRepository(destDir)
+	require.NoError(t, err)
+	require.NoError(t, dest.Put(ctx, "something-else", []byte("hello")))
+	err = migrateRepository(ctx, migrateOpts{updateConfig: true}, "file://"+destDir, ioutil.Discard)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "has 1 files that aren't in")
+	require.NoError(t, dest.Delete(ctx, "something-else"))
+
+	// Carries on from a previous migration that was interrupted
+	require.NoError(t, dest.Put(ctx, "experiments/1eeeeeeeee.tar.gz", []byte("tarball")))
+	require.NoError(t, dest.Put(ctx, "checkpoints/1ccccccccc.tar.gz", []byte("tarb")))
+	out := new(bytes.Buffer)
+	require.NoError(t, migrateRepository(ctx, migrateOpts{dryRun: true, updateConfig: true}, "file://"+destDir, out))
+	require.Equal(t, "Would copy checkpoints/1ccccccccc.tar.gz\nWould copy metadata/experiments/1eeeeeeeee.json\n", out.String())
+	exists, err := files.FileExists(path.Join(projectDir, "replicate.yaml"))
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NoError(t, migrateRepository(ctx, migrateOpts{updateConfig: true}, "file://"+destDir, ioutil.Discard))
+
+	destFiles, err := listAllFiles(ctx, dest)
+	require.NoError(t, err)
+	require.Len(t, destFiles, 3)
+	data, err := dest.Get(ctx, "checkpoints/1ccccccccc.tar.gz")
+	require.NoError(t, err)
+	require.Equal(t, []byte("tarball"), data)
+
+	// replicate.yaml is created, and the old repository is left as it was
+	conf, err := config.LoadConfig(path.Join(projectDir, "replicate.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "file://"+destDir, conf.Repository)
+	sourceFiles, err := listAllFiles(ctx, source)
+	require.NoError(t, err)
+	require.Len(t, sourceFiles, 3)
+}
+
+func TestVerifyMigration(t *testing.T) {
+	ctx := context.Background()
+	source, err := repository.NewMemoryRepository("", "")
+	require.NoError(t, err)
+	dest, err := repository.NewMemoryRepository("", "")
+	require.NoError(t, err)
+	for _, p := range []string{"same", "different", "missing"} {
+		require.NoError(t, source.Put(ctx, p, []byte("hello")))
+	}
+	require.NoError(t, dest.Put(ctx, "same", []byte("hello")))
+	require.NoError(t, dest.Put(ctx, "different", []byte("world")))
+
+	mismatched, err := verifyMigration(ctx, source, dest)
+	require.NoError(t, err)
+	require.Equal(t, []string{"different", "missing"}, mismatched)
+}
+
+func TestUpdateRepositoryInConfig(t *testing.T) {
+	projectDir, err := files.TempDir("test-migrate-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(projectDir)
+
+	configPath := path.Join(projectDir, "replicate.yaml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(`# Where experiments are stored
+repository: "file://.replicate/storage"
+retention:
+  keep_latest: 5
+`), 0644))
+	require.NoError(t, updateRepositoryInConfig(projectDir, "gs://team-bucket/project"))
+	text, err := ioutil.ReadFile(configPath)
+	require.NoError(t, err)
+	require.Equal(t, `# Where experiments are stored
+repository: gs://team-bucket/project
+retention:
+  keep_latest: 5
+`, string(text))
+
+	// Invalid configs are not written
+	require.NoError(t, ioutil.WriteFile(configPath, []byte("repository: file://foo\ns3:\n  region: us-east-1\n"), 0644))
+	require.Error(t, updateRepositoryInConfig(projectDir, "gs://team-bucket/project"))
+	text, err = ioutil.ReadFile(configPath)
+	require.NoError(t, err)
+	require.Equal(t, "repository: file://foo\ns3:\n  region: us-east-1\n", string(text))
+}
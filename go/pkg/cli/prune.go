@@ -0,0 +1,176 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/replicate/go/pkg/config"
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/global"
+	"github.com/replicate/replicate/go/pkg/project"
+)
+
+type pruneOpts struct {
+	dryRun        bool
+	keepBest      int
+	keepLatest    int
+	keepEvery     int
+	repositoryURL string
+}
+
+func newPruneCommand() *cobra.Command {
+	var opts pruneOpts
+
+	cmd := &cobra.Command{
+		Use:   "prune [experiment ID...]",
+		Short: "Remove checkpoints that a retention policy doesn't keep",
+		Long: `Remove checkpoints that a retention policy doesn't keep.
+
+The policy can keep the best checkpoints according to the primary metric, the
+latest checkpoints, and checkpoints at every Kth step. A checkpoint is kept if
+any part of the policy keeps it.
+
+The policy is taken from the flags if any are passed, otherwise from the
+'retention' section in replicate.yaml.
+
+Checkpoints are removed from every experiment, or only the experiments passed
+as arguments (which can be ID prefixes). Running experiments are skipped, and
+so are experiments without a primary metric if the policy keeps the best
+checkpoints.
+`,
+		Example: `Keep the 3 best checkpoints and the latest checkpoint of every experiment:
+replicate prune --keep-best 3 --keep-latest 1
+
+See what would be removed, without removing anything:
+replicate prune --dry-run`,
+		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
+			policy, err := getRetentionPolicy(cmd, opts)
+			if err != nil {
+				return err
+			}
+			return prune(cmd.Context(), opts, policy, args, os.Stdout)
+		}),
+	}
+
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Print what would be removed, without removing anything")
+	cmd.Flags().IntVar(&opts.keepBest, "keep-best", 0, "Keep this many of the best checkpoints, according to the primary metric")
+	cmd.Flags().IntVar(&opts.keepLatest, "keep-latest", 0, "Keep this many of the latest checkpoints")
+	cmd.Flags().IntVar(&opts.keepEvery, "keep-every", 0, "Keep checkpoints where the step is a multiple of this")
+	addRepositoryURLFlagVar(cmd, &opts.repositoryURL)
+
+	return cmd
+}
+
+// getRetentionPolicy returns the policy from the flags if any are set, otherwise from replicate.yaml
+func getRetentionPolicy(cmd *cobra.Command, opts pruneOpts) (project.RetentionPolicy, error) {
+	if cmd.Flags().Changed("keep-best") || cmd.Flags().Changed("keep-latest") || cmd.Flags().Changed("keep-every") {
+		policy := project.RetentionPolicy{
+			KeepBest:   opts.keepBest,
+			KeepLatest: opts.keepLatest,
+			KeepEvery:  opts.keepEvery,
+		}
+		if policy.KeepBest < 0 || policy.KeepLatest < 0 || policy.KeepEvery < 0 {
+			return policy, fmt.Errorf("--keep-best, --keep-latest, and --keep-every can't be negative")
+		}
+		return policy, nil
+	}
+	conf, _, err := config.FindConfigInWorkingDir(global.ProjectDirectory)
+	if err != nil {
+		return project.RetentionPolicy{}, err
+	}
+	if conf.Retention == nil {
+		return project.RetentionPolicy{}, fmt.Errorf("No retention policy. Pass --keep-best, --keep-latest, or --keep-every, or add a 'retention' section to replicate.yaml.")
+	}
+	return project.RetentionPolicy{
+		KeepBest:   conf.Retention.KeepBest,
+		KeepLatest: conf.Retention.KeepLatest,
+		KeepEvery:  conf.Retention.KeepEvery,
+	}, nil
+}
+
+func prune(ctx context.Context, opts pruneOpts, policy project.RetentionPolicy, prefixes []string, out io.Writer) error {
+	if policy.IsEmpty() {
+		// Otherwise every checkpoint would be removed
+		return fmt.Errorf("The retention policy doesn't keep any checkpoints")
+	}
+
+	repositoryURL, projectDir, err := getRepositoryURLFromStringOrConfig(opts.repositoryURL)
+	if err != nil {
+		return err
+	}
+	repo, err := getRepository(ctx, repositoryURL, projectDir)
+	if err != nil {
+		return err
+	}
+	proj := project.NewProject(repo)
+
+	experiments := []*project.Experiment{}
+	if len(prefixes) == 0 {
+		experiments, err = proj.Experiments(ctx)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, prefix := range prefixes {
+			comOrExp, err := proj.CheckpointOrExperimentFromPrefix(ctx, prefix)
+			if err != nil {
+				return err
+			}
+			if comOrExp.Checkpoint != nil {
+				return fmt.Errorf("%s is a checkpoint, not an experiment", prefix)
+			}
+			experiments = append(experiments, comOrExp.Experiment)
+		}
+	}
+	sort.Slice(experiments, func(i, j int) bool {
+		return experiments[i].Created.Before(experiments[j].Created)
+	})
+
+	numCheckpoints := 0
+	numExperiments := 0
+	for _, exp := range experiments {
+		status, err := proj.ExperimentStatus(ctx, exp.ID)
+		if err != nil {
+			return err
+		}
+		if status == project.StatusRunning {
+			// The training script would save the checkpoints again
+			console.Info("Skipping experiment %s because it is running", exp.ShortID())
+			continue
+		}
+
+		pruned := policy.CheckpointsToPrune(exp)
+		if len(pruned) == 0 {
+			continue
+		}
+		numExperiments++
+		numCheckpoints += len(pruned)
+		if opts.dryRun {
+			for _, chk := range pruned {
+				fmt.Fprintf(out, "Would remove checkpoint %s (step %d) from experiment %s\n", chk.ShortID(), chk.Step, exp.ShortID())
+			}
+			continue
+		}
+		if err := proj.DeleteCheckpoints(ctx, pruned); err != nil {
+			return err
+		}
+		for _, chk := range pruned {
+			fmt.Fprintf(out, "Removed checkpoint %s (step %d) from experiment %s\n", chk.ShortID(), chk.Step, exp.ShortID())
+		}
+	}
+
+	if opts.dryRun {
+		console.Info("Would remove %d checkpoints from %d experiments", numCheckpoints, numExperiments)
+		return nil
+	}
+	if numCheckpoints > 0 {
+		updateIndex(ctx, repo)
+	}
+	console.Info("Removed %d checkpoints from %d experiments", numCheckpoints, numExperiments)
+	return nil
+}
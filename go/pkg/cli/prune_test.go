@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/param"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestPrune(t *testing.T) {
+	repoDir, err := files.TempDir("test-prune")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+
+	created := time.Now().Add(-time.Hour)
+	losses := []float64{0.5, 0.1, 0.4, 0.2, 0.3, 0.6}
+	exp := &project.Experiment{ID: "1eeeeeeeee", Created: created}
+	for i, loss := range losses {
+		exp.Checkpoints = append(exp.Checkpoints, &project.Checkpoint{
+			ID:            string(rune('a'+i)) + "ccccccccc",
+			Created:       created.Add(time.Duration(i) * time.Minute),
+			Step:          (i + 1) * 10,
+			Path:          ".",
+			Metrics:       param.ValueMap{"loss": param.Float(loss)},
+			PrimaryMetric: &project.PrimaryMetric{Name: "loss", Goal: project.GoalMinimize},
+		})
+	}
+	require.NoError(t, exp.Save(ctx, repo))
+	for _, chk := range exp.Checkpoints {
+		require.NoError(t, repo.Put(ctx, chk.StorageTarPath(), []byte("tarball")))
+	}
+
+	// Best is b, latest is f, every 30 steps is c and f
+	policy := project.RetentionPolicy{KeepBest: 1, KeepLatest: 1, KeepEvery: 30}
+	opts := pruneOpts{dryRun: true, repositoryURL: "file://" + repoDir}
+	out := new(bytes.Buffer)
+	require.NoError(t, prune(ctx, opts, policy, nil, out))
+	require.Equal(t, `Would remove checkpoint acccccc (step 10) from experiment 1eeeeee
+Would remove checkpoint dcccccc (step 40) from experiment 1eeeeee
+Would remove checkpoint ecccccc (step 50) from experiment 1eeeeee
+`, out.String())
+	paths, err := repo.List(ctx, "checkpoints")
+	require.NoError(t, err)
+	require.Len(t, paths, 6)
+
+	opts.dryRun = false
+	out = new(bytes.Buffer)
+	require.NoError(t, prune(ctx, opts, policy, []string{"1eee"}, out))
+	require.Contains(t, out.String(), "Removed checkpoint acccccc (step 10) from experiment 1eeeeee\n")
+
+	paths, err = repo.List(ctx, "checkpoints")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"checkpoints/bccccccccc.tar.gz",
+		"checkpoints/cccccccccc.tar.gz",
+		"checkpoints/fccccccccc.tar.gz",
+	}, paths)
+	proj := project.NewProject(repo)
+	experiments, err := proj.Experiments(ctx)
+	require.NoError(t, err)
+	require.Len(t, experiments, 1)
+	ids := []string{}
+	for _, chk := range experiments[0].Checkpoints {
+		ids = append(ids, chk.ID)
+	}
+	require.Equal(t, []string{"bccccccccc", "cccccccccc", "fccccccccc"}, ids)
+
+	// Nothing else to remove
+	out = new(bytes.Buffer)
+	require.NoError(t, prune(ctx, opts, policy, nil, out))
+	require.Empty(t, out.String())
+
+	require.Error(t, prune(ctx, opts, project.RetentionPolicy{}, nil, new(bytes.Buffer)))
+}
+
+func TestRetentionPolicyWithoutPrimaryMetric(t *testing.T) {
+	exp := &project.Experiment{
+		Checkpoints: []*project.Checkpoint{
+			{ID: "a", Step: 1, Created: time.Unix(1, 0)},
+			{ID: "b", Step: 2, Created: time.Unix(2, 0)},
+			{ID: "c", Step: 3, Created: time.Unix(3, 0)},
+		},
+	}
+	// Without a primary metric, there is no telling which checkpoints are best, so they
+	// are all kept
+	pruned := project.RetentionPolicy{KeepBest: 2}.CheckpointsToPrune(exp)
+	require.Empty(t, pruned)
+	pruned = project.RetentionPolicy{KeepBest: 2, KeepLatest: 1}.CheckpointsToPrune(exp)
+	require.Empty(t, pruned)
+	// Other rules still apply if the best checkpoints aren't kept
+	pruned = project.RetentionPolicy{KeepLatest: 1}.CheckpointsToPrune(exp)
+	require.Equal(t, []*project.Checkpoint{exp.Checkpoints[0], exp.Checkpoints[1]}, pruned)
+}
+
+func TestPruneKeepBestWithoutPrimaryMetric(t *testing.T) {
+	repoDir, err := files.TempDir("test-prune")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+
+	created := time.Now().Add(-time.Hour)
+	exp := &project.Experiment{ID: "1eeeeeeeee", Created: created}
+	for i := 0; i < 3; i++ {
+		exp.Checkpoints = append(exp.Checkpoints, &project.Checkpoint{
+			ID:      string(rune('a'+i)) + "ccccccccc",
+			Created: created.Add(time.Duration(i) * time.Minute),
+			Step:    i + 1,
+			Path:    ".",
+			Metrics: param.ValueMap{"loss": param.Float(0.1)},
+		})
+	}
+	require.NoError(t, exp.Save(ctx, repo))
+	for _, chk := range exp.Checkpoints {
+		require.NoError(t, repo.Put(ctx, chk.StorageTarPath(), []byte("tarball")))
+	}
+
+	// There is no telling which checkpoints are best, so none are removed
+	opts := pruneOpts{repositoryURL: "file://" + repoDir}
+	out := new(bytes.Buffer)
+	require.NoError(t, prune(ctx, opts, project.RetentionPolicy{KeepBest: 1}, nil, out))
+	require.Empty(t, out.String())
+	paths, err := repo.List(ctx, "checkpoints")
+	require.NoError(t, err)
+	require.Len(t, paths, 3)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/replicate/replicate/go/pkg/cli/list"
 	"github.com/replicate/replicate/go/pkg/param"
+	"github.com/replicate/replicate/go/pkg/project"
 )
 
 func newPsCommand() *cobra.Command {
@@ -41,10 +42,10 @@ func listRunningExperiments(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	filters.SetExclusive("status", param.OperatorEqual, param.String("running"))
-	repo, err := getRepository(repositoryURL, projectDir)
+	filters.SetExclusive("status", param.OperatorEqual, param.String(string(project.StatusRunning)))
+	repo, err := getRepository(cmd.Context(), repositoryURL, projectDir)
 	if err != nil {
 		return err
 	}
-	return list.Experiments(repo, format, allParams, filters, sortKey)
+	return list.Experiments(cmd.Context(), repo, format, allParams, filters, sortKey)
 }
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+type reindexOpts struct {
+	repositoryURL string
+}
+
+func newReindexCommand() *cobra.Command {
+	var opts reindexOpts
+
+	cmd := &cobra.Command{
+		Use:   "reindex",
+		Short: "Rebuild the index of experiments in the repository",
+		Long: `Rebuild the index of experiments in the repository.
+
+The index is a summary of every experiment, so experiments can be listed
+without loading every experiment's metadata. Commands that change experiments,
+like rm and prune, update it, but experiments that are saved while training
+aren't added to it until you run this. Listing experiments works either way,
+but it is slower if many experiments aren't in the index.
+
+Every experiment's metadata is loaded, so this can take a while for large
+repositories.
+`,
+		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
+			return reindex(cmd.Context(), opts, os.Stdout)
+		}),
+		Args: cobra.NoArgs,
+	}
+
+	addRepositoryURLFlagVar(cmd, &opts.repositoryURL)
+
+	return cmd
+}
+
+func reindex(ctx context.Context, opts reindexOpts, out io.Writer) error {
+	repositoryURL, projectDir, err := getRepositoryURLFromStringOrConfig(opts.repositoryURL)
+	if err != nil {
+		return err
+	}
+	// Not cached, so every experiment that is in the repository is indexed
+	repo, err := getUncachedRepository(repositoryURL, projectDir)
+	if err != nil {
+		return err
+	}
+	console.Info("Indexing experiments in %s...", repo.RootURL())
+	n, err := project.Reindex(ctx, repo)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Indexed %d experiments\n", n)
+	return nil
+}
+
+// updateIndex updates the index after a command has changed experiments. The index is only
+// an optimization, so if that fails, it only warns.
+func updateIndex(ctx context.Context, repo repository.Repository) {
+	if err := project.UpdateIndex(ctx, repo); err != nil {
+		console.Warn("Failed to update the index of experiments, so listing them may be slower: %s. To rebuild it, run 'replicate reindex'.", err)
+	}
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/param"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestReindex(t *testing.T) {
+	repoDir, err := files.TempDir("test-reindex")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+	for _, id := range []string{"1eeeeeeeee", "2eeeeeeeee"} {
+		exp := &project.Experiment{
+			ID:          id,
+			Created:     time.Now(),
+			Params:      param.ValueMap{"learning_rate": param.Float(0.01)},
+			Checkpoints: []*project.Checkpoint{{ID: id[:1] + "ccccccccc", Created: time.Now()}},
+		}
+		require.NoError(t, exp.Save(ctx, repo))
+	}
+
+	out := new(bytes.Buffer)
+	require.NoError(t, reindex(ctx, reindexOpts{repositoryURL: "file://" + repoDir}, out))
+	require.Equal(t, "Indexed 2 experiments\n", out.String())
+	data, err := repo.Get(ctx, project.IndexPath)
+	require.NoError(t, err)
+	idx := struct {
+		Experiments map[string]*project.ExperimentSummary `json:"experiments"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &idx))
+	require.Len(t, idx.Experiments, 2)
+	require.Equal(t, []string{"1ccccccccc"}, idx.Experiments["1eeeeeeeee"].CheckpointIDs)
+	require.Equal(t, "1ccccccccc", idx.Experiments["1eeeeeeeee"].LatestCheckpoint.ID)
+}
+
+func TestExperimentSummariesUseIndex(t *testing.T) {
+	repoDir, err := files.TempDir("test-summaries")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+	exp := &project.Experiment{
+		ID:          "1eeeeeeeee",
+		Created:     time.Now(),
+		Params:      param.ValueMap{"learning_rate": param.Float(0.01)},
+		Checkpoints: []*project.Checkpoint{{ID: "1ccccccccc", Created: time.Now()}},
+	}
+	require.NoError(t, exp.Save(ctx, repo))
+
+	summaries := func() map[string]*project.ExperimentSummary {
+		ret := map[string]*project.ExperimentSummary{}
+		summaries, err := project.NewProject(repo).ExperimentSummaries(ctx)
+		require.NoError(t, err)
+		for _, summary := range summaries {
+			ret[summary.ID] = summary
+		}
+		return ret
+	}
+
+	// Listing experiments doesn't save the index, but updating it does
+	require.Len(t, summaries(), 1)
+	_, err = repo.Get(ctx, project.IndexPath)
+	require.IsType(t, &repository.DoesNotExistError{}, err)
+	require.NoError(t, project.UpdateIndex(ctx, repo))
+	data, err := repo.Get(ctx, project.IndexPath)
+	require.NoError(t, err)
+
+	// Entries are used if the experiment hasn't changed since it was indexed
+	idx := map[string]map[string]map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &idx))
+	idx["experiments"]["1eeeeeeeee"]["command"] = "from the index"
+	data, err = json.Marshal(idx)
+	require.NoError(t, err)
+	require.NoError(t, repo.Put(ctx, project.IndexPath, data))
+	require.Equal(t, "from the index", summaries()["1eeeeeeeee"].Command)
+
+	// Experiments that have changed, or aren't in the index, are loaded from their metadata,
+	// even if they were written without updating the index
+	exp.Command = "train.py"
+	exp.Checkpoints = append(exp.Checkpoints, &project.Checkpoint{ID: "2ccccccccc", Created: time.Now().Add(time.Second)})
+	require.NoError(t, exp.Save(ctx, repo))
+	exp2 := &project.Experiment{ID: "2eeeeeeeee", Created: time.Now()}
+	require.NoError(t, exp2.Save(ctx, repo))
+	s := summaries()
+	require.Len(t, s, 2)
+	require.Equal(t, "train.py", s["1eeeeeeeee"].Command)
+	require.Equal(t, "2ccccccccc", s["1eeeeeeeee"].LatestCheckpoint.ID)
+	newData, err := repo.Get(ctx, project.IndexPath)
+	require.NoError(t, err)
+	require.Equal(t, data, newData)
+
+	// Checkpoints saved in their own files make the experiment's entry stale too
+	require.NoError(t, project.SaveCheckpoint(ctx, repo, exp.ID, &project.Checkpoint{ID: "3ccccccccc", Created: time.Now().Add(2 * time.Second)}))
+	s = summaries()
+	require.Equal(t, []string{"1ccccccccc", "2ccccccccc", "3ccccccccc"}, s["1eeeeeeeee"].CheckpointIDs)
+	require.Equal(t, "3ccccccccc", s["1eeeeeeeee"].LatestCheckpoint.ID)
+
+	// Deleted experiments aren't listed, and corrupt indexes are ignored
+	require.NoError(t, repo.Delete(ctx, exp2.MetadataPath()))
+	require.Len(t, summaries(), 1)
+	require.NoError(t, repo.Put(ctx, project.IndexPath, []byte("not json")))
+	require.Len(t, summaries(), 1)
+
+	// Prefixes match checkpoints in the index, and the whole experiment is loaded
+	result, err := project.NewProject(repo).CheckpointOrExperimentFromPrefix(ctx, "2cc")
+	require.NoError(t, err)
+	require.Equal(t, "2ccccccccc", result.Checkpoint.ID)
+	require.Len(t, result.Experiment.Checkpoints, 3)
+}
@@ -0,0 +1,446 @@
+package cli
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+type repairOpts struct {
+	dryRun        bool
+	repositoryURL string
+}
+
+func newRepairCommand() *cobra.Command {
+	var opts repairOpts
+
+	cmd := &cobra.Command{
+		Use:   "repair",
+		Short: "Rebuild lost experiment and checkpoint metadata from tarballs",
+		Long: `Rebuild lost experiment and checkpoint metadata from tarballs.
+
+If an experiment's or checkpoint's metadata is deleted or corrupt, its tarball
+is still in the repository, but Replicate can't see it. This finds tarballs
+under experiments/ and checkpoints/ that don't have metadata, and saves
+metadata for them again.
+
+Only what can be worked out from the tarballs is recovered: when they were
+created, from when the tarballs were saved, and their paths, from the files in
+the tarballs. Params, metrics, and everything else are lost. Recovered
+experiments and checkpoints are marked as recovered.
+
+Checkpoints whose metadata was lost are added to the latest experiment that
+was created before them. If another experiment was still running when that
+experiment was created, the checkpoint could belong to either of them, so it is
+listed as unrecoverable instead.
+
+Anything that can't be recovered is listed, and the command exits with a
+non-zero status.
+`,
+		Example: `See what would be recovered, without saving anything:
+replicate repair --dry-run`,
+		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
+			return repair(cmd.Context(), opts, os.Stdout)
+		}),
+		Args: cobra.NoArgs,
+	}
+
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Print what would be recovered, without saving anything")
+	addRepositoryURLFlagVar(cmd, &opts.repositoryURL)
+
+	return cmd
+}
+
+// repairPlan is the metadata that can be rebuilt from a repository's tarballs
+type repairPlan struct {
+	// experiments is experiments whose metadata was lost, with the checkpoints that were
+	// added to them
+	experiments []*project.Experiment
+	// checkpoints is checkpoints to save in their own files, for experiments whose metadata
+	// is fine and checkpoints whose own metadata file was corrupt, by experiment ID
+	checkpoints map[string][]*project.Checkpoint
+	// unrecoverable is files that metadata couldn't be rebuilt for
+	unrecoverable []*unrecoverableFile
+}
+
+type unrecoverableFile struct {
+	path   string
+	reason string
+}
+
+func (p *repairPlan) addUnrecoverable(path string, format string, a ...interface{}) {
+	p.unrecoverable = append(p.unrecoverable, &unrecoverableFile{path: path, reason: fmt.Sprintf(format, a...)})
+}
+
+func repair(ctx context.Context, opts repairOpts, out io.Writer) error {
+	repositoryURL, projectDir, err := getRepositoryURLFromStringOrConfig(opts.repositoryURL)
+	if err != nil {
+		return err
+	}
+	// Not cached, so we see exactly what is in the repository
+	repo, err := getUncachedRepository(repositoryURL, projectDir)
+	if err != nil {
+		return err
+	}
+	console.Info("Looking for tarballs without metadata in %s...", repo.RootURL())
+	plan, err := planRepair(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	verb := "Recovered"
+	if opts.dryRun {
+		verb = "Would recover"
+	}
+	numCheckpoints := 0
+	failed := 0
+	for _, exp := range plan.experiments {
+		fmt.Fprintf(out, "%s experiment %s with %d checkpoints\n", verb, exp.ID, len(exp.Checkpoints))
+		numCheckpoints += len(exp.Checkpoints)
+		if opts.dryRun {
+			continue
+		}
+		if err := exp.Save(ctx, repo); err != nil {
+			console.Warn("Failed to save experiment %s: %s", exp.ID, err)
+			failed++
+		}
+	}
+	experimentIDs := []string{}
+	for id := range plan.checkpoints {
+		experimentIDs = append(experimentIDs, id)
+	}
+	sort.Strings(experimentIDs)
+	for _, experimentID := range experimentIDs {
+		for _, chk := range plan.checkpoints[experimentID] {
+			fmt.Fprintf(out, "%s checkpoint %s of experiment %s\n", verb, chk.ID, experimentID)
+			numCheckpoints++
+			if opts.dryRun {
+				continue
+			}
+			if err := project.SaveCheckpoint(ctx, repo, experimentID, chk); err != nil {
+				console.Warn("Failed to save checkpoint %s: %s", chk.ID, err)
+				failed++
+			}
+		}
+	}
+
+	if len(plan.unrecoverable) > 0 {
+		fmt.Fprintf(out, "\nCould not recover (%d):\n", len(plan.unrecoverable))
+		for _, file := range plan.unrecoverable {
+			fmt.Fprintf(out, "  %s: %s\n", file.path, file.reason)
+		}
+	}
+
+	if !opts.dryRun && len(plan.experiments)+numCheckpoints > 0 {
+		updateIndex(ctx, repo)
+	}
+	console.Info("%s %d experiments and %d checkpoints", verb, len(plan.experiments), numCheckpoints)
+	if failed > 0 {
+		return fmt.Errorf("Failed to save metadata for %d experiments and checkpoints", failed)
+	}
+	if len(plan.unrecoverable) > 0 {
+		return fmt.Errorf("Could not recover %d files", len(plan.unrecoverable))
+	}
+	return nil
+}
+
+// planRepair works out what metadata can be rebuilt from the tarballs in repo
+func planRepair(ctx context.Context, repo repository.Repository) (*repairPlan, error) {
+	plan := &repairPlan{
+		experiments:   []*project.Experiment{},
+		checkpoints:   map[string][]*project.Checkpoint{},
+		unrecoverable: []*unrecoverableFile{},
+	}
+	scan, err := scanRepository(ctx, repo, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Experiments whose metadata is fine, by ID
+	known := map[string]*project.Experiment{}
+	// Paths of experiments' metadata that is corrupt, by experiment ID
+	corrupt := map[string]string{}
+	// Checkpoints that have metadata
+	referenced := map[string]bool{}
+	// Paths of checkpoints' own metadata files that are corrupt, by checkpoint ID
+	corruptCheckpoints := map[string]string{}
+	// The earliest checkpoint of experiments that have checkpoints saved in their own files,
+	// in case the experiment's metadata is lost too
+	earliestCheckpoint := map[string]time.Time{}
+	// When each experiment was last known to be running, from its checkpoints and heartbeat
+	lastActive := map[string]time.Time{}
+	markActive := func(experimentID string, t time.Time) {
+		if t.After(lastActive[experimentID]) {
+			lastActive[experimentID] = t
+		}
+	}
+
+	for _, p := range scan.experimentPaths {
+		id := strings.TrimSuffix(path.Base(p), ".json")
+		data, err := repo.Get(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := project.ParseExperiment(p, data)
+		if err := checkNotNewer(p, err); err != nil {
+			return nil, err
+		}
+		if err != nil || exp.ID != id {
+			corrupt[id] = p
+			continue
+		}
+		known[id] = exp
+		markActive(id, exp.Created)
+		for _, chk := range exp.Checkpoints {
+			referenced[chk.ID] = true
+			markActive(id, chk.Created)
+		}
+	}
+	for _, p := range scan.checkpointPaths {
+		id := strings.TrimSuffix(path.Base(p), ".json")
+		experimentID := path.Base(path.Dir(p))
+		data, err := repo.Get(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		chk, err := project.ParseCheckpoint(p, data)
+		if err := checkNotNewer(p, err); err != nil {
+			return nil, err
+		}
+		if err != nil || chk.ID != id {
+			corruptCheckpoints[id] = p
+			continue
+		}
+		referenced[id] = true
+		markActive(experimentID, chk.Created)
+		if t, ok := earliestCheckpoint[experimentID]; !ok || chk.Created.Before(t) {
+			earliestCheckpoint[experimentID] = chk.Created
+		}
+	}
+	for _, p := range scan.heartbeatPaths {
+		data, err := repo.Get(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		// Heartbeats are only used to tell when experiments were running, so it doesn't
+		// matter if they are corrupt
+		heartbeat := new(project.Heartbeat)
+		if err := json.Unmarshal(data, heartbeat); err == nil {
+			markActive(heartbeat.ExperimentID, heartbeat.LastHeartbeat)
+		}
+	}
+
+	tarballPaths := []string{}
+	for p := range scan.tarballs {
+		tarballPaths = append(tarballPaths, p)
+	}
+	sort.Strings(tarballPaths)
+
+	recovered := map[string]*project.Experiment{}
+	for _, p := range tarballPaths {
+		if !strings.HasPrefix(p, "experiments/") {
+			continue
+		}
+		id := strings.TrimSuffix(path.Base(p), ".tar.gz")
+		if known[id] != nil {
+			continue
+		}
+		info, err := readTarballInfo(ctx, repo, scan.tarballs[p])
+		if err != nil {
+			plan.addUnrecoverable(p, "%s", err)
+			continue
+		}
+		recovered[id] = &project.Experiment{ID: id, Created: info.created, Path: info.path, Recovered: true}
+	}
+	// Experiments without a path don't save a tarball, but they can be found from their
+	// checkpoints
+	for id, created := range earliestCheckpoint {
+		if known[id] == nil && recovered[id] == nil {
+			recovered[id] = &project.Experiment{ID: id, Created: created, Recovered: true}
+		}
+	}
+	for id, exp := range recovered {
+		markActive(id, exp.Created)
+	}
+
+	// Experiments that checkpoints whose metadata was lost could belong to, sorted by when
+	// they were created, so checkpoints are added to the latest experiment before them
+	candidates := []*project.Experiment{}
+	for _, exp := range known {
+		candidates = append(candidates, exp)
+	}
+	for _, exp := range recovered {
+		candidates = append(candidates, exp)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Created.Equal(candidates[j].Created) {
+			return candidates[i].ID < candidates[j].ID
+		}
+		return candidates[i].Created.Before(candidates[j].Created)
+	})
+
+	for _, p := range tarballPaths {
+		if !strings.HasPrefix(p, "checkpoints/") {
+			continue
+		}
+		id := strings.TrimSuffix(path.Base(p), ".tar.gz")
+		if referenced[id] {
+			continue
+		}
+		info, err := readTarballInfo(ctx, repo, scan.tarballs[p])
+		if err != nil {
+			plan.addUnrecoverable(p, "%s", err)
+			continue
+		}
+		chk := &project.Checkpoint{ID: id, Created: info.created, Path: info.path, Recovered: true}
+
+		// Checkpoints whose own metadata file is corrupt are saved in the same place
+		if recordPath, ok := corruptCheckpoints[id]; ok {
+			experimentID := path.Base(path.Dir(recordPath))
+			plan.checkpoints[experimentID] = append(plan.checkpoints[experimentID], chk)
+			delete(corruptCheckpoints, id)
+			if known[experimentID] == nil && recovered[experimentID] == nil {
+				recovered[experimentID] = &project.Experiment{ID: experimentID, Created: chk.Created, Recovered: true}
+			}
+			continue
+		}
+
+		var exp *project.Experiment
+		for _, e := range candidates {
+			if e.Created.After(chk.Created) {
+				break
+			}
+			exp = e
+		}
+		if exp == nil {
+			plan.addUnrecoverable(p, "No experiment was created before it")
+			continue
+		}
+		// If another experiment was still running when this one was created, the checkpoint
+		// could belong to either of them
+		var overlapping *project.Experiment
+		for _, e := range candidates {
+			if e == exp {
+				break
+			}
+			if !lastActive[e.ID].Before(exp.Created) {
+				overlapping = e
+				break
+			}
+		}
+		if overlapping != nil {
+			plan.addUnrecoverable(p, "It could belong to experiment %s or %s, because they were running at the same time", overlapping.ID, exp.ID)
+			continue
+		}
+		if known[exp.ID] != nil {
+			plan.checkpoints[exp.ID] = append(plan.checkpoints[exp.ID], chk)
+		} else {
+			exp.Checkpoints = append(exp.Checkpoints, chk)
+		}
+	}
+
+	for id, p := range corruptCheckpoints {
+		plan.addUnrecoverable(p, "Checkpoint %s's metadata is corrupt, and it has no tarball to recover it from", id)
+	}
+	for id, p := range corrupt {
+		if recovered[id] == nil {
+			plan.addUnrecoverable(p, "Experiment %s's metadata is corrupt, and it has no tarball or checkpoints to recover it from", id)
+		}
+	}
+	sort.Slice(plan.unrecoverable, func(i, j int) bool {
+		return plan.unrecoverable[i].path < plan.unrecoverable[j].path
+	})
+
+	for _, exp := range recovered {
+		sort.SliceStable(exp.Checkpoints, func(i, j int) bool {
+			return exp.Checkpoints[i].Created.Before(exp.Checkpoints[j].Created)
+		})
+		plan.experiments = append(plan.experiments, exp)
+	}
+	sort.Slice(plan.experiments, func(i, j int) bool {
+		return plan.experiments[i].ID < plan.experiments[j].ID
+	})
+	return plan, nil
+}
+
+// checkNotNewer returns an error if err is because a record was saved by a newer version of
+// Replicate, because then it isn't possible to tell what is referenced
+func checkNotNewer(p string, err error) error {
+	var newer *project.NewerSchemaError
+	if errors.As(err, &newer) {
+		return fmt.Errorf("%s was saved by a newer version of Replicate, so it isn't possible to tell which tarballs have metadata. Upgrade Replicate, then run this command again.", p)
+	}
+	return nil
+}
+
+// tarballInfo is what can be worked out about an experiment or checkpoint from its tarball
+type tarballInfo struct {
+	path    string
+	created time.Time
+}
+
+// readTarballInfo reads the tarball in result. The path is the file or directory in the
+// tarball, or "." if it has more than one, and created is when the tarball was saved.
+func readTarballInfo(ctx context.Context, repo repository.Repository, result repository.ListResult) (*tarballInfo, error) {
+	reader, err := repo.GetReader(ctx, result.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Not a gzip file: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	// Everything in the tarball is in a directory named after it (see putPathTar())
+	names := map[string]bool{}
+	var newest time.Time
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read tar file: %w", err)
+		}
+		parts := strings.SplitN(strings.TrimPrefix(header.Name, "./"), "/", 3)
+		if len(parts) >= 2 && parts[1] != "" {
+			names[parts[1]] = true
+		}
+		if header.ModTime.After(newest) {
+			newest = header.ModTime
+		}
+	}
+
+	info := &tarballInfo{path: ".", created: result.MTime}
+	if len(names) == 1 {
+		for name := range names {
+			info.path = name
+		}
+	}
+	// Not all repositories know when files were modified, so fall back to the files in it
+	if info.created.IsZero() {
+		info.created = newest
+	}
+	if info.created.IsZero() {
+		return nil, fmt.Errorf("Couldn't work out when it was created")
+	}
+	info.created = info.created.UTC()
+	return info, nil
+}
@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestRepair(t *testing.T) {
+	repoDir, err := files.TempDir("test-repair")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+	sourceDir, err := files.TempDir("test-repair-source")
+	require.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	require.NoError(t, ioutil.WriteFile(path.Join(sourceDir, "train.py"), []byte("print(1 + 1)"), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(sourceDir, "weights"), []byte("1.2kg"), 0644))
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	putTarball := func(p string, includePath string, mtime time.Time) {
+		require.NoError(t, repo.PutPathTar(ctx, sourceDir, p, includePath))
+		require.NoError(t, os.Chtimes(path.Join(repoDir, p), mtime, mtime))
+	}
+
+	// A healthy experiment
+	exp1 := &project.Experiment{
+		ID:          "1eeeeeeeee",
+		Created:     start,
+		Path:        ".",
+		Checkpoints: []*project.Checkpoint{{ID: "1ccccccccc", Created: start, Path: "weights"}},
+	}
+	require.NoError(t, exp1.Save(ctx, repo))
+	putTarball(exp1.StorageTarPath(), ".", start)
+	putTarball(exp1.Checkpoints[0].StorageTarPath(), "weights", start)
+	// A checkpoint of it saved in its own file that is corrupt
+	putTarball("checkpoints/1ddddddddd.tar.gz", "weights", start.Add(time.Minute))
+	require.NoError(t, repo.Put(ctx, "metadata/checkpoints/1eeeeeeeee/1ddddddddd.json", []byte("{")))
+
+	// An experiment whose metadata was deleted, with two checkpoints
+	putTarball("experiments/2eeeeeeeee.tar.gz", ".", start.Add(10*time.Minute))
+	putTarball("checkpoints/2ccccccccc.tar.gz", "weights", start.Add(11*time.Minute))
+	putTarball("checkpoints/2ddddddddd.tar.gz", "weights", start.Add(12*time.Minute))
+	// A checkpoint from before any experiment
+	putTarball("checkpoints/0ccccccccc.tar.gz", "weights", start.Add(-5*time.Minute))
+	// An experiment without a path whose metadata was deleted, but that has a checkpoint
+	// saved in its own file
+	require.NoError(t, project.SaveCheckpoint(ctx, repo, "3eeeeeeeee", &project.Checkpoint{ID: "3ccccccccc", Created: start.Add(20 * time.Minute)}))
+	// An experiment whose metadata is corrupt, without anything to recover it from
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/4eeeeeeeee.json", []byte("{")))
+
+	// Dry runs don't save anything
+	opts := repairOpts{repositoryURL: "file://" + repoDir, dryRun: true}
+	out := new(bytes.Buffer)
+	require.EqualError(t, repair(ctx, opts, out), "Could not recover 2 files")
+	require.Equal(t, `Would recover experiment 2eeeeeeeee with 2 checkpoints
+Would recover experiment 3eeeeeeeee with 0 checkpoints
+Would recover checkpoint 1ddddddddd of experiment 1eeeeeeeee
+
+Could not recover (2):
+  checkpoints/0ccccccccc.tar.gz: No experiment was created before it
+  metadata/experiments/4eeeeeeeee.json: Experiment 4eeeeeeeee's metadata is corrupt, and it has no tarball or checkpoints to recover it from
+`, out.String())
+	_, err = repo.Get(ctx, "metadata/experiments/2eeeeeeeee.json")
+	require.Error(t, err)
+
+	opts.dryRun = false
+	out = new(bytes.Buffer)
+	require.EqualError(t, repair(ctx, opts, out), "Could not recover 2 files")
+	require.Contains(t, out.String(), "Recovered experiment 2eeeeeeeee with 2 checkpoints\n")
+
+	proj := project.NewProject(repo)
+	exp2, err := proj.Experiment(ctx, "2eeeeeeeee")
+	require.NoError(t, err)
+	require.True(t, exp2.Recovered)
+	require.Equal(t, ".", exp2.Path)
+	require.True(t, exp2.Created.Equal(start.Add(10*time.Minute)))
+	require.Len(t, exp2.Checkpoints, 2)
+	require.Equal(t, "2ccccccccc", exp2.Checkpoints[0].ID)
+	require.Equal(t, "weights", exp2.Checkpoints[0].Path)
+	require.True(t, exp2.Checkpoints[0].Recovered)
+	require.True(t, exp2.Checkpoints[1].Created.Equal(start.Add(12*time.Minute)))
+
+	exp3, err := proj.Experiment(ctx, "3eeeeeeeee")
+	require.NoError(t, err)
+	require.True(t, exp3.Recovered)
+	require.Equal(t, "", exp3.Path)
+	require.True(t, exp3.Created.Equal(start.Add(20*time.Minute)))
+	require.Len(t, exp3.Checkpoints, 1)
+
+	exp1, err = proj.Experiment(ctx, "1eeeeeeeee")
+	require.NoError(t, err)
+	require.False(t, exp1.Recovered)
+	require.Len(t, exp1.Checkpoints, 2)
+	require.True(t, exp1.Checkpoints[1].Recovered)
+
+	// Running it again only finds what can't be recovered
+	out = new(bytes.Buffer)
+	require.EqualError(t, repair(ctx, opts, out), "Could not recover 2 files")
+	require.NotContains(t, out.String(), "Recovered")
+}
+
+func TestRepairCheckpointsOfKnownExperiments(t *testing.T) {
+	repoDir, err := files.TempDir("test-repair-known")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+	sourceDir, err := files.TempDir("test-repair-known-source")
+	require.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	require.NoError(t, ioutil.WriteFile(path.Join(sourceDir, "weights"), []byte("1.2kg"), 0644))
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+	start := time.Now().Add(-30 * 24 * time.Hour).Truncate(time.Second).UTC()
+	putTarball := func(p string, mtime time.Time) {
+		require.NoError(t, repo.PutPathTar(ctx, sourceDir, p, "weights"))
+		require.NoError(t, os.Chtimes(path.Join(repoDir, p), mtime, mtime))
+	}
+
+	// An experiment whose metadata was lost weeks ago
+	putTarball("experiments/1eeeeeeeee.tar.gz", start)
+	// A healthy experiment, with a checkpoint whose own metadata was lost
+	tuesday := start.Add(14 * 24 * time.Hour)
+	exp2 := &project.Experiment{ID: "2eeeeeeeee", Created: tuesday}
+	require.NoError(t, exp2.Save(ctx, repo))
+	require.NoError(t, project.SaveCheckpoint(ctx, repo, exp2.ID, &project.Checkpoint{ID: "2ccccccccc", Created: tuesday.Add(time.Minute)}))
+	putTarball("checkpoints/2ddddddddd.tar.gz", tuesday.Add(time.Hour))
+
+	// A healthy experiment that was still running when an experiment whose metadata was lost
+	// was created, so it isn't clear which one a checkpoint without metadata belongs to
+	wednesday := tuesday.Add(24 * time.Hour)
+	exp3 := &project.Experiment{ID: "3eeeeeeeee", Created: wednesday}
+	require.NoError(t, exp3.Save(ctx, repo))
+	require.NoError(t, (&project.Heartbeat{ExperimentID: exp3.ID, LastHeartbeat: wednesday.Add(2 * time.Hour)}).Save(ctx, repo))
+	putTarball("experiments/4eeeeeeeee.tar.gz", wednesday.Add(time.Hour))
+	putTarball("checkpoints/4ccccccccc.tar.gz", wednesday.Add(3*time.Hour))
+
+	opts := repairOpts{repositoryURL: "file://" + repoDir}
+	out := new(bytes.Buffer)
+	require.EqualError(t, repair(ctx, opts, out), "Could not recover 1 files")
+	require.Equal(t, `Recovered experiment 1eeeeeeeee with 0 checkpoints
+Recovered experiment 4eeeeeeeee with 0 checkpoints
+Recovered checkpoint 2ddddddddd of experiment 2eeeeeeeee
+
+Could not recover (1):
+  checkpoints/4ccccccccc.tar.gz: It could belong to experiment 3eeeeeeeee or 4eeeeeeeee, because they were running at the same time
+`, out.String())
+
+	// The checkpoint is added to the experiment it was saved in, not the lost one before it
+	proj := project.NewProject(repo)
+	exp2, err = proj.Experiment(ctx, exp2.ID)
+	require.NoError(t, err)
+	require.False(t, exp2.Recovered)
+	require.Len(t, exp2.Checkpoints, 2)
+	require.Equal(t, "2ddddddddd", exp2.Checkpoints[1].ID)
+	require.True(t, exp2.Checkpoints[1].Recovered)
+	exp1, err := proj.Experiment(ctx, "1eeeeeeeee")
+	require.NoError(t, err)
+	require.Len(t, exp1.Checkpoints, 0)
+}
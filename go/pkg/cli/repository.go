@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func newRepositoryCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repository",
+		Short: "Manage repositories",
+	}
+
+	cmd.AddCommand(
+		newRepositoryIndexCommand(),
+		newRepositoryMigrateCommand(),
+	)
+
+	return cmd
+}
+
+func newRepositoryIndexCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "index",
+		Short: "Generate an index so the repository can be read over HTTP",
+		Long: `Generate an index so the repository can be read over HTTP.
+
+HTTP doesn't have a way of listing files, so repositories that are published
+as a static website or a public bucket need an index of all the files in them.
+Run this command on the repository before publishing it, and every time it
+changes. Collaborators can then read the repository without credentials, with
+commands like "replicate ls -R https://...".
+`,
+		Example: `Generate an index for a repository on S3, then read it over HTTPS:
+replicate repository index -R s3://hooli-hotdog-detector
+replicate ls -R https://hooli-hotdog-detector.s3.amazonaws.com`,
+		Run:  handleErrors(indexRepository),
+		Args: cobra.NoArgs,
+	}
+
+	addRepositoryURLFlag(cmd)
+
+	return cmd
+}
+
+func indexRepository(cmd *cobra.Command, args []string) error {
+	repositoryURL, _, err := getRepositoryURLFromFlagOrConfig(cmd)
+	if err != nil {
+		return err
+	}
+	// Not cached, because the whole repository is listed
+	repo, err := repository.ForURL(repositoryURL)
+	if err != nil {
+		return err
+	}
+	console.Info("Indexing %s...", repo.RootURL())
+	index, err := repository.GenerateHTTPIndex(cmd.Context(), repo)
+	if err != nil {
+		return err
+	}
+	console.Info("Indexed %d files in %s/%s", len(index.Files), repo.RootURL(), repository.HTTPIndexPath)
+	return nil
+}
@@ -1,15 +1,22 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/spf13/cobra"
 
+	"github.com/replicate/replicate/go/pkg/cli/list"
+	"github.com/replicate/replicate/go/pkg/concurrency"
 	"github.com/replicate/replicate/go/pkg/console"
 	"github.com/replicate/replicate/go/pkg/interact"
+	"github.com/replicate/replicate/go/pkg/param"
 	"github.com/replicate/replicate/go/pkg/project"
 )
 
+// Number of experiments that are deleted at the same time
+const rmWorkers = 8
+
 func newRmCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "rm <experiment or checkpoint ID> [experiment or checkpoint ID...]",
@@ -17,9 +24,24 @@ func newRmCommand() *cobra.Command {
 		Long: `Remove experiments or checkpoints.
 
 To remove experiments or checkpoints, pass any number of IDs (or prefixes).
+To remove experiments that match filters, pass --filter (the same as
+'replicate ls --filter').
+
+Removing a checkpoint also removes it from its experiment, so it is no longer
+listed.
+
+Experiments and checkpoints are moved to the trash in the repository, so they
+can be restored with 'replicate restore'. They are deleted for good when the
+trash is emptied with 'replicate trash empty', or straight away if you pass
+--permanent.
 `,
-		Run:        handleErrors(removeExperimentOrCheckpoint),
-		Args:       cobra.MinimumNArgs(1),
+		Run: handleErrors(removeExperimentOrCheckpoint),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && !cmd.Flags().Changed("filter") {
+				return fmt.Errorf("Pass at least one experiment or checkpoint ID, or --filter")
+			}
+			return nil
+		},
 		Aliases:    []string{"delete"},
 		SuggestFor: []string{"remove"},
 		Example: `Delete an experiment and its checkpoints
@@ -28,55 +50,63 @@ replicate rm a1b2c3d4
 
 Delete all experiments where the metric "val_accuracy" is less
 than 0.2 at the best checkpoints:
-replicate rm $(replicate ls -q --filter "val_accuracy < 0.2")
+replicate rm --filter "val_accuracy < 0.2"
 `,
 	}
 
 	addRepositoryURLFlag(cmd)
 	cmd.Flags().BoolP("force", "f", false, "Force delete without interactive prompt")
+	cmd.Flags().Bool("permanent", false, "Delete permanently, instead of moving to the trash")
+	// No shorthand, because -f is --force
+	cmd.Flags().StringArray("filter", []string{}, "Remove experiments that match filters (format: \"<name> <operator> <value>\")")
 
 	return cmd
 }
 
 func removeExperimentOrCheckpoint(cmd *cobra.Command, prefixes []string) error {
+	ctx := cmd.Context()
 	repositoryURL, projectDir, err := getRepositoryURLFromFlagOrConfig(cmd)
 	if err != nil {
 		return err
 	}
-	repo, err := getRepository(repositoryURL, projectDir)
+	force, err := cmd.Flags().GetBool("force")
 	if err != nil {
 		return err
 	}
-	proj := project.NewProject(repo)
+	permanent, err := cmd.Flags().GetBool("permanent")
 	if err != nil {
 		return err
 	}
-	force, err := cmd.Flags().GetBool("force")
+	filters, err := parseListFilterFlag(cmd)
 	if err != nil {
 		return err
 	}
-
-	comOrExps := []*project.CheckpointOrExperiment{}
-	for _, prefix := range prefixes {
-		comOrExp, err := proj.CheckpointOrExperimentFromPrefix(prefix)
-		if err != nil {
-			return err
-		}
-		comOrExps = append(comOrExps, comOrExp)
+	repo, err := getRepository(ctx, repositoryURL, projectDir)
+	if err != nil {
+		return err
 	}
+	proj := project.NewProject(repo)
 
-	if len(comOrExps) == 0 {
+	experiments, checkpoints, err := findObjectsToRemove(ctx, proj, prefixes, filters, cmd.Flags().Changed("filter"))
+	if err != nil {
+		return err
+	}
+	if len(experiments) == 0 && len(checkpoints) == 0 {
+		console.Info("Nothing to remove")
 		return nil
 	}
 
 	if !force {
-		fmt.Println("You are about to delete the following:")
-		for _, comOrExp := range comOrExps {
-			if comOrExp.Experiment != nil {
-				fmt.Printf("* Experiment %s (%d checkpoints)\n", comOrExp.Experiment.ShortID(), len(comOrExp.Experiment.Checkpoints))
-			} else {
-				fmt.Printf("* Checkpoint %s\n", comOrExp.Checkpoint.ShortID())
-			}
+		if permanent {
+			fmt.Println("You are about to permanently delete the following:")
+		} else {
+			fmt.Println("You are about to move the following to the trash:")
+		}
+		for _, exp := range experiments {
+			fmt.Printf("* Experiment %s (%d checkpoints)\n", exp.ShortID(), len(exp.Checkpoints))
+		}
+		for _, com := range checkpoints {
+			fmt.Printf("* Checkpoint %s\n", com.ShortID())
 		}
 		continueDelete, err := interact.InteractiveBool{
 			Prompt:  "\nDo you want to continue?",
@@ -90,30 +120,94 @@ func removeExperimentOrCheckpoint(cmd *cobra.Command, prefixes []string) error {
 		}
 	}
 
+	if permanent {
+		if err := removeObjects(ctx, proj, experiments, checkpoints); err != nil {
+			return err
+		}
+		updateIndex(ctx, repo)
+		return nil
+	}
+	trash, err := proj.MoveToTrash(ctx, experiments, checkpoints)
+	if err != nil {
+		return err
+	}
+	updateIndex(ctx, repo)
+	console.Info("Moved %d experiments and %d checkpoints to the trash at %s/%s", len(experiments), len(checkpoints), repo.RootURL(), trash.Path)
+	console.Info("To restore them, run: replicate restore <ID>")
+	return nil
+}
+
+// findObjectsToRemove returns the experiments and checkpoints that match prefixes, and the
+// experiments that match filters if useFilters is true. Checkpoints of experiments that are
+// being removed are not returned separately.
+func findObjectsToRemove(ctx context.Context, proj *project.Project, prefixes []string, filters *param.Filters, useFilters bool) ([]*project.Experiment, []*project.Checkpoint, error) {
+	experiments := []*project.Experiment{}
+	experimentIDs := map[string]bool{}
+	addExperiment := func(exp *project.Experiment) {
+		if !experimentIDs[exp.ID] {
+			experimentIDs[exp.ID] = true
+			experiments = append(experiments, exp)
+		}
+	}
+	comOrExps := []*project.CheckpointOrExperiment{}
 	for _, prefix := range prefixes {
-		comOrExp, err := proj.CheckpointOrExperimentFromPrefix(prefix)
+		comOrExp, err := proj.CheckpointOrExperimentFromPrefix(ctx, prefix)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		if comOrExp.Checkpoint != nil {
-			console.Info("Removing checkpoint %s...", comOrExp.Checkpoint.ShortID())
-			if err := proj.DeleteCheckpoint(comOrExp.Checkpoint); err != nil {
-				return err
-			}
-		} else {
-			console.Info("Removing experiment %s and its checkpoints...", comOrExp.Experiment.ShortID())
-			experiment := comOrExp.Experiment
-			// This is slow, see https://github.com/replicate/replicate/issues/333
-			for _, checkpoint := range experiment.Checkpoints {
-				if err := proj.DeleteCheckpoint(checkpoint); err != nil {
-					return err
-				}
-			}
-			if err := proj.DeleteExperiment(experiment); err != nil {
-				return err
+		comOrExps = append(comOrExps, comOrExp)
+		if comOrExp.Checkpoint == nil {
+			addExperiment(comOrExp.Experiment)
+		}
+	}
+	if useFilters {
+		ids, err := list.MatchingExperimentIDs(ctx, proj, filters)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, id := range ids {
+			comOrExp, err := proj.CheckpointOrExperimentFromPrefix(ctx, id)
+			if err != nil {
+				return nil, nil, err
 			}
+			addExperiment(comOrExp.Experiment)
 		}
 	}
 
-	return nil
+	checkpoints := []*project.Checkpoint{}
+	checkpointIDs := map[string]bool{}
+	for _, comOrExp := range comOrExps {
+		com := comOrExp.Checkpoint
+		if com == nil || experimentIDs[comOrExp.Experiment.ID] || checkpointIDs[com.ID] {
+			continue
+		}
+		checkpointIDs[com.ID] = true
+		checkpoints = append(checkpoints, com)
+	}
+	return experiments, checkpoints, nil
+}
+
+func removeObjects(ctx context.Context, proj *project.Project, experiments []*project.Experiment, checkpoints []*project.Checkpoint) error {
+	if len(checkpoints) > 0 {
+		for _, com := range checkpoints {
+			console.Info("Removing checkpoint %s...", com.ShortID())
+		}
+		if err := proj.DeleteCheckpoints(ctx, checkpoints); err != nil {
+			return err
+		}
+	}
+
+	queue := concurrency.NewWorkerQueue(ctx, rmWorkers)
+	for _, exp := range experiments {
+		// Variables used in closure
+		exp := exp
+		err := queue.Go(func() error {
+			console.Info("Removing experiment %s and its checkpoints...", exp.ShortID())
+			return proj.DeleteExperiment(queue.Context(), exp)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return queue.Wait()
 }
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/param"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestRemove(t *testing.T) {
+	repoDir, err := files.TempDir("test-rm")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+
+	for i, optimizer := range []string{"adam", "sgd", "sgd"} {
+		exp := &project.Experiment{
+			ID:      string(rune('1'+i)) + "eeeeeeeee",
+			Created: time.Now().Add(time.Duration(i) * time.Minute),
+			Params:  param.ValueMap{"optimizer": param.String(optimizer)},
+			Checkpoints: []*project.Checkpoint{
+				{ID: string(rune('1'+i)) + "ccccccccc", Created: time.Now(), Step: 1},
+				{ID: string(rune('1'+i)) + "ddddddddd", Created: time.Now(), Step: 2},
+			},
+		}
+		require.NoError(t, exp.Save(ctx, repo))
+		require.NoError(t, repo.Put(ctx, exp.StorageTarPath(), []byte("tarball")))
+		for _, chk := range exp.Checkpoints {
+			require.NoError(t, repo.Put(ctx, chk.StorageTarPath(), []byte("tarball")))
+		}
+	}
+
+	proj := project.NewProject(repo)
+	filters, err := param.MakeFilters([]string{"optimizer = sgd"})
+	require.NoError(t, err)
+	// 2ccccccc belongs to an experiment that is removed anyway
+	experiments, checkpoints, err := findObjectsToRemove(ctx, proj, []string{"1ddd", "2ccc", "3eee"}, filters, true)
+	require.NoError(t, err)
+	require.Len(t, experiments, 2)
+	require.Equal(t, "3eeeeeeeee", experiments[0].ID)
+	require.Equal(t, "2eeeeeeeee", experiments[1].ID)
+	require.Len(t, checkpoints, 1)
+	require.Equal(t, "1ddddddddd", checkpoints[0].ID)
+
+	require.NoError(t, removeObjects(ctx, proj, experiments, checkpoints))
+
+	// The checkpoint is removed from its experiment's metadata, so it is no longer the latest
+	proj = project.NewProject(repo)
+	remaining, err := proj.Experiments(ctx)
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	require.Len(t, remaining[0].Checkpoints, 1)
+	require.Equal(t, "1ccccccccc", remaining[0].LatestCheckpoint().ID)
+
+	paths, err := repo.List(ctx, "checkpoints")
+	require.NoError(t, err)
+	require.Equal(t, []string{"checkpoints/1ccccccccc.tar.gz"}, paths)
+	paths, err = repo.List(ctx, "experiments")
+	require.NoError(t, err)
+	require.Equal(t, []string{"experiments/1eeeeeeeee.tar.gz"}, paths)
+}
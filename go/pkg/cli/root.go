@@ -41,10 +41,19 @@ To learn how to get started, go to ` + global.WebURL + `/docs/tutorial`,
 		newRmCommand(),
 		newDiffCommand(),
 		newFeedbackCommand(),
+		newFsckCommand(),
+		newGCCommand(),
 		newGenerateDocsCommand(&rootCmd),
 		newListCommand(),
+		newPruneCommand(),
 		newPsCommand(),
+		newReindexCommand(),
+		newRepairCommand(),
+		newRepositoryCommand(),
+		newRestoreCommand(),
 		newShowCommand(),
+		newTrashCommand(),
+		newUpgradeRepositoryCommand(),
 	)
 
 	return &rootCmd, nil
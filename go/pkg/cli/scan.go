@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+// repositoryScan is the files in a repository, sorted by what they are
+type repositoryScan struct {
+	experimentPaths []string
+	// checkpointPaths is checkpoints saved in their own files, in a directory per experiment
+	checkpointPaths []string
+	heartbeatPaths  []string
+	// tarballs is experiment and checkpoint tarballs, by path
+	tarballs map[string]repository.ListResult
+}
+
+// scanRepository lists the metadata in repo and, if withTarballs is true, the experiment and
+// checkpoint tarballs. Listing tarballs can be slow, because disk repositories checksum them
+// and SFTP repositories download them to do so, so it is only done when they are needed.
+func scanRepository(ctx context.Context, repo repository.Repository, withTarballs bool) (*repositoryScan, error) {
+	scan := &repositoryScan{
+		experimentPaths: []string{},
+		checkpointPaths: []string{},
+		heartbeatPaths:  []string{},
+		tarballs:        map[string]repository.ListResult{},
+	}
+	dirs := []string{"metadata"}
+	if withTarballs {
+		dirs = append(dirs, "experiments", "checkpoints")
+	}
+	for _, dir := range dirs {
+		if err := scan.add(ctx, repo, dir); err != nil {
+			return nil, err
+		}
+	}
+	return scan, nil
+}
+
+// add lists the files in dir and adds them to the scan
+func (s *repositoryScan) add(ctx context.Context, repo repository.Repository, dir string) error {
+	// Cancelled if we return early, so the listing goroutine doesn't block forever
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan repository.ListResult)
+	go repo.ListRecursive(listCtx, results, dir)
+	for result := range results {
+		if result.Error != nil {
+			return fmt.Errorf("Failed to list %s: %w", repo.RootURL(), result.Error)
+		}
+		dir, name := path.Split(result.Path)
+		// Lock and temporary files left by writers
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		switch {
+		case dir == "metadata/experiments/" && strings.HasSuffix(name, ".json"):
+			s.experimentPaths = append(s.experimentPaths, result.Path)
+		case path.Dir(path.Clean(dir)) == "metadata/checkpoints" && strings.HasSuffix(name, ".json"):
+			s.checkpointPaths = append(s.checkpointPaths, result.Path)
+		case dir == "metadata/heartbeats/" && strings.HasSuffix(name, ".json"):
+			s.heartbeatPaths = append(s.heartbeatPaths, result.Path)
+		case (dir == "experiments/" || dir == "checkpoints/") && strings.HasSuffix(name, ".tar.gz"):
+			s.tarballs[result.Path] = result
+		}
+	}
+	return nil
+}
+
+func (s *repositoryScan) hasTarball(p string) bool {
+	_, ok := s.tarballs[p]
+	return ok
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestScanRepository(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repository.NewMemoryRepository("", "")
+	require.NoError(t, err)
+	for _, p := range []string{
+		"metadata/experiments/1eeeeeeeee.json",
+		"metadata/experiments/.1eeeeeeeee.json.lock",
+		"metadata/checkpoints/1eeeeeeeee/1ccccccccc.json",
+		"metadata/heartbeats/1eeeeeeeee.json",
+		"experiments/1eeeeeeeee.tar.gz",
+		"checkpoints/1ccccccccc.tar.gz",
+		"repository.json",
+	} {
+		require.NoError(t, repo.Put(ctx, p, []byte("{}")))
+	}
+
+	scan, err := scanRepository(ctx, repo, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"metadata/experiments/1eeeeeeeee.json"}, scan.experimentPaths)
+	require.Equal(t, []string{"metadata/checkpoints/1eeeeeeeee/1ccccccccc.json"}, scan.checkpointPaths)
+	require.Equal(t, []string{"metadata/heartbeats/1eeeeeeeee.json"}, scan.heartbeatPaths)
+	require.True(t, scan.hasTarball("experiments/1eeeeeeeee.tar.gz"))
+	require.True(t, scan.hasTarball("checkpoints/1ccccccccc.tar.gz"))
+
+	// Only metadata is listed if tarballs aren't needed
+	scan, err = scanRepository(ctx, repo, false)
+	require.NoError(t, err)
+	require.Len(t, scan.experimentPaths, 1)
+	require.Empty(t, scan.tarballs)
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -32,7 +33,7 @@ func newShowCommand() *cobra.Command {
 		Use:   "show <experiment or checkpoint ID>",
 		Short: "View information about an experiment or checkpoint",
 		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
-			return show(opts, args, os.Stdout)
+			return show(cmd.Context(), opts, args, os.Stdout)
 		}),
 		Args: cobra.ExactArgs(1),
 	}
@@ -43,18 +44,18 @@ func newShowCommand() *cobra.Command {
 	return cmd
 }
 
-func show(opts showOpts, args []string, out io.Writer) error {
+func show(ctx context.Context, opts showOpts, args []string, out io.Writer) error {
 	prefix := args[0]
 	repositoryURL, projectDir, err := getRepositoryURLFromStringOrConfig(opts.repositoryURL)
 	if err != nil {
 		return err
 	}
-	repo, err := getRepository(repositoryURL, projectDir)
+	repo, err := getRepository(ctx, repositoryURL, projectDir)
 	if err != nil {
 		return err
 	}
 	proj := project.NewProject(repo)
-	result, err := proj.CheckpointOrExperimentFromPrefix(prefix)
+	result, err := proj.CheckpointOrExperimentFromPrefix(ctx, prefix)
 	if err != nil {
 		return err
 	}
@@ -71,13 +72,17 @@ func show(opts showOpts, args []string, out io.Writer) error {
 	}
 
 	if result.Checkpoint != nil {
-		return showCheckpoint(au, out, proj, result.Experiment, result.Checkpoint)
+		return showCheckpoint(ctx, au, out, proj, result.Experiment, result.Checkpoint)
 	}
-	return showExperiment(au, out, proj, result.Experiment)
+	return showExperiment(ctx, au, out, proj, result.Experiment)
 }
 
-func showCheckpoint(au aurora.Aurora, out io.Writer, proj *project.Project, exp *project.Experiment, com *project.Checkpoint) error {
-	experimentRunning, err := proj.ExperimentIsRunning(exp.ID)
+func showCheckpoint(ctx context.Context, au aurora.Aurora, out io.Writer, proj *project.Project, exp *project.Experiment, com *project.Checkpoint) error {
+	status, err := proj.ExperimentStatus(ctx, exp.ID)
+	if err != nil {
+		return err
+	}
+	exitReason, err := proj.ExperimentExitReason(ctx, exp.ID)
 	if err != nil {
 		return err
 	}
@@ -88,13 +93,16 @@ func showCheckpoint(au aurora.Aurora, out io.Writer, proj *project.Project, exp
 	fmt.Fprintf(w, "Created:\t%s\n", com.Created.In(timezone).Format(time.RFC1123))
 	fmt.Fprintf(w, "Path:\t%s\n", com.Path)
 	fmt.Fprintf(w, "Step:\t%d\n", com.Step)
+	if com.Recovered {
+		fmt.Fprint(w, "Recovered:\tyes, by 'replicate repair', so only its path is known\n")
+	}
 
 	fmt.Fprintf(w, "\t\n")
 	fmt.Fprintf(w, "%s\t\n", au.Bold("Experiment"))
 
 	fmt.Fprintf(w, "ID:\t%s\n", exp.ID)
 
-	writeExperimentCommon(au, w, exp, experimentRunning)
+	writeExperimentCommon(au, w, exp, status, exitReason)
 
 	if err := writeCheckpointMetrics(au, w, proj, com); err != nil {
 		return err
@@ -104,8 +112,12 @@ func showCheckpoint(au aurora.Aurora, out io.Writer, proj *project.Project, exp
 	return w.Flush()
 }
 
-func showExperiment(au aurora.Aurora, out io.Writer, proj *project.Project, exp *project.Experiment) error {
-	experimentRunning, err := proj.ExperimentIsRunning(exp.ID)
+func showExperiment(ctx context.Context, au aurora.Aurora, out io.Writer, proj *project.Project, exp *project.Experiment) error {
+	status, err := proj.ExperimentStatus(ctx, exp.ID)
+	if err != nil {
+		return err
+	}
+	exitReason, err := proj.ExperimentExitReason(ctx, exp.ID)
 	if err != nil {
 		return err
 	}
@@ -113,7 +125,7 @@ func showExperiment(au aurora.Aurora, out io.Writer, proj *project.Project, exp
 	fmt.Fprintf(out, "%s\n\n", au.Underline(au.Bold(fmt.Sprintf("Experiment: %s", exp.ID))))
 
 	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
-	writeExperimentCommon(au, w, exp, experimentRunning)
+	writeExperimentCommon(au, w, exp, status, exitReason)
 	if err := w.Flush(); err != nil {
 		return err
 	}
@@ -161,12 +173,14 @@ func showExperiment(au aurora.Aurora, out io.Writer, proj *project.Project, exp
 	return nil
 }
 
-func writeExperimentCommon(au aurora.Aurora, w *tabwriter.Writer, exp *project.Experiment, experimentRunning bool) {
+func writeExperimentCommon(au aurora.Aurora, w *tabwriter.Writer, exp *project.Experiment, status project.ExperimentStatus, exitReason string) {
 	fmt.Fprintf(w, "Created:\t%s\n", exp.Created.In(timezone).Format(time.RFC1123))
-	if experimentRunning {
-		fmt.Fprint(w, "Status:\trunning\n")
-	} else {
-		fmt.Fprint(w, "Status:\tstopped\n")
+	fmt.Fprintf(w, "Status:\t%s\n", status)
+	if exitReason != "" {
+		fmt.Fprintf(w, "Exit reason:\t%s\n", exitReason)
+	}
+	if exp.Recovered {
+		fmt.Fprint(w, "Recovered:\tyes, by 'replicate repair', so only its path is known\n")
 	}
 	fmt.Fprintf(w, "Host:\t%s\n", exp.Host)
 	fmt.Fprintf(w, "User:\t%s\n", exp.User)
@@ -2,10 +2,13 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"testing"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/replicate/replicate/go/pkg/config"
+	"github.com/replicate/replicate/go/pkg/files"
 	"github.com/replicate/replicate/go/pkg/param"
 	"github.com/replicate/replicate/go/pkg/project"
 	"github.com/replicate/replicate/go/pkg/repository"
@@ -108,11 +112,11 @@ func createShowTestData(t *testing.T, workingDir string, conf *config.Config) re
 		},
 	}}
 	for _, exp := range experiments {
-		require.NoError(t, exp.Save(repo))
+		require.NoError(t, exp.Save(context.Background(), repo))
 	}
 
-	require.NoError(t, project.CreateHeartbeat(repo, experiments[0].ID, time.Now().UTC()))
-	require.NoError(t, project.CreateHeartbeat(repo, experiments[1].ID, time.Now().UTC().Add(-1*time.Minute)))
+	require.NoError(t, project.CreateHeartbeat(context.Background(), repo, experiments[0].ID, time.Now().UTC()))
+	require.NoError(t, project.CreateHeartbeat(context.Background(), repo, experiments[1].ID, time.Now().UTC().Add(-1*time.Minute)))
 
 	return repo
 }
@@ -125,13 +129,13 @@ func TestShowCheckpoint(t *testing.T) {
 	conf := &config.Config{}
 	repo := createShowTestData(t, workingDir, conf)
 	proj := project.NewProject(repo)
-	result, err := proj.CheckpointOrExperimentFromPrefix("3cc")
+	result, err := proj.CheckpointOrExperimentFromPrefix(context.Background(), "3cc")
 	require.NoError(t, err)
 	require.NotNil(t, result.Checkpoint)
 
 	out := new(bytes.Buffer)
 	au := aurora.NewAurora(false)
-	err = showCheckpoint(au, out, proj, result.Experiment, result.Checkpoint)
+	err = showCheckpoint(context.Background(), au, out, proj, result.Experiment, result.Checkpoint)
 	require.NoError(t, err)
 	actual := out.String()
 
@@ -169,7 +173,7 @@ metric-2:        2
 
 	// json
 	out = new(bytes.Buffer)
-	err = show(showOpts{repositoryURL: "file://" + path.Join(workingDir, ".replicate"), json: true}, []string{"3ccc"}, out)
+	err = show(context.Background(), showOpts{repositoryURL: "file://" + path.Join(workingDir, ".replicate"), json: true}, []string{"3ccc"}, out)
 	require.NoError(t, err)
 	var chkpt project.Checkpoint
 	require.NoError(t, json.Unmarshal(out.Bytes(), &chkpt))
@@ -184,13 +188,13 @@ func TestShowExperiment(t *testing.T) {
 	conf := &config.Config{}
 	repo := createShowTestData(t, workingDir, conf)
 	proj := project.NewProject(repo)
-	result, err := proj.CheckpointOrExperimentFromPrefix("1eee")
+	result, err := proj.CheckpointOrExperimentFromPrefix(context.Background(), "1eee")
 	require.NoError(t, err)
 	require.NotNil(t, result.Experiment)
 
 	out := new(bytes.Buffer)
 	au := aurora.NewAurora(false)
-	err = showExperiment(au, out, proj, result.Experiment)
+	err = showExperiment(context.Background(), au, out, proj, result.Experiment)
 	require.NoError(t, err)
 	actual := out.String()
 
@@ -226,7 +230,7 @@ To see more details about a checkpoint, run:
 
 	// json
 	out = new(bytes.Buffer)
-	err = show(showOpts{repositoryURL: "file://" + path.Join(workingDir, ".replicate"), json: true}, []string{"1eee"}, out)
+	err = show(context.Background(), showOpts{repositoryURL: "file://" + path.Join(workingDir, ".replicate"), json: true}, []string{"1eee"}, out)
 	require.NoError(t, err)
 	var exp project.Experiment
 	require.NoError(t, json.Unmarshal(out.Bytes(), &exp))
@@ -234,3 +238,99 @@ To see more details about a checkpoint, run:
 	require.Equal(t, "1ccccccccc", exp.Checkpoints[0].ID)
 
 }
+
+func TestShowCrashedExperiment(t *testing.T) {
+	workingDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(workingDir)
+
+	conf := &config.Config{}
+	repo := createShowTestData(t, workingDir, conf)
+	ctx := context.Background()
+	heartbeat := &project.Heartbeat{
+		ExperimentID:  "2eeeeeeeee",
+		LastHeartbeat: time.Now().UTC(),
+		State:         project.StatusCrashed,
+		ExitReason:    "ValueError: loss is nan",
+	}
+	require.NoError(t, heartbeat.Save(ctx, repo))
+
+	proj := project.NewProject(repo)
+	result, err := proj.CheckpointOrExperimentFromPrefix(ctx, "2eee")
+	require.NoError(t, err)
+	out := new(bytes.Buffer)
+	err = showExperiment(ctx, aurora.NewAurora(false), out, proj, result.Experiment)
+	require.NoError(t, err)
+	actual := testutil.TrimRightLines(out.String())
+	require.Contains(t, actual, `
+Status:          crashed
+Exit reason:     ValueError: loss is nan
+Host:            10.1.1.2
+`)
+}
+
+func TestProjectLoadsExperimentsConcurrently(t *testing.T) {
+	repoDir, err := files.TempDir("test-project-load")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+	// More than are loaded at the same time
+	ids := []string{}
+	for i := 0; i < 40; i++ {
+		id := fmt.Sprintf("%02deeeeeeee", i)
+		ids = append(ids, id)
+		exp := &project.Experiment{ID: id, Created: time.Now()}
+		require.NoError(t, exp.Save(ctx, repo))
+		require.NoError(t, project.CreateHeartbeat(ctx, repo, id, time.Now().UTC()))
+	}
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/99eeeeeeee.json", []byte("not json")))
+
+	// Only the experiment that is asked for is loaded
+	proj := project.NewProject(repo)
+	exp, err := proj.Experiment(ctx, "05eeeeeeee")
+	require.NoError(t, err)
+	require.Equal(t, "05eeeeeeee", exp.ID)
+	_, err = proj.Experiment(ctx, "98eeeeeeee")
+	require.Error(t, err)
+
+	seen := []string{}
+	require.NoError(t, proj.IterateExperiments(ctx, func(e *project.Experiment) error {
+		seen = append(seen, e.ID)
+		if e.ID == "05eeeeeeee" {
+			// The same experiment that was returned before
+			require.True(t, e == exp)
+		}
+		return nil
+	}))
+	sort.Strings(seen)
+	require.Equal(t, ids, seen)
+
+	// Every experiment has been loaded, so they aren't loaded again
+	experiments, err := proj.Experiments(ctx)
+	require.NoError(t, err)
+	require.Len(t, experiments, 40)
+
+	// Stops when fn returns an error, whether the experiments have been loaded or not
+	stop := fmt.Errorf("stop")
+	for _, p := range []*project.Project{proj, project.NewProject(repo)} {
+		n := 0
+		err = p.IterateExperiments(ctx, func(e *project.Experiment) error {
+			n++
+			return stop
+		})
+		require.Equal(t, stop, err)
+		require.Equal(t, 1, n)
+	}
+
+	experiments, err = project.NewProject(repo).Experiments(ctx)
+	require.NoError(t, err)
+	require.Len(t, experiments, 40)
+	for _, id := range ids {
+		status, err := proj.ExperimentStatus(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, project.StatusRunning, status)
+	}
+}
@@ -0,0 +1,218 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/interact"
+	"github.com/replicate/replicate/go/pkg/project"
+)
+
+func newRestoreCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore <experiment or checkpoint ID> [experiment or checkpoint ID...]",
+		Short: "Restore experiments or checkpoints from the trash",
+		Long: `Restore experiments or checkpoints from the trash.
+
+Experiments and checkpoints removed with 'replicate rm' are moved to the trash
+until it is emptied. Pass any number of IDs (or prefixes) to move them back.
+A checkpoint can only be restored if its experiment exists.
+`,
+		Example: `See what is in the trash:
+replicate trash list
+
+Restore an experiment and its checkpoints (where a1b2c3d4 is an experiment ID):
+replicate restore a1b2c3d4`,
+		Run:  handleErrors(restoreFromTrash),
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	addRepositoryURLFlag(cmd)
+
+	return cmd
+}
+
+func restoreFromTrash(cmd *cobra.Command, prefixes []string) error {
+	ctx := cmd.Context()
+	repositoryURL, projectDir, err := getRepositoryURLFromFlagOrConfig(cmd)
+	if err != nil {
+		return err
+	}
+	repo, err := getRepository(ctx, repositoryURL, projectDir)
+	if err != nil {
+		return err
+	}
+	proj := project.NewProject(repo)
+
+	for _, prefix := range prefixes {
+		comOrExp, err := proj.Restore(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		if comOrExp.Checkpoint != nil {
+			console.Info("Restored checkpoint %s to experiment %s", comOrExp.Checkpoint.ShortID(), comOrExp.Experiment.ShortID())
+		} else {
+			console.Info("Restored experiment %s and its %d checkpoints", comOrExp.Experiment.ShortID(), len(comOrExp.Experiment.Checkpoints))
+		}
+	}
+	updateIndex(ctx, repo)
+	return nil
+}
+
+func newTrashCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trash",
+		Short: "Manage experiments and checkpoints in the trash",
+	}
+
+	cmd.AddCommand(
+		newTrashListCommand(),
+		newTrashEmptyCommand(),
+	)
+
+	return cmd
+}
+
+func newTrashListCommand() *cobra.Command {
+	var repositoryURL string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List experiments and checkpoints in the trash",
+		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
+			return listTrash(cmd.Context(), repositoryURL, os.Stdout)
+		}),
+		Args:    cobra.NoArgs,
+		Aliases: []string{"ls"},
+	}
+
+	addRepositoryURLFlagVar(cmd, &repositoryURL)
+
+	return cmd
+}
+
+func listTrash(ctx context.Context, repositoryURL string, out io.Writer) error {
+	repositoryURL, projectDir, err := getRepositoryURLFromStringOrConfig(repositoryURL)
+	if err != nil {
+		return err
+	}
+	repo, err := getRepository(ctx, repositoryURL, projectDir)
+	if err != nil {
+		return err
+	}
+	trashes, err := project.ListTrash(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if len(trashes) == 0 {
+		console.Info("The trash is empty")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DELETED\tTYPE\tID\tEXPERIMENT")
+	for _, trash := range trashes {
+		deleted := console.FormatTime(trash.Deleted)
+		for _, id := range trash.Experiments {
+			fmt.Fprintf(tw, "%s\texperiment\t%s\t\n", deleted, id)
+		}
+		for _, com := range trash.Checkpoints {
+			fmt.Fprintf(tw, "%s\tcheckpoint\t%s\t%s\n", deleted, com.Checkpoint.ID, com.ExperimentID)
+		}
+	}
+	return tw.Flush()
+}
+
+type trashEmptyOpts struct {
+	force         bool
+	olderThan     string
+	repositoryURL string
+}
+
+func newTrashEmptyCommand() *cobra.Command {
+	var opts trashEmptyOpts
+
+	cmd := &cobra.Command{
+		Use:   "empty",
+		Short: "Permanently delete experiments and checkpoints in the trash",
+		Long: `Permanently delete experiments and checkpoints in the trash.
+
+By default, everything in the trash is deleted. Pass --older-than to only
+delete things that were moved to the trash longer ago than that.
+`,
+		Example: `Delete everything that was moved to the trash more than 30 days ago:
+replicate trash empty --older-than 30d`,
+		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
+			return emptyTrash(cmd.Context(), opts)
+		}),
+		Args: cobra.NoArgs,
+	}
+
+	cmd.Flags().BoolVarP(&opts.force, "force", "f", false, "Force delete without interactive prompt")
+	cmd.Flags().StringVar(&opts.olderThan, "older-than", "0", "Only delete things that were moved to the trash longer ago than this (e.g. 30d, 12h)")
+	addRepositoryURLFlagVar(cmd, &opts.repositoryURL)
+
+	return cmd
+}
+
+func emptyTrash(ctx context.Context, opts trashEmptyOpts) error {
+	olderThan, err := parseAge(opts.olderThan)
+	if err != nil {
+		return err
+	}
+	repositoryURL, projectDir, err := getRepositoryURLFromStringOrConfig(opts.repositoryURL)
+	if err != nil {
+		return err
+	}
+	repo, err := getRepository(ctx, repositoryURL, projectDir)
+	if err != nil {
+		return err
+	}
+
+	if !opts.force {
+		continueDelete, err := interact.InteractiveBool{
+			Prompt:  "Do you want to permanently delete what is in the trash?",
+			Default: false,
+		}.Read()
+		if err != nil {
+			return err
+		}
+		if !continueDelete {
+			return nil
+		}
+	}
+
+	deleted, err := project.EmptyTrash(ctx, repo, time.Now().Add(-olderThan))
+	numExperiments, numCheckpoints := 0, 0
+	for _, trash := range deleted {
+		numExperiments += len(trash.Experiments)
+		numCheckpoints += len(trash.Checkpoints)
+	}
+	console.Info("Permanently deleted %d experiments and %d checkpoints", numExperiments, numCheckpoints)
+	return err
+}
+
+// parseAge parses a duration like time.ParseDuration, but also accepts a number of days,
+// like "30d"
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil && days >= 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("Invalid age %q. It should be a number of days like 30d, or a duration like 12h", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestTrash(t *testing.T) {
+	repoDir, err := files.TempDir("test-trash")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		exp := &project.Experiment{
+			ID:      string(rune('1'+i)) + "eeeeeeeee",
+			Created: time.Now(),
+			Checkpoints: []*project.Checkpoint{
+				{ID: string(rune('1'+i)) + "ccccccccc", Created: time.Now().Add(-time.Minute)},
+			},
+		}
+		require.NoError(t, exp.Save(ctx, repo))
+		// Saved in its own file, like newer versions of the Python library do
+		chk := &project.Checkpoint{ID: string(rune('1'+i)) + "ddddddddd", Created: time.Now()}
+		require.NoError(t, project.SaveCheckpoint(ctx, repo, exp.ID, chk))
+		require.NoError(t, repo.Put(ctx, exp.StorageTarPath(), []byte("tarball")))
+		for _, chk := range append(exp.Checkpoints, chk) {
+			require.NoError(t, repo.Put(ctx, chk.StorageTarPath(), []byte("tarball")))
+		}
+	}
+
+	proj := project.NewProject(repo)
+	experiments, checkpoints, err := findObjectsToRemove(ctx, proj, []string{"1eee", "2ddd"}, nil, false)
+	require.NoError(t, err)
+	trash, err := proj.MoveToTrash(ctx, experiments, checkpoints)
+	require.NoError(t, err)
+
+	// Everything is moved, not deleted
+	paths, err := repo.List(ctx, "checkpoints")
+	require.NoError(t, err)
+	require.Equal(t, []string{"checkpoints/2ccccccccc.tar.gz"}, paths)
+	paths, err = repo.List(ctx, trash.Path+"/checkpoints")
+	require.NoError(t, err)
+	require.Len(t, paths, 3)
+	for _, p := range []string{"metadata/experiments/1eeeeeeeee.json", "metadata/checkpoints/1eeeeeeeee/1ddddddddd.json"} {
+		exists, err := files.FileExists(repoDir + "/" + trash.Path + "/" + p)
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+	exists, err := files.FileExists(repoDir + "/metadata/checkpoints/2eeeeeeeee/2ddddddddd.json")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	proj = project.NewProject(repo)
+	remaining, err := proj.Experiments(ctx)
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	require.Len(t, remaining[0].Checkpoints, 1)
+
+	out := new(bytes.Buffer)
+	require.NoError(t, listTrash(ctx, "file://"+repoDir, out))
+	require.Contains(t, out.String(), "experiment  1eeeeeeeee")
+	require.Contains(t, out.String(), "checkpoint  2ddddddddd  2eeeeeeeee")
+
+	// Restore the checkpoint, then the experiment
+	comOrExp, err := proj.Restore(ctx, "2ddd")
+	require.NoError(t, err)
+	require.Equal(t, "2ddddddddd", comOrExp.Checkpoint.ID)
+	require.Equal(t, "2ddddddddd", comOrExp.Experiment.LatestCheckpoint().ID)
+	_, err = proj.Restore(ctx, "2ddd")
+	require.Error(t, err)
+
+	comOrExp, err = proj.Restore(ctx, "1eee")
+	require.NoError(t, err)
+	require.Len(t, comOrExp.Experiment.Checkpoints, 2)
+	experiments, err = proj.Experiments(ctx)
+	require.NoError(t, err)
+	require.Len(t, experiments, 2)
+	paths, err = repo.List(ctx, "checkpoints")
+	require.NoError(t, err)
+	require.Len(t, paths, 4)
+
+	// Nothing left in the trash, so its directory is deleted
+	trashes, err := project.ListTrash(ctx, repo)
+	require.NoError(t, err)
+	require.Empty(t, trashes)
+
+	// Emptying the trash only deletes what is old enough
+	_, err = proj.MoveToTrash(ctx, experiments[:1], nil)
+	require.NoError(t, err)
+	deleted, err := project.EmptyTrash(ctx, repo, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+	deleted, err = project.EmptyTrash(ctx, repo, time.Now())
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	paths, err = repo.List(ctx, "trash")
+	require.NoError(t, err)
+	require.Empty(t, paths)
+}
+
+func TestParseAge(t *testing.T) {
+	age, err := parseAge("30d")
+	require.NoError(t, err)
+	require.Equal(t, 30*24*time.Hour, age)
+	age, err = parseAge("12h")
+	require.NoError(t, err)
+	require.Equal(t, 12*time.Hour, age)
+	age, err = parseAge("0")
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), age)
+	_, err = parseAge("-1d")
+	require.Error(t, err)
+	_, err = parseAge("a month")
+	require.Error(t, err)
+}
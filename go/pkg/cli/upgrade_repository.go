@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/replicate/go/pkg/concurrency"
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+const upgradeWorkers = 8
+
+type upgradeRepositoryOpts struct {
+	dryRun        bool
+	repositoryURL string
+}
+
+func newUpgradeRepositoryCommand() *cobra.Command {
+	var opts upgradeRepositoryOpts
+
+	cmd := &cobra.Command{
+		Use:   "upgrade-repository",
+		Short: "Rewrite metadata saved by older versions of Replicate in the current format",
+		Long: `Rewrite metadata saved by older versions of Replicate in the current format.
+
+Experiment, checkpoint, and heartbeat metadata records which version of the
+metadata format it was saved with. Metadata saved by older versions of
+Replicate is upgraded when it is loaded, so you don't need to run this to read
+it, but rewriting it means the upgrade doesn't have to be done every time.
+
+Metadata saved by a newer version of Replicate is left alone, and reported as
+an error.
+`,
+		Example: `See what would be upgraded, without changing anything:
+replicate upgrade-repository --dry-run`,
+		Run: handleErrors(func(cmd *cobra.Command, args []string) error {
+			return upgradeRepository(cmd.Context(), opts, os.Stdout)
+		}),
+		Args: cobra.NoArgs,
+	}
+
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Print what would be upgraded, without changing anything")
+	addRepositoryURLFlagVar(cmd, &opts.repositoryURL)
+
+	return cmd
+}
+
+func upgradeRepository(ctx context.Context, opts upgradeRepositoryOpts, out io.Writer) error {
+	repositoryURL, projectDir, err := getRepositoryURLFromStringOrConfig(opts.repositoryURL)
+	if err != nil {
+		return err
+	}
+	// Not cached, so every record that is in the repository is upgraded
+	repo, err := getUncachedRepository(repositoryURL, projectDir)
+	if err != nil {
+		return err
+	}
+	console.Info("Finding metadata saved by older versions of Replicate in %s...", repo.RootURL())
+	scan, err := scanRepository(ctx, repo, false)
+	if err != nil {
+		return err
+	}
+	paths := []string{}
+	paths = append(paths, scan.experimentPaths...)
+	paths = append(paths, scan.checkpointPaths...)
+	paths = append(paths, scan.heartbeatPaths...)
+
+	upgraded, failed, err := upgradeRecords(ctx, repo, paths, opts.dryRun)
+	if err != nil {
+		return err
+	}
+	sort.Strings(upgraded)
+	for _, p := range upgraded {
+		if opts.dryRun {
+			fmt.Fprintf(out, "Would upgrade %s\n", p)
+		} else {
+			fmt.Fprintf(out, "Upgraded %s\n", p)
+		}
+	}
+
+	if !opts.dryRun && len(upgraded) > 0 {
+		updateIndex(ctx, repo)
+	}
+	if opts.dryRun {
+		console.Info("Would upgrade %d of %d records to schema version %d", len(upgraded), len(paths), project.SchemaVersion)
+	} else {
+		console.Info("Upgraded %d of %d records to schema version %d", len(upgraded), len(paths), project.SchemaVersion)
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to upgrade %d records", failed)
+	}
+	return nil
+}
+
+// upgradeRecords upgrades the records at paths to the current schema, returning the paths
+// that were upgraded and the number that couldn't be. If dryRun is true, records are only
+// checked.
+func upgradeRecords(ctx context.Context, repo repository.Repository, paths []string, dryRun bool) (upgraded []string, failed int, err error) {
+	var lock sync.Mutex
+	upgraded = []string{}
+	queue := concurrency.NewWorkerQueue(ctx, upgradeWorkers)
+	for _, p := range paths {
+		// Variables used in closure
+		p := p
+		err := queue.Go(func() error {
+			var version int
+			var err error
+			if dryRun {
+				version, err = recordSchemaVersion(queue.Context(), repo, p)
+			} else {
+				version, err = project.UpgradeRecord(queue.Context(), repo, p)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			var doesNotExist *repository.DoesNotExistError
+			switch {
+			case errors.As(err, &doesNotExist):
+				// Deleted since the repository was scanned
+			case err != nil:
+				console.Warn("Failed to upgrade %s: %s", p, err)
+				failed++
+			case version > project.SchemaVersion:
+				console.Warn("Failed to upgrade %s: %s", p, &project.NewerSchemaError{Path: p, Version: version})
+				failed++
+			case version < project.SchemaVersion:
+				upgraded = append(upgraded, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := queue.Wait(); err != nil {
+		return nil, 0, err
+	}
+	return upgraded, failed, nil
+}
+
+func recordSchemaVersion(ctx context.Context, repo repository.Repository, p string) (int, error) {
+	data, err := repo.Get(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	return project.RecordSchemaVersion(data)
+}
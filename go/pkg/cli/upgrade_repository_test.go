@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/param"
+	"github.com/replicate/replicate/go/pkg/project"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestUpgradeRepository(t *testing.T) {
+	repoDir, err := files.TempDir("test-upgrade-repository")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoDir)
+
+	ctx := context.Background()
+	repo, err := repository.NewDiskRepository(repoDir)
+	require.NoError(t, err)
+
+	// Saved before schema versions were recorded
+	legacy := map[string]string{
+		"metadata/experiments/1eeeeeeeee.json":            `{"id": "1eeeeeeeee", "created": "2020-10-07T22:44:06.243914Z", "params": {"learning_rate": 0.01, "epochs": 10}, "checkpoints": [{"id": "1ccccccccc", "created": "2020-10-07T22:45:06.243914Z", "step": 3}]}`,
+		"metadata/checkpoints/1eeeeeeeee/1ddddddddd.json": `{"id": "1ddddddddd", "created": "2020-10-07T22:46:06.243914Z", "step": 4}`,
+		"metadata/heartbeats/1eeeeeeeee.json":             `{"experiment_id": "1eeeeeeeee", "last_heartbeat": "2020-10-07T22:46:06.243914Z"}`,
+	}
+	for p, data := range legacy {
+		require.NoError(t, repo.Put(ctx, p, []byte(data)))
+	}
+	// Saved by a newer version
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/2eeeeeeeee.json", []byte(`{"schema_version": 1000, "id": "2eeeeeeeee", "created": "2020-10-07T22:44:06.243914Z"}`)))
+
+	// Old records are migrated when they are loaded, and newer ones are errors
+	proj := project.NewProject(repo)
+	experiments, err := proj.Experiments(ctx)
+	require.NoError(t, err)
+	require.Len(t, experiments, 1)
+	exp := experiments[0]
+	require.Equal(t, project.SchemaVersion, exp.SchemaVersion)
+	require.Equal(t, param.ValueMap{"learning_rate": param.Float(0.01), "epochs": param.Int(10)}, exp.Params)
+	require.Len(t, exp.Checkpoints, 2)
+	require.Equal(t, 3, exp.Checkpoints[0].Step)
+	require.Equal(t, project.SchemaVersion, exp.Checkpoints[1].SchemaVersion)
+
+	_, err = proj.Experiment(ctx, "2eeeeeeeee")
+	var newer *project.NewerSchemaError
+	require.True(t, errors.As(err, &newer))
+	require.Equal(t, 1000, newer.Version)
+	// Saving it would lose whatever the newer version saved
+	newExp := &project.Experiment{ID: "2eeeeeeeee", Created: time.Now()}
+	require.True(t, errors.As(newExp.Save(ctx, repo), &newer))
+
+	// Dry runs don't change anything
+	opts := upgradeRepositoryOpts{repositoryURL: "file://" + repoDir, dryRun: true}
+	out := new(bytes.Buffer)
+	require.EqualError(t, upgradeRepository(ctx, opts, out), "Failed to upgrade 1 records")
+	require.Equal(t, `Would upgrade metadata/checkpoints/1eeeeeeeee/1ddddddddd.json
+Would upgrade metadata/experiments/1eeeeeeeee.json
+Would upgrade metadata/heartbeats/1eeeeeeeee.json
+`, out.String())
+	for p, data := range legacy {
+		saved, err := repo.Get(ctx, p)
+		require.NoError(t, err)
+		require.Equal(t, data, string(saved))
+	}
+
+	opts.dryRun = false
+	out = new(bytes.Buffer)
+	require.EqualError(t, upgradeRepository(ctx, opts, out), "Failed to upgrade 1 records")
+	require.Contains(t, out.String(), "Upgraded metadata/experiments/1eeeeeeeee.json\n")
+	for p := range legacy {
+		data, err := repo.Get(ctx, p)
+		require.NoError(t, err)
+		version, err := project.RecordSchemaVersion(data)
+		require.NoError(t, err)
+		require.Equal(t, project.SchemaVersion, version, p)
+	}
+	experiments, err = project.NewProject(repo).Experiments(ctx)
+	require.NoError(t, err)
+	require.Equal(t, exp.Params, experiments[0].Params)
+	require.Equal(t, exp.Created, experiments[0].Created)
+	require.Len(t, experiments[0].Checkpoints, 2)
+	require.Equal(t, 3, experiments[0].Checkpoints[0].Step)
+
+	// Once the newer record is gone, there is nothing left to upgrade
+	require.NoError(t, repo.Delete(ctx, "metadata/experiments/2eeeeeeeee.json"))
+	out = new(bytes.Buffer)
+	require.NoError(t, upgradeRepository(ctx, opts, out))
+	require.Empty(t, out.String())
+}
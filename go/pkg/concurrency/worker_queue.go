@@ -8,18 +8,27 @@ import (
 )
 
 type WorkerQueue struct {
-	group *errgroup.Group
-	ctx   context.Context
-	sem   *semaphore.Weighted
+	group     *errgroup.Group
+	parentCtx context.Context
+	ctx       context.Context
+	sem       *semaphore.Weighted
 }
 
+// NewWorkerQueue creates a queue which runs at most maxWorkers at once. If ctx is
+// cancelled, no more workers are started and Wait() returns the context's error.
 func NewWorkerQueue(ctx context.Context, maxWorkers int) *WorkerQueue {
-	wq := &WorkerQueue{}
+	wq := &WorkerQueue{parentCtx: ctx}
 	wq.group, wq.ctx = errgroup.WithContext(ctx)
 	wq.sem = semaphore.NewWeighted(int64(maxWorkers))
 	return wq
 }
 
+// Context returns the context workers should use. It is cancelled when the parent
+// context is cancelled, or when any worker returns an error.
+func (wq *WorkerQueue) Context() context.Context {
+	return wq.ctx
+}
+
 // Go starts a Go routine as a worker. If there are already maxWorkers running, it
 // will block until one of them finishes
 //
@@ -31,6 +40,10 @@ func (wq *WorkerQueue) Go(f func() error) error {
 		return nil
 	}
 	if err := wq.sem.Acquire(wq.ctx, 1); err != nil {
+		// Same as above, if context was cancelled while waiting
+		if wq.ctx.Err() != nil {
+			return nil
+		}
 		return err
 	}
 	wq.group.Go(func() error {
@@ -41,7 +54,11 @@ func (wq *WorkerQueue) Go(f func() error) error {
 }
 
 // Wait until all workers have finished their work. Any errors returned by workers will
-// be returned by this function.
+// be returned by this function. If the parent context was cancelled, its error is
+// returned, because some work may not have been started.
 func (wq *WorkerQueue) Wait() error {
-	return wq.group.Wait()
+	if err := wq.group.Wait(); err != nil {
+		return err
+	}
+	return wq.parentCtx.Err()
 }
@@ -2,11 +2,37 @@ package config
 
 // Config is replicate.yaml
 type Config struct {
-	Repository string `json:"repository"`
+	Repository string            `json:"repository"`
+	S3         *S3Config         `json:"s3,omitempty"`
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
+	Retention  *RetentionConfig  `json:"retention,omitempty"`
 
 	Storage string `json:"storage"` // deprecated
 }
 
+// S3Config configures repositories on S3-compatible services. The options are added to
+// the query string of the repository URL, unless they are already set there.
+type S3Config struct {
+	Endpoint       string `json:"endpoint,omitempty"`
+	Region         string `json:"region,omitempty"`
+	ForcePathStyle *bool  `json:"force_path_style,omitempty"`
+}
+
+// EncryptionConfig enables client-side encryption of the repository. The key is read from
+// either an environment variable or a secret in the user's settings directory.
+type EncryptionConfig struct {
+	KeyEnv    string `json:"key_env,omitempty"`
+	KeySecret string `json:"key_secret,omitempty"`
+}
+
+// RetentionConfig is the policy `replicate prune` uses to decide which checkpoints to keep.
+// A checkpoint is kept if any of the options keep it.
+type RetentionConfig struct {
+	KeepBest   int `json:"keep_best,omitempty"`
+	KeepLatest int `json:"keep_latest,omitempty"`
+	KeepEvery  int `json:"keep_every,omitempty"`
+}
+
 func getDefaultConfig(workingDir string) *Config {
 	// should match defaults in config.py
 	return &Config{}
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 
 	"github.com/ghodss/yaml"
 
@@ -136,9 +138,59 @@ func Parse(text []byte, dir string) (conf *Config, err error) {
 		return nil, fmt.Errorf("Missing required field in replicate.yaml: repository")
 	}
 
+	if conf.S3 != nil {
+		conf.Repository, err = addS3OptionsToURL(conf.Repository, conf.S3)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.Encryption != nil {
+		if (conf.Encryption.KeyEnv == "") == (conf.Encryption.KeySecret == "") {
+			return nil, fmt.Errorf("The 'encryption' option in replicate.yaml must have exactly one of 'key_env' or 'key_secret'")
+		}
+	}
+
+	if conf.Retention != nil {
+		r := conf.Retention
+		if r.KeepBest < 0 || r.KeepLatest < 0 || r.KeepEvery < 0 {
+			return nil, fmt.Errorf("The options in 'retention' in replicate.yaml can't be negative")
+		}
+		if r.KeepBest == 0 && r.KeepLatest == 0 && r.KeepEvery == 0 {
+			return nil, fmt.Errorf("The 'retention' option in replicate.yaml must have at least one of 'keep_best', 'keep_latest', or 'keep_every'")
+		}
+	}
+
 	return conf, nil
 }
 
+// addS3OptionsToURL adds the options in the s3 section of replicate.yaml to the query
+// string of repositoryURL. Options already in the URL take precedence.
+//
+// Keep in sync with add_s3_options_to_url() in config.py
+func addS3OptionsToURL(repositoryURL string, s3Config *S3Config) (string, error) {
+	u, err := url.Parse(repositoryURL)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse repository URL %q: %w", repositoryURL, err)
+	}
+	if u.Scheme != "s3" {
+		return "", fmt.Errorf("The 's3' option in replicate.yaml can only be used with S3 repositories (s3://...)")
+	}
+	query := u.Query()
+	setIfMissing := func(key, value string) {
+		if value != "" && query.Get(key) == "" {
+			query.Set(key, value)
+		}
+	}
+	setIfMissing("endpoint", s3Config.Endpoint)
+	setIfMissing("region", s3Config.Region)
+	if s3Config.ForcePathStyle != nil {
+		setIfMissing("force_path_style", strconv.FormatBool(*s3Config.ForcePathStyle))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
 func FindConfigPath(startFolder string) (configPath string, deprecatedRepositoryProjectRoot string, err error) {
 	folder := startFolder
 	for i := 0; i < maxSearchDepth; i++ {
@@ -74,6 +74,74 @@ func TestStorageBackwardsCompatible(t *testing.T) {
 	}, conf)
 }
 
+func TestParseS3Options(t *testing.T) {
+	conf, err := Parse([]byte(`
+repository: s3://foobar/root?region=eu-west-1
+s3:
+  endpoint: http://localhost:9000
+  region: us-east-1
+  force_path_style: false
+`), "")
+	require.NoError(t, err)
+	// Options in the URL take precedence
+	require.Equal(t, "s3://foobar/root?endpoint=http%3A%2F%2Flocalhost%3A9000&force_path_style=false&region=eu-west-1", conf.Repository)
+
+	_, err = Parse([]byte(`
+repository: gs://foobar
+s3:
+  endpoint: http://localhost:9000
+`), "")
+	require.Error(t, err)
+}
+
+func TestParseEncryption(t *testing.T) {
+	conf, err := Parse([]byte(`
+repository: s3://foobar
+encryption:
+  key_env: HOTDOG_KEY
+`), "")
+	require.NoError(t, err)
+	require.Equal(t, &EncryptionConfig{KeyEnv: "HOTDOG_KEY"}, conf.Encryption)
+
+	_, err = Parse([]byte(`
+repository: s3://foobar
+encryption:
+  key_env: HOTDOG_KEY
+  key_secret: hotdog
+`), "")
+	require.Error(t, err)
+
+	_, err = Parse([]byte(`
+repository: s3://foobar
+encryption: {}
+`), "")
+	require.Error(t, err)
+}
+
+func TestParseRetention(t *testing.T) {
+	conf, err := Parse([]byte(`
+repository: s3://foobar
+retention:
+  keep_best: 3
+  keep_every: 1000
+`), "")
+	require.NoError(t, err)
+	require.Equal(t, &RetentionConfig{KeepBest: 3, KeepEvery: 1000}, conf.Retention)
+
+	_, err = Parse([]byte(`
+repository: s3://foobar
+retention: {}
+`), "")
+	require.Error(t, err)
+
+	_, err = Parse([]byte(`
+repository: s3://foobar
+retention:
+  keep_latest: -1
+`), "")
+	require.Error(t, err)
+}
+
 func TestDeprecatedRepositoryBackwardsCompatible(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "replicate-test")
 	require.NoError(t, err)
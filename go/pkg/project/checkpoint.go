@@ -1,11 +1,17 @@
 package project
 
 import (
+	"context"
+	"encoding/json"
+	"path"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/replicate/replicate/go/pkg/console"
 	"github.com/replicate/replicate/go/pkg/hash"
 	"github.com/replicate/replicate/go/pkg/param"
+	"github.com/replicate/replicate/go/pkg/repository"
 )
 
 type MetricGoal string
@@ -22,20 +28,25 @@ type PrimaryMetric struct {
 
 // Checkpoint is a snapshot of an experiment's filesystem
 type Checkpoint struct {
+	SchemaVersion int            `json:"schema_version"`
 	ID            string         `json:"id"`
 	Created       time.Time      `json:"created"`
 	Metrics       param.ValueMap `json:"metrics"`
 	Step          int            `json:"step"`
 	Path          string         `json:"path"`
 	PrimaryMetric *PrimaryMetric `json:"primary_metric"`
+	// Recovered is true if the checkpoint was rebuilt by `replicate repair` because its
+	// metadata was lost
+	Recovered bool `json:"recovered,omitempty"`
 }
 
 // NewCheckpoint creates a checkpoint with default values
 func NewCheckpoint(metrics param.ValueMap) *Checkpoint {
 	return &Checkpoint{
-		ID:      hash.Random(),
-		Created: time.Now().UTC(),
-		Metrics: metrics,
+		SchemaVersion: SchemaVersion,
+		ID:            hash.Random(),
+		Created:       time.Now().UTC(),
+		Metrics:       metrics,
 	}
 }
 
@@ -57,3 +68,88 @@ func (c *Checkpoint) ShortID() string {
 func (c *Checkpoint) StorageTarPath() string {
 	return "checkpoints/" + c.ID + ".tar.gz"
 }
+
+// SaveCheckpoint saves the metadata of a checkpoint in the experiment with the ID
+// experimentID, in its own file (see Experiment.CheckpointMetadataPath())
+func SaveCheckpoint(ctx context.Context, repo repository.Repository, experimentID string, chk *Checkpoint) error {
+	chk.SchemaVersion = SchemaVersion
+	data, err := json.MarshalIndent(chk, "", " ")
+	if err != nil {
+		return err
+	}
+	exp := &Experiment{ID: experimentID}
+	return repo.Put(ctx, exp.CheckpointMetadataPath(chk.ID), data)
+}
+
+// listCheckpointRecords lists the files in dir that checkpoints are saved in (see
+// Experiment.CheckpointMetadataPath()), by experiment ID
+func listCheckpointRecords(ctx context.Context, repo repository.Repository, dir string) (map[string][]repository.ListResult, error) {
+	// Cancelled if we return early, so the listing goroutine doesn't block forever
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	records := map[string][]repository.ListResult{}
+	results := make(chan repository.ListResult)
+	go repo.ListRecursive(listCtx, results, dir)
+	for result := range results {
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		experimentDir, name := path.Split(result.Path)
+		// Skip lock and temporary files left by writers, and anything that isn't in a
+		// directory for an experiment
+		if path.Dir(path.Clean(experimentDir)) != path.Clean(dir) || !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		experimentID := path.Base(experimentDir)
+		records[experimentID] = append(records[experimentID], result)
+	}
+	return records, nil
+}
+
+// loadCheckpointRecords loads checkpoints from the files at paths. The checkpoint from
+// paths[i] is at index i, or nil if it couldn't be loaded.
+func loadCheckpointRecords(ctx context.Context, repo repository.Repository, paths []string) ([]*Checkpoint, error) {
+	loaded := make([]*Checkpoint, len(paths))
+	err := loadEach(ctx, paths, func(ctx context.Context, i int, p string) error {
+		chk := new(Checkpoint)
+		if err := loadRecord(ctx, repo, checkpointRecord, p, chk); err != nil {
+			// TODO: should this just be ignored? can this be recovered from?
+			console.Warn("Failed to load metadata from %q: %s", p, err)
+			return nil
+		}
+		loaded[i] = chk
+		return nil
+	})
+	return loaded, err
+}
+
+// addCheckpointRecords adds the checkpoints saved in their own files to each of experiments.
+// records is from listCheckpointRecords().
+func addCheckpointRecords(ctx context.Context, repo repository.Repository, experiments []*Experiment, records map[string][]repository.ListResult) error {
+	paths := []string{}
+	experimentIDs := []string{}
+	for _, exp := range experiments {
+		for _, record := range records[exp.ID] {
+			paths = append(paths, record.Path)
+			experimentIDs = append(experimentIDs, exp.ID)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	loaded, err := loadCheckpointRecords(ctx, repo, paths)
+	if err != nil {
+		return err
+	}
+	byExperimentID := map[string][]*Checkpoint{}
+	for i, chk := range loaded {
+		if chk != nil {
+			byExperimentID[experimentIDs[i]] = append(byExperimentID[experimentIDs[i]], chk)
+		}
+	}
+	for _, exp := range experiments {
+		exp.mergeCheckpoints(byExperimentID[exp.ID])
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package project
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"path"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/replicate/replicate/go/pkg/config"
@@ -13,8 +17,13 @@ import (
 	"github.com/replicate/replicate/go/pkg/repository"
 )
 
+// checkpointsMetadataDir is the directory checkpoints are saved in. See
+// Experiment.CheckpointsMetadataDir().
+const checkpointsMetadataDir = "metadata/checkpoints"
+
 // Experiment represents a training run
 type Experiment struct {
+	SchemaVersion    int               `json:"schema_version"`
 	ID               string            `json:"id"`
 	Created          time.Time         `json:"created"`
 	Params           param.ValueMap    `json:"params"`
@@ -26,6 +35,9 @@ type Experiment struct {
 	PythonPackages   map[string]string `json:"python_packages"`
 	Checkpoints      []*Checkpoint     `json:"checkpoints"`
 	ReplicateVersion string            `json:"replicate_version"`
+	// Recovered is true if the experiment was rebuilt by `replicate repair` because its
+	// metadata was lost, so it only has what could be worked out from its files
+	Recovered bool `json:"recovered,omitempty"`
 }
 
 type NamedParam struct {
@@ -36,19 +48,86 @@ type NamedParam struct {
 // NewExperiment creates an experiment, setting ID and Created
 func NewExperiment(params param.ValueMap) *Experiment {
 	return &Experiment{
-		ID:      hash.Random(),
-		Created: time.Now().UTC(),
-		Params:  params,
+		SchemaVersion: SchemaVersion,
+		ID:            hash.Random(),
+		Created:       time.Now().UTC(),
+		Params:        params,
 	}
 }
 
 // Save experiment to repository
-func (e *Experiment) Save(repo repository.Repository) error {
-	data, err := json.MarshalIndent(e, "", " ")
-	if err != nil {
-		return err
+//
+// If the experiment has been saved by something else since it was loaded (e.g. a training
+// process adding checkpoints), the checkpoints that were added are kept, not overwritten.
+// Every other field is overwritten with this copy's, even if something else changed it, so
+// use UpdateExperiment() to change experiments that have already been saved.
+func (e *Experiment) Save(ctx context.Context, repo repository.Repository) error {
+	e.setSchemaVersion()
+	return repository.Update(ctx, repo, e.MetadataPath(), func(data []byte) ([]byte, error) {
+		if data != nil {
+			saved, err := ParseExperiment(e.MetadataPath(), data)
+			var newer *NewerSchemaError
+			if errors.As(err, &newer) {
+				// Overwriting it would lose whatever the newer version saved
+				return nil, err
+			} else if err != nil {
+				console.Warn("Failed to parse %s, overwriting it: %s", e.MetadataPath(), err)
+			} else {
+				e.mergeCheckpoints(saved.Checkpoints)
+			}
+		}
+		return json.MarshalIndent(e, "", " ")
+	})
+}
+
+// UpdateExperiment loads an experiment, calls update to modify it, then saves it. If the
+// experiment is changed by something else in the meantime, update is called again with the
+// new version of the experiment.
+func UpdateExperiment(ctx context.Context, repo repository.Repository, id string, update func(exp *Experiment) error) error {
+	p := path.Join("metadata", "experiments", id+".json")
+	return repository.Update(ctx, repo, p, func(data []byte) ([]byte, error) {
+		if data == nil {
+			return nil, fmt.Errorf("Experiment %s does not exist in %s", id, repo.RootURL())
+		}
+		exp, err := ParseExperiment(p, data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %w", p, err)
+		}
+		if err := update(exp); err != nil {
+			return nil, err
+		}
+		exp.setSchemaVersion()
+		return json.MarshalIndent(exp, "", " ")
+	})
+}
+
+// setSchemaVersion marks the experiment and its checkpoints as being in the current schema,
+// because that is what they are saved as
+func (e *Experiment) setSchemaVersion() {
+	e.SchemaVersion = SchemaVersion
+	for _, chk := range e.Checkpoints {
+		chk.SchemaVersion = SchemaVersion
+	}
+}
+
+// mergeCheckpoints adds checkpoints that aren't in the experiment
+func (e *Experiment) mergeCheckpoints(checkpoints []*Checkpoint) {
+	ids := map[string]bool{}
+	for _, chk := range e.Checkpoints {
+		ids[chk.ID] = true
+	}
+	added := false
+	for _, chk := range checkpoints {
+		if !ids[chk.ID] {
+			e.Checkpoints = append(e.Checkpoints, chk)
+			added = true
+		}
+	}
+	if added {
+		sort.SliceStable(e.Checkpoints, func(i, j int) bool {
+			return e.Checkpoints[i].Created.Before(e.Checkpoints[j].Created)
+		})
 	}
-	return repo.Put(path.Join("metadata", "experiments", e.ID+".json"), data)
 }
 
 func (c *Experiment) SortedParams() []*NamedParam {
@@ -70,6 +149,17 @@ func (e *Experiment) MetadataPath() string {
 	return "metadata/experiments/" + e.ID + ".json"
 }
 
+// CheckpointsMetadataDir is the directory the experiment's checkpoints are saved in, one
+// file per checkpoint, so saving a checkpoint doesn't rewrite every checkpoint before it.
+// Older versions saved checkpoints in the experiment's metadata, so that is read too.
+func (e *Experiment) CheckpointsMetadataDir() string {
+	return path.Join(checkpointsMetadataDir, e.ID)
+}
+
+func (e *Experiment) CheckpointMetadataPath(checkpointID string) string {
+	return path.Join(e.CheckpointsMetadataDir(), checkpointID+".json")
+}
+
 func (e *Experiment) HeartbeatPath() string {
 	return "metadata/heartbeats/" + e.ID + ".json"
 }
@@ -80,83 +170,155 @@ func (e *Experiment) StorageTarPath() string {
 
 // LatestCheckpoint returns the latest checkpoint for an experiment
 func (e *Experiment) LatestCheckpoint() *Checkpoint {
-	if len(e.Checkpoints) == 0 {
+	latest := e.LatestCheckpoints(1)
+	if len(latest) == 0 {
 		return nil
 	}
+	return latest[0]
+}
+
+// LatestCheckpoints returns up to n of the latest checkpoints for an experiment, latest first
+func (e *Experiment) LatestCheckpoints(n int) []*Checkpoint {
 	checkpoints := copyCheckpoints(e.Checkpoints)
-	sort.Slice(checkpoints, func(i, j int) bool {
-		return checkpoints[i].Created.Before(checkpoints[j].Created)
+	sort.SliceStable(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Created.After(checkpoints[j].Created)
 	})
-	return checkpoints[len(checkpoints)-1]
+	if len(checkpoints) > n {
+		checkpoints = checkpoints[:n]
+	}
+	return checkpoints
 }
 
 // BestCheckpoint returns the best checkpoint for an experiment
 // according to the primary metric, or nil if primary metric is not
 // defined or if none of the checkpoints have the primary metric defined
 func (e *Experiment) BestCheckpoint() *Checkpoint {
-	if len(e.Checkpoints) == 0 {
+	best := e.BestCheckpoints(1)
+	if len(best) == 0 {
 		return nil
 	}
-	checkpoints := copyCheckpoints(e.Checkpoints)
+	return best[0]
+}
+
+// BestCheckpoints returns up to n of the best checkpoints for an experiment according to
+// the primary metric, best first. Checkpoints that don't have the primary metric are not
+// returned.
+func (e *Experiment) BestCheckpoints(n int) []*Checkpoint {
+	if len(e.Checkpoints) == 0 {
+		return []*Checkpoint{}
+	}
 
 	// Use primary metric from first checkpoint
 	// TODO (bfirsh): warn if primary metric differs across checkpoints
-	primaryMetric := checkpoints[0].PrimaryMetric
+	primaryMetric := e.Checkpoints[0].PrimaryMetric
 	if primaryMetric == nil {
-		return nil
+		return []*Checkpoint{}
 	}
 
-	sort.Slice(checkpoints, func(i, j int) bool {
-		iVal, iOK := checkpoints[i].Metrics[primaryMetric.Name]
-		jVal, jOK := checkpoints[j].Metrics[primaryMetric.Name]
-		if !iOK {
-			return true
-		}
-		if !jOK {
-			return false
+	checkpoints := []*Checkpoint{}
+	for _, chk := range e.Checkpoints {
+		if _, ok := chk.Metrics[primaryMetric.Name]; ok {
+			checkpoints = append(checkpoints, chk)
 		}
+	}
+
+	sort.SliceStable(checkpoints, func(i, j int) bool {
+		iVal := checkpoints[i].Metrics[primaryMetric.Name]
+		jVal := checkpoints[j].Metrics[primaryMetric.Name]
 		if primaryMetric.Goal == GoalMaximize {
-			less, err := iVal.LessThan(jVal)
+			greater, err := iVal.GreaterThan(jVal)
 			if err != nil {
 				console.Warn("Got error when comparing metrics: %s", err)
 			}
-			return less
+			return greater
 		} else {
-			greater, err := iVal.GreaterThan(jVal)
+			less, err := iVal.LessThan(jVal)
 			if err != nil {
 				console.Warn("Got error when comparing metrics: %s", err)
 			}
-			return greater
+			return less
 		}
 	})
-	best := checkpoints[len(checkpoints)-1]
-
-	// if the last (best) checkpoint in the sorted list doesn't have
-	// a value for the primary metric, none of them do
-	if _, ok := best.Metrics[primaryMetric.Name]; !ok {
-		return nil
+	if len(checkpoints) > n {
+		checkpoints = checkpoints[:n]
 	}
-	return best
+	return checkpoints
 }
 
-func listExperiments(repo repository.Repository) ([]*Experiment, error) {
-	paths, err := repo.List("metadata/experiments/")
+func listExperiments(ctx context.Context, repo repository.Repository) ([]*Experiment, error) {
+	paths, err := listExperimentPaths(ctx, repo)
 	if err != nil {
 		return nil, err
 	}
-	experiments := []*Experiment{}
-	for _, p := range paths {
+	// Loaded concurrently, but kept in the order they were listed
+	loaded := make([]*Experiment, len(paths))
+	err = loadEach(ctx, paths, func(ctx context.Context, i int, p string) error {
 		exp := new(Experiment)
-		if err := loadFromPath(repo, p, exp); err == nil {
-			experiments = append(experiments, exp)
-		} else {
+		if err := loadRecord(ctx, repo, experimentRecord, p, exp); err != nil {
 			// TODO: should this just be ignored? can this be recovered from?
 			console.Warn("Failed to load metadata from %q: %s", p, err)
+			return nil
 		}
+		loaded[i] = exp
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	experiments := []*Experiment{}
+	for _, exp := range loaded {
+		if exp != nil {
+			experiments = append(experiments, exp)
+		}
+	}
+	records, err := listCheckpointRecords(ctx, repo, checkpointsMetadataDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := addCheckpointRecords(ctx, repo, experiments, records); err != nil {
+		return nil, err
 	}
 	return experiments, nil
 }
 
+// loadExperiment loads the experiment with the ID id, including the checkpoints saved in
+// their own files
+func loadExperiment(ctx context.Context, repo repository.Repository, id string) (*Experiment, error) {
+	exp := &Experiment{ID: id}
+	if err := loadRecord(ctx, repo, experimentRecord, exp.MetadataPath(), exp); err != nil {
+		return nil, err
+	}
+	paths, err := repo.List(ctx, exp.CheckpointsMetadataDir()+"/")
+	if err != nil {
+		return nil, err
+	}
+	records := []repository.ListResult{}
+	for _, p := range paths {
+		if strings.HasSuffix(p, ".json") && !strings.HasPrefix(path.Base(p), ".") {
+			records = append(records, repository.ListResult{Path: p})
+		}
+	}
+	err = addCheckpointRecords(ctx, repo, []*Experiment{exp}, map[string][]repository.ListResult{id: records})
+	return exp, err
+}
+
+// listExperimentPaths lists the paths of experiments' metadata
+func listExperimentPaths(ctx context.Context, repo repository.Repository) ([]string, error) {
+	paths, err := repo.List(ctx, "metadata/experiments/")
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, p := range paths {
+		// Skip lock and temporary files left by writers
+		if !strings.HasSuffix(p, ".json") || strings.HasPrefix(path.Base(p), ".") {
+			continue
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
 func copyCheckpoints(checkpoints []*Checkpoint) []*Checkpoint {
 	copied := make([]*Checkpoint, len(checkpoints))
 	copy(copied, checkpoints)
@@ -0,0 +1,57 @@
+package project
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/param"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+func TestExperimentSaveOnlyMergesCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repository.NewMemoryRepository("", "")
+	require.NoError(t, err)
+
+	exp := &Experiment{
+		ID:      "1eeeeeeeee",
+		Created: time.Now().UTC(),
+		Params:  param.ValueMap{"learning_rate": param.Float(0.01)},
+	}
+	require.NoError(t, exp.Save(ctx, repo))
+
+	load := func() *Experiment {
+		data, err := repo.Get(ctx, exp.MetadataPath())
+		require.NoError(t, err)
+		loaded, err := ParseExperiment(exp.MetadataPath(), data)
+		require.NoError(t, err)
+		return loaded
+	}
+
+	// Something else loads the experiment, changes it, and saves it
+	other := load()
+	other.Params = param.ValueMap{"learning_rate": param.Float(0.1)}
+	other.Checkpoints = append(other.Checkpoints, &Checkpoint{ID: "1ccccccccc", Created: time.Now().UTC()})
+	require.NoError(t, other.Save(ctx, repo))
+
+	// Checkpoints it added are kept, but everything else is overwritten
+	require.NoError(t, exp.Save(ctx, repo))
+	saved := load()
+	require.Len(t, saved.Checkpoints, 1)
+	require.Equal(t, param.Float(0.01), saved.Params["learning_rate"])
+
+	// UpdateExperiment changes the latest version, so nothing else is overwritten
+	other.Params = param.ValueMap{"learning_rate": param.Float(0.1)}
+	require.NoError(t, other.Save(ctx, repo))
+	require.NoError(t, UpdateExperiment(ctx, repo, exp.ID, func(e *Experiment) error {
+		e.Command = "train.py"
+		return nil
+	}))
+	saved = load()
+	require.Equal(t, "train.py", saved.Command)
+	require.Equal(t, param.Float(0.1), saved.Params["learning_rate"])
+	require.Len(t, saved.Checkpoints, 1)
+}
@@ -1,8 +1,8 @@
 package project
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"path"
 	"time"
 
@@ -14,57 +14,102 @@ import (
 var heartbeatRefreshInterval = 10 * time.Second
 
 // the number of missed heartbeats we tolerate before declaring
-// the experiment "stopped"
+// the experiment's status "unknown"
 var heartbeatMissTolerance = 3
 
+// ExperimentStatus is where an experiment is in its lifecycle
+type ExperimentStatus string
+
+const (
+	StatusRunning  ExperimentStatus = "running"
+	StatusFinished ExperimentStatus = "finished"
+	StatusCrashed  ExperimentStatus = "crashed"
+	// StatusUnknown is experiments that stopped sending heartbeats without recording how
+	// they ended, e.g. because they were killed, or were saved by an older version of
+	// Replicate
+	StatusUnknown ExperimentStatus = "unknown"
+)
+
 type Heartbeat struct {
+	SchemaVersion int       `json:"schema_version"`
 	ExperimentID  string    `json:"experiment_id"`
 	LastHeartbeat time.Time `json:"last_heartbeat"`
+	// State is how the experiment ended, StatusFinished or StatusCrashed. It is written
+	// with the last heartbeat, and is empty while the experiment is running.
+	State ExperimentStatus `json:"state,omitempty"`
+	// ExitReason is why the experiment ended, e.g. the exception it crashed with
+	ExitReason string `json:"exit_reason,omitempty"`
 }
 
-func CreateHeartbeat(repo repository.Repository, experimentID string, t time.Time) error {
+func CreateHeartbeat(ctx context.Context, repo repository.Repository, experimentID string, t time.Time) error {
 	heartbeat := &Heartbeat{
 		ExperimentID:  experimentID,
 		LastHeartbeat: t,
 	}
-	data, err := json.MarshalIndent(heartbeat, "", " ")
+	return heartbeat.Save(ctx, repo)
+}
+
+// Save writes the heartbeat to the repository, replacing the experiment's previous one
+func (h *Heartbeat) Save(ctx context.Context, repo repository.Repository) error {
+	h.SchemaVersion = SchemaVersion
+	data, err := json.MarshalIndent(h, "", " ")
 	if err != nil {
 		return err
 	}
-	return repo.Put(path.Join("metadata", "heartbeats", experimentID+".json"), data)
+	return repo.Put(ctx, path.Join("metadata", "heartbeats", h.ExperimentID+".json"), data)
 }
 
-func listHeartbeats(repo repository.Repository) ([]*Heartbeat, error) {
-	paths, err := repo.List("metadata/heartbeats/")
+func listHeartbeats(ctx context.Context, repo repository.Repository) ([]*Heartbeat, error) {
+	paths, err := repo.List(ctx, "metadata/heartbeats/")
 	if err != nil {
 		return nil, err
 	}
-	heartbeats := []*Heartbeat{}
-	for _, p := range paths {
-		if hb, err := loadHeartbeatFromPath(repo, p); err == nil {
-			heartbeats = append(heartbeats, hb)
-		} else {
+	// Loaded concurrently, but kept in the order they were listed
+	loaded := make([]*Heartbeat, len(paths))
+	err = loadEach(ctx, paths, func(ctx context.Context, i int, p string) error {
+		hb, err := loadHeartbeatFromPath(ctx, repo, p)
+		if err != nil {
 			// TODO: should this just be ignored? can this be recovered from?
 			console.Warn("Failed to load metadata from %q: %s", p, err)
+			return nil
+		}
+		loaded[i] = hb
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	heartbeats := []*Heartbeat{}
+	for _, hb := range loaded {
+		if hb != nil {
+			heartbeats = append(heartbeats, hb)
 		}
 	}
 	return heartbeats, nil
 }
 
+// Status returns the status of the heartbeat's experiment. How it ended takes precedence
+// over when the last heartbeat was.
+func (h *Heartbeat) Status() ExperimentStatus {
+	if h.State != "" {
+		return h.State
+	}
+	if h.IsRunning() {
+		return StatusRunning
+	}
+	return StatusUnknown
+}
+
 func (h *Heartbeat) IsRunning() bool {
 	now := time.Now().UTC()
 	lastTolerableHeartbeat := now.Add(-heartbeatRefreshInterval * time.Duration(heartbeatMissTolerance))
 	return h.LastHeartbeat.After(lastTolerableHeartbeat)
 }
 
-func loadHeartbeatFromPath(repo repository.Repository, path string) (*Heartbeat, error) {
-	contents, err := repo.Get(path)
-	if err != nil {
-		return nil, err
-	}
+func loadHeartbeatFromPath(ctx context.Context, repo repository.Repository, path string) (*Heartbeat, error) {
 	hb := new(Heartbeat)
-	if err := json.Unmarshal(contents, hb); err != nil {
-		return nil, fmt.Errorf("Parse error: %s", err)
+	if err := loadRecord(ctx, repo, heartbeatRecord, path, hb); err != nil {
+		return nil, err
 	}
 	return hb, nil
 }
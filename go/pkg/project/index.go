@@ -0,0 +1,257 @@
+package project
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/replicate/replicate/go/pkg/config"
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/param"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+// IndexPath is a summary of every experiment in a repository, so experiments can be listed
+// without loading every experiment's metadata
+//
+// Each entry records the checksum of the metadata files it was made from. Entries whose
+// metadata has changed since, and experiments that aren't in the index, are loaded from
+// their metadata instead. So, it doesn't matter if something writes experiments without
+// updating the index. Reading experiments doesn't save the index: commands that change
+// experiments update it with UpdateIndex(), and `replicate reindex` rebuilds it from scratch.
+const IndexPath = "metadata/index.json"
+
+// ExperimentSummary is the part of an experiment that is needed to list it
+type ExperimentSummary struct {
+	ID               string         `json:"id"`
+	Created          time.Time      `json:"created"`
+	Params           param.ValueMap `json:"params"`
+	Host             string         `json:"host"`
+	User             string         `json:"user"`
+	Config           *config.Config `json:"config"`
+	Command          string         `json:"command"`
+	CheckpointIDs    []string       `json:"checkpoint_ids"`
+	LatestCheckpoint *Checkpoint    `json:"latest_checkpoint"`
+	BestCheckpoint   *Checkpoint    `json:"best_checkpoint"`
+}
+
+// NewExperimentSummary summarizes exp
+func NewExperimentSummary(exp *Experiment) *ExperimentSummary {
+	checkpointIDs := []string{}
+	for _, chk := range exp.Checkpoints {
+		checkpointIDs = append(checkpointIDs, chk.ID)
+	}
+	return &ExperimentSummary{
+		ID:               exp.ID,
+		Created:          exp.Created,
+		Params:           exp.Params,
+		Host:             exp.Host,
+		User:             exp.User,
+		Config:           exp.Config,
+		Command:          exp.Command,
+		CheckpointIDs:    checkpointIDs,
+		LatestCheckpoint: exp.LatestCheckpoint(),
+		BestCheckpoint:   exp.BestCheckpoint(),
+	}
+}
+
+// index is the contents of IndexPath
+type index struct {
+	Experiments map[string]*indexEntry `json:"experiments"`
+}
+
+type indexEntry struct {
+	// Checksum is the checksum of the experiment's metadata when it was summarized. See
+	// indexChecksum().
+	Checksum string `json:"checksum"`
+	ExperimentSummary
+}
+
+func newIndex() *index {
+	return &index{Experiments: map[string]*indexEntry{}}
+}
+
+// loadIndex returns an empty index if there isn't one or it can't be read, because anything
+// that isn't in it is loaded from experiments' metadata
+func loadIndex(ctx context.Context, repo repository.Repository) *index {
+	idx := newIndex()
+	if err := loadFromPath(ctx, repo, IndexPath, idx); err != nil {
+		var doesNotExist *repository.DoesNotExistError
+		if !errors.As(err, &doesNotExist) {
+			console.Debug("Failed to load %s, loading every experiment instead: %s", IndexPath, err)
+		}
+		return newIndex()
+	}
+	if idx.Experiments == nil {
+		idx.Experiments = map[string]*indexEntry{}
+	}
+	return idx
+}
+
+func (idx *index) save(ctx context.Context, repo repository.Repository) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := repo.Put(ctx, IndexPath, data); err != nil {
+		return fmt.Errorf("Failed to save %s: %w", IndexPath, err)
+	}
+	return nil
+}
+
+// listExperimentFiles lists experiments' metadata files, by experiment ID
+func listExperimentFiles(ctx context.Context, repo repository.Repository) (map[string]repository.ListResult, error) {
+	// Cancelled if we return early, so the listing goroutine doesn't block forever
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	files := map[string]repository.ListResult{}
+	results := make(chan repository.ListResult)
+	go repo.ListRecursive(listCtx, results, "metadata/experiments")
+	for result := range results {
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		dir, name := path.Split(result.Path)
+		// Skip lock and temporary files left by writers, and anything in subdirectories
+		if path.Clean(dir) != "metadata/experiments" || !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		files[strings.TrimSuffix(name, ".json")] = result
+	}
+	return files, nil
+}
+
+// loadSummaries summarizes every experiment, using the index for experiments that haven't
+// changed since they were indexed, unless rebuild is true. If save is true and the index is
+// out of date, it is saved.
+//
+// Experiments that had to be loaded are returned too, so they don't have to be loaded again.
+func loadSummaries(ctx context.Context, repo repository.Repository, rebuild bool, save bool) (summaries []*ExperimentSummary, loaded []*Experiment, err error) {
+	experimentFiles, err := listExperimentFiles(ctx, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	records, err := listCheckpointRecords(ctx, repo, checkpointsMetadataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	oldIndex := newIndex()
+	if !rebuild {
+		oldIndex = loadIndex(ctx, repo)
+	}
+
+	newIdx := newIndex()
+	summaries = []*ExperimentSummary{}
+	paths := []string{}
+	checksums := map[string]string{}
+	for id, file := range experimentFiles {
+		checksum := indexChecksum(file, records[id])
+		checksums[id] = checksum
+		if entry, ok := oldIndex.Experiments[id]; ok && checksum != "" && entry.Checksum == checksum {
+			summaries = append(summaries, &entry.ExperimentSummary)
+			newIdx.Experiments[id] = entry
+			continue
+		}
+		paths = append(paths, file.Path)
+	}
+	loadedByIndex := make([]*Experiment, len(paths))
+	err = loadEach(ctx, paths, func(ctx context.Context, i int, p string) error {
+		exp := new(Experiment)
+		if err := loadRecord(ctx, repo, experimentRecord, p, exp); err != nil {
+			// TODO: should this just be ignored? can this be recovered from?
+			console.Warn("Failed to load metadata from %q: %s", p, err)
+			return nil
+		}
+		loadedByIndex[i] = exp
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	loaded = []*Experiment{}
+	for _, exp := range loadedByIndex {
+		if exp != nil {
+			loaded = append(loaded, exp)
+		}
+	}
+	if err := addCheckpointRecords(ctx, repo, loaded, records); err != nil {
+		return nil, nil, err
+	}
+
+	for _, exp := range loaded {
+		summary := NewExperimentSummary(exp)
+		summaries = append(summaries, summary)
+		// Without a checksum, there is no way of telling whether it is out of date
+		if checksum := checksums[exp.ID]; checksum != "" {
+			newIdx.Experiments[exp.ID] = &indexEntry{Checksum: checksum, ExperimentSummary: *summary}
+		}
+	}
+
+	if save && (rebuild || !newIdx.equal(oldIndex)) {
+		if repository.IsReadOnly(repo) {
+			return nil, nil, fmt.Errorf("Can't save the index, because %s is read-only", repo.RootURL())
+		}
+		if err := newIdx.save(ctx, repo); err != nil {
+			return nil, nil, err
+		}
+	}
+	return summaries, loaded, nil
+}
+
+// indexChecksum returns the checksum of an experiment's metadata file and the files its
+// checkpoints are saved in, or "" if any of them doesn't have a checksum
+func indexChecksum(file repository.ListResult, records []repository.ListResult) string {
+	checksum := file.Checksum.String()
+	if checksum == "" || len(records) == 0 {
+		return checksum
+	}
+	lines := []string{}
+	for _, record := range records {
+		if record.Checksum.IsZero() {
+			return ""
+		}
+		lines = append(lines, path.Base(record.Path)+" "+record.Checksum.String())
+	}
+	sort.Strings(lines)
+	combined := md5.Sum([]byte(checksum + "\n" + strings.Join(lines, "\n")))
+	return hex.EncodeToString(combined[:])
+}
+
+// equal returns true if the indexes have the same experiments with the same checksums
+func (idx *index) equal(other *index) bool {
+	if len(idx.Experiments) != len(other.Experiments) {
+		return false
+	}
+	for id, entry := range idx.Experiments {
+		otherEntry, ok := other.Experiments[id]
+		if !ok || otherEntry.Checksum != entry.Checksum {
+			return false
+		}
+	}
+	return true
+}
+
+// Reindex rebuilds the index of the repository's experiments from their metadata, and
+// returns the number of experiments in it
+func Reindex(ctx context.Context, repo repository.Repository) (int, error) {
+	summaries, _, err := loadSummaries(ctx, repo, true, true)
+	if err != nil {
+		return 0, err
+	}
+	return len(summaries), nil
+}
+
+// UpdateIndex saves the index if any experiments have changed since they were indexed. It is
+// called after changing experiments, so listing them doesn't have to load them again.
+func UpdateIndex(ctx context.Context, repo repository.Repository) error {
+	_, _, err := loadSummaries(ctx, repo, false, true)
+	return err
+}
@@ -1,33 +1,49 @@
 package project
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/replicate/replicate/go/pkg/concurrency"
 	"github.com/replicate/replicate/go/pkg/console"
 	"github.com/replicate/replicate/go/pkg/repository"
 )
 
+// Number of files that are deleted at the same time
+const deleteWorkers = 16
+
+// Number of metadata files that are loaded at the same time
+const loadWorkers = 16
+
 // Project is essentially a data access object for retrieving
 // metadata objects
 type Project struct {
-	repository        repository.Repository
-	experimentsByID   map[string]*Experiment
-	heartbeatsByExpID map[string]*Heartbeat
-	hasLoaded         bool
+	repository repository.Repository
+	// experimentsByID has the experiments that have been loaded. It has every experiment if
+	// hasLoaded is true.
+	experimentsByID     map[string]*Experiment
+	summariesByID       map[string]*ExperimentSummary
+	heartbeatsByExpID   map[string]*Heartbeat
+	hasLoaded           bool
+	hasLoadedSummaries  bool
+	hasLoadedHeartbeats bool
 }
 
 func NewProject(repo repository.Repository) *Project {
 	return &Project{
-		repository: repo,
-		hasLoaded:  false,
+		repository:      repo,
+		experimentsByID: map[string]*Experiment{},
+		hasLoaded:       false,
 	}
 }
 
 // Experiments returns all experiments in this project
-func (p *Project) Experiments() ([]*Experiment, error) {
-	if err := p.ensureLoaded(); err != nil {
+func (p *Project) Experiments(ctx context.Context) ([]*Experiment, error) {
+	if err := p.ensureLoaded(ctx); err != nil {
 		return nil, err
 	}
 	experiments := []*Experiment{}
@@ -37,17 +53,53 @@ func (p *Project) Experiments() ([]*Experiment, error) {
 	return experiments, nil
 }
 
-func (p *Project) ExperimentIsRunning(experimentID string) (bool, error) {
-	if err := p.ensureLoaded(); err != nil {
-		return false, err
+// ExperimentSummaries returns a summary of every experiment in this project. It is much
+// faster than Experiments(), because it uses the index (see IndexPath).
+func (p *Project) ExperimentSummaries(ctx context.Context) ([]*ExperimentSummary, error) {
+	if err := p.ensureSummariesLoaded(ctx); err != nil {
+		return nil, err
+	}
+	summaries := []*ExperimentSummary{}
+	for _, summary := range p.summariesByID {
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// ExperimentStatus returns whether an experiment is running, finished, crashed, or if that
+// is unknown, from its heartbeat
+func (p *Project) ExperimentStatus(ctx context.Context, experimentID string) (ExperimentStatus, error) {
+	heartbeat, err := p.experimentHeartbeat(ctx, experimentID)
+	if err != nil {
+		return "", err
+	}
+	if heartbeat == nil {
+		return StatusUnknown, nil
+	}
+	return heartbeat.Status(), nil
+}
+
+// ExperimentExitReason returns why an experiment ended, e.g. the exception it crashed with,
+// or an empty string if it is still running or it wasn't recorded
+func (p *Project) ExperimentExitReason(ctx context.Context, experimentID string) (string, error) {
+	heartbeat, err := p.experimentHeartbeat(ctx, experimentID)
+	if err != nil || heartbeat == nil {
+		return "", err
+	}
+	return heartbeat.ExitReason, nil
+}
+
+// experimentHeartbeat returns an experiment's heartbeat, or nil if it doesn't have one
+func (p *Project) experimentHeartbeat(ctx context.Context, experimentID string) (*Heartbeat, error) {
+	if err := p.ensureHeartbeatsLoaded(ctx); err != nil {
+		return nil, err
 	}
 	heartbeat, ok := p.heartbeatsByExpID[experimentID]
 	if !ok {
-		// TODO(bfirsh): unknown state? https://github.com/replicate/replicate/issues/36
 		console.Debug("No heartbeat found for experiment %s", experimentID)
-		return false, nil
+		return nil, nil
 	}
-	return heartbeat.IsRunning(), nil
+	return heartbeat, nil
 }
 
 type CheckpointOrExperiment struct {
@@ -58,21 +110,27 @@ type CheckpointOrExperiment struct {
 // CheckpointOrExperimentFromPrefix returns a checkpoint/experiment given a
 // prefix. This is a single function so we can detect ambiguities
 // across both checkpoints and experiments.
-func (p *Project) CheckpointOrExperimentFromPrefix(prefix string) (*CheckpointOrExperiment, error) {
-	if err := p.ensureLoaded(); err != nil {
+//
+// It is matched against the summaries of experiments, then only the experiment that matches
+// is loaded.
+func (p *Project) CheckpointOrExperimentFromPrefix(ctx context.Context, prefix string) (*CheckpointOrExperiment, error) {
+	if err := p.ensureSummariesLoaded(ctx); err != nil {
 		return nil, err
 	}
 
-	matches := []*CheckpointOrExperiment{}
-	for id := range p.experimentsByID {
-		exp := p.experimentsByID[id]
+	type match struct {
+		experimentID string
+		checkpointID string
+	}
+	matches := []match{}
+	for id, summary := range p.summariesByID {
 		if strings.HasPrefix(id, prefix) {
-			matches = append(matches, &CheckpointOrExperiment{Experiment: exp})
+			matches = append(matches, match{experimentID: id})
 		}
 
-		for _, checkpoint := range exp.Checkpoints {
-			if strings.HasPrefix(checkpoint.ID, prefix) {
-				matches = append(matches, &CheckpointOrExperiment{Experiment: exp, Checkpoint: checkpoint})
+		for _, checkpointID := range summary.CheckpointIDs {
+			if strings.HasPrefix(checkpointID, prefix) {
+				matches = append(matches, match{experimentID: id, checkpointID: checkpointID})
 			}
 		}
 	}
@@ -83,62 +141,329 @@ func (p *Project) CheckpointOrExperimentFromPrefix(prefix string) (*CheckpointOr
 	if len(matches) > 1 {
 		return nil, fmt.Errorf("Prefix is ambiguous: %s (%d matching checkpoints/experiments)", prefix, len(matches))
 	}
-	return matches[0], nil
+
+	m := matches[0]
+	exp, err := p.Experiment(ctx, m.experimentID)
+	if err != nil {
+		return nil, err
+	}
+	if m.checkpointID == "" {
+		return &CheckpointOrExperiment{Experiment: exp}, nil
+	}
+	for _, checkpoint := range exp.Checkpoints {
+		if checkpoint.ID == m.checkpointID {
+			return &CheckpointOrExperiment{Experiment: exp, Checkpoint: checkpoint}, nil
+		}
+	}
+	return nil, fmt.Errorf("Checkpoint %s is not in experiment %s any more", m.checkpointID, m.experimentID)
 }
 
-func (p *Project) DeleteCheckpoint(com *Checkpoint) error {
-	if err := p.repository.Delete(com.StorageTarPath()); err != nil {
-		console.Warn("Failed to delete checkpoint storage directory %s: %s", com.StorageTarPath(), err)
+// Experiment returns the experiment with the full ID id. Only that experiment is loaded,
+// if it hasn't been loaded already.
+func (p *Project) Experiment(ctx context.Context, id string) (*Experiment, error) {
+	if exp, ok := p.experimentsByID[id]; ok {
+		return exp, nil
+	}
+	exp, err := loadExperiment(ctx, p.repository, id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load experiment %s: %w", id, err)
+	}
+	p.experimentsByID[id] = exp
+	return exp, nil
+}
+
+// IterateExperiments calls fn with each experiment in this project, as they are loaded, so
+// it can start work before every experiment has been loaded. Experiments are loaded
+// concurrently, in no particular order, but fn is not called concurrently.
+//
+// If fn returns an error, no more experiments are loaded and the error is returned.
+func (p *Project) IterateExperiments(ctx context.Context, fn func(exp *Experiment) error) error {
+	if p.hasLoaded {
+		for _, exp := range p.experimentsByID {
+			if err := fn(exp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	paths, err := listExperimentPaths(ctx, p.repository)
+	if err != nil {
+		return err
+	}
+	records, err := listCheckpointRecords(ctx, p.repository, checkpointsMetadataDir)
+	if err != nil {
+		return err
 	}
+	var mu sync.Mutex
+	stopped := false
+	err = loadEach(ctx, paths, func(ctx context.Context, _ int, metadataPath string) error {
+		exp := new(Experiment)
+		if err := loadRecord(ctx, p.repository, experimentRecord, metadataPath, exp); err != nil {
+			console.Warn("Failed to load metadata from %q: %s", metadataPath, err)
+			return nil
+		}
+		if err := addCheckpointRecords(ctx, p.repository, []*Experiment{exp}, records); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// Other experiments might have been loaded at the same time as the one fn failed on
+		if stopped {
+			return nil
+		}
+		// Experiments that have already been returned are kept, like ensureLoaded() does
+		if loaded, ok := p.experimentsByID[exp.ID]; ok {
+			exp = loaded
+		} else {
+			p.experimentsByID[exp.ID] = exp
+		}
+		if err := fn(exp); err != nil {
+			stopped = true
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Every experiment has been loaded, so they don't need to be loaded again
+	p.hasLoaded = true
+	p.hasLoadedSummaries = false
 	return nil
 }
 
-func (p *Project) DeleteExperiment(exp *Experiment) error {
-	if err := p.repository.Delete(exp.HeartbeatPath()); err != nil {
+// DeleteCheckpoint removes a checkpoint from its experiment's metadata and deletes its files
+func (p *Project) DeleteCheckpoint(ctx context.Context, com *Checkpoint) error {
+	return p.DeleteCheckpoints(ctx, []*Checkpoint{com})
+}
+
+// DeleteCheckpoints removes checkpoints from their experiments' metadata, so they are no
+// longer listed, then deletes their files
+func (p *Project) DeleteCheckpoints(ctx context.Context, checkpoints []*Checkpoint) error {
+	if _, err := p.removeCheckpointsFromMetadata(ctx, checkpoints); err != nil {
+		return err
+	}
+	// Metadata no longer refers to the tarballs, so any that fail to be deleted are just left
+	// for `replicate gc` to clean up
+	return p.deleteTarballs(ctx, checkpoints)
+}
+
+// removeCheckpointsFromMetadata removes checkpoints from their experiments' metadata, and
+// returns the ID of the experiment each checkpoint was in, by checkpoint ID
+func (p *Project) removeCheckpointsFromMetadata(ctx context.Context, checkpoints []*Checkpoint) (map[string]string, error) {
+	if err := p.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+
+	// Each experiment's metadata is only rewritten once, however many of its checkpoints are removed
+	toRemove := map[string]bool{}
+	for _, com := range checkpoints {
+		toRemove[com.ID] = true
+	}
+	experimentIDs := p.checkpointExperimentIDs(checkpoints)
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, id := range experimentIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		err := UpdateExperiment(ctx, p.repository, id, func(exp *Experiment) error {
+			exp.Checkpoints = withoutCheckpoints(exp.Checkpoints, toRemove)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to remove checkpoints from experiment %s: %w", id, err)
+		}
+		exp := p.experimentsByID[id]
+		exp.Checkpoints = withoutCheckpoints(exp.Checkpoints, toRemove)
+	}
+	// Checkpoints might be saved in their own files too
+	for _, com := range checkpoints {
+		exp := &Experiment{ID: experimentIDs[com.ID]}
+		if err := p.repository.Delete(ctx, exp.CheckpointMetadataPath(com.ID)); err != nil {
+			return nil, fmt.Errorf("Failed to remove checkpoint %s from experiment %s: %w", com.ID, exp.ID, err)
+		}
+	}
+	// Summaries are made again from the changed experiments next time they are used
+	p.hasLoadedSummaries = false
+	return experimentIDs, nil
+}
+
+// checkpointExperimentIDs returns the ID of the experiment each of checkpoints is in, by
+// checkpoint ID. The project must be loaded.
+func (p *Project) checkpointExperimentIDs(checkpoints []*Checkpoint) map[string]string {
+	wanted := map[string]bool{}
+	for _, com := range checkpoints {
+		wanted[com.ID] = true
+	}
+	experimentIDs := map[string]string{}
+	for id, exp := range p.experimentsByID {
+		for _, com := range exp.Checkpoints {
+			if wanted[com.ID] {
+				experimentIDs[com.ID] = id
+			}
+		}
+	}
+	return experimentIDs
+}
+
+// DeleteExperiment deletes an experiment and all of its checkpoints
+func (p *Project) DeleteExperiment(ctx context.Context, exp *Experiment) error {
+	if err := p.deleteTarballs(ctx, exp.Checkpoints); err != nil {
+		return err
+	}
+	if err := p.repository.Delete(ctx, exp.HeartbeatPath()); err != nil {
 		console.Warn("Failed to delete heartbeat file %s: %s", exp.HeartbeatPath(), err)
 	}
-	if err := p.repository.Delete(exp.StorageTarPath()); err != nil {
+	if err := p.repository.Delete(ctx, exp.StorageTarPath()); err != nil {
 		console.Warn("Failed to delete checkpoint storage directory %s: %s", exp.StorageTarPath(), err)
 	}
-	if err := p.repository.Delete(exp.MetadataPath()); err != nil {
+	if err := p.repository.Delete(ctx, exp.CheckpointsMetadataDir()); err != nil {
+		console.Warn("Failed to delete checkpoint metadata directory %s: %s", exp.CheckpointsMetadataDir(), err)
+	}
+	if err := p.repository.Delete(ctx, exp.MetadataPath()); err != nil {
 		console.Warn("Failed to delete experiment metadata file %s: %s", exp.MetadataPath(), err)
 	}
 	return nil
 }
 
+// deleteTarballs deletes the tarballs of checkpoints in parallel
+func (p *Project) deleteTarballs(ctx context.Context, checkpoints []*Checkpoint) error {
+	queue := concurrency.NewWorkerQueue(ctx, deleteWorkers)
+	for _, com := range checkpoints {
+		// Variables used in closure
+		com := com
+		err := queue.Go(func() error {
+			if err := p.repository.Delete(queue.Context(), com.StorageTarPath()); err != nil {
+				console.Warn("Failed to delete checkpoint storage directory %s: %s", com.StorageTarPath(), err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return queue.Wait()
+}
+
+func withoutCheckpoints(checkpoints []*Checkpoint, toRemove map[string]bool) []*Checkpoint {
+	ret := []*Checkpoint{}
+	for _, com := range checkpoints {
+		if !toRemove[com.ID] {
+			ret = append(ret, com)
+		}
+	}
+	return ret
+}
+
 // ensureLoaded eagerly loads all the metadata for this project.
 // This is highly inefficient, see https://github.com/replicate/replicate/issues/305
-func (p *Project) ensureLoaded() error {
+// Use ensureSummariesLoaded() if the whole of each experiment isn't needed.
+func (p *Project) ensureLoaded(ctx context.Context) error {
 	if p.hasLoaded {
 		return nil
 	}
-	experiments, err := listExperiments(p.repository)
+	experiments, err := listExperiments(ctx, p.repository)
 	if err != nil {
 		return err
 	}
-	heartbeats, err := listHeartbeats(p.repository)
-	if err != nil {
-		heartbeats = []*Heartbeat{}
-		console.Warn("Failed to load heartbeats: %s", err)
+	if err := p.ensureHeartbeatsLoaded(ctx); err != nil {
+		return err
+	}
+	experimentsByID := map[string]*Experiment{}
+	for _, exp := range experiments {
+		// Experiments that have already been returned are kept, so changes made to them
+		// are seen by whatever they were returned to
+		if loaded, ok := p.experimentsByID[exp.ID]; ok {
+			exp = loaded
+		}
+		experimentsByID[exp.ID] = exp
 	}
-	p.setObjects(experiments, heartbeats)
+	p.experimentsByID = experimentsByID
 	p.hasLoaded = true
+	// Made from the experiments that were just loaded, next time they are used
+	p.hasLoadedSummaries = false
 	return nil
 }
 
-func (p *Project) setObjects(experiments []*Experiment, heartbeats []*Heartbeat) {
-	p.experimentsByID = map[string]*Experiment{}
-	for _, exp := range experiments {
+// ensureSummariesLoaded loads the summaries of all the experiments in this project, from the
+// index where possible
+func (p *Project) ensureSummariesLoaded(ctx context.Context) error {
+	if p.hasLoadedSummaries {
+		return nil
+	}
+	p.summariesByID = map[string]*ExperimentSummary{}
+	if p.hasLoaded {
+		for id, exp := range p.experimentsByID {
+			p.summariesByID[id] = NewExperimentSummary(exp)
+		}
+		p.hasLoadedSummaries = true
+		return nil
+	}
+	summaries, loaded, err := loadSummaries(ctx, p.repository, false, false)
+	if err != nil {
+		return err
+	}
+	for _, summary := range summaries {
+		p.summariesByID[summary.ID] = summary
+	}
+	for _, exp := range loaded {
 		p.experimentsByID[exp.ID] = exp
 	}
+	p.hasLoadedSummaries = true
+	return nil
+}
+
+func (p *Project) ensureHeartbeatsLoaded(ctx context.Context) error {
+	if p.hasLoadedHeartbeats {
+		return nil
+	}
+	heartbeats, err := listHeartbeats(ctx, p.repository)
+	if err != nil {
+		heartbeats = []*Heartbeat{}
+		console.Warn("Failed to load heartbeats: %s", err)
+	}
 	p.heartbeatsByExpID = map[string]*Heartbeat{}
 	for _, hb := range heartbeats {
 		p.heartbeatsByExpID[hb.ExperimentID] = hb
 	}
+	p.hasLoadedHeartbeats = true
+	return nil
+}
+
+// invalidate makes everything be loaded again next time it is used, because the project
+// has been changed
+func (p *Project) invalidate() {
+	p.experimentsByID = map[string]*Experiment{}
+	p.hasLoaded = false
+	p.hasLoadedSummaries = false
+	p.hasLoadedHeartbeats = false
+}
+
+// loadEach calls load with each of paths and its index in paths, loadWorkers at a time. If
+// load returns an error, no more paths are loaded and the error is returned.
+func loadEach(ctx context.Context, paths []string, load func(ctx context.Context, i int, p string) error) error {
+	queue := concurrency.NewWorkerQueue(ctx, loadWorkers)
+	for i, p := range paths {
+		// Variables used in closure
+		i, p := i, p
+		err := queue.Go(func() error {
+			return load(queue.Context(), i, p)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return queue.Wait()
 }
 
-func loadFromPath(repo repository.Repository, path string, obj interface{}) error {
-	contents, err := repo.Get(path)
+func loadFromPath(ctx context.Context, repo repository.Repository, path string, obj interface{}) error {
+	contents, err := repo.Get(ctx, path)
 	if err != nil {
 		return err
 	}
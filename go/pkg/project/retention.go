@@ -0,0 +1,52 @@
+package project
+
+// RetentionPolicy decides which checkpoints of an experiment to keep. A checkpoint is kept
+// if any of the rules keep it. Rules that are zero are not used.
+type RetentionPolicy struct {
+	// KeepBest keeps this many of the best checkpoints, according to the primary metric
+	KeepBest int
+	// KeepLatest keeps this many of the latest checkpoints
+	KeepLatest int
+	// KeepEvery keeps checkpoints where the step is a multiple of this
+	KeepEvery int
+}
+
+// IsEmpty returns true if the policy has no rules, so it wouldn't keep anything
+func (p RetentionPolicy) IsEmpty() bool {
+	return p.KeepBest <= 0 && p.KeepLatest <= 0 && p.KeepEvery <= 0
+}
+
+// CheckpointsToPrune returns the checkpoints of exp that the policy doesn't keep, in the
+// order they are in the experiment. If the policy keeps the best checkpoints, but exp
+// doesn't have a primary metric to say which are best, it keeps all of them.
+func (p RetentionPolicy) CheckpointsToPrune(exp *Experiment) []*Checkpoint {
+	keep := map[string]bool{}
+	if p.KeepBest > 0 {
+		best := exp.BestCheckpoints(p.KeepBest)
+		if len(best) == 0 {
+			return []*Checkpoint{}
+		}
+		for _, chk := range best {
+			keep[chk.ID] = true
+		}
+	}
+	if p.KeepLatest > 0 {
+		for _, chk := range exp.LatestCheckpoints(p.KeepLatest) {
+			keep[chk.ID] = true
+		}
+	}
+	if p.KeepEvery > 0 {
+		for _, chk := range exp.Checkpoints {
+			if chk.Step%p.KeepEvery == 0 {
+				keep[chk.ID] = true
+			}
+		}
+	}
+	prune := []*Checkpoint{}
+	for _, chk := range exp.Checkpoints {
+		if !keep[chk.ID] {
+			prune = append(prune, chk)
+		}
+	}
+	return prune
+}
@@ -0,0 +1,216 @@
+package project
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+// SchemaVersion is the version of the format of experiment, checkpoint and heartbeat
+// records that this version of Replicate writes. Records saved with an older version are
+// migrated when they are loaded, and `replicate upgrade-repository` rewrites them.
+//
+// Corresponds to SCHEMA_VERSION in constants.py
+const SchemaVersion = 1
+
+type recordKind string
+
+const (
+	experimentRecord recordKind = "experiment"
+	checkpointRecord recordKind = "checkpoint"
+	heartbeatRecord  recordKind = "heartbeat"
+)
+
+// A migration upgrades a record, decoded as generic JSON so it can have fields the current
+// structs don't have, from one schema version to the next
+type migration func(kind recordKind, record map[string]interface{}) error
+
+// migrations[i] upgrades records from version i to version i+1. Checkpoints saved in an
+// experiment's record are migrated along with it.
+//
+// Only bump SchemaVersion for changes that older versions would misread. Fields that can be
+// left out, and that older versions can ignore, don't need a new version.
+var migrations = []migration{
+	// Version 0 is records saved before schema versions were recorded. Apart from the
+	// version, they are the same as version 1.
+	func(kind recordKind, record map[string]interface{}) error {
+		return nil
+	},
+}
+
+// NewerSchemaError is returned when a record was saved by a newer version of Replicate. The
+// message doesn't include the path, because it is usually reported alongside it.
+type NewerSchemaError struct {
+	Path    string
+	Version int
+}
+
+func (e *NewerSchemaError) Error() string {
+	return fmt.Sprintf("Saved with schema version %d by a newer version of Replicate, but this version only supports up to version %d. To read it, upgrade Replicate.", e.Version, SchemaVersion)
+}
+
+// ParseExperiment parses the experiment record at p, migrating it if it is old
+func ParseExperiment(p string, data []byte) (*Experiment, error) {
+	exp := new(Experiment)
+	if _, err := decodeRecord(experimentRecord, p, data, exp); err != nil {
+		return nil, err
+	}
+	return exp, nil
+}
+
+// ParseCheckpoint parses the checkpoint record at p, migrating it if it is old
+func ParseCheckpoint(p string, data []byte) (*Checkpoint, error) {
+	chk := new(Checkpoint)
+	if _, err := decodeRecord(checkpointRecord, p, data, chk); err != nil {
+		return nil, err
+	}
+	return chk, nil
+}
+
+// ParseHeartbeat parses the heartbeat record at p, migrating it if it is old
+func ParseHeartbeat(p string, data []byte) (*Heartbeat, error) {
+	hb := new(Heartbeat)
+	if _, err := decodeRecord(heartbeatRecord, p, data, hb); err != nil {
+		return nil, err
+	}
+	return hb, nil
+}
+
+// RecordSchemaVersion returns the schema version a record was saved with
+func RecordSchemaVersion(data []byte) (int, error) {
+	header := struct {
+		SchemaVersion int `json:"schema_version"`
+	}{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, fmt.Errorf("Parse error: %s", err)
+	}
+	if header.SchemaVersion < 0 {
+		return 0, fmt.Errorf("Invalid schema version: %d", header.SchemaVersion)
+	}
+	return header.SchemaVersion, nil
+}
+
+// UpgradeRecord rewrites the experiment, checkpoint or heartbeat record at p with the
+// current schema, if it was saved with an older one. It returns the version it was saved
+// with.
+func UpgradeRecord(ctx context.Context, repo repository.Repository, p string) (int, error) {
+	kind, err := recordKindForPath(p)
+	if err != nil {
+		return 0, err
+	}
+	data, err := repo.Get(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	version, err := RecordSchemaVersion(data)
+	if err != nil || version >= SchemaVersion {
+		return version, err
+	}
+	err = repository.Update(ctx, repo, p, func(data []byte) ([]byte, error) {
+		if data == nil {
+			return nil, fmt.Errorf("%s does not exist", p)
+		}
+		var obj interface{}
+		switch kind {
+		case experimentRecord:
+			obj = new(Experiment)
+		case checkpointRecord:
+			obj = new(Checkpoint)
+		case heartbeatRecord:
+			obj = new(Heartbeat)
+		}
+		if _, err := decodeRecord(kind, p, data, obj); err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(obj, "", " ")
+	})
+	return version, err
+}
+
+// recordKindForPath returns what kind of record is saved at p
+func recordKindForPath(p string) (recordKind, error) {
+	dir := path.Dir(p)
+	if strings.HasSuffix(p, ".json") {
+		switch {
+		case dir == "metadata/experiments":
+			return experimentRecord, nil
+		case path.Dir(dir) == checkpointsMetadataDir:
+			return checkpointRecord, nil
+		case dir == "metadata/heartbeats":
+			return heartbeatRecord, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not an experiment, checkpoint, or heartbeat", p)
+}
+
+// loadRecord loads the record of the given kind at p into obj, migrating it if it is old
+func loadRecord(ctx context.Context, repo repository.Repository, kind recordKind, p string, obj interface{}) error {
+	data, err := repo.Get(ctx, p)
+	if err != nil {
+		return err
+	}
+	_, err = decodeRecord(kind, p, data, obj)
+	return err
+}
+
+// decodeRecord parses the record of the given kind at p into obj. If it was saved with an
+// older schema, it is migrated to the current one first. It returns the version the record
+// was saved with.
+func decodeRecord(kind recordKind, p string, data []byte, obj interface{}) (int, error) {
+	version, err := RecordSchemaVersion(data)
+	if err != nil {
+		return 0, err
+	}
+	if version > SchemaVersion {
+		return version, &NewerSchemaError{Path: p, Version: version}
+	}
+	if version < SchemaVersion {
+		record := map[string]interface{}{}
+		// Numbers are kept as they are written, rather than being converted to floats
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&record); err != nil {
+			return version, fmt.Errorf("Parse error: %s", err)
+		}
+		if err := migrate(kind, record, version); err != nil {
+			return version, fmt.Errorf("Failed to migrate %s from schema version %d: %w", p, version, err)
+		}
+		if data, err = json.Marshal(record); err != nil {
+			return version, err
+		}
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
+		return version, fmt.Errorf("Parse error: %s", err)
+	}
+	return version, nil
+}
+
+// migrate upgrades record from schema version `from` to SchemaVersion, in place
+func migrate(kind recordKind, record map[string]interface{}, from int) error {
+	for v := from; v < SchemaVersion; v++ {
+		if err := migrations[v](kind, record); err != nil {
+			return err
+		}
+		record["schema_version"] = v + 1
+		if kind != experimentRecord {
+			continue
+		}
+		checkpoints, _ := record["checkpoints"].([]interface{})
+		for _, c := range checkpoints {
+			chk, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := migrations[v](checkpointRecord, chk); err != nil {
+				return err
+			}
+			chk["schema_version"] = v + 1
+		}
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package project
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/replicate/replicate/go/pkg/concurrency"
+	"github.com/replicate/replicate/go/pkg/repository"
+)
+
+// Experiments and checkpoints that are moved to the trash are put in trash/<timestamp>/,
+// with the same paths they had in the repository, and a manifest of what was deleted
+const trashDir = "trash"
+const trashTimeFormat = "20060102T150405.000000000Z"
+const trashManifestName = "manifest.json"
+
+// TrashedCheckpoint is a checkpoint that was moved to the trash without its experiment
+type TrashedCheckpoint struct {
+	ExperimentID string      `json:"experiment_id"`
+	Checkpoint   *Checkpoint `json:"checkpoint"`
+}
+
+// Trash is the experiments and checkpoints that were moved to the trash at the same time
+type Trash struct {
+	// Path is the directory in the repository the files were moved to
+	Path        string               `json:"-"`
+	Deleted     time.Time            `json:"deleted"`
+	Experiments []string             `json:"experiments"`
+	Checkpoints []*TrashedCheckpoint `json:"checkpoints"`
+}
+
+func (t *Trash) manifestPath() string {
+	return path.Join(t.Path, trashManifestName)
+}
+
+// MoveToTrash moves experiments and checkpoints to a new directory in the trash, so they
+// can be restored with Restore(). Checkpoints are removed from their experiments' metadata.
+func (p *Project) MoveToTrash(ctx context.Context, experiments []*Experiment, checkpoints []*Checkpoint) (*Trash, error) {
+	if err := p.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+	deleted := time.Now().UTC()
+	trash := &Trash{
+		Path:        path.Join(trashDir, deleted.Format(trashTimeFormat)),
+		Deleted:     deleted,
+		Experiments: []string{},
+		Checkpoints: []*TrashedCheckpoint{},
+	}
+	for _, exp := range experiments {
+		trash.Experiments = append(trash.Experiments, exp.ID)
+	}
+	experimentIDs := p.checkpointExperimentIDs(checkpoints)
+	for _, com := range checkpoints {
+		trash.Checkpoints = append(trash.Checkpoints, &TrashedCheckpoint{
+			ExperimentID: experimentIDs[com.ID],
+			Checkpoint:   com,
+		})
+	}
+
+	// Write the manifest first, so if this is interrupted, what was moved can still be restored
+	data, err := json.MarshalIndent(trash, "", " ")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.repository.Put(ctx, trash.manifestPath(), data); err != nil {
+		return nil, err
+	}
+
+	if len(checkpoints) > 0 {
+		if _, err := p.removeCheckpointsFromMetadata(ctx, checkpoints); err != nil {
+			return nil, err
+		}
+		paths := []string{}
+		for _, com := range checkpoints {
+			paths = append(paths, com.StorageTarPath())
+		}
+		if err := p.moveFiles(ctx, paths, "", trash.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Metadata is moved last, so experiments are still listed if moving their files fails
+	paths := []string{}
+	checkpointMetadataPaths := []string{}
+	metadataPaths := []string{}
+	for _, exp := range experiments {
+		for _, com := range exp.Checkpoints {
+			paths = append(paths, com.StorageTarPath())
+			checkpointMetadataPaths = append(checkpointMetadataPaths, exp.CheckpointMetadataPath(com.ID))
+		}
+		paths = append(paths, exp.StorageTarPath(), exp.HeartbeatPath())
+		metadataPaths = append(metadataPaths, exp.MetadataPath())
+	}
+	if err := p.moveFiles(ctx, paths, "", trash.Path); err != nil {
+		return nil, err
+	}
+	if err := p.moveFiles(ctx, checkpointMetadataPaths, "", trash.Path); err != nil {
+		return nil, err
+	}
+	if err := p.moveFiles(ctx, metadataPaths, "", trash.Path); err != nil {
+		return nil, err
+	}
+	for _, exp := range experiments {
+		delete(p.experimentsByID, exp.ID)
+		delete(p.summariesByID, exp.ID)
+	}
+	return trash, nil
+}
+
+// ListTrash returns what is in the trash in repo, oldest first
+func ListTrash(ctx context.Context, repo repository.Repository) ([]*Trash, error) {
+	results := make(chan repository.ListResult)
+	go repo.MatchFilenamesRecursive(ctx, results, trashDir, trashManifestName)
+	trashes := []*Trash{}
+	for result := range results {
+		if result.Error != nil {
+			return nil, fmt.Errorf("Failed to list trash in %s: %w", repo.RootURL(), result.Error)
+		}
+		trash := &Trash{Path: path.Dir(result.Path)}
+		if err := loadFromPath(ctx, repo, result.Path, trash); err != nil {
+			return nil, fmt.Errorf("Failed to load %s: %w", result.Path, err)
+		}
+		trashes = append(trashes, trash)
+	}
+	sort.Slice(trashes, func(i, j int) bool {
+		return trashes[i].Deleted.Before(trashes[j].Deleted)
+	})
+	return trashes, nil
+}
+
+// EmptyTrash permanently deletes everything that was moved to the trash before `before`,
+// and returns what was deleted
+func EmptyTrash(ctx context.Context, repo repository.Repository, before time.Time) ([]*Trash, error) {
+	trashes, err := ListTrash(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []*Trash{}
+	for _, trash := range trashes {
+		if !trash.Deleted.Before(before) {
+			continue
+		}
+		if err := repo.Delete(ctx, trash.Path); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, trash)
+	}
+	return deleted, nil
+}
+
+// Restore moves the experiment or checkpoint with ID prefix `prefix` out of the trash, back to
+// where it was. A checkpoint is added back to its experiment, which must exist.
+func (p *Project) Restore(ctx context.Context, prefix string) (*CheckpointOrExperiment, error) {
+	trashes, err := ListTrash(ctx, p.repository)
+	if err != nil {
+		return nil, err
+	}
+
+	type match struct {
+		trash        *Trash
+		experimentID string
+		checkpoint   *TrashedCheckpoint
+	}
+	matches := []match{}
+	for _, trash := range trashes {
+		for _, id := range trash.Experiments {
+			if strings.HasPrefix(id, prefix) {
+				matches = append(matches, match{trash: trash, experimentID: id})
+			}
+		}
+		for _, com := range trash.Checkpoints {
+			if strings.HasPrefix(com.Checkpoint.ID, prefix) {
+				matches = append(matches, match{trash: trash, checkpoint: com})
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("Checkpoint/experiment not found in trash: %s", prefix)
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("Prefix is ambiguous: %s (%d matching checkpoints/experiments in trash)", prefix, len(matches))
+	}
+
+	// Project has changed, so load it again next time it is used
+	p.invalidate()
+
+	m := matches[0]
+	if m.checkpoint != nil {
+		exp, err := p.restoreCheckpoint(ctx, m.trash, m.checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		return &CheckpointOrExperiment{Experiment: exp, Checkpoint: m.checkpoint.Checkpoint}, nil
+	}
+	exp, err := p.restoreExperiment(ctx, m.trash, m.experimentID)
+	if err != nil {
+		return nil, err
+	}
+	return &CheckpointOrExperiment{Experiment: exp}, nil
+}
+
+func (p *Project) restoreExperiment(ctx context.Context, trash *Trash, id string) (*Experiment, error) {
+	exp := &Experiment{ID: id}
+	if _, err := p.repository.Get(ctx, exp.MetadataPath()); err == nil {
+		return nil, fmt.Errorf("Experiment %s can't be restored, because an experiment with the same ID exists", id)
+	}
+	if err := loadRecord(ctx, p.repository, experimentRecord, path.Join(trash.Path, exp.MetadataPath()), exp); err != nil {
+		return nil, fmt.Errorf("Failed to load experiment %s from trash: %w", id, err)
+	}
+	trashedCheckpointsDir := path.Join(trash.Path, checkpointsMetadataDir)
+	records, err := listCheckpointRecords(ctx, p.repository, trashedCheckpointsDir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load experiment %s from trash: %w", id, err)
+	}
+	if err := addCheckpointRecords(ctx, p.repository, []*Experiment{exp}, records); err != nil {
+		return nil, fmt.Errorf("Failed to load experiment %s from trash: %w", id, err)
+	}
+
+	paths := []string{}
+	checkpointMetadataPaths := []string{}
+	for _, com := range exp.Checkpoints {
+		paths = append(paths, com.StorageTarPath())
+	}
+	for _, record := range records[id] {
+		checkpointMetadataPaths = append(checkpointMetadataPaths, strings.TrimPrefix(record.Path, trash.Path+"/"))
+	}
+	paths = append(paths, exp.StorageTarPath(), exp.HeartbeatPath())
+	if err := p.moveFiles(ctx, paths, trash.Path, ""); err != nil {
+		return nil, err
+	}
+	if err := p.moveFiles(ctx, checkpointMetadataPaths, trash.Path, ""); err != nil {
+		return nil, err
+	}
+	if err := p.moveFiles(ctx, []string{exp.MetadataPath()}, trash.Path, ""); err != nil {
+		return nil, err
+	}
+
+	err = p.updateTrash(ctx, trash, func(t *Trash) {
+		experiments := []string{}
+		for _, expID := range t.Experiments {
+			if expID != id {
+				experiments = append(experiments, expID)
+			}
+		}
+		t.Experiments = experiments
+	})
+	return exp, err
+}
+
+func (p *Project) restoreCheckpoint(ctx context.Context, trash *Trash, trashed *TrashedCheckpoint) (*Experiment, error) {
+	com := trashed.Checkpoint
+	exp := &Experiment{ID: trashed.ExperimentID}
+	if _, err := p.repository.Get(ctx, exp.MetadataPath()); err != nil {
+		var doesNotExist *repository.DoesNotExistError
+		if errors.As(err, &doesNotExist) {
+			return nil, fmt.Errorf("Checkpoint %s can't be restored, because its experiment %s doesn't exist. Restore the experiment first.", com.ID, trashed.ExperimentID)
+		}
+		return nil, err
+	}
+
+	if err := p.moveFiles(ctx, []string{com.StorageTarPath()}, trash.Path, ""); err != nil {
+		return nil, err
+	}
+	if err := SaveCheckpoint(ctx, p.repository, exp.ID, com); err != nil {
+		return nil, err
+	}
+	exp, err := loadExperiment(ctx, p.repository, exp.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.updateTrash(ctx, trash, func(t *Trash) {
+		checkpoints := []*TrashedCheckpoint{}
+		for _, c := range t.Checkpoints {
+			if c.Checkpoint.ID != com.ID {
+				checkpoints = append(checkpoints, c)
+			}
+		}
+		t.Checkpoints = checkpoints
+	})
+	return exp, err
+}
+
+// updateTrash updates the manifest of trash, and deletes the directory if nothing is left in it
+func (p *Project) updateTrash(ctx context.Context, trash *Trash, update func(t *Trash)) error {
+	isEmpty := false
+	err := repository.Update(ctx, p.repository, trash.manifestPath(), func(data []byte) ([]byte, error) {
+		if data == nil {
+			return nil, fmt.Errorf("%s does not exist", trash.manifestPath())
+		}
+		latest := new(Trash)
+		if err := json.Unmarshal(data, latest); err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %w", trash.manifestPath(), err)
+		}
+		update(latest)
+		isEmpty = len(latest.Experiments) == 0 && len(latest.Checkpoints) == 0
+		return json.MarshalIndent(latest, "", " ")
+	})
+	if err != nil {
+		return err
+	}
+	if isEmpty {
+		return p.repository.Delete(ctx, trash.Path)
+	}
+	return nil
+}
+
+// moveFiles moves each of paths from under srcDir to under dstDir in parallel. Paths that
+// don't exist are skipped, because experiments and checkpoints don't always have every file.
+func (p *Project) moveFiles(ctx context.Context, paths []string, srcDir, dstDir string) error {
+	queue := concurrency.NewWorkerQueue(ctx, deleteWorkers)
+	for _, relPath := range paths {
+		// Variables used in closure
+		src := path.Join(srcDir, relPath)
+		dst := path.Join(dstDir, relPath)
+		err := queue.Go(func() error {
+			err := p.repository.Move(queue.Context(), src, dst)
+			var doesNotExist *repository.DoesNotExistError
+			if err != nil && !errors.As(err, &doesNotExist) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return queue.Wait()
+}
@@ -1,12 +1,26 @@
 package repository
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/replicate/replicate/go/pkg/concurrency"
 	"github.com/replicate/replicate/go/pkg/console"
 )
 
+// fullSyncInterval is how often SyncCache() syncs everything, instead of only what the
+// manifest says has changed
+const fullSyncInterval = time.Hour
+
 // CachedRepository wraps another repository, caching a prefix in a local directory.
 //
 // SyncCache() syncs cachePrefix locally, which you must call before doing any
@@ -28,110 +42,345 @@ func NewCachedRepository(repo Repository, cachePrefix string, cacheDir string) (
 	if err != nil {
 		return nil, err
 	}
+	return newCachedRepository(repo, cachePrefix, cacheDir, cacheRepository), nil
+}
+
+// NewCachedMetadataRepository returns a CachedRepository that caches the metadata/ path in
+// .replicate/metadata-cache in a source dir
+//
+// The checksums of the cached files are kept in .replicate/metadata-cache-checksums.json, so
+// syncing the cache doesn't have to read every file in it.
+func NewCachedMetadataRepository(repo Repository, projectDir string) (*CachedRepository, error) {
+	cacheDir := path.Join(projectDir, ".replicate/metadata-cache")
+	cacheRepository, err := NewDiskRepositoryWithChecksumIndex(cacheDir, path.Join(projectDir, ".replicate/metadata-cache-checksums.json"))
+	if err != nil {
+		return nil, err
+	}
+	return newCachedRepository(repo, "metadata", cacheDir, cacheRepository), nil
+}
+
+func newCachedRepository(repo Repository, cachePrefix string, cacheDir string, cacheRepository *DiskRepository) *CachedRepository {
 	return &CachedRepository{
 		repository:      repo,
 		cachePrefix:     cachePrefix,
 		cacheDir:        cacheDir,
 		cacheRepository: cacheRepository,
 		isSynced:        false,
-	}, nil
+	}
 }
 
-// NewCachedMetadataRepository returns a CachedRepository that caches the metadata/ path in
-// .replicate/metadata-cache in a source dir
-func NewCachedMetadataRepository(repo Repository, projectDir string) (*CachedRepository, error) {
-	return NewCachedRepository(repo, "metadata", path.Join(projectDir, ".replicate/metadata-cache"))
+func (s *CachedRepository) Get(ctx context.Context, p string) ([]byte, error) {
+	if strings.HasPrefix(p, s.cachePrefix) {
+		return s.cacheRepository.Get(ctx, p)
+	}
+	return s.repository.Get(ctx, p)
+}
+
+func (s *CachedRepository) Put(ctx context.Context, p string, data []byte) error {
+	// FIXME: potential for cache and remote to get out of sync on error
+	if s.writesToCache(p) {
+		if err := s.cacheRepository.Put(ctx, p, data); err != nil {
+			return err
+		}
+	}
+	return s.repository.Put(ctx, p, data)
 }
 
-func (s *CachedRepository) Get(p string) ([]byte, error) {
+func (s *CachedRepository) GetReader(ctx context.Context, p string) (io.ReadCloser, error) {
 	if strings.HasPrefix(p, s.cachePrefix) {
-		return s.cacheRepository.Get(p)
+		return s.cacheRepository.GetReader(ctx, p)
 	}
-	return s.repository.Get(p)
+	return s.repository.GetReader(ctx, p)
 }
 
-func (s *CachedRepository) Put(p string, data []byte) error {
+func (s *CachedRepository) PutReader(ctx context.Context, p string, reader io.Reader) error {
 	// FIXME: potential for cache and remote to get out of sync on error
-	if strings.HasPrefix(p, s.cachePrefix) {
-		if err := s.cacheRepository.Put(p, data); err != nil {
+	if s.writesToCache(p) {
+		// Write to the cache first, then stream the cached copy to the remote, so we only read
+		// reader once without holding it in memory
+		if err := s.cacheRepository.PutReader(ctx, p, reader); err != nil {
+			return err
+		}
+		cached, err := s.cacheRepository.GetReader(ctx, p)
+		if err != nil {
 			return err
 		}
+		defer cached.Close()
+		return s.repository.PutReader(ctx, p, cached)
+	}
+	return s.repository.PutReader(ctx, p, reader)
+}
+
+// GetWithVersion always reads from the repository, because the cache doesn't know versions
+func (s *CachedRepository) GetWithVersion(ctx context.Context, p string) ([]byte, string, error) {
+	conditional, err := asConditional(s.repository)
+	if err != nil {
+		return nil, "", err
+	}
+	return conditional.GetWithVersion(ctx, p)
+}
+
+// PutIfMatch writes to the repository, then to the cache if the write succeeded
+func (s *CachedRepository) PutIfMatch(ctx context.Context, p string, data []byte, version string) error {
+	conditional, err := asConditional(s.repository)
+	if err != nil {
+		return err
+	}
+	if err := conditional.PutIfMatch(ctx, p, data, version); err != nil {
+		return err
 	}
-	return s.repository.Put(p, data)
+	if s.writesToCache(p) {
+		return s.cacheRepository.Put(ctx, p, data)
+	}
+	return nil
+}
+
+// Copy copies src to dst in the repository, then in the cache
+func (s *CachedRepository) Copy(ctx context.Context, src, dst string) error {
+	if err := s.repository.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	if !s.writesToCache(dst) {
+		return nil
+	}
+	if s.writesToCache(src) {
+		return s.cacheRepository.Copy(ctx, src, dst)
+	}
+	return s.cacheFromRepository(ctx, dst)
 }
 
-func (s *CachedRepository) GetPath(repoPath string, localPath string) error {
+// Move moves src to dst in the repository, then in the cache
+func (s *CachedRepository) Move(ctx context.Context, src, dst string) error {
+	if err := s.repository.Move(ctx, src, dst); err != nil {
+		return err
+	}
+	srcCached := s.writesToCache(src)
+	dstCached := s.writesToCache(dst)
+	switch {
+	case srcCached && dstCached:
+		return s.cacheRepository.Move(ctx, src, dst)
+	case srcCached:
+		return s.cacheRepository.Delete(ctx, src)
+	case dstCached:
+		return s.cacheFromRepository(ctx, dst)
+	}
+	return nil
+}
+
+// cacheFromRepository copies p from the repository to the cache
+func (s *CachedRepository) cacheFromRepository(ctx context.Context, p string) error {
+	reader, err := s.repository.GetReader(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return s.cacheRepository.PutReader(ctx, p, reader)
+}
+
+func (s *CachedRepository) GetPath(ctx context.Context, repoPath string, localPath string) error {
 	if strings.HasPrefix(repoPath, s.cachePrefix) {
-		return s.cacheRepository.GetPath(repoPath, localPath)
+		return s.cacheRepository.GetPath(ctx, repoPath, localPath)
 	}
-	return s.repository.GetPath(repoPath, localPath)
+	return s.repository.GetPath(ctx, repoPath, localPath)
 }
 
-func (s *CachedRepository) GetPathTar(tarPath, localPath string) error {
+func (s *CachedRepository) GetPathTar(ctx context.Context, tarPath, localPath string) error {
 	if strings.HasPrefix(tarPath, s.cachePrefix) {
-		return s.cacheRepository.GetPathTar(tarPath, localPath)
+		return s.cacheRepository.GetPathTar(ctx, tarPath, localPath)
 	}
-	return s.repository.GetPathTar(tarPath, localPath)
+	return s.repository.GetPathTar(ctx, tarPath, localPath)
 }
 
-func (s *CachedRepository) PutPath(localPath string, repoPath string) error {
+func (s *CachedRepository) PutPath(ctx context.Context, localPath string, repoPath string) error {
 	// FIXME: potential for cache and remote to get out of sync on error
-	if strings.HasPrefix(repoPath, s.cachePrefix) {
-		if err := s.cacheRepository.PutPath(localPath, repoPath); err != nil {
+	if s.writesToCache(repoPath) {
+		if err := s.cacheRepository.PutPath(ctx, localPath, repoPath); err != nil {
 			return err
 		}
 	}
-	return s.repository.PutPath(localPath, repoPath)
+	return s.repository.PutPath(ctx, localPath, repoPath)
 
 }
 
-func (s *CachedRepository) PutPathTar(localPath, tarPath, includePath string) error {
+func (s *CachedRepository) PutPathTar(ctx context.Context, localPath, tarPath, includePath string) error {
 	// FIXME: potential for cache and remote to get out of sync on error
-	if strings.HasPrefix(tarPath, s.cachePrefix) {
-		if err := s.cacheRepository.PutPathTar(localPath, tarPath, includePath); err != nil {
+	if s.writesToCache(tarPath) {
+		if err := s.cacheRepository.PutPathTar(ctx, localPath, tarPath, includePath); err != nil {
 			return err
 		}
 	}
-	return s.repository.PutPathTar(localPath, tarPath, includePath)
+	return s.repository.PutPathTar(ctx, localPath, tarPath, includePath)
 }
 
-func (s *CachedRepository) List(p string) ([]string, error) {
+func (s *CachedRepository) List(ctx context.Context, p string) ([]string, error) {
 	if strings.HasPrefix(p, s.cachePrefix) {
-		return s.cacheRepository.List(p)
+		return s.cacheRepository.List(ctx, p)
 	}
-	return s.repository.List(p)
+	return s.repository.List(ctx, p)
 }
 
-func (s *CachedRepository) ListRecursive(results chan<- ListResult, path string) {
+func (s *CachedRepository) ListRecursive(ctx context.Context, results chan<- ListResult, path string) {
 	if strings.HasPrefix(path, s.cachePrefix) {
-		s.cacheRepository.ListRecursive(results, path)
+		s.cacheRepository.ListRecursive(ctx, results, path)
 		return
 	}
-	s.repository.ListRecursive(results, path)
+	s.repository.ListRecursive(ctx, results, path)
 }
 
-func (s *CachedRepository) MatchFilenamesRecursive(results chan<- ListResult, path string, filename string) {
+func (s *CachedRepository) MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, path string, filename string) {
 	if strings.HasPrefix(path, s.cachePrefix) {
-		s.cacheRepository.MatchFilenamesRecursive(results, path, filename)
+		s.cacheRepository.MatchFilenamesRecursive(ctx, results, path, filename)
 		return
 	}
-	s.repository.MatchFilenamesRecursive(results, path, filename)
+	s.repository.MatchFilenamesRecursive(ctx, results, path, filename)
 }
 
-func (s *CachedRepository) Delete(p string) error {
-	if strings.HasPrefix(p, s.cachePrefix) {
-		if err := s.cacheRepository.Delete(p); err != nil {
+func (s *CachedRepository) Delete(ctx context.Context, p string) error {
+	if s.writesToCache(p) {
+		if err := s.cacheRepository.Delete(ctx, p); err != nil {
 			return err
 		}
 	}
-	return s.repository.Delete(p)
+	return s.repository.Delete(ctx, p)
+}
+
+// writesToCache returns true if a write to p should also be written to the cache. Writes to
+// read-only repositories aren't, so the cache doesn't end up with data the repository doesn't have.
+func (s *CachedRepository) writesToCache(p string) bool {
+	return strings.HasPrefix(p, s.cachePrefix) && !IsReadOnly(s.repository)
 }
 
 func (s *CachedRepository) RootURL() string {
 	return s.repository.RootURL()
 }
 
-func (s *CachedRepository) SyncCache() error {
+// SyncCache syncs cachePrefix from the repository to the cache
+//
+// If the cache includes ManifestPath, only the files that have changed since the last sync
+// are fetched. It does a full sync if the manifest is missing or can't be read, if the cache is
+// further behind than the manifest goes back, and every fullSyncInterval, in case something
+// wrote to the repository without updating the manifest.
+func (s *CachedRepository) SyncCache(ctx context.Context) error {
+	if !isUnderPrefix(ManifestPath, s.cachePrefix) || !SupportsConditionalWrites(s.repository) {
+		return s.fullSync(ctx)
+	}
+
+	// Read before the full sync, so anything that changes during it is fetched next time
+	manifest, err := s.readManifest(ctx)
+	if err != nil {
+		console.Debug("Doing a full sync of %s/%s: %s", s.repository.RootURL(), s.cachePrefix, err)
+		return s.fullSync(ctx)
+	}
+
+	state := s.loadSyncState()
+	if state != nil && state.ManifestID == manifest.ID && time.Since(state.LastFullSync) < fullSyncInterval {
+		if changes, ok := manifest.ChangesSince(state.Generation); ok {
+			console.Debug("Fetching %d changed files from %s/%s", len(changes), s.repository.RootURL(), s.cachePrefix)
+			if err := s.applyChanges(ctx, changes); err != nil {
+				return err
+			}
+			state.Generation = manifest.Generation
+			s.saveSyncState(state)
+			return nil
+		}
+	}
+
+	if err := s.fullSync(ctx); err != nil {
+		return err
+	}
+	s.saveSyncState(&cacheSyncState{
+		ManifestID:   manifest.ID,
+		Generation:   manifest.Generation,
+		LastFullSync: time.Now(),
+	})
+	return nil
+}
+
+func (s *CachedRepository) fullSync(ctx context.Context) error {
 	console.Debug("Syncing %s/%s to %s/%s", s.repository.RootURL(), s.cachePrefix, s.cacheRepository.RootURL(), s.cachePrefix)
-	return Sync(s.repository, s.cachePrefix, s.cacheRepository, s.cachePrefix)
+	_, err := Sync(ctx, s.repository, s.cachePrefix, s.cacheRepository, s.cachePrefix, SyncOptions{})
+	return err
+}
+
+func (s *CachedRepository) readManifest(ctx context.Context) (*Manifest, error) {
+	data, err := s.repository.Get(ctx, ManifestPath)
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(Manifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s/%s: %w", s.repository.RootURL(), ManifestPath, err)
+	}
+	if manifest.ID == "" {
+		return nil, fmt.Errorf("%s/%s does not have an ID", s.repository.RootURL(), ManifestPath)
+	}
+	return manifest, nil
+}
+
+// applyChanges fetches changed files from the repository to the cache, and deletes deleted ones
+func (s *CachedRepository) applyChanges(ctx context.Context, changes []ManifestChange) error {
+	queue := concurrency.NewWorkerQueue(ctx, maxWorkers)
+	for _, change := range changes {
+		// Variables used in closure
+		change := change
+		if !isUnderPrefix(change.Path, s.cachePrefix) {
+			continue
+		}
+		err := queue.Go(func() error {
+			if !change.Deleted {
+				err := s.cacheFromRepository(queue.Context(), change.Path)
+				var doesNotExist *DoesNotExistError
+				if !errors.As(err, &doesNotExist) {
+					return err
+				}
+				// Deleted since the change was recorded
+			}
+			return s.cacheRepository.Delete(queue.Context(), change.Path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return queue.Wait()
+}
+
+// cacheSyncState is what the cache was last synced to, stored in syncStatePath()
+type cacheSyncState struct {
+	ManifestID   string    `json:"manifest_id"`
+	Generation   int64     `json:"generation"`
+	LastFullSync time.Time `json:"last_full_sync"`
+}
+
+// syncStatePath is outside cachePrefix, so it isn't touched by syncing
+func (s *CachedRepository) syncStatePath() string {
+	return path.Join(s.cacheDir, ".sync-state.json")
+}
+
+// loadSyncState returns nil if the cache has not been synced with a manifest
+func (s *CachedRepository) loadSyncState() *cacheSyncState {
+	data, err := ioutil.ReadFile(s.syncStatePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			console.Debug("Failed to read %s: %s", s.syncStatePath(), err)
+		}
+		return nil
+	}
+	state := new(cacheSyncState)
+	if err := json.Unmarshal(data, state); err != nil {
+		console.Debug("Failed to parse %s: %s", s.syncStatePath(), err)
+		return nil
+	}
+	return state
+}
+
+// saveSyncState only warns if it fails, because the cache has been synced. The next sync
+// will be a full sync.
+func (s *CachedRepository) saveSyncState(state *cacheSyncState) {
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = writeFileAtomic(s.syncStatePath(), bytes.NewReader(data), 0644)
+	}
+	if err != nil {
+		console.Warn("Failed to save cache sync state to %s: %s", s.syncStatePath(), err)
+	}
 }
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type ChecksumType string
+
+const (
+	ChecksumMD5 ChecksumType = "md5"
+	// ChecksumMultipartETag is the ETag S3 gives objects that were uploaded in parts. It is
+	// the MD5 of the MD5s of each part, so it depends on the size of the parts, and can only
+	// be compared to ETags of objects that were uploaded with the same number of parts.
+	ChecksumMultipartETag ChecksumType = "multipart-etag"
+	// ChecksumCRC32C is what Google Cloud Storage gives composite objects, which don't have an MD5
+	ChecksumCRC32C ChecksumType = "crc32c"
+)
+
+// Checksum is a hash of a file's contents, as reported by the repository it is in
+//
+// Different repositories have different types of checksum, and checksums of different
+// types can't be compared. The zero Checksum means the repository doesn't know.
+type Checksum struct {
+	Type  ChecksumType
+	Value []byte
+	// Parts is the number of parts a ChecksumMultipartETag was uploaded in
+	Parts int
+}
+
+// MD5Checksum returns a Checksum for the MD5 hash md5sum
+func MD5Checksum(md5sum []byte) Checksum {
+	return Checksum{Type: ChecksumMD5, Value: md5sum}
+}
+
+// IsZero returns true if the checksum is unknown
+func (c Checksum) IsZero() bool {
+	return c.Type == "" || len(c.Value) == 0
+}
+
+// Compare returns whether c and other are checksums of the same data. If they can't be
+// compared, because they are different types of checksum or either is unknown, ok is false.
+func (c Checksum) Compare(other Checksum) (equal bool, ok bool) {
+	if c.IsZero() || other.IsZero() || c.Type != other.Type {
+		return false, false
+	}
+	if c.Type == ChecksumMultipartETag && c.Parts != other.Parts {
+		return false, false
+	}
+	return bytes.Equal(c.Value, other.Value), true
+}
+
+// String formats the checksum as "<type>:<hex value>", with "-<parts>" on the end of
+// multipart ETags. The zero Checksum is formatted as "".
+func (c Checksum) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	s := string(c.Type) + ":" + hex.EncodeToString(c.Value)
+	if c.Type == ChecksumMultipartETag {
+		s += "-" + strconv.Itoa(c.Parts)
+	}
+	return s
+}
+
+// ParseChecksum parses a checksum formatted with Checksum.String()
+func ParseChecksum(s string) (Checksum, error) {
+	if s == "" {
+		return Checksum{}, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return Checksum{}, fmt.Errorf("Invalid checksum %q", s)
+	}
+	c := Checksum{Type: ChecksumType(parts[0])}
+	value := parts[1]
+	switch c.Type {
+	case ChecksumMD5, ChecksumCRC32C:
+	case ChecksumMultipartETag:
+		i := strings.LastIndex(value, "-")
+		if i == -1 {
+			return Checksum{}, fmt.Errorf("Invalid checksum %q, multipart ETags must end with the number of parts", s)
+		}
+		n, err := strconv.Atoi(value[i+1:])
+		if err != nil {
+			return Checksum{}, fmt.Errorf("Invalid checksum %q: %w", s, err)
+		}
+		c.Parts = n
+		value = value[:i]
+	default:
+		return Checksum{}, fmt.Errorf("Invalid checksum %q, unknown type %q", s, c.Type)
+	}
+	v, err := hex.DecodeString(value)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("Invalid checksum %q: %w", s, err)
+	}
+	c.Value = v
+	return c, nil
+}
+
+// s3ETagChecksum returns the checksum for an S3 ETag. Objects uploaded in one part have the
+// MD5 of their contents as their ETag, and objects uploaded in parts have a multipart ETag,
+// like "<hex>-<number of parts>". If the ETag isn't either of these, it returns the zero Checksum.
+func s3ETagChecksum(etag string) Checksum {
+	// The ETag includes quotes for some reason
+	etag = strings.Trim(etag, "\"")
+	if i := strings.Index(etag, "-"); i != -1 {
+		value, err := hex.DecodeString(etag[:i])
+		if err != nil {
+			return Checksum{}
+		}
+		parts, err := strconv.Atoi(etag[i+1:])
+		if err != nil {
+			return Checksum{}
+		}
+		return Checksum{Type: ChecksumMultipartETag, Value: value, Parts: parts}
+	}
+	value, err := hex.DecodeString(etag)
+	if err != nil {
+		return Checksum{}
+	}
+	return MD5Checksum(value)
+}
+
+// crc32cChecksum returns the checksum for the CRC32C crc, in big-endian order like
+// Google Cloud Storage reports it
+func crc32cChecksum(crc uint32) Checksum {
+	return Checksum{
+		Type:  ChecksumCRC32C,
+		Value: []byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)},
+	}
+}
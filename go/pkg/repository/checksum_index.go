@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/replicate/replicate/go/pkg/console"
+)
+
+// checksumIndexRacyWindow is how recently a file can have been modified for its checksum
+// not to be saved in the index. Some filesystems only store modification times to the
+// second (or two), so a file could be modified again without its modification time changing.
+const checksumIndexRacyWindow = 2 * time.Second
+
+// checksumIndex is a file that stores the MD5s of files in a DiskRepository, so listing it
+// doesn't have to read every file. A checksum is used if the file's size and modification
+// time haven't changed since it was hashed.
+//
+// Entries are removed when files are written or deleted through the repository, and the index
+// is saved after each listing.
+type checksumIndex struct {
+	path string
+
+	mu      sync.Mutex
+	loaded  bool
+	dirty   bool
+	entries map[string]checksumIndexEntry
+}
+
+type checksumIndexEntry struct {
+	Size int64 `json:"size"`
+	// MTime is the modification time, in nanoseconds since the epoch
+	MTime int64  `json:"mtime"`
+	MD5   string `json:"md5"`
+}
+
+type checksumIndexFile struct {
+	Files map[string]checksumIndexEntry `json:"files"`
+}
+
+func newChecksumIndex(indexPath string) *checksumIndex {
+	return &checksumIndex{path: indexPath, entries: map[string]checksumIndexEntry{}}
+}
+
+// load reads the index from disk the first time it is used. An index that can't be read is
+// treated as empty, because it is only a cache.
+func (c *checksumIndex) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			console.Debug("Failed to read checksum index %s: %s", c.path, err)
+		}
+		return
+	}
+	file := new(checksumIndexFile)
+	if err := json.Unmarshal(data, file); err != nil {
+		console.Debug("Failed to parse checksum index %s: %s", c.path, err)
+		return
+	}
+	if file.Files != nil {
+		c.entries = file.Files
+	}
+}
+
+// get returns the MD5 of p, if it is in the index and the file hasn't changed
+func (c *checksumIndex) get(p string, info os.FileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	entry, ok := c.entries[p]
+	if !ok || entry.Size != info.Size() || entry.MTime != info.ModTime().UnixNano() {
+		return nil, false
+	}
+	md5sum, err := hex.DecodeString(entry.MD5)
+	if err != nil {
+		return nil, false
+	}
+	return md5sum, true
+}
+
+// set adds the MD5 of p to the index, unless it was modified too recently to trust its
+// modification time
+func (c *checksumIndex) set(p string, info os.FileInfo, md5sum []byte) {
+	if time.Since(info.ModTime()) < checksumIndexRacyWindow {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	c.entries[p] = checksumIndexEntry{
+		Size:  info.Size(),
+		MTime: info.ModTime().UnixNano(),
+		MD5:   hex.EncodeToString(md5sum),
+	}
+	c.dirty = true
+}
+
+// invalidate removes p from the index. If p is a directory, everything in it is removed.
+func (c *checksumIndex) invalidate(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	p = path.Clean(p)
+	if p == "." || p == "/" {
+		p = ""
+	}
+	for entryPath := range c.entries {
+		if isUnderPrefix(entryPath, p) {
+			delete(c.entries, entryPath)
+			c.dirty = true
+		}
+	}
+}
+
+// prune removes entries in dir that weren't seen when it was listed, because the files
+// were deleted without going through the repository
+func (c *checksumIndex) prune(dir string, seen map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dir = path.Clean(dir)
+	if dir == "." || dir == "/" {
+		dir = ""
+	}
+	for entryPath := range c.entries {
+		if isUnderPrefix(entryPath, dir) && !seen[entryPath] {
+			delete(c.entries, entryPath)
+			c.dirty = true
+		}
+	}
+}
+
+// save writes the index to disk, if it has changed
+func (c *checksumIndex) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.Marshal(&checksumIndexFile{Files: c.entries})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path, bytes.NewReader(data), 0644); err != nil {
+		return fmt.Errorf("Failed to save checksum index: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumString(t *testing.T) {
+	for _, c := range []Checksum{
+		MD5Checksum([]byte{0x93, 0x48, 0xae}),
+		{Type: ChecksumMultipartETag, Value: []byte{0x93, 0x48}, Parts: 12},
+		crc32cChecksum(0x01020304),
+		{},
+	} {
+		parsed, err := ParseChecksum(c.String())
+		require.NoError(t, err)
+		require.Equal(t, c, parsed)
+	}
+	require.Equal(t, "md5:9348ae", MD5Checksum([]byte{0x93, 0x48, 0xae}).String())
+	require.Equal(t, "crc32c:01020304", crc32cChecksum(0x01020304).String())
+
+	for _, s := range []string{"9348ae", "sha1:9348ae", "md5:zz", "multipart-etag:9348"} {
+		_, err := ParseChecksum(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestS3ETagChecksum(t *testing.T) {
+	require.Equal(t, MD5Checksum([]byte{0x93, 0x48, 0xae}), s3ETagChecksum(`"9348ae"`))
+	require.Equal(t, Checksum{Type: ChecksumMultipartETag, Value: []byte{0x93, 0x48, 0xae}, Parts: 3}, s3ETagChecksum(`"9348ae-3"`))
+	require.True(t, s3ETagChecksum(`"not an etag"`).IsZero())
+	require.True(t, s3ETagChecksum("").IsZero())
+}
+
+func TestChecksumCompare(t *testing.T) {
+	md5 := MD5Checksum([]byte{1, 2, 3})
+	equal, ok := md5.Compare(MD5Checksum([]byte{1, 2, 3}))
+	require.True(t, ok)
+	require.True(t, equal)
+	equal, ok = md5.Compare(MD5Checksum([]byte{3, 2, 1}))
+	require.True(t, ok)
+	require.False(t, equal)
+
+	// Different types, unknown checksums, and multipart ETags with different parts can't be compared
+	_, ok = md5.Compare(crc32cChecksum(1))
+	require.False(t, ok)
+	_, ok = md5.Compare(Checksum{})
+	require.False(t, ok)
+	_, ok = Checksum{Type: ChecksumMultipartETag, Value: []byte{1}, Parts: 2}.Compare(Checksum{Type: ChecksumMultipartETag, Value: []byte{1}, Parts: 3})
+	require.False(t, ok)
+}
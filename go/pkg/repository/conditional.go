@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/replicate/replicate/go/pkg/console"
+)
+
+// maxUpdateAttempts is the number of times Update() reads and writes a file before giving up,
+// if something else keeps changing it
+const maxUpdateAttempts = 10
+
+// ConditionalRepository is a repository that can write a file only if it hasn't changed since
+// it was read, so processes that update the same file don't overwrite each other's changes.
+//
+// A version is an opaque string that identifies the contents of a file: an ETag on S3, a
+// generation on Google Cloud Storage, and an MD5 on disk. The empty version means the file
+// does not exist.
+type ConditionalRepository interface {
+	Repository
+
+	// GetWithVersion returns the data at path and its version
+	GetWithVersion(ctx context.Context, path string) (data []byte, version string, err error)
+
+	// PutIfMatch writes data to path if the version of path is `version`, or if path does not
+	// exist and `version` is empty. Otherwise, it returns a VersionMismatchError.
+	PutIfMatch(ctx context.Context, path string, data []byte, version string) error
+}
+
+// SupportsConditionalWrites returns true if GetWithVersion() and PutIfMatch() can be used on
+// the repository
+func SupportsConditionalWrites(repo Repository) bool {
+	switch r := repo.(type) {
+	case *EncryptedRepository:
+		return SupportsConditionalWrites(r.repository)
+	case *RetryRepository:
+		return SupportsConditionalWrites(r.repository)
+	case *CachedRepository:
+		return SupportsConditionalWrites(r.repository)
+	case *ManifestRepository:
+		return SupportsConditionalWrites(r.repository)
+	case ConditionalRepository:
+		return true
+	}
+	return false
+}
+
+// asConditional returns repo as a ConditionalRepository, for wrappers that pass conditional
+// operations through to the repository they wrap
+func asConditional(repo Repository) (ConditionalRepository, error) {
+	if !SupportsConditionalWrites(repo) {
+		return nil, fmt.Errorf("The repository %s does not support conditional writes", repo.RootURL())
+	}
+	return repo.(ConditionalRepository), nil
+}
+
+// Update reads the data at path, passes it to update, and writes what update returns back
+// to path. If path does not exist, update is passed nil.
+//
+// If the repository supports conditional writes and path is changed by something else
+// between the read and the write, the data is read again and update is called again, so
+// update must be safe to call more than once. If the repository doesn't support conditional
+// writes, the data is written unconditionally.
+func Update(ctx context.Context, repo Repository, p string, update func(data []byte) ([]byte, error)) error {
+	if !SupportsConditionalWrites(repo) {
+		data, err := repo.Get(ctx, p)
+		if err != nil {
+			var doesNotExist *DoesNotExistError
+			if !errors.As(err, &doesNotExist) {
+				return err
+			}
+			data = nil
+		}
+		newData, err := update(data)
+		if err != nil {
+			return err
+		}
+		return repo.Put(ctx, p, newData)
+	}
+
+	conditional := repo.(ConditionalRepository)
+	for attempt := 1; attempt <= maxUpdateAttempts; attempt++ {
+		data, version, err := conditional.GetWithVersion(ctx, p)
+		if err != nil {
+			var doesNotExist *DoesNotExistError
+			if !errors.As(err, &doesNotExist) {
+				return err
+			}
+			data, version = nil, ""
+		}
+		newData, err := update(data)
+		if err != nil {
+			return err
+		}
+		err = conditional.PutIfMatch(ctx, p, newData, version)
+		var mismatch *VersionMismatchError
+		if !errors.As(err, &mismatch) {
+			return err
+		}
+		console.Debug("%s/%s was changed while it was being updated, trying again", repo.RootURL(), p)
+	}
+	return fmt.Errorf("Failed to update %s/%s, because it was changed by something else %d times in a row", repo.RootURL(), p, maxUpdateAttempts)
+}
+
+// md5Version returns the version of data for repositories that don't store versions, like
+// disk and memory
+func md5Version(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func versionMismatchError(rootURL, p string) error {
+	return &VersionMismatchError{msg: "PutIfMatch: " + rootURL + "/" + p + " has been changed since it was read"}
+}
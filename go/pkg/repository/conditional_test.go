@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testConditionalWrites(t *testing.T, repository ConditionalRepository) {
+	ctx := context.Background()
+
+	_, _, err := repository.GetWithVersion(ctx, "some-file")
+	require.IsType(t, &DoesNotExistError{}, err)
+
+	// Empty version means the file must not exist
+	require.NoError(t, repository.PutIfMatch(ctx, "some-file", []byte("hello"), ""))
+	err = repository.PutIfMatch(ctx, "some-file", []byte("hello again"), "")
+	require.IsType(t, &VersionMismatchError{}, err)
+
+	data, version, err := repository.GetWithVersion(ctx, "some-file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), data)
+	require.NotEmpty(t, version)
+
+	require.NoError(t, repository.PutIfMatch(ctx, "some-file", []byte("goodbye"), version))
+
+	// Version is stale now
+	err = repository.PutIfMatch(ctx, "some-file", []byte("hello again"), version)
+	require.IsType(t, &VersionMismatchError{}, err)
+	data, err = repository.Get(ctx, "some-file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("goodbye"), data)
+}
+
+func TestMemoryRepositoryConditionalWrites(t *testing.T) {
+	repository, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	testConditionalWrites(t, repository)
+}
+
+func TestDiskRepositoryConditionalWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repository, err := NewDiskRepository(dir)
+	require.NoError(t, err)
+	testConditionalWrites(t, repository)
+
+	// Lock file is cleaned up
+	_, err = os.Stat(path.Join(dir, ".some-file.lock"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestDiskRepositoryStaleLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repository, err := NewDiskRepository(dir)
+	require.NoError(t, err)
+
+	lockPath := path.Join(dir, ".some-file.lock")
+	require.NoError(t, ioutil.WriteFile(lockPath, []byte{}, 0644))
+
+	// Waits for a lock that is held
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = repository.PutIfMatch(ctx, "some-file", []byte("hello"), "")
+	require.Error(t, err)
+
+	// Removes a lock that was left behind
+	old := time.Now().Add(-2 * diskLockStaleAfter)
+	require.NoError(t, os.Chtimes(lockPath, old, old))
+	require.NoError(t, repository.PutIfMatch(context.Background(), "some-file", []byte("hello"), ""))
+}
+
+func TestWrappedConditionalWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	encrypted, inner := newTestEncryptedRepository(t)
+	testConditionalWrites(t, encrypted)
+	// Data is encrypted in the inner repository
+	data, err := inner.Get(context.Background(), "some-file")
+	require.NoError(t, err)
+	require.NotEqual(t, []byte("goodbye"), data)
+
+	memory, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	testConditionalWrites(t, NewRetryRepository(memory, RetryOptions{MaxAttempts: 3}))
+
+	memory, err = NewMemoryRepository("", "")
+	require.NoError(t, err)
+	cached, err := NewCachedRepository(memory, "some", dir)
+	require.NoError(t, err)
+	testConditionalWrites(t, cached)
+	data, err = ioutil.ReadFile(path.Join(dir, "some-file"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("goodbye"), data)
+}
+
+func TestSupportsConditionalWrites(t *testing.T) {
+	memory, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	require.True(t, SupportsConditionalWrites(memory))
+	require.True(t, SupportsConditionalWrites(NewRetryRepository(memory, DefaultRetryOptions())))
+
+	http, err := NewHTTPRepository("https://example.com/repo")
+	require.NoError(t, err)
+	require.False(t, SupportsConditionalWrites(http))
+	retry := NewRetryRepository(http, DefaultRetryOptions())
+	require.False(t, SupportsConditionalWrites(retry))
+	_, _, err = retry.GetWithVersion(context.Background(), "some-file")
+	require.Error(t, err)
+}
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repository, err := NewDiskRepository(dir)
+	require.NoError(t, err)
+
+	// Concurrent increments of a counter aren't lost
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Update(context.Background(), repository, "counter", func(data []byte) ([]byte, error) {
+				count := 0
+				if data != nil {
+					var err error
+					count, err = strconv.Atoi(string(data))
+					if err != nil {
+						return nil, err
+					}
+				}
+				return []byte(strconv.Itoa(count + 1)), nil
+			})
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	data, err := repository.Get(context.Background(), "counter")
+	require.NoError(t, err)
+	require.Equal(t, "5", string(data))
+}
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// copyByStreaming copies src to dst in repo by downloading and uploading it, for repositories
+// that can't copy files themselves
+func copyByStreaming(ctx context.Context, repo Repository, src, dst string) error {
+	reader, err := repo.GetReader(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := repo.PutReader(ctx, dst, reader); err != nil {
+		return fmt.Errorf("Failed to copy %s/%s to %s: %w", repo.RootURL(), src, dst, err)
+	}
+	return nil
+}
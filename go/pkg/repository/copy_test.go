@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testCopyAndMove(t *testing.T, repository Repository) {
+	ctx := context.Background()
+
+	require.NoError(t, repository.Put(ctx, "some/file", []byte("hello")))
+	require.NoError(t, repository.Copy(ctx, "some/file", "copied/file"))
+	data, err := repository.Get(ctx, "copied/file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), data)
+
+	// The copy is independent of the original
+	require.NoError(t, repository.Put(ctx, "copied/file", []byte("goodbye")))
+	data, err = repository.Get(ctx, "some/file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), data)
+
+	// Replaces what is already there
+	require.NoError(t, repository.Copy(ctx, "some/file", "copied/file"))
+	data, err = repository.Get(ctx, "copied/file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), data)
+
+	require.NoError(t, repository.Move(ctx, "some/file", "other/dir/file"))
+	data, err = repository.Get(ctx, "other/dir/file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), data)
+	_, err = repository.Get(ctx, "some/file")
+	require.IsType(t, &DoesNotExistError{}, err)
+
+	err = repository.Move(ctx, "some/file", "another/file")
+	require.IsType(t, &DoesNotExistError{}, err)
+	err = repository.Copy(ctx, "some/file", "another/file")
+	require.IsType(t, &DoesNotExistError{}, err)
+}
+
+func TestMemoryRepositoryCopyAndMove(t *testing.T) {
+	repository, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	testCopyAndMove(t, repository)
+}
+
+func TestDiskRepositoryCopyAndMove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repository, err := NewDiskRepository(dir)
+	require.NoError(t, err)
+	testCopyAndMove(t, repository)
+
+	// Copies are hard links
+	require.NoError(t, repository.Copy(context.Background(), "other/dir/file", "linked"))
+	info, err := os.Stat(path.Join(dir, "other/dir/file"))
+	require.NoError(t, err)
+	linkedInfo, err := os.Stat(path.Join(dir, "linked"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(info, linkedInfo))
+}
+
+func TestCopyByStreaming(t *testing.T) {
+	repository, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, repository.Put(ctx, "some/file", []byte("hello")))
+	require.NoError(t, copyByStreaming(ctx, repository, "some/file", "copied/file"))
+	data, err := repository.Get(ctx, "copied/file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), data)
+	err = copyByStreaming(ctx, repository, "missing", "copied/file")
+	require.IsType(t, &DoesNotExistError{}, err)
+}
+
+func TestWrappedCopyAndMove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	encrypted, _ := newTestEncryptedRepository(t)
+	testCopyAndMove(t, encrypted)
+
+	memory, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	testCopyAndMove(t, NewRetryRepository(memory, RetryOptions{MaxAttempts: 3}))
+
+	// Copying and moving in and out of the cached prefix keeps the cache up to date
+	memory, err = NewMemoryRepository("", "")
+	require.NoError(t, err)
+	cached, err := NewCachedRepository(memory, "some", dir)
+	require.NoError(t, err)
+	testCopyAndMove(t, cached)
+
+	ctx := context.Background()
+	require.NoError(t, cached.Copy(ctx, "other/dir/file", "some/copied"))
+	data, err := ioutil.ReadFile(path.Join(dir, "some/copied"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), data)
+	require.NoError(t, cached.Move(ctx, "other/dir/file", "some/file"))
+	require.NoError(t, cached.Move(ctx, "some/file", "some/renamed"))
+	data, err = ioutil.ReadFile(path.Join(dir, "some/renamed"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), data)
+	_, err = os.Stat(path.Join(dir, "some/file"))
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, cached.Move(ctx, "some/renamed", "other/file"))
+	_, err = os.Stat(path.Join(dir, "some/renamed"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestHTTPRepositoryCopyAndMove(t *testing.T) {
+	repository, err := NewHTTPRepository("https://example.com/repo")
+	require.NoError(t, err)
+	require.IsType(t, &ReadOnlyError{}, repository.Copy(context.Background(), "a", "b"))
+	require.IsType(t, &ReadOnlyError{}, repository.Move(context.Background(), "a", "b"))
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"fmt"
 	"io"
@@ -9,14 +11,19 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/otiai10/copy"
 
+	"github.com/replicate/replicate/go/pkg/console"
 	"github.com/replicate/replicate/go/pkg/files"
+	"github.com/replicate/replicate/go/pkg/hash"
 )
 
 type DiskRepository struct {
 	rootDir string
+	// checksums is nil if the repository doesn't have a checksum index
+	checksums *checksumIndex
 }
 
 func NewDiskRepository(rootDir string) (*DiskRepository, error) {
@@ -25,12 +32,22 @@ func NewDiskRepository(rootDir string) (*DiskRepository, error) {
 	}, nil
 }
 
+// NewDiskRepositoryWithChecksumIndex returns a DiskRepository that stores the checksums of
+// its files in indexPath, so ListRecursive() only has to read files that have changed.
+// indexPath must be outside rootDir.
+func NewDiskRepositoryWithChecksumIndex(rootDir, indexPath string) (*DiskRepository, error) {
+	return &DiskRepository{
+		rootDir:   rootDir,
+		checksums: newChecksumIndex(indexPath),
+	}, nil
+}
+
 func (s *DiskRepository) RootURL() string {
 	return "file://" + s.rootDir
 }
 
 // Get data at path
-func (s *DiskRepository) Get(p string) ([]byte, error) {
+func (s *DiskRepository) Get(ctx context.Context, p string) ([]byte, error) {
 	data, err := ioutil.ReadFile(path.Join(s.rootDir, p))
 	if err != nil && os.IsNotExist(err) {
 		return nil, &DoesNotExistError{msg: "Get: path does not exist: " + p}
@@ -38,8 +55,23 @@ func (s *DiskRepository) Get(p string) ([]byte, error) {
 	return data, err
 }
 
+// GetReader returns a reader for the data at path
+func (s *DiskRepository) GetReader(ctx context.Context, p string) (io.ReadCloser, error) {
+	f, err := os.Open(path.Join(s.rootDir, p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &DoesNotExistError{msg: "GetReader: path does not exist: " + p}
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
 // GetPath recursively copies repoDir to localDir
-func (s *DiskRepository) GetPath(repoDir string, localDir string) error {
+func (s *DiskRepository) GetPath(ctx context.Context, repoDir string, localDir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if err := copy.Copy(path.Join(s.rootDir, repoDir), localDir); err != nil {
 		return fmt.Errorf("Failed to copy directory from %s to %s: %w", repoDir, localDir, err)
 	}
@@ -49,7 +81,7 @@ func (s *DiskRepository) GetPath(repoDir string, localDir string) error {
 // GetPathTar extracts tarball `tarPath` to `localPath`
 //
 // See repository.go for full documentation.
-func (s *DiskRepository) GetPathTar(tarPath, localPath string) error {
+func (s *DiskRepository) GetPathTar(ctx context.Context, tarPath, localPath string) error {
 	fullTarPath := path.Join(s.rootDir, tarPath)
 	exists, err := files.FileExists(fullTarPath)
 	if err != nil {
@@ -58,43 +90,95 @@ func (s *DiskRepository) GetPathTar(tarPath, localPath string) error {
 	if !exists {
 		return &DoesNotExistError{msg: "GetPathTar: does not exist: " + fullTarPath}
 	}
-	return extractTar(fullTarPath, localPath)
+	f, err := os.Open(fullTarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return extractTar(ctx, f, localPath)
 }
 
 // Put data at path
-func (s *DiskRepository) Put(p string, data []byte) error {
+func (s *DiskRepository) Put(ctx context.Context, p string, data []byte) error {
+	return s.PutReader(ctx, p, bytes.NewReader(data))
+}
+
+// PutReader streams data from reader to path
+func (s *DiskRepository) PutReader(ctx context.Context, p string, reader io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.invalidateChecksums(p)
+	// Write atomically so cancelled writes don't leave half-written files in the repository
+	return writeFileAtomic(path.Join(s.rootDir, p), reader, 0644)
+}
+
+// GetWithVersion returns the data at path and its MD5, as its version
+func (s *DiskRepository) GetWithVersion(ctx context.Context, p string) ([]byte, string, error) {
+	data, err := s.Get(ctx, p)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, md5Version(data), nil
+}
+
+// PutIfMatch writes data to path if the version of path is still `version`
+//
+// Writers hold a lock file next to path while they compare versions and write, so they
+// can't interleave. The data is renamed into place, so readers don't need the lock.
+func (s *DiskRepository) PutIfMatch(ctx context.Context, p string, data []byte, version string) error {
 	fullPath := path.Join(s.rootDir, p)
-	err := os.MkdirAll(filepath.Dir(fullPath), 0755)
+	unlock, err := lockFile(ctx, path.Join(path.Dir(fullPath), "."+path.Base(fullPath)+".lock"))
 	if err != nil {
+		return fmt.Errorf("Failed to lock %s: %w", fullPath, err)
+	}
+	defer unlock()
+
+	currentVersion := ""
+	current, err := ioutil.ReadFile(fullPath)
+	if err == nil {
+		currentVersion = md5Version(current)
+	} else if !os.IsNotExist(err) {
 		return err
 	}
-	return ioutil.WriteFile(fullPath, data, 0644)
+	if currentVersion != version {
+		return versionMismatchError(s.RootURL(), p)
+	}
+	s.invalidateChecksums(p)
+	return writeFileAtomic(fullPath, bytes.NewReader(data), 0644)
 }
 
 // PutPath recursively puts the local `localPath` directory into path `repoPath` in the repository
-func (s *DiskRepository) PutPath(localPath string, repoPath string) error {
+func (s *DiskRepository) PutPath(ctx context.Context, localPath string, repoPath string) error {
 	files, err := getListOfFilesToPut(localPath, repoPath)
 	if err != nil {
 		return err
 	}
 	for _, file := range files {
-		data, err := ioutil.ReadFile(file.Source)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		err = s.Put(file.Dest, data)
-		if err != nil {
+		if err := s.putFile(ctx, file); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func (s *DiskRepository) putFile(ctx context.Context, file fileToPut) error {
+	f, err := os.Open(file.Source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.PutReader(ctx, file.Dest, f)
+}
+
 // PutPathTar recursively puts the local `localPath` directory into a tar.gz file `tarPath` in the repository
 // If `includePath` is set, only that will be included.
 //
 // See repository.go for full documentation.
-func (s *DiskRepository) PutPathTar(localPath, tarPath, includePath string) error {
+func (s *DiskRepository) PutPathTar(ctx context.Context, localPath, tarPath, includePath string) error {
 	if !strings.HasSuffix(tarPath, ".tar.gz") {
 		return fmt.Errorf("PutPathTar: tarPath must end with .tar.gz")
 	}
@@ -105,25 +189,82 @@ func (s *DiskRepository) PutPathTar(localPath, tarPath, includePath string) erro
 		return err
 	}
 
-	tarFile, err := os.Create(fullPath)
-	if err != nil {
-		return err
+	s.invalidateChecksums(tarPath)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(putPathTar(ctx, localPath, writer, filepath.Base(tarPath), includePath))
+	}()
+	err = writeFileAtomic(fullPath, reader, 0644)
+	// Unblock the tarball writer if writing fails
+	reader.CloseWithError(err)
+	return err
+}
+
+// Delete deletes path. If path is a directory, it recursively deletes
+// all everything under path
+func (s *DiskRepository) Delete(ctx context.Context, pathToDelete string) error {
+	s.invalidateChecksums(pathToDelete)
+	if err := os.RemoveAll(path.Join(s.rootDir, pathToDelete)); err != nil {
+		return fmt.Errorf("Failed to delete %s/%s: %w", s.rootDir, pathToDelete, err)
 	}
-	defer tarFile.Close()
+	return nil
+}
 
-	if err := putPathTar(localPath, tarFile, filepath.Base(tarPath), includePath); err != nil {
+// Copy hard links dst to src, so the data isn't copied. Files in the repository are never
+// modified in place, only replaced by renaming a new file over them, so writing to one path
+// doesn't change the other. If hard links aren't supported, the data is copied.
+func (s *DiskRepository) Copy(ctx context.Context, src, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	srcPath := path.Join(s.rootDir, src)
+	dstPath := path.Join(s.rootDir, dst)
+	if _, err := os.Stat(srcPath); err != nil {
+		if os.IsNotExist(err) {
+			return &DoesNotExistError{msg: "Copy: path does not exist: " + srcPath}
+		}
 		return err
 	}
+	if err := os.MkdirAll(path.Dir(dstPath), 0755); err != nil {
+		return fmt.Errorf("Failed to create directory %s: %w", path.Dir(dstPath), err)
+	}
+	s.invalidateChecksums(dst)
+
+	// Link to a temporary path then rename it, because links can't replace existing files
+	tmpPath := path.Join(path.Dir(dstPath), "."+path.Base(dstPath)+".tmp-"+hash.Random()[:8])
+	if err := os.Link(srcPath, tmpPath); err == nil {
+		if err := os.Rename(tmpPath, dstPath); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("Failed to copy %s to %s: %w", srcPath, dstPath, err)
+		}
+		return nil
+	}
+	console.Debug("Failed to hard link %s to %s, copying instead", srcPath, dstPath)
 
-	// Explicitly call Close() on success to capture error
-	return tarFile.Close()
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("Failed to copy %s to %s: %w", srcPath, dstPath, err)
+	}
+	defer f.Close()
+	return writeFileAtomic(dstPath, f, 0644)
 }
 
-// Delete deletes path. If path is a directory, it recursively deletes
-// all everything under path
-func (s *DiskRepository) Delete(pathToDelete string) error {
-	if err := os.RemoveAll(path.Join(s.rootDir, pathToDelete)); err != nil {
-		return fmt.Errorf("Failed to delete %s/%s: %w", s.rootDir, pathToDelete, err)
+// Move renames the file at src to dst
+func (s *DiskRepository) Move(ctx context.Context, src, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	srcPath := path.Join(s.rootDir, src)
+	dstPath := path.Join(s.rootDir, dst)
+	if err := os.MkdirAll(path.Dir(dstPath), 0755); err != nil {
+		return fmt.Errorf("Failed to create directory %s: %w", path.Dir(dstPath), err)
+	}
+	s.invalidateChecksums(src, dst)
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		if os.IsNotExist(err) {
+			return &DoesNotExistError{msg: "Move: path does not exist: " + srcPath}
+		}
+		return fmt.Errorf("Failed to move %s to %s: %w", srcPath, dstPath, err)
 	}
 	return nil
 }
@@ -133,7 +274,7 @@ func (s *DiskRepository) Delete(pathToDelete string) error {
 // Returns a list of paths, prefixed with the given path, that can be passed straight to Get().
 // Directories are not listed.
 // If path does not exist, an empty list will be returned.
-func (s *DiskRepository) List(p string) ([]string, error) {
+func (s *DiskRepository) List(ctx context.Context, p string) ([]string, error) {
 	files, err := ioutil.ReadDir(path.Join(s.rootDir, p))
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -150,62 +291,103 @@ func (s *DiskRepository) List(p string) ([]string, error) {
 	return result, nil
 }
 
-func (s *DiskRepository) ListRecursive(results chan<- ListResult, folder string) {
-	err := filepath.Walk(path.Join(s.rootDir, folder), func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+func (s *DiskRepository) ListRecursive(ctx context.Context, results chan<- ListResult, folder string) {
+	defer close(results)
+	seen := map[string]bool{}
+	err := s.walk(ctx, results, folder, func(p string, info os.FileInfo) (*ListResult, error) {
+		if info.IsDir() {
+			return nil, nil
 		}
-		if !info.IsDir() {
-			relPath, err := filepath.Rel(s.rootDir, path)
-			if err != nil {
-				return err
-			}
-
-			md5sum, err := md5File(path)
-			if err != nil {
-				return err
-			}
-			results <- ListResult{Path: relPath, MD5: md5sum}
+		md5sum, err := s.md5File(p, info)
+		if err != nil {
+			return nil, err
 		}
-		return nil
+		seen[p] = true
+		return &ListResult{Checksum: MD5Checksum(md5sum), Size: info.Size(), MTime: info.ModTime()}, nil
 	})
-	if err != nil {
-		// If directory does not exist, treat this as empty. This is consistent with how blob storage
-		// would behave
-		if os.IsNotExist(err) {
-			close(results)
-			return
+	if s.checksums != nil && err == nil {
+		s.checksums.prune(folder, seen)
+		if err := s.checksums.save(); err != nil {
+			console.Debug("%s", err)
 		}
-		results <- ListResult{Error: err}
 	}
-	close(results)
 }
 
-func (s *DiskRepository) MatchFilenamesRecursive(results chan<- ListResult, folder string, filename string) {
-	err := filepath.Walk(path.Join(s.rootDir, folder), func(path string, info os.FileInfo, err error) error {
+// md5File returns the MD5 of the file at p, from the checksum index if it hasn't changed
+func (s *DiskRepository) md5File(p string, info os.FileInfo) ([]byte, error) {
+	if s.checksums == nil {
+		return md5File(path.Join(s.rootDir, p))
+	}
+	if md5sum, ok := s.checksums.get(p, info); ok {
+		return md5sum, nil
+	}
+	md5sum, err := md5File(path.Join(s.rootDir, p))
+	if err != nil {
+		return nil, err
+	}
+	s.checksums.set(p, info, md5sum)
+	return md5sum, nil
+}
+
+// invalidateChecksums removes paths from the checksum index, if there is one
+func (s *DiskRepository) invalidateChecksums(paths ...string) {
+	if s.checksums == nil {
+		return
+	}
+	for _, p := range paths {
+		s.checksums.invalidate(p)
+	}
+}
+
+func (s *DiskRepository) MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, folder string, filename string) {
+	defer close(results)
+	s.walk(ctx, results, folder, func(p string, info os.FileInfo) (*ListResult, error) {
+		if filepath.Base(p) != filename {
+			return nil, nil
+		}
+		return &ListResult{Size: info.Size(), MTime: info.ModTime()}, nil
+	})
+}
+
+// walk walks folder, sending a result for each path that fn returns a result for. fn is
+// passed paths relative to the repository root, which are set as the path on the result.
+// If walking fails, the error is sent to results and returned.
+func (s *DiskRepository) walk(ctx context.Context, results chan<- ListResult, folder string, fn func(p string, info os.FileInfo) (*ListResult, error)) error {
+	err := filepath.Walk(path.Join(s.rootDir, folder), func(fullPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if filepath.Base(path) == filename {
-			relPath, err := filepath.Rel(s.rootDir, path)
-			if err != nil {
-				return err
-			}
-			results <- ListResult{Path: relPath}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p, err := filepath.Rel(s.rootDir, fullPath)
+		if err != nil {
+			return err
+		}
+		result, err := fn(p, info)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			return nil
+		}
+		result.Path = p
+		select {
+		case results <- *result:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		return nil
 	})
 	if err != nil {
 		// If directory does not exist, treat this as empty. This is consistent with how blob storage
 		// would behave
 		if os.IsNotExist(err) {
-			close(results)
-			return
+			return nil
 		}
-
-		results <- ListResult{Error: err}
+		sendListError(ctx, results, err)
 	}
-	close(results)
+	return err
 }
 
 func md5File(path string) ([]byte, error) {
@@ -220,3 +402,35 @@ func md5File(path string) ([]byte, error) {
 	}
 	return h.Sum(nil), nil
 }
+
+// diskLockStaleAfter is how old a lock file has to be before it is assumed to have been left
+// behind by a process that crashed
+const diskLockStaleAfter = 30 * time.Second
+
+// lockFile takes a lock by creating lockPath, waiting for it if something else holds it.
+// It returns a function that releases the lock.
+func lockFile(ctx context.Context, lockPath string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, err
+	}
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > diskLockStaleAfter {
+			console.Debug("Removing stale lock %s", lockPath)
+			os.Remove(lockPath)
+			continue
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -1,10 +1,18 @@
 package repository
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -22,13 +30,35 @@ func TestDiskRepositoryGet(t *testing.T) {
 	err = ioutil.WriteFile(path.Join(dir, "some-file"), []byte("hello"), 0644)
 	require.NoError(t, err)
 
-	_, err = repository.Get("does-not-exist")
+	_, err = repository.Get(context.Background(), "does-not-exist")
 	require.IsType(t, &DoesNotExistError{}, err)
 
-	content, err := repository.Get("some-file")
+	content, err := repository.Get(context.Background(), "some-file")
 	require.NoError(t, err)
 	require.Equal(t, []byte("hello"), content)
 }
+func TestDiskRepositoryGetReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repository, err := NewDiskRepository(dir)
+	require.NoError(t, err)
+
+	err = ioutil.WriteFile(path.Join(dir, "some-file"), []byte("hello"), 0644)
+	require.NoError(t, err)
+
+	_, err = repository.GetReader(context.Background(), "does-not-exist")
+	require.IsType(t, &DoesNotExistError{}, err)
+
+	reader, err := repository.GetReader(context.Background(), "some-file")
+	require.NoError(t, err)
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+}
+
 func TestDiskGetPathTar(t *testing.T) {
 	dir, err := ioutil.TempDir("", "replicate-test")
 	require.NoError(t, err)
@@ -39,7 +69,7 @@ func TestDiskGetPathTar(t *testing.T) {
 
 	tmpDir, err := files.TempDir("test")
 	require.NoError(t, err)
-	err = repository.GetPathTar("does-not-exist.tar.gz", tmpDir)
+	err = repository.GetPathTar(context.Background(), "does-not-exist.tar.gz", tmpDir)
 	require.IsType(t, &DoesNotExistError{}, err)
 }
 
@@ -51,14 +81,14 @@ func TestDiskRepositoryPut(t *testing.T) {
 	repository, err := NewDiskRepository(dir)
 	require.NoError(t, err)
 
-	err = repository.Put("some-file", []byte("hello"))
+	err = repository.Put(context.Background(), "some-file", []byte("hello"))
 	require.NoError(t, err)
 
 	content, err := ioutil.ReadFile(path.Join(dir, "some-file"))
 	require.NoError(t, err)
 	require.Equal(t, []byte("hello"), content)
 
-	err = repository.Put("subdirectory/another-file", []byte("hello again"))
+	err = repository.Put(context.Background(), "subdirectory/another-file", []byte("hello again"))
 	require.NoError(t, err)
 
 	content, err = ioutil.ReadFile(path.Join(dir, "subdirectory/another-file"))
@@ -66,6 +96,115 @@ func TestDiskRepositoryPut(t *testing.T) {
 	require.Equal(t, []byte("hello again"), content)
 }
 
+func TestDiskRepositoryPutReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repository, err := NewDiskRepository(dir)
+	require.NoError(t, err)
+
+	err = repository.PutReader(context.Background(), "subdirectory/some-file", strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	content, err := ioutil.ReadFile(path.Join(dir, "subdirectory/some-file"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+}
+
+func TestDiskRepositoryPutReaderFailureLeavesNoFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repository, err := NewDiskRepository(dir)
+	require.NoError(t, err)
+
+	reader := io.MultiReader(strings.NewReader("hel"), &errorReader{fmt.Errorf("connection reset")})
+	err = repository.PutReader(context.Background(), "some-file", reader)
+	require.Error(t, err)
+
+	paths, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, paths)
+}
+
+func TestDiskRepositoryCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repository, err := NewDiskRepository(dir)
+	require.NoError(t, err)
+	require.NoError(t, repository.Put(context.Background(), "checkpoints/abc123.json", []byte("yep")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = repository.Put(ctx, "some-file", []byte("hello"))
+	require.True(t, errors.Is(err, context.Canceled))
+	_, err = os.Stat(path.Join(dir, "some-file"))
+	require.True(t, os.IsNotExist(err))
+
+	err = repository.PutPathTar(ctx, dir, "checkpoints/def456.tar.gz", "")
+	require.True(t, errors.Is(err, context.Canceled))
+
+	results := make(chan ListResult)
+	go repository.ListRecursive(ctx, results, "checkpoints")
+	var listErr error
+	for result := range results {
+		if result.Error != nil {
+			listErr = result.Error
+		}
+	}
+	require.True(t, errors.Is(listErr, context.Canceled))
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestDiskPutPathTarGetPathTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repository, err := NewDiskRepository(dir)
+	require.NoError(t, err)
+
+	workDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+	require.NoError(t, os.MkdirAll(path.Join(workDir, "data/subdirectory"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(workDir, "train.py"), []byte("not included"), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(workDir, "data/weights"), []byte("hello"), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(workDir, "data/subdirectory/more-weights"), []byte("hello again"), 0644))
+
+	err = repository.PutPathTar(context.Background(), workDir, "checkpoints/abc123.tar.gz", "data")
+	require.NoError(t, err)
+
+	outputDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	err = repository.GetPathTar(context.Background(), "checkpoints/abc123.tar.gz", outputDir)
+	require.NoError(t, err)
+
+	content, err := ioutil.ReadFile(path.Join(outputDir, "data/weights"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+	content, err = ioutil.ReadFile(path.Join(outputDir, "data/subdirectory/more-weights"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello again"), content)
+	exists, err := files.FileExists(path.Join(outputDir, "train.py"))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
 func TestDiskRepositoryList(t *testing.T) {
 	dir, err := ioutil.TempDir("", "replicate-test")
 	require.NoError(t, err)
@@ -74,20 +213,20 @@ func TestDiskRepositoryList(t *testing.T) {
 	repository, err := NewDiskRepository(dir)
 	require.NoError(t, err)
 
-	err = repository.Put("some-file", []byte("hello"))
+	err = repository.Put(context.Background(), "some-file", []byte("hello"))
 	require.NoError(t, err)
-	err = repository.Put("dir/another-file", []byte("hello"))
+	err = repository.Put(context.Background(), "dir/another-file", []byte("hello"))
 	require.NoError(t, err)
 
-	paths, err := repository.List("")
+	paths, err := repository.List(context.Background(), "")
 	require.NoError(t, err)
 	require.Equal(t, []string{"some-file"}, paths)
 
-	paths, err = repository.List("dir")
+	paths, err = repository.List(context.Background(), "dir")
 	require.NoError(t, err)
 	require.Equal(t, []string{"dir/another-file"}, paths)
 
-	paths, err = repository.List("dir-that-does-not-exist")
+	paths, err = repository.List(context.Background(), "dir-that-does-not-exist")
 	require.NoError(t, err)
 	require.Equal(t, []string{}, paths)
 }
@@ -107,7 +246,7 @@ func TestPutPath(t *testing.T) {
 	require.NoError(t, os.Mkdir(path.Join(workDir, "subdirectory"), 0755))
 	require.NoError(t, ioutil.WriteFile(path.Join(workDir, "subdirectory/another-file"), []byte("hello again"), 0644))
 
-	err = repository.PutPath(workDir, "parent")
+	err = repository.PutPath(context.Background(), workDir, "parent")
 	require.NoError(t, err)
 
 	content, err := ioutil.ReadFile(path.Join(repositoryDir, "parent/some-file"))
@@ -128,21 +267,88 @@ func TestDiskListRecursive(t *testing.T) {
 	repository, err := NewDiskRepository(dir)
 	require.NoError(t, err)
 	results := make(chan ListResult)
-	go repository.ListRecursive(results, "checkpoints")
+	go repository.ListRecursive(context.Background(), results, "checkpoints")
 	require.Empty(t, <-results)
 
 	// Lists stuff!
-	require.NoError(t, repository.Put("checkpoints/abc123.json", []byte("yep")))
-	require.NoError(t, repository.Put("experiments/def456.json", []byte("nope")))
+	require.NoError(t, repository.Put(context.Background(), "checkpoints/abc123.json", []byte("yep")))
+	require.NoError(t, repository.Put(context.Background(), "experiments/def456.json", []byte("nope")))
 	results = make(chan ListResult)
-	go repository.ListRecursive(results, "checkpoints")
+	go repository.ListRecursive(context.Background(), results, "checkpoints")
 	require.Equal(t, ListResult{
-		Path: "checkpoints/abc123.json",
-		MD5:  []byte{0x93, 0x48, 0xae, 0x78, 0x51, 0xcf, 0x3b, 0xa7, 0x98, 0xd9, 0x56, 0x4e, 0xf3, 0x8, 0xec, 0x25},
-	}, <-results)
+		Path:     "checkpoints/abc123.json",
+		Checksum: MD5Checksum([]byte{0x93, 0x48, 0xae, 0x78, 0x51, 0xcf, 0x3b, 0xa7, 0x98, 0xd9, 0x56, 0x4e, 0xf3, 0x8, 0xec, 0x25}),
+		Size:     3,
+	}, withoutMTime(t, <-results))
 	require.Empty(t, <-results)
 }
 
+func TestDiskListRecursiveChecksumIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	indexDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(indexDir)
+	indexPath := path.Join(indexDir, "checksums.json")
+
+	ctx := context.Background()
+	repository, err := NewDiskRepositoryWithChecksumIndex(dir, indexPath)
+	require.NoError(t, err)
+	listChecksums := func(repository *DiskRepository) map[string]string {
+		results := make(chan ListResult)
+		go repository.ListRecursive(ctx, results, "")
+		checksums := map[string]string{}
+		for result := range results {
+			require.NoError(t, result.Error)
+			checksums[result.Path] = result.Checksum.String()
+		}
+		return checksums
+	}
+	// Files modified in the last couple of seconds aren't indexed, in case they are modified
+	// again without the modification time changing
+	old := time.Now().Add(-time.Hour)
+	setOld := func(p string) {
+		require.NoError(t, os.Chtimes(path.Join(dir, p), old, old))
+	}
+
+	require.NoError(t, repository.Put(ctx, "a/one", []byte("one")))
+	require.NoError(t, repository.Put(ctx, "a/two", []byte("two")))
+	require.NoError(t, repository.Put(ctx, "new", []byte("new")))
+	setOld("a/one")
+	setOld("a/two")
+	listChecksums(repository)
+	data, err := ioutil.ReadFile(indexPath)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"a/one"`)
+	require.Contains(t, string(data), `"a/two"`)
+	require.NotContains(t, string(data), `"new"`)
+
+	// Checksums are read from the index, so a fake checksum in it is returned
+	fakeMD5 := strings.Repeat("ab", 16)
+	oneMD5 := md5.Sum([]byte("one"))
+	data = []byte(strings.Replace(string(data), hex.EncodeToString(oneMD5[:]), fakeMD5, 1))
+	require.NoError(t, ioutil.WriteFile(indexPath, data, 0644))
+	repository, err = NewDiskRepositoryWithChecksumIndex(dir, indexPath)
+	require.NoError(t, err)
+	require.Equal(t, "md5:"+fakeMD5, listChecksums(repository)["a/one"])
+
+	// Writing through the repository invalidates the index, even if the file has the
+	// same size and modification time
+	require.NoError(t, repository.Put(ctx, "a/one", []byte("uno")))
+	setOld("a/one")
+	unoMD5 := md5.Sum([]byte("uno"))
+	require.Equal(t, "md5:"+hex.EncodeToString(unoMD5[:]), listChecksums(repository)["a/one"])
+
+	// Deleted files are removed from the index, whether or not they are deleted through the repository
+	require.NoError(t, repository.Delete(ctx, "a/one"))
+	require.NoError(t, os.Remove(path.Join(dir, "a/two")))
+	require.Len(t, listChecksums(repository), 1)
+	data, err = ioutil.ReadFile(indexPath)
+	require.NoError(t, err)
+	require.Equal(t, `{"files":{}}`, string(data))
+}
+
 func TestDiskMatchFilenamesRecursive(t *testing.T) {
 	dir, err := ioutil.TempDir("", "replicate-test")
 	require.NoError(t, err)
@@ -152,7 +358,7 @@ func TestDiskMatchFilenamesRecursive(t *testing.T) {
 	repository, err := NewDiskRepository(dir)
 	require.NoError(t, err)
 	results := make(chan ListResult)
-	go repository.MatchFilenamesRecursive(results, "checkpoints", "replicate-metadata.json")
+	go repository.MatchFilenamesRecursive(context.Background(), results, "checkpoints", "replicate-metadata.json")
 	v := <-results
 	require.Empty(t, v)
 }
@@ -0,0 +1,421 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replicate/replicate/go/pkg/settings"
+)
+
+// Encrypted objects are made of a header then a sequence of chunks:
+//
+//	magic (8 bytes) | salt (32 bytes) | chunk | chunk | ... | final chunk
+//
+// Each object is encrypted with its own key, derived from the repository key and the salt.
+// Each chunk is up to encryptedChunkSize bytes of plaintext sealed with AES-GCM. The nonce
+// is the chunk's index plus a flag marking the final chunk, so chunks can't be reordered
+// or removed without decryption failing. Every chunk except the final one is full-size.
+const encryptedMagic = "RPLENC01"
+const encryptedSaltSize = 32
+const encryptedChunkSize = 64 * 1024
+const encryptedKeySize = 32
+
+// EncryptionOptions configures where the key for an EncryptedRepository comes from
+type EncryptionOptions struct {
+	// KeyEnv is the name of an environment variable with the key in it
+	KeyEnv string
+	// KeySecret is the name of a secret in the user's settings directory with the key in it
+	KeySecret string
+}
+
+// LoadEncryptionKey loads the key for an EncryptedRepository. The key is 32 bytes, base64-encoded.
+func LoadEncryptionKey(opts EncryptionOptions) ([]byte, error) {
+	var encoded []byte
+	var source string
+	switch {
+	case opts.KeyEnv != "":
+		source = "environment variable " + opts.KeyEnv
+		encoded = []byte(os.Getenv(opts.KeyEnv))
+	case opts.KeySecret != "":
+		source = "secret " + opts.KeySecret
+		var err error
+		encoded, err = settings.GetSecret(opts.KeySecret)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read encryption key from %s: %w", source, err)
+		}
+	default:
+		return nil, fmt.Errorf("No encryption key configured. Set either key_env or key_secret in the encryption section of replicate.yaml.")
+	}
+	if len(bytes.TrimSpace(encoded)) == 0 {
+		return nil, fmt.Errorf("The encryption key in %s is not set", source)
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("The encryption key in %s is not valid base64: %w", source, err)
+	}
+	if len(key) != encryptedKeySize {
+		return nil, fmt.Errorf("The encryption key in %s must be %d bytes, but it is %d bytes. You can generate one with: head -c %d /dev/urandom | base64", source, encryptedKeySize, len(key), encryptedKeySize)
+	}
+	return key, nil
+}
+
+// EncryptedRepository wraps another repository, encrypting data before it is written
+// and decrypting it when it is read.
+//
+// Only the contents of files are encrypted, not paths. List methods return the checksum of
+// the encrypted data, so Sync() compares and copies encrypted files without decrypting them.
+type EncryptedRepository struct {
+	repository Repository
+	key        []byte
+}
+
+func NewEncryptedRepository(repo Repository, key []byte) (*EncryptedRepository, error) {
+	if len(key) != encryptedKeySize {
+		return nil, fmt.Errorf("Encryption key must be %d bytes", encryptedKeySize)
+	}
+	return &EncryptedRepository{
+		repository: repo,
+		key:        key,
+	}, nil
+}
+
+func (s *EncryptedRepository) RootURL() string {
+	return s.repository.RootURL()
+}
+
+// Get data at path
+func (s *EncryptedRepository) Get(ctx context.Context, p string) ([]byte, error) {
+	reader, err := s.GetReader(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s/%s: %w", s.RootURL(), p, err)
+	}
+	return data, nil
+}
+
+// GetReader returns a reader for the decrypted data at path
+func (s *EncryptedRepository) GetReader(ctx context.Context, p string) (io.ReadCloser, error) {
+	reader, err := s.repository.GetReader(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	decrypter, err := newDecryptReader(s.key, reader)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("Failed to decrypt %s/%s: %w", s.RootURL(), p, err)
+	}
+	return decrypter, nil
+}
+
+// GetPath recursively copies repoDir to localDir
+func (s *EncryptedRepository) GetPath(ctx context.Context, repoDir string, localDir string) error {
+	results := make(chan ListResult)
+	go s.repository.ListRecursive(ctx, results, repoDir)
+	for result := range results {
+		if result.Error != nil {
+			return result.Error
+		}
+		relPath, err := filepath.Rel(repoDir, result.Path)
+		if err != nil {
+			return fmt.Errorf("Failed to determine directory of %s relative to %s: %w", result.Path, repoDir, err)
+		}
+		if err := s.download(ctx, result.Path, filepath.Join(localDir, relPath)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EncryptedRepository) download(ctx context.Context, p string, localPath string) error {
+	reader, err := s.GetReader(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := writeFileAtomic(localPath, reader, 0644); err != nil {
+		return fmt.Errorf("Failed to copy %s/%s to %s: %w", s.RootURL(), p, localPath, err)
+	}
+	return nil
+}
+
+// GetPathTar extracts tarball `tarPath` to `localPath`
+//
+// See repository.go for full documentation.
+func (s *EncryptedRepository) GetPathTar(ctx context.Context, tarPath, localPath string) error {
+	reader, err := s.GetReader(ctx, tarPath)
+	if err != nil {
+		if _, ok := err.(*DoesNotExistError); ok {
+			return &DoesNotExistError{msg: "GetPathTar: does not exist: " + tarPath}
+		}
+		return err
+	}
+	defer reader.Close()
+	return extractTar(ctx, reader, localPath)
+}
+
+// Put data at path
+func (s *EncryptedRepository) Put(ctx context.Context, p string, data []byte) error {
+	return s.PutReader(ctx, p, bytes.NewReader(data))
+}
+
+// PutReader encrypts data from reader and streams it to path
+func (s *EncryptedRepository) PutReader(ctx context.Context, p string, reader io.Reader) error {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(encrypt(s.key, pipeWriter, reader))
+	}()
+	err := s.repository.PutReader(ctx, p, pipeReader)
+	// Unblock the encrypter if the upload fails
+	pipeReader.CloseWithError(err)
+	return err
+}
+
+// GetWithVersion returns the decrypted data at path and the version of the encrypted data
+func (s *EncryptedRepository) GetWithVersion(ctx context.Context, p string) ([]byte, string, error) {
+	conditional, err := asConditional(s.repository)
+	if err != nil {
+		return nil, "", err
+	}
+	ciphertext, version, err := conditional.GetWithVersion(ctx, p)
+	if err != nil {
+		return nil, "", err
+	}
+	decrypter, err := newDecryptReader(s.key, ioutil.NopCloser(bytes.NewReader(ciphertext)))
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to decrypt %s/%s: %w", s.RootURL(), p, err)
+	}
+	data, err := ioutil.ReadAll(decrypter)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to decrypt %s/%s: %w", s.RootURL(), p, err)
+	}
+	return data, version, nil
+}
+
+// PutIfMatch encrypts data and writes it to path if the version of path is still `version`
+func (s *EncryptedRepository) PutIfMatch(ctx context.Context, p string, data []byte, version string) error {
+	conditional, err := asConditional(s.repository)
+	if err != nil {
+		return err
+	}
+	var ciphertext bytes.Buffer
+	if err := encrypt(s.key, &ciphertext, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("Failed to encrypt %s/%s: %w", s.RootURL(), p, err)
+	}
+	return conditional.PutIfMatch(ctx, p, ciphertext.Bytes(), version)
+}
+
+// Copy copies the encrypted file at src to dst. It doesn't need to be decrypted, because the
+// encryption doesn't depend on the path.
+func (s *EncryptedRepository) Copy(ctx context.Context, src, dst string) error {
+	return s.repository.Copy(ctx, src, dst)
+}
+
+// Move moves the encrypted file at src to dst
+func (s *EncryptedRepository) Move(ctx context.Context, src, dst string) error {
+	return s.repository.Move(ctx, src, dst)
+}
+
+// PutPath recursively puts the local `localPath` directory into path `repoPath` in the repository
+func (s *EncryptedRepository) PutPath(ctx context.Context, localPath string, repoPath string) error {
+	files, err := getListOfFilesToPut(localPath, repoPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := s.putFile(ctx, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EncryptedRepository) putFile(ctx context.Context, file fileToPut) error {
+	f, err := os.Open(file.Source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.PutReader(ctx, file.Dest, f)
+}
+
+// PutPathTar recursively puts the local `localPath` directory into a tar.gz file `tarPath` in the repository
+// If `includePath` is set, only that will be included.
+//
+// See repository.go for full documentation.
+func (s *EncryptedRepository) PutPathTar(ctx context.Context, localPath, tarPath, includePath string) error {
+	if !strings.HasSuffix(tarPath, ".tar.gz") {
+		return fmt.Errorf("PutPathTar: tarPath must end with .tar.gz")
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(putPathTar(ctx, localPath, writer, filepath.Base(tarPath), includePath))
+	}()
+	err := s.PutReader(ctx, tarPath, reader)
+	reader.CloseWithError(err)
+	return err
+}
+
+func (s *EncryptedRepository) Delete(ctx context.Context, p string) error {
+	return s.repository.Delete(ctx, p)
+}
+
+func (s *EncryptedRepository) List(ctx context.Context, p string) ([]string, error) {
+	return s.repository.List(ctx, p)
+}
+
+func (s *EncryptedRepository) ListRecursive(ctx context.Context, results chan<- ListResult, folder string) {
+	s.repository.ListRecursive(ctx, results, folder)
+}
+
+func (s *EncryptedRepository) MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, folder string, filename string) {
+	s.repository.MatchFilenamesRecursive(ctx, results, folder, filename)
+}
+
+// newObjectCipher returns the cipher for an object with salt
+func newObjectCipher(key []byte, salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, index uint64, final bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], index)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encrypt reads plaintext from reader and writes the encrypted object to w
+func encrypt(key []byte, w io.Writer, reader io.Reader) error {
+	salt := make([]byte, encryptedSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := newObjectCipher(key, salt)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append([]byte(encryptedMagic), salt...)); err != nil {
+		return err
+	}
+
+	// Read one byte ahead so we know whether a chunk is the final one
+	buf := bufio.NewReaderSize(reader, encryptedChunkSize+1)
+	chunk := make([]byte, encryptedChunkSize)
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(buf, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		final := n < encryptedChunkSize
+		if !final {
+			if _, err := buf.Peek(1); err == io.EOF {
+				final = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(aead.Seal(nil, chunkNonce(aead, index, final), chunk[:n], nil)); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+type decryptReader struct {
+	source io.ReadCloser
+	reader *bufio.Reader
+	aead   cipher.AEAD
+	index  uint64
+	done   bool
+	chunk  []byte
+	plain  []byte
+}
+
+func newDecryptReader(key []byte, source io.ReadCloser) (*decryptReader, error) {
+	reader := bufio.NewReaderSize(source, encryptedChunkSize+1024)
+	header := make([]byte, len(encryptedMagic)+encryptedSaltSize)
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(encryptedMagic)]) != encryptedMagic {
+		return nil, fmt.Errorf("Data is not encrypted")
+	}
+	aead, err := newObjectCipher(key, header[len(encryptedMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		source: source,
+		reader: reader,
+		aead:   aead,
+		chunk:  make([]byte, encryptedChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *decryptReader) readChunk() error {
+	n, err := io.ReadFull(r.reader, r.chunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("Encrypted data is truncated")
+	}
+	final := n < len(r.chunk)
+	if !final {
+		if _, err := r.reader.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+	plain, err := r.aead.Open(r.chunk[:0], chunkNonce(r.aead, r.index, final), r.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt data. It might have been encrypted with a different key, or modified.")
+	}
+	r.plain = plain
+	r.index++
+	r.done = final
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.source.Close()
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEncryptedRepository(t *testing.T) (*EncryptedRepository, *MemoryRepository) {
+	inner, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	require.NoError(t, err)
+	repository, err := NewEncryptedRepository(inner, key)
+	require.NoError(t, err)
+	return repository, inner
+}
+
+func TestEncryptedRepositoryGetPut(t *testing.T) {
+	repository, inner := newTestEncryptedRepository(t)
+
+	// Empty, smaller than a chunk, exactly a chunk, and a few chunks
+	for _, size := range []int{0, 100, encryptedChunkSize, encryptedChunkSize*3 + 7} {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+
+		require.NoError(t, repository.Put(context.Background(), "some-file", data))
+		ciphertext, err := inner.Get(context.Background(), "some-file")
+		require.NoError(t, err)
+		if size > 0 {
+			require.False(t, bytes.Contains(ciphertext, data))
+		}
+
+		content, err := repository.Get(context.Background(), "some-file")
+		require.NoError(t, err)
+		require.Equal(t, data, content)
+	}
+
+	_, err := repository.Get(context.Background(), "does-not-exist")
+	require.IsType(t, &DoesNotExistError{}, err)
+}
+
+func TestEncryptedRepositoryTampering(t *testing.T) {
+	repository, inner := newTestEncryptedRepository(t)
+	data := bytes.Repeat([]byte("hello"), encryptedChunkSize)
+	require.NoError(t, repository.Put(context.Background(), "some-file", data))
+	ciphertext, err := inner.Get(context.Background(), "some-file")
+	require.NoError(t, err)
+
+	// Modified
+	modified := append([]byte{}, ciphertext...)
+	modified[len(modified)-1] ^= 1
+	require.NoError(t, inner.Put(context.Background(), "modified", modified))
+	_, err = repository.Get(context.Background(), "modified")
+	require.Error(t, err)
+
+	// Truncated at a chunk boundary
+	headerSize := len(encryptedMagic) + encryptedSaltSize
+	require.NoError(t, inner.Put(context.Background(), "truncated", ciphertext[:headerSize+encryptedChunkSize+16]))
+	_, err = repository.Get(context.Background(), "truncated")
+	require.Error(t, err)
+
+	// Not encrypted
+	require.NoError(t, inner.Put(context.Background(), "plaintext", []byte("hello")))
+	_, err = repository.Get(context.Background(), "plaintext")
+	require.Error(t, err)
+
+	// Different key
+	otherKey := make([]byte, 32)
+	other, err := NewEncryptedRepository(inner, otherKey)
+	require.NoError(t, err)
+	_, err = other.Get(context.Background(), "some-file")
+	require.Error(t, err)
+}
+
+func TestEncryptedRepositoryPaths(t *testing.T) {
+	repository, inner := newTestEncryptedRepository(t)
+
+	workDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+	require.NoError(t, os.MkdirAll(path.Join(workDir, "data/subdirectory"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(workDir, "data/subdirectory/weights"), []byte("hello"), 0644))
+
+	require.NoError(t, repository.PutPath(context.Background(), path.Join(workDir, "data"), "parent"))
+	require.NoError(t, repository.PutPathTar(context.Background(), workDir, "checkpoints/abc123.tar.gz", "data"))
+
+	paths, err := repository.List(context.Background(), "parent/subdirectory")
+	require.NoError(t, err)
+	require.Equal(t, []string{"parent/subdirectory/weights"}, paths)
+	ciphertext, err := inner.Get(context.Background(), "parent/subdirectory/weights")
+	require.NoError(t, err)
+	require.NotEqual(t, []byte("hello"), ciphertext)
+
+	outputDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	require.NoError(t, repository.GetPath(context.Background(), "parent", path.Join(outputDir, "copy")))
+	content, err := ioutil.ReadFile(path.Join(outputDir, "copy/subdirectory/weights"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+
+	require.NoError(t, repository.GetPathTar(context.Background(), "checkpoints/abc123.tar.gz", outputDir))
+	content, err = ioutil.ReadFile(path.Join(outputDir, "data/subdirectory/weights"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+}
+
+func TestEncryptedRepositorySync(t *testing.T) {
+	repository, inner := newTestEncryptedRepository(t)
+	require.NoError(t, repository.Put(context.Background(), "metadata/experiments/abc123.json", []byte("{}")))
+
+	// The cache is synced without decrypting, and can be read through the encrypted repository
+	cacheDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+	cached, err := NewCachedRepository(inner, "metadata", cacheDir)
+	require.NoError(t, err)
+	require.NoError(t, cached.SyncCache(context.Background()))
+	encryptedCache, err := NewEncryptedRepository(cached, repository.key)
+	require.NoError(t, err)
+	content, err := encryptedCache.Get(context.Background(), "metadata/experiments/abc123.json")
+	require.NoError(t, err)
+	require.Equal(t, []byte("{}"), content)
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	os.Setenv("REPLICATE_TEST_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(key)+"\n")
+	defer os.Unsetenv("REPLICATE_TEST_ENCRYPTION_KEY")
+	loaded, err := LoadEncryptionKey(EncryptionOptions{KeyEnv: "REPLICATE_TEST_ENCRYPTION_KEY"})
+	require.NoError(t, err)
+	require.Equal(t, key, loaded)
+
+	_, err = LoadEncryptionKey(EncryptionOptions{KeyEnv: "REPLICATE_TEST_ENCRYPTION_KEY_DOES_NOT_EXIST"})
+	require.Error(t, err)
+
+	os.Setenv("REPLICATE_TEST_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString([]byte("too short")))
+	_, err = LoadEncryptionKey(EncryptionOptions{KeyEnv: "REPLICATE_TEST_ENCRYPTION_KEY"})
+	require.Error(t, err)
+}
@@ -7,3 +7,22 @@ type DoesNotExistError struct {
 func (e *DoesNotExistError) Error() string {
 	return e.msg
 }
+
+// ReadOnlyError is returned when writing to a repository that can't be written to
+type ReadOnlyError struct {
+	msg string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return e.msg
+}
+
+// VersionMismatchError is returned by PutIfMatch when the file has been changed since the
+// version that was passed was read
+type VersionMismatchError struct {
+	msg string
+}
+
+func (e *VersionMismatchError) Error() string {
+	return e.msg
+}
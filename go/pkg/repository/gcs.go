@@ -1,21 +1,25 @@
 package repository
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 
 	"github.com/replicate/replicate/go/pkg/concurrency"
 	"github.com/replicate/replicate/go/pkg/console"
-	"github.com/replicate/replicate/go/pkg/files"
 )
 
 type GCSRepository struct {
@@ -26,7 +30,7 @@ type GCSRepository struct {
 }
 
 func NewGCSRepository(bucket, root string) (*GCSRepository, error) {
-	client, err := storage.NewClient(context.TODO())
+	client, err := storage.NewClient(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("Failed to connect to Google Cloud Storage: %w", err)
 	}
@@ -46,35 +50,44 @@ func (s *GCSRepository) RootURL() string {
 	return ret
 }
 
-func (s *GCSRepository) Get(path string) ([]byte, error) {
-	key := filepath.Join(s.root, path)
-	pathString := fmt.Sprintf("gs://%s/%s", s.bucketName, key)
-	bucket := s.client.Bucket(s.bucketName)
-	obj := bucket.Object(key)
-	reader, err := obj.NewReader(context.TODO())
+func (s *GCSRepository) Get(ctx context.Context, path string) ([]byte, error) {
+	reader, err := s.GetReader(ctx, path)
 	if err != nil {
-		if err == storage.ErrObjectNotExist {
-			return nil, &DoesNotExistError{msg: "Get: path does not exist: " + pathString}
-		}
-		return nil, fmt.Errorf("Failed to open %s: %s", pathString, err)
+		return nil, err
 	}
 	// FIXME: unhandled error
 	defer reader.Close()
 	data, err := ioutil.ReadAll(reader)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read %s: %s", pathString, err)
+		return nil, fmt.Errorf("Failed to read %s/%s: %w", s.RootURL(), path, err)
 	}
 
 	return data, nil
 }
 
+// GetReader returns a reader for the data at path
+func (s *GCSRepository) GetReader(ctx context.Context, path string) (io.ReadCloser, error) {
+	key := filepath.Join(s.root, path)
+	pathString := fmt.Sprintf("gs://%s/%s", s.bucketName, key)
+	bucket := s.client.Bucket(s.bucketName)
+	obj := bucket.Object(key)
+	reader, err := obj.NewReader(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, &DoesNotExistError{msg: "Get: path does not exist: " + pathString}
+		}
+		return nil, fmt.Errorf("Failed to open %s: %w", pathString, err)
+	}
+	return reader, nil
+}
+
 // Delete deletes path. If path is a directory, it recursively deletes
 // all everything under path
-func (s *GCSRepository) Delete(path string) error {
+func (s *GCSRepository) Delete(ctx context.Context, path string) error {
 	console.Debug("Deleting %s/%s...", s.RootURL(), path)
 	prefix := filepath.Join(s.root, path)
-	err := s.applyRecursive(prefix, func(obj *storage.ObjectHandle) error {
-		return obj.Delete(context.TODO())
+	err := s.applyRecursive(ctx, prefix, func(ctx context.Context, obj *storage.ObjectHandle) error {
+		return obj.Delete(ctx)
 	})
 	if err != nil {
 		return fmt.Errorf("Failed to delete %s/%s: %w", s.RootURL(), path, err)
@@ -82,64 +95,130 @@ func (s *GCSRepository) Delete(path string) error {
 	return nil
 }
 
+// Copy copies the object at src to dst on Google Cloud Storage, so the data isn't downloaded.
+// Large objects are rewritten in several requests.
+func (s *GCSRepository) Copy(ctx context.Context, src, dst string) error {
+	bucket := s.client.Bucket(s.bucketName)
+	srcObj := bucket.Object(filepath.Join(s.root, src))
+	dstObj := bucket.Object(filepath.Join(s.root, dst))
+	if _, err := dstObj.CopierFrom(srcObj).Run(ctx); err != nil {
+		if err == storage.ErrObjectNotExist {
+			return &DoesNotExistError{msg: "Copy: path does not exist: " + src}
+		}
+		return fmt.Errorf("Failed to copy %s/%s to %s: %w", s.RootURL(), src, dst, err)
+	}
+	return nil
+}
+
+// Move copies the object at src to dst on Google Cloud Storage, then deletes src
+func (s *GCSRepository) Move(ctx context.Context, src, dst string) error {
+	if err := s.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	if err := s.client.Bucket(s.bucketName).Object(filepath.Join(s.root, src)).Delete(ctx); err != nil {
+		return fmt.Errorf("Failed to delete %s/%s: %w", s.RootURL(), src, err)
+	}
+	return nil
+}
+
 // Put data at path
-func (s *GCSRepository) Put(path string, data []byte) error {
+func (s *GCSRepository) Put(ctx context.Context, path string, data []byte) error {
+	return s.PutReader(ctx, path, bytes.NewReader(data))
+}
+
+// PutReader streams data from reader to path
+func (s *GCSRepository) PutReader(ctx context.Context, path string, reader io.Reader) error {
 	key := filepath.Join(s.root, path)
 	pathString := fmt.Sprintf("gs://%s/%s", s.bucketName, key)
-	bucket := s.client.Bucket(s.bucketName)
-	obj := bucket.Object(key)
-	writer := obj.NewWriter(context.TODO())
-	_, err := writer.Write(data)
+	err := s.writeObject(ctx, key, reader)
+	if err == nil {
+		return nil
+	}
+	if !strings.Contains(err.Error(), "notFound") {
+		return fmt.Errorf("Failed to write %q: %w", pathString, err)
+	}
+
+	// The bucket doesn't exist yet. Create it, then try again if we can rewind the reader.
+	if err := s.ensureBucketExists(ctx); err != nil {
+		return fmt.Errorf("Error creating bucket: %w", err)
+	}
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return fmt.Errorf("Failed to write %q: %w", pathString, err)
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("Failed to write %q: %w", pathString, err)
+	}
+	if err := s.writeObject(ctx, key, reader); err != nil {
+		return fmt.Errorf("Failed to write %q: %w", pathString, err)
+	}
+	return nil
+}
+
+// GetWithVersion returns the data at path and its generation, as its version
+func (s *GCSRepository) GetWithVersion(ctx context.Context, path string) ([]byte, string, error) {
+	key := filepath.Join(s.root, path)
+	pathString := fmt.Sprintf("gs://%s/%s", s.bucketName, key)
+	reader, err := s.client.Bucket(s.bucketName).Object(key).NewReader(ctx)
 	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, "", &DoesNotExistError{msg: "Get: path does not exist: " + pathString}
+		}
+		return nil, "", fmt.Errorf("Failed to open %s: %w", pathString, err)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to read %s: %w", pathString, err)
+	}
+	return data, strconv.FormatInt(reader.Attrs.Generation, 10), nil
+}
+
+// PutIfMatch writes data to path if its generation is still `version`
+func (s *GCSRepository) PutIfMatch(ctx context.Context, path string, data []byte, version string) error {
+	key := filepath.Join(s.root, path)
+	pathString := fmt.Sprintf("gs://%s/%s", s.bucketName, key)
+	conditions := storage.Conditions{DoesNotExist: true}
+	if version != "" {
+		generation, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid version for %s: %q", pathString, version)
+		}
+		conditions = storage.Conditions{GenerationMatch: generation}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := s.client.Bucket(s.bucketName).Object(key).If(conditions).NewWriter(ctx)
+	if _, err := writer.Write(data); err != nil {
 		return fmt.Errorf("Failed to write %q: %w", pathString, err)
 	}
 	if err := writer.Close(); err != nil {
-		if strings.Contains(err.Error(), "notFound") {
-			if err := s.ensureBucketExists(); err != nil {
-				return fmt.Errorf("Error creating bucket: %w", err)
-			}
-			writer := obj.NewWriter(context.TODO())
-			_, err := writer.Write(data)
-			if err != nil {
-				return fmt.Errorf("Failed to write %q: %w", pathString, err)
-			}
-			if err := writer.Close(); err != nil {
-				return fmt.Errorf("Failed to write %q: %w", pathString, err)
-			}
-			return nil
+		var googleErr *googleapi.Error
+		if errors.As(err, &googleErr) && googleErr.Code == http.StatusPreconditionFailed {
+			return versionMismatchError(s.RootURL(), path)
 		}
 		return fmt.Errorf("Failed to write %q: %w", pathString, err)
 	}
 	return nil
 }
 
-func (s *GCSRepository) PutPath(localPath string, repoPath string) error {
+func (s *GCSRepository) PutPath(ctx context.Context, localPath string, repoPath string) error {
 	files, err := getListOfFilesToPut(localPath, filepath.Join(s.root, repoPath))
-	bucket := s.client.Bucket(s.bucketName)
 	if err != nil {
 		return err
 	}
-	queue := concurrency.NewWorkerQueue(context.Background(), maxWorkers)
+	queue := concurrency.NewWorkerQueue(ctx, maxWorkers)
 	for _, file := range files {
 		// Variables used in closure
 		file := file
 		err := queue.Go(func() error {
-			writer := bucket.Object(file.Dest).NewWriter(context.TODO())
-
 			reader, err := os.Open(file.Source)
 			if err != nil {
 				return err
 			}
-			if _, err := io.Copy(writer, reader); err != nil {
-				return err
-			}
-			if err := reader.Close(); err != nil {
-				return err
-			}
-			if err := writer.Close(); err != nil {
-				return err
-			}
-			return nil
+			defer reader.Close()
+			return s.writeObject(queue.Context(), file.Dest, reader)
 		})
 		if err != nil {
 			return err
@@ -148,27 +227,26 @@ func (s *GCSRepository) PutPath(localPath string, repoPath string) error {
 	return queue.Wait()
 }
 
-func (s *GCSRepository) PutPathTar(localPath, tarPath, includePath string) error {
+func (s *GCSRepository) PutPathTar(ctx context.Context, localPath, tarPath, includePath string) error {
 	if !strings.HasSuffix(tarPath, ".tar.gz") {
 		return fmt.Errorf("PutPathTar: tarPath must end with .tar.gz")
 	}
-	if err := s.ensureBucketExists(); err != nil {
+	if err := s.ensureBucketExists(ctx); err != nil {
 		return fmt.Errorf("Error creating bucket: %w", err)
 	}
 
-	key := filepath.Join(s.root, tarPath)
-	bucket := s.client.Bucket(s.bucketName)
-	obj := bucket.Object(key)
-	writer := obj.NewWriter(context.TODO())
-
-	if err := putPathTar(localPath, writer, filepath.Base(tarPath), includePath); err != nil {
-		return err
-	}
-	return writer.Close()
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(putPathTar(ctx, localPath, writer, filepath.Base(tarPath), includePath))
+	}()
+	err := s.PutReader(ctx, tarPath, reader)
+	// Unblock the tarball writer if the upload fails
+	reader.CloseWithError(err)
+	return err
 }
 
 // List files in a path non-recursively
-func (s *GCSRepository) List(dir string) ([]string, error) {
+func (s *GCSRepository) List(ctx context.Context, dir string) ([]string, error) {
 	results := []string{}
 	prefix := filepath.Join(s.root, dir)
 
@@ -179,7 +257,7 @@ func (s *GCSRepository) List(dir string) ([]string, error) {
 	prefix = strings.TrimPrefix(prefix, "/")
 
 	bucket := s.client.Bucket(s.bucketName)
-	it := bucket.Objects(context.TODO(), &storage.Query{
+	it := bucket.Objects(ctx, &storage.Query{
 		Prefix:    prefix,
 		Delimiter: "/",
 	})
@@ -189,7 +267,7 @@ func (s *GCSRepository) List(dir string) ([]string, error) {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("Failed to list %s/%s: %s", s.RootURL(), dir, err)
+			return nil, fmt.Errorf("Failed to list %s/%s: %w", s.RootURL(), dir, err)
 		}
 		p := attrs.Name
 		if s.root != "" {
@@ -203,17 +281,17 @@ func (s *GCSRepository) List(dir string) ([]string, error) {
 }
 
 // List files in a path recursively
-func (s *GCSRepository) ListRecursive(results chan<- ListResult, dir string) {
-	s.listRecursive(results, dir, func(_ string) bool { return true })
+func (s *GCSRepository) ListRecursive(ctx context.Context, results chan<- ListResult, dir string) {
+	s.listRecursive(ctx, results, dir, func(_ string) bool { return true })
 }
 
-func (s *GCSRepository) MatchFilenamesRecursive(results chan<- ListResult, folder string, filename string) {
-	s.listRecursive(results, folder, func(key string) bool {
+func (s *GCSRepository) MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, folder string, filename string) {
+	s.listRecursive(ctx, results, folder, func(key string) bool {
 		return filepath.Base(key) == filename
 	})
 }
 
-func (s *GCSRepository) listRecursive(results chan<- ListResult, dir string, filter func(string) bool) {
+func (s *GCSRepository) listRecursive(ctx context.Context, results chan<- ListResult, dir string, filter func(string) bool) {
 	prefix := filepath.Join(s.root, dir)
 	// prefixes must end with / and must not end with /
 	if !strings.HasSuffix(prefix, "/") {
@@ -222,7 +300,7 @@ func (s *GCSRepository) listRecursive(results chan<- ListResult, dir string, fil
 	prefix = strings.TrimPrefix(prefix, "/")
 
 	bucket := s.client.Bucket(s.bucketName)
-	it := bucket.Objects(context.TODO(), &storage.Query{
+	it := bucket.Objects(ctx, &storage.Query{
 		Prefix: prefix,
 	})
 	for {
@@ -237,7 +315,7 @@ func (s *GCSRepository) listRecursive(results chan<- ListResult, dir string, fil
 				break
 			}
 
-			results <- ListResult{Error: fmt.Errorf("Failed to list gs://%s/%s: %s", s.bucketName, prefix, err)}
+			sendListError(ctx, results, fmt.Errorf("Failed to list gs://%s/%s: %w", s.bucketName, prefix, err))
 			break
 		}
 		if filter(attrs.Name) {
@@ -245,18 +323,29 @@ func (s *GCSRepository) listRecursive(results chan<- ListResult, dir string, fil
 			if s.root != "" {
 				p = strings.TrimPrefix(strings.TrimPrefix(p, s.root), "/")
 			}
-			results <- ListResult{Path: p, MD5: attrs.MD5}
+			// Composite objects don't have an MD5
+			checksum := MD5Checksum(attrs.MD5)
+			if len(attrs.MD5) == 0 {
+				checksum = crc32cChecksum(attrs.CRC32C)
+			}
+			select {
+			case results <- ListResult{Path: p, Checksum: checksum, Size: attrs.Size, MTime: attrs.Updated}:
+			case <-ctx.Done():
+				sendListError(ctx, results, fmt.Errorf("Failed to list gs://%s/%s: %w", s.bucketName, prefix, ctx.Err()))
+				close(results)
+				return
+			}
 		}
 	}
 	close(results)
 }
 
 // GetPath recursively copies repoDir to localDir
-func (s *GCSRepository) GetPath(repoDir string, localDir string) error {
+func (s *GCSRepository) GetPath(ctx context.Context, repoDir string, localDir string) error {
 	prefix := filepath.Join(s.root, repoDir)
-	err := s.applyRecursive(prefix, func(obj *storage.ObjectHandle) error {
+	err := s.applyRecursive(ctx, prefix, func(ctx context.Context, obj *storage.ObjectHandle) error {
 		gcsPathString := fmt.Sprintf("gs://%s/%s", s.bucketName, obj.ObjectName())
-		reader, err := obj.NewReader(context.TODO())
+		reader, err := obj.NewReader(ctx)
 		if err != nil {
 			return fmt.Errorf("Failed to open %s: %w", gcsPathString, err)
 		}
@@ -267,19 +356,9 @@ func (s *GCSRepository) GetPath(repoDir string, localDir string) error {
 			return fmt.Errorf("Failed to determine directory of %s relative to %s: %w", obj.ObjectName(), repoDir, err)
 		}
 		localPath := filepath.Join(localDir, relPath)
-		localDir := filepath.Dir(localPath)
-		if err := os.MkdirAll(localDir, 0755); err != nil {
-			return fmt.Errorf("Failed to create directory %s: %w", localDir, err)
-		}
-
-		f, err := os.Create(localPath)
-		if err != nil {
-			return fmt.Errorf("Failed to create file %s: %w", localPath, err)
-		}
-		defer f.Close()
 
 		console.Debug("Downloading %s to %s", gcsPathString, localPath)
-		if _, err := io.Copy(f, reader); err != nil {
+		if err := writeFileAtomic(localPath, reader, 0644); err != nil {
 			return fmt.Errorf("Failed to copy %s to %s: %w", gcsPathString, localPath, err)
 		}
 		return nil
@@ -291,31 +370,33 @@ func (s *GCSRepository) GetPath(repoDir string, localDir string) error {
 	return nil
 }
 
-func (s *GCSRepository) GetPathTar(tarPath, localPath string) error {
-	// archiver doesn't let us use readers, so download to temporary file
-	// TODO: make a better tar implementation
-	tmpdir, err := files.TempDir("tar")
+func (s *GCSRepository) GetPathTar(ctx context.Context, tarPath, localPath string) error {
+	reader, err := s.GetReader(ctx, tarPath)
 	if err != nil {
+		if _, ok := err.(*DoesNotExistError); ok {
+			return &DoesNotExistError{msg: "GetPathTar: does not exist: " + tarPath}
+		}
 		return err
 	}
-	defer os.RemoveAll(tmpdir)
-	tmptarball := filepath.Join(tmpdir, filepath.Base(tarPath))
-	if err := s.GetPath(tarPath, tmptarball); err != nil {
-		return err
-	}
-	exists, err := files.FileExists(tmptarball)
-	if err != nil {
+	defer reader.Close()
+	return extractTar(ctx, reader, localPath)
+}
+
+// writeObject streams reader to key. Note key includes s.root
+func (s *GCSRepository) writeObject(ctx context.Context, key string, reader io.Reader) error {
+	// Cancelling the context aborts the upload, so a partial object isn't written on error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := s.client.Bucket(s.bucketName).Object(key).NewWriter(ctx)
+	if _, err := io.Copy(writer, reader); err != nil {
 		return err
 	}
-	if !exists {
-		return &DoesNotExistError{msg: "GetPathTar: does not exist: " + tmptarball}
-	}
-	return extractTar(tmptarball, localPath)
+	return writer.Close()
 }
 
-func (s *GCSRepository) bucketExists() (bool, error) {
+func (s *GCSRepository) bucketExists(ctx context.Context) (bool, error) {
 	bucket := s.client.Bucket(s.bucketName)
-	_, err := bucket.Attrs(context.TODO())
+	_, err := bucket.Attrs(ctx)
 	if err == nil {
 		return true, nil
 	}
@@ -325,35 +406,35 @@ func (s *GCSRepository) bucketExists() (bool, error) {
 	return false, fmt.Errorf("Failed to determine if bucket gs://%s exists: %w", s.bucketName, err)
 }
 
-func (s *GCSRepository) ensureBucketExists() error {
-	exists, err := s.bucketExists()
+func (s *GCSRepository) ensureBucketExists(ctx context.Context) error {
+	exists, err := s.bucketExists(ctx)
 	if err != nil {
 		return err
 	}
 	if !exists {
-		return s.CreateBucket()
+		return s.CreateBucket(ctx)
 	}
 	return nil
 }
 
-func (s *GCSRepository) CreateBucket() error {
+func (s *GCSRepository) CreateBucket(ctx context.Context) error {
 	projectID, err := s.getProjectID()
 	if err != nil {
 		return err
 	}
 	bucket := s.client.Bucket(s.bucketName)
-	if err := bucket.Create(context.TODO(), projectID, nil); err != nil {
+	if err := bucket.Create(ctx, projectID, nil); err != nil {
 		return fmt.Errorf("Failed to create bucket gs://%s: %w", s.bucketName, err)
 	}
 	return nil
 }
 
 // Note: prefix does not include s.root
-func (s *GCSRepository) applyRecursive(prefix string, fn func(obj *storage.ObjectHandle) error) error {
-	queue := concurrency.NewWorkerQueue(context.Background(), maxWorkers)
+func (s *GCSRepository) applyRecursive(ctx context.Context, prefix string, fn func(ctx context.Context, obj *storage.ObjectHandle) error) error {
+	queue := concurrency.NewWorkerQueue(ctx, maxWorkers)
 
 	bucket := s.client.Bucket(s.bucketName)
-	it := bucket.Objects(context.TODO(), &storage.Query{
+	it := bucket.Objects(queue.Context(), &storage.Query{
 		Prefix: prefix,
 	})
 	for {
@@ -362,12 +443,16 @@ func (s *GCSRepository) applyRecursive(prefix string, fn func(obj *storage.Objec
 			break
 		}
 		if err != nil {
+			// Prefer the error that cancelled the listing, if a worker failed
+			if werr := queue.Wait(); werr != nil {
+				return werr
+			}
 			return err
 		}
 
 		err = queue.Go(func() error {
 			obj := bucket.Object(attrs.Name)
-			return fn(obj)
+			return fn(queue.Context(), obj)
 		})
 		if err != nil {
 			return err
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"cloud.google.com/go/storage"
@@ -88,20 +89,37 @@ func TestGCSRepository(t *testing.T) {
 		createObject(t, bucket, "foo.txt", []byte("hello"))
 		repository, err := NewGCSRepository(bucketName, "")
 		require.NoError(t, err)
-		data, err := repository.Get("foo.txt")
+		data, err := repository.Get(context.Background(), "foo.txt")
 		require.NoError(t, err)
 		require.Equal(t, []byte("hello"), data)
 	})
 
 	clearGCSBucket(t, bucket)
 
+	t.Run("GetReader", func(t *testing.T) {
+		createObject(t, bucket, "foo.txt", []byte("hello"))
+		repository, err := NewGCSRepository(bucketName, "")
+		require.NoError(t, err)
+		reader, err := repository.GetReader(context.Background(), "foo.txt")
+		require.NoError(t, err)
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello"), data)
+
+		_, err = repository.GetReader(context.Background(), "does-not-exist")
+		require.IsType(t, &DoesNotExistError{}, err)
+	})
+
+	clearGCSBucket(t, bucket)
+
 	t.Run("GetPathTar", func(t *testing.T) {
 		repository, err := NewGCSRepository(bucketName, "")
 		require.NoError(t, err)
 
 		tmpDir, err := files.TempDir("test")
 		require.NoError(t, err)
-		err = repository.GetPathTar("does-not-exist.tar.gz", tmpDir)
+		err = repository.GetPathTar(context.Background(), "does-not-exist.tar.gz", tmpDir)
 		require.IsType(t, &DoesNotExistError{}, err)
 	})
 
@@ -110,7 +128,18 @@ func TestGCSRepository(t *testing.T) {
 	t.Run("Put", func(t *testing.T) {
 		repository, err := NewGCSRepository(bucketName, "")
 		require.NoError(t, err)
-		err = repository.Put("foo.txt", []byte("hello"))
+		err = repository.Put(context.Background(), "foo.txt", []byte("hello"))
+		require.NoError(t, err)
+
+		require.Equal(t, []byte("hello"), readObject(t, bucket, "foo.txt"))
+	})
+
+	clearGCSBucket(t, bucket)
+
+	t.Run("PutReader", func(t *testing.T) {
+		repository, err := NewGCSRepository(bucketName, "")
+		require.NoError(t, err)
+		err = repository.PutReader(context.Background(), "foo.txt", strings.NewReader("hello"))
 		require.NoError(t, err)
 
 		require.Equal(t, []byte("hello"), readObject(t, bucket, "foo.txt"))
@@ -131,12 +160,12 @@ func TestGCSRepository(t *testing.T) {
 		require.NoError(t, err)
 
 		// Whole directory
-		err = repository.PutPath(filepath.Join(tmpDir, "somedir"), "anotherdir")
+		err = repository.PutPath(context.Background(), filepath.Join(tmpDir, "somedir"), "anotherdir")
 		require.NoError(t, err)
 		require.Equal(t, []byte("hello"), readObject(t, bucket, "anotherdir/foo.txt"))
 
 		// Single file
-		err = repository.PutPath(filepath.Join(tmpDir, "somedir/foo.txt"), "singlefile/foo.txt")
+		err = repository.PutPath(context.Background(), filepath.Join(tmpDir, "somedir/foo.txt"), "singlefile/foo.txt")
 		require.NoError(t, err)
 		require.Equal(t, []byte("hello"), readObject(t, bucket, "singlefile/foo.txt"))
 	})
@@ -149,25 +178,37 @@ func TestGCSRepository(t *testing.T) {
 
 		// Works with empty repository
 		results := make(chan ListResult)
-		go repository.ListRecursive(results, "checkpoints")
+		go repository.ListRecursive(context.Background(), results, "checkpoints")
 		require.Empty(t, <-results)
 
 		// Lists stuff!
-		require.NoError(t, repository.Put("checkpoints/abc123.json", []byte("yep")))
-		require.NoError(t, repository.Put("experiments/def456.json", []byte("nope")))
+		require.NoError(t, repository.Put(context.Background(), "checkpoints/abc123.json", []byte("yep")))
+		require.NoError(t, repository.Put(context.Background(), "experiments/def456.json", []byte("nope")))
 		results = make(chan ListResult)
-		go repository.ListRecursive(results, "checkpoints")
+		go repository.ListRecursive(context.Background(), results, "checkpoints")
 		require.Equal(t, ListResult{
-			Path: "checkpoints/abc123.json",
-			MD5:  []byte{0x93, 0x48, 0xae, 0x78, 0x51, 0xcf, 0x3b, 0xa7, 0x98, 0xd9, 0x56, 0x4e, 0xf3, 0x8, 0xec, 0x25},
-		}, <-results)
+			Path:     "checkpoints/abc123.json",
+			Checksum: MD5Checksum([]byte{0x93, 0x48, 0xae, 0x78, 0x51, 0xcf, 0x3b, 0xa7, 0x98, 0xd9, 0x56, 0x4e, 0xf3, 0x8, 0xec, 0x25}),
+			Size:     3,
+		}, withoutMTime(t, <-results))
 		require.Empty(t, <-results)
 
 		// Works with non-existent bucket
 		repository, err = NewGCSRepository("replicate-test-"+hash.Random()[0:10], "")
 		require.NoError(t, err)
 		results = make(chan ListResult)
-		go repository.ListRecursive(results, "checkpoints")
+		go repository.ListRecursive(context.Background(), results, "checkpoints")
 		require.Empty(t, <-results)
 	})
 }
+
+func TestGCSRepositoryCopyAndMove(t *testing.T) {
+	client, err := storage.NewClient(context.TODO())
+	require.NoError(t, err)
+	bucket, bucketName := createGCSBucket(t, client)
+	t.Cleanup(func() { deleteGCSBucket(t, bucket) })
+
+	repository, err := NewGCSRepository(bucketName, "root")
+	require.NoError(t, err)
+	testCopyAndMove(t, repository)
+}
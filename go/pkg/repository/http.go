@@ -0,0 +1,335 @@
+package repository
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HTTPIndexPath is the path of the index file, relative to the root of the repository
+//
+// HTTP doesn't have a way of listing files, so HTTPRepository lists files from this index
+// instead. It is generated with GenerateHTTPIndex() (`replicate repository index`).
+const HTTPIndexPath = "replicate-index.json"
+
+type HTTPIndex struct {
+	Files []HTTPIndexFile `json:"files"`
+}
+
+type HTTPIndexFile struct {
+	Path string `json:"path"`
+	MD5  string `json:"md5"`
+	// Checksum is set instead of MD5 for files that don't have an MD5, formatted with
+	// Checksum.String()
+	Checksum string `json:"checksum,omitempty"`
+}
+
+func (f HTTPIndexFile) checksum() (Checksum, error) {
+	if f.Checksum != "" {
+		return ParseChecksum(f.Checksum)
+	}
+	if f.MD5 == "" {
+		return Checksum{}, nil
+	}
+	md5, err := hex.DecodeString(f.MD5)
+	if err != nil {
+		return Checksum{}, err
+	}
+	return MD5Checksum(md5), nil
+}
+
+// HTTPRepository is a read-only repository that is served over HTTP(S), such as a static
+// website or a public bucket
+type HTTPRepository struct {
+	rootURL string
+	client  *http.Client
+
+	indexLock sync.Mutex
+	index     *HTTPIndex
+}
+
+// NewHTTPRepository returns a repository for the http:// or https:// URL rootURL
+func NewHTTPRepository(rootURL string) (*HTTPRepository, error) {
+	u, err := url.Parse(rootURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid repository URL %q: %w", rootURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid repository URL %q: scheme must be http or https", rootURL)
+	}
+	return &HTTPRepository{
+		rootURL: strings.TrimSuffix(rootURL, "/"),
+		client:  http.DefaultClient,
+	}, nil
+}
+
+func (s *HTTPRepository) RootURL() string {
+	return s.rootURL
+}
+
+// Get data at path
+func (s *HTTPRepository) Get(ctx context.Context, p string) ([]byte, error) {
+	reader, err := s.GetReader(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s/%s: %w", s.rootURL, p, err)
+	}
+	return data, nil
+}
+
+// GetReader returns a reader for the data at path
+func (s *HTTPRepository) GetReader(ctx context.Context, p string) (io.ReadCloser, error) {
+	fileURL := s.rootURL + "/" + strings.TrimPrefix(path.Clean("/"+p), "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s: %w", fileURL, err)
+	}
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.Body, nil
+	// Public buckets return 403 for files that don't exist if listing isn't allowed
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden:
+		resp.Body.Close()
+		return nil, &DoesNotExistError{msg: "Get: path does not exist: " + fileURL}
+	default:
+		resp.Body.Close()
+		return nil, &httpStatusError{url: fileURL, code: resp.StatusCode, status: resp.Status}
+	}
+}
+
+// httpStatusError is returned when the server returns an unexpected status code
+type httpStatusError struct {
+	url    string
+	code   int
+	status string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("Failed to get %s: server returned %s", e.url, e.status)
+}
+
+// GetPath recursively copies repoDir to localDir
+func (s *HTTPRepository) GetPath(ctx context.Context, repoDir string, localDir string) error {
+	files, err := s.filesUnder(ctx, repoDir)
+	if err != nil {
+		return err
+	}
+	prefix := path.Clean(repoDir)
+	for _, file := range files {
+		relPath, err := filepath.Rel(prefix, file.Path)
+		if err != nil {
+			return fmt.Errorf("Failed to determine directory of %s relative to %s: %w", file.Path, prefix, err)
+		}
+		if err := s.download(ctx, file.Path, filepath.Join(localDir, relPath)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *HTTPRepository) download(ctx context.Context, p string, localPath string) error {
+	reader, err := s.GetReader(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := writeFileAtomic(localPath, reader, 0644); err != nil {
+		return fmt.Errorf("Failed to copy %s/%s to %s: %w", s.rootURL, p, localPath, err)
+	}
+	return nil
+}
+
+// GetPathTar extracts tarball `tarPath` to `localPath`
+//
+// See repository.go for full documentation.
+func (s *HTTPRepository) GetPathTar(ctx context.Context, tarPath, localPath string) error {
+	reader, err := s.GetReader(ctx, tarPath)
+	if err != nil {
+		if _, ok := err.(*DoesNotExistError); ok {
+			return &DoesNotExistError{msg: "GetPathTar: does not exist: " + tarPath}
+		}
+		return err
+	}
+	defer reader.Close()
+	return extractTar(ctx, reader, localPath)
+}
+
+func (s *HTTPRepository) Put(ctx context.Context, p string, data []byte) error {
+	return s.readOnlyError()
+}
+
+func (s *HTTPRepository) PutReader(ctx context.Context, p string, reader io.Reader) error {
+	return s.readOnlyError()
+}
+
+func (s *HTTPRepository) PutPath(ctx context.Context, localPath string, repoPath string) error {
+	return s.readOnlyError()
+}
+
+func (s *HTTPRepository) PutPathTar(ctx context.Context, localPath, tarPath, includePath string) error {
+	return s.readOnlyError()
+}
+
+func (s *HTTPRepository) Delete(ctx context.Context, p string) error {
+	return s.readOnlyError()
+}
+
+func (s *HTTPRepository) Copy(ctx context.Context, src, dst string) error {
+	return s.readOnlyError()
+}
+
+func (s *HTTPRepository) Move(ctx context.Context, src, dst string) error {
+	return s.readOnlyError()
+}
+
+// List files in a path non-recursively
+//
+// Returns a list of paths, prefixed with the given path, that can be passed straight to Get().
+// Directories are not listed.
+// If path does not exist, an empty list will be returned.
+func (s *HTTPRepository) List(ctx context.Context, p string) ([]string, error) {
+	files, err := s.filesUnder(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	dir := path.Clean(p)
+	result := []string{}
+	for _, file := range files {
+		if path.Dir(file.Path) == dir {
+			result = append(result, path.Join(p, path.Base(file.Path)))
+		}
+	}
+	return result, nil
+}
+
+func (s *HTTPRepository) ListRecursive(ctx context.Context, results chan<- ListResult, folder string) {
+	s.listRecursive(ctx, results, folder, func(_ string) bool { return true })
+}
+
+func (s *HTTPRepository) MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, folder string, filename string) {
+	s.listRecursive(ctx, results, folder, func(p string) bool {
+		return path.Base(p) == filename
+	})
+}
+
+func (s *HTTPRepository) listRecursive(ctx context.Context, results chan<- ListResult, folder string, filter func(string) bool) {
+	defer close(results)
+	files, err := s.filesUnder(ctx, folder)
+	if err != nil {
+		sendListError(ctx, results, err)
+		return
+	}
+	for _, file := range files {
+		if !filter(file.Path) {
+			continue
+		}
+		checksum, err := file.checksum()
+		if err != nil {
+			sendListError(ctx, results, fmt.Errorf("Invalid checksum for %s in %s/%s: %w", file.Path, s.rootURL, HTTPIndexPath, err))
+			return
+		}
+		select {
+		case results <- ListResult{Path: file.Path, Checksum: checksum}:
+		case <-ctx.Done():
+			sendListError(ctx, results, fmt.Errorf("Failed to list %s/%s: %w", s.rootURL, folder, ctx.Err()))
+			return
+		}
+	}
+}
+
+// filesUnder returns the files in the index that are at or under p
+func (s *HTTPRepository) filesUnder(ctx context.Context, p string) ([]HTTPIndexFile, error) {
+	index, err := s.getIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := path.Clean(p)
+	if prefix == "." || prefix == "/" {
+		prefix = ""
+	}
+	files := []HTTPIndexFile{}
+	for _, file := range index.Files {
+		if isUnderPrefix(file.Path, prefix) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// getIndex fetches the index, or returns the index that has already been fetched
+func (s *HTTPRepository) getIndex(ctx context.Context) (*HTTPIndex, error) {
+	s.indexLock.Lock()
+	defer s.indexLock.Unlock()
+	if s.index != nil {
+		return s.index, nil
+	}
+	data, err := s.Get(ctx, HTTPIndexPath)
+	if err != nil {
+		if _, ok := err.(*DoesNotExistError); ok {
+			return nil, fmt.Errorf("%s/%s does not exist. HTTP repositories need an index to list files. Run 'replicate repository index' on the repository before publishing it.", s.rootURL, HTTPIndexPath)
+		}
+		return nil, err
+	}
+	index := new(HTTPIndex)
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s/%s: %w", s.rootURL, HTTPIndexPath, err)
+	}
+	s.index = index
+	return index, nil
+}
+
+func (s *HTTPRepository) readOnlyError() error {
+	return &ReadOnlyError{msg: "The repository " + s.rootURL + " is read-only, because it is served over HTTP."}
+}
+
+// GenerateHTTPIndex writes an index of all the files in repo, so it can be read by
+// HTTPRepository when it is published over HTTP
+func GenerateHTTPIndex(ctx context.Context, repo Repository) (*HTTPIndex, error) {
+	index := &HTTPIndex{Files: []HTTPIndexFile{}}
+	results := make(chan ListResult)
+	go repo.ListRecursive(ctx, results, "")
+	for result := range results {
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.Path == HTTPIndexPath {
+			continue
+		}
+		file := HTTPIndexFile{Path: result.Path}
+		if result.Checksum.Type == ChecksumMD5 {
+			file.MD5 = hex.EncodeToString(result.Checksum.Value)
+		} else {
+			file.Checksum = result.Checksum.String()
+		}
+		index.Files = append(index.Files, file)
+	}
+	sort.Slice(index.Files, func(i, j int) bool {
+		return index.Files[i].Path < index.Files[j].Path
+	})
+	data, err := json.MarshalIndent(index, "", " ")
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.Put(ctx, HTTPIndexPath, data); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"crypto/md5"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestHTTPRepository publishes a disk repository over HTTP
+func newTestHTTPRepository(t *testing.T) (*HTTPRepository, *DiskRepository, func()) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	disk, err := NewDiskRepository(dir)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.StripPrefix("/root", http.FileServer(http.Dir(dir))))
+	repository, err := NewHTTPRepository(server.URL + "/root/")
+	require.NoError(t, err)
+	return repository, disk, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHTTPRepositoryGet(t *testing.T) {
+	repository, disk, cleanup := newTestHTTPRepository(t)
+	defer cleanup()
+
+	require.NoError(t, disk.Put(context.Background(), "some-file", []byte("hello")))
+
+	content, err := repository.Get(context.Background(), "some-file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+
+	_, err = repository.Get(context.Background(), "does-not-exist")
+	require.IsType(t, &DoesNotExistError{}, err)
+}
+
+func TestHTTPRepositoryList(t *testing.T) {
+	repository, disk, cleanup := newTestHTTPRepository(t)
+	defer cleanup()
+
+	require.NoError(t, disk.Put(context.Background(), "some-file", []byte("hello")))
+	require.NoError(t, disk.Put(context.Background(), "dir/another-file", []byte("hello")))
+	require.NoError(t, disk.Put(context.Background(), "dir/sub/third-file", []byte("hello")))
+
+	// Listing needs an index
+	_, err := repository.List(context.Background(), "")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "replicate repository index")
+
+	index, err := GenerateHTTPIndex(context.Background(), disk)
+	require.NoError(t, err)
+	require.Len(t, index.Files, 3)
+
+	paths, err := repository.List(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"some-file"}, paths)
+
+	paths, err = repository.List(context.Background(), "dir")
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/another-file"}, paths)
+
+	paths, err = repository.List(context.Background(), "dir-that-does-not-exist")
+	require.NoError(t, err)
+	require.Equal(t, []string{}, paths)
+
+	results := make(chan ListResult)
+	go repository.ListRecursive(context.Background(), results, "dir")
+	hash := md5.Sum([]byte("hello"))
+	require.Equal(t, ListResult{Path: "dir/another-file", Checksum: MD5Checksum(hash[:])}, <-results)
+	require.Equal(t, ListResult{Path: "dir/sub/third-file", Checksum: MD5Checksum(hash[:])}, <-results)
+	_, ok := <-results
+	require.False(t, ok)
+
+	results = make(chan ListResult)
+	go repository.MatchFilenamesRecursive(context.Background(), results, "", "third-file")
+	require.Equal(t, "dir/sub/third-file", (<-results).Path)
+	_, ok = <-results
+	require.False(t, ok)
+
+	outputDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+	require.NoError(t, repository.GetPath(context.Background(), "dir", outputDir))
+	content, err := ioutil.ReadFile(path.Join(outputDir, "sub/third-file"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+}
+
+func TestHTTPIndexFileChecksum(t *testing.T) {
+	checksum, err := HTTPIndexFile{Path: "a", MD5: "9348ae"}.checksum()
+	require.NoError(t, err)
+	require.Equal(t, MD5Checksum([]byte{0x93, 0x48, 0xae}), checksum)
+
+	// Files without an MD5 have another type of checksum
+	checksum, err = HTTPIndexFile{Path: "a", Checksum: "multipart-etag:9348ae-2"}.checksum()
+	require.NoError(t, err)
+	require.Equal(t, Checksum{Type: ChecksumMultipartETag, Value: []byte{0x93, 0x48, 0xae}, Parts: 2}, checksum)
+
+	checksum, err = HTTPIndexFile{Path: "a"}.checksum()
+	require.NoError(t, err)
+	require.True(t, checksum.IsZero())
+
+	_, err = HTTPIndexFile{Path: "a", MD5: "not hex"}.checksum()
+	require.Error(t, err)
+}
+
+func TestHTTPRepositoryIsReadOnly(t *testing.T) {
+	repository, disk, cleanup := newTestHTTPRepository(t)
+	defer cleanup()
+
+	require.True(t, IsReadOnly(repository))
+	require.IsType(t, &ReadOnlyError{}, repository.Put(context.Background(), "some-file", []byte("hello")))
+	require.IsType(t, &ReadOnlyError{}, repository.Delete(context.Background(), "some-file"))
+
+	// Reads are cached, but writes don't touch the cache
+	require.NoError(t, disk.Put(context.Background(), "metadata/experiments/abc123.json", []byte("{}")))
+	_, err := GenerateHTTPIndex(context.Background(), disk)
+	require.NoError(t, err)
+
+	cacheDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+	cached, err := NewCachedRepository(repository, "metadata", cacheDir)
+	require.NoError(t, err)
+	require.NoError(t, cached.SyncCache(context.Background()))
+	content, err := ioutil.ReadFile(path.Join(cacheDir, "metadata/experiments/abc123.json"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("{}"), content)
+
+	err = cached.Put(context.Background(), "metadata/experiments/def456.json", []byte("{}"))
+	require.IsType(t, &ReadOnlyError{}, err)
+	_, err = os.Stat(path.Join(cacheDir, "metadata/experiments/def456.json"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestHTTPPutPathTarGetPathTar(t *testing.T) {
+	repository, disk, cleanup := newTestHTTPRepository(t)
+	defer cleanup()
+
+	workDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+	require.NoError(t, os.MkdirAll(path.Join(workDir, "data"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(workDir, "data/weights"), []byte("hello"), 0644))
+	require.NoError(t, disk.PutPathTar(context.Background(), workDir, "checkpoints/abc123.tar.gz", "data"))
+
+	outputDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+	require.NoError(t, repository.GetPathTar(context.Background(), "checkpoints/abc123.tar.gz", outputDir))
+	content, err := ioutil.ReadFile(path.Join(outputDir, "data/weights"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+
+	err = repository.GetPathTar(context.Background(), "checkpoints/does-not-exist.tar.gz", outputDir)
+	require.IsType(t, &DoesNotExistError{}, err)
+}
@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/hash"
+)
+
+// ManifestPath is a log of the most recent changes to files in metadata/, so caches of
+// metadata/ can fetch what has changed instead of listing everything. See SyncCache().
+const ManifestPath = "metadata/manifest.json"
+
+// manifestDir is the directory the manifest records changes in
+const manifestDir = "metadata"
+
+// maxManifestChanges is the number of changes kept in the manifest. Only the latest change
+// to each path is kept, so this is the number of different paths. Caches that are further
+// behind than this do a full sync.
+const maxManifestChanges = 1000
+
+// Manifest is the contents of ManifestPath
+type Manifest struct {
+	// ID is set when the manifest is created. If the manifest is deleted and created again,
+	// its generations start again, so caches use this to tell it is a different manifest.
+	ID string `json:"id"`
+	// Generation is incremented for every change. It is the generation of the latest change.
+	Generation int64 `json:"generation"`
+	// Since is the generation the changes go back to. Every path that has changed after it
+	// is in Changes. It is more than zero once changes have been trimmed.
+	Since int64 `json:"since"`
+	// Changes is the latest change to each path, oldest first. Files that are written often,
+	// like heartbeats, replace their previous change rather than filling up the manifest.
+	Changes []ManifestChange `json:"changes"`
+}
+
+type ManifestChange struct {
+	Generation int64  `json:"generation"`
+	Path       string `json:"path"`
+	Deleted    bool   `json:"deleted,omitempty"`
+}
+
+// ChangesSince returns the paths that have changed since generation, and whether the
+// manifest still has all of them. For each path, only the latest change is returned.
+func (m *Manifest) ChangesSince(generation int64) (changes []ManifestChange, ok bool) {
+	if generation > m.Generation || generation < m.Since {
+		return nil, false
+	}
+	latest := map[string]int{}
+	changes = []ManifestChange{}
+	for _, change := range m.Changes {
+		if change.Generation <= generation {
+			continue
+		}
+		if i, ok := latest[change.Path]; ok {
+			changes[i] = change
+			continue
+		}
+		latest[change.Path] = len(changes)
+		changes = append(changes, change)
+	}
+	return changes, true
+}
+
+// ManifestRepository wraps another repository, recording writes and deletes in metadata/
+// in ManifestPath.
+//
+// Changes are only recorded if the repository supports conditional writes, so concurrent
+// writers don't lose each other's changes. Otherwise, there is no manifest, and caches do a
+// full sync every time.
+type ManifestRepository struct {
+	repository Repository
+}
+
+func NewManifestRepository(repo Repository) *ManifestRepository {
+	return &ManifestRepository{repository: repo}
+}
+
+// WithManifest wraps repo in a ManifestRepository if it is slow enough to be cached (see
+// NeedsCaching()). Repositories that aren't cached don't need a manifest.
+func WithManifest(repo Repository) Repository {
+	if !NeedsCaching(repo) {
+		return repo
+	}
+	return NewManifestRepository(repo)
+}
+
+func (s *ManifestRepository) RootURL() string {
+	return s.repository.RootURL()
+}
+
+func (s *ManifestRepository) Get(ctx context.Context, p string) ([]byte, error) {
+	return s.repository.Get(ctx, p)
+}
+
+func (s *ManifestRepository) GetReader(ctx context.Context, p string) (io.ReadCloser, error) {
+	return s.repository.GetReader(ctx, p)
+}
+
+func (s *ManifestRepository) GetPath(ctx context.Context, repoDir string, localDir string) error {
+	return s.repository.GetPath(ctx, repoDir, localDir)
+}
+
+func (s *ManifestRepository) GetPathTar(ctx context.Context, tarPath, localPath string) error {
+	return s.repository.GetPathTar(ctx, tarPath, localPath)
+}
+
+func (s *ManifestRepository) Put(ctx context.Context, p string, data []byte) error {
+	if err := s.repository.Put(ctx, p, data); err != nil {
+		return err
+	}
+	s.record(ctx, false, p)
+	return nil
+}
+
+func (s *ManifestRepository) PutReader(ctx context.Context, p string, reader io.Reader) error {
+	if err := s.repository.PutReader(ctx, p, reader); err != nil {
+		return err
+	}
+	s.record(ctx, false, p)
+	return nil
+}
+
+func (s *ManifestRepository) PutPath(ctx context.Context, localPath string, repoPath string) error {
+	if err := s.repository.PutPath(ctx, localPath, repoPath); err != nil {
+		return err
+	}
+	if !isUnderPrefix(repoPath, manifestDir) && !isUnderPrefix(manifestDir, repoPath) {
+		return nil
+	}
+	files, err := getListOfFilesToPut(localPath, repoPath)
+	if err != nil {
+		return err
+	}
+	paths := []string{}
+	for _, file := range files {
+		paths = append(paths, file.Dest)
+	}
+	s.record(ctx, false, paths...)
+	return nil
+}
+
+func (s *ManifestRepository) PutPathTar(ctx context.Context, localPath, tarPath, includePath string) error {
+	if err := s.repository.PutPathTar(ctx, localPath, tarPath, includePath); err != nil {
+		return err
+	}
+	s.record(ctx, false, tarPath)
+	return nil
+}
+
+func (s *ManifestRepository) Delete(ctx context.Context, p string) error {
+	if err := s.repository.Delete(ctx, p); err != nil {
+		return err
+	}
+	s.record(ctx, true, p)
+	return nil
+}
+
+func (s *ManifestRepository) Copy(ctx context.Context, src, dst string) error {
+	if err := s.repository.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	s.record(ctx, false, dst)
+	return nil
+}
+
+func (s *ManifestRepository) Move(ctx context.Context, src, dst string) error {
+	if err := s.repository.Move(ctx, src, dst); err != nil {
+		return err
+	}
+	s.record(ctx, true, src)
+	s.record(ctx, false, dst)
+	return nil
+}
+
+func (s *ManifestRepository) GetWithVersion(ctx context.Context, p string) ([]byte, string, error) {
+	conditional, err := asConditional(s.repository)
+	if err != nil {
+		return nil, "", err
+	}
+	return conditional.GetWithVersion(ctx, p)
+}
+
+func (s *ManifestRepository) PutIfMatch(ctx context.Context, p string, data []byte, version string) error {
+	conditional, err := asConditional(s.repository)
+	if err != nil {
+		return err
+	}
+	if err := conditional.PutIfMatch(ctx, p, data, version); err != nil {
+		return err
+	}
+	s.record(ctx, false, p)
+	return nil
+}
+
+func (s *ManifestRepository) List(ctx context.Context, p string) ([]string, error) {
+	return s.repository.List(ctx, p)
+}
+
+func (s *ManifestRepository) ListRecursive(ctx context.Context, results chan<- ListResult, folder string) {
+	s.repository.ListRecursive(ctx, results, folder)
+}
+
+func (s *ManifestRepository) MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, folder string, filename string) {
+	s.repository.MatchFilenamesRecursive(ctx, results, folder, filename)
+}
+
+// record adds the paths in metadata/ to the manifest. The write has already succeeded, so
+// if this fails, it only warns. Caches pick up the change on their next full sync.
+func (s *ManifestRepository) record(ctx context.Context, deleted bool, paths ...string) {
+	changed := []string{}
+	for _, p := range paths {
+		p = path.Clean(p)
+		if isUnderPrefix(p, manifestDir) && p != ManifestPath {
+			changed = append(changed, p)
+		}
+	}
+	if len(changed) == 0 || !SupportsConditionalWrites(s.repository) {
+		return
+	}
+	err := Update(ctx, s.repository, ManifestPath, func(data []byte) ([]byte, error) {
+		manifest := new(Manifest)
+		if data != nil {
+			if err := json.Unmarshal(data, manifest); err != nil {
+				console.Debug("Failed to parse %s/%s, creating a new one: %s", s.RootURL(), ManifestPath, err)
+				manifest = new(Manifest)
+			}
+		}
+		if manifest.ID == "" {
+			manifest.ID = hash.Random()
+			manifest.Generation = 0
+			manifest.Since = 0
+			manifest.Changes = nil
+		}
+		for _, p := range changed {
+			manifest.Generation++
+			manifest.Changes = append(manifest.Changes, ManifestChange{
+				Generation: manifest.Generation,
+				Path:       p,
+				Deleted:    deleted,
+			})
+		}
+		manifest.Changes = latestChanges(manifest.Changes)
+		if len(manifest.Changes) > maxManifestChanges {
+			trimmed := manifest.Changes[:len(manifest.Changes)-maxManifestChanges]
+			manifest.Since = trimmed[len(trimmed)-1].Generation
+			manifest.Changes = manifest.Changes[len(trimmed):]
+		}
+		return json.Marshal(manifest)
+	})
+	if err != nil {
+		console.Warn("Failed to record changes to %s in %s/%s: %s", strings.Join(changed, ", "), s.RootURL(), ManifestPath, err)
+	}
+}
+
+// latestChanges returns only the latest change to each path, in the order they were made
+func latestChanges(changes []ManifestChange) []ManifestChange {
+	latest := map[string]int64{}
+	for _, change := range changes {
+		latest[change.Path] = change.Generation
+	}
+	compacted := []ManifestChange{}
+	for _, change := range changes {
+		if latest[change.Path] == change.Generation {
+			compacted = append(compacted, change)
+		}
+	}
+	return compacted
+}
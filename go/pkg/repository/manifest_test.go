@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/replicate/go/pkg/files"
+)
+
+func readTestManifest(t *testing.T, repo Repository) *Manifest {
+	data, err := repo.Get(context.Background(), ManifestPath)
+	require.NoError(t, err)
+	manifest := new(Manifest)
+	require.NoError(t, json.Unmarshal(data, manifest))
+	return manifest
+}
+
+func TestManifestRepositoryRecordsChanges(t *testing.T) {
+	ctx := context.Background()
+	inner, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	repo := NewManifestRepository(inner)
+
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/1eeeeeeeee.json", []byte("{}")))
+	require.NoError(t, repo.Put(ctx, "experiments/1eeeeeeeee.tar.gz", []byte("tarball")))
+	require.NoError(t, repo.Move(ctx, "metadata/experiments/1eeeeeeeee.json", "metadata/experiments/2eeeeeeeee.json"))
+	require.NoError(t, repo.Delete(ctx, "metadata/heartbeats/1eeeeeeeee.json"))
+
+	manifest := readTestManifest(t, inner)
+	require.NotEmpty(t, manifest.ID)
+	require.Equal(t, int64(4), manifest.Generation)
+	// Only the latest change to each path is kept
+	require.Equal(t, []ManifestChange{
+		{Generation: 2, Path: "metadata/experiments/1eeeeeeeee.json", Deleted: true},
+		{Generation: 3, Path: "metadata/experiments/2eeeeeeeee.json"},
+		{Generation: 4, Path: "metadata/heartbeats/1eeeeeeeee.json", Deleted: true},
+	}, manifest.Changes)
+
+	changes, ok := manifest.ChangesSince(0)
+	require.True(t, ok)
+	require.Len(t, changes, 3)
+	changes, ok = manifest.ChangesSince(1)
+	require.True(t, ok)
+	require.Equal(t, []ManifestChange{
+		{Generation: 2, Path: "metadata/experiments/1eeeeeeeee.json", Deleted: true},
+		{Generation: 3, Path: "metadata/experiments/2eeeeeeeee.json"},
+		{Generation: 4, Path: "metadata/heartbeats/1eeeeeeeee.json", Deleted: true},
+	}, changes)
+	changes, ok = manifest.ChangesSince(4)
+	require.True(t, ok)
+	require.Empty(t, changes)
+	_, ok = manifest.ChangesSince(5)
+	require.False(t, ok)
+
+	// Corrupt manifests are replaced with a new one
+	require.NoError(t, inner.Put(ctx, ManifestPath, []byte("not json")))
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/3eeeeeeeee.json", []byte("{}")))
+	newManifest := readTestManifest(t, inner)
+	require.NotEqual(t, manifest.ID, newManifest.ID)
+	require.Equal(t, int64(1), newManifest.Generation)
+}
+
+func TestManifestRepositoryTrimsChanges(t *testing.T) {
+	ctx := context.Background()
+	inner, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	manifest := &Manifest{ID: "abc"}
+	for i := 0; i < maxManifestChanges; i++ {
+		manifest.Generation++
+		manifest.Changes = append(manifest.Changes, ManifestChange{Generation: manifest.Generation, Path: fmt.Sprintf("metadata/%d", i)})
+	}
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, inner.Put(ctx, ManifestPath, data))
+
+	repo := NewManifestRepository(inner)
+	require.NoError(t, repo.Put(ctx, "metadata/bar", []byte("bar")))
+	manifest = readTestManifest(t, inner)
+	require.Len(t, manifest.Changes, maxManifestChanges)
+	require.Equal(t, int64(1), manifest.Since)
+	require.Equal(t, int64(2), manifest.Changes[0].Generation)
+	require.Equal(t, ManifestChange{Generation: maxManifestChanges + 1, Path: "metadata/bar"}, manifest.Changes[maxManifestChanges-1])
+
+	// Caches that have missed changes that have been trimmed can't use it
+	_, ok := manifest.ChangesSince(0)
+	require.False(t, ok)
+	_, ok = manifest.ChangesSince(1)
+	require.True(t, ok)
+}
+
+func TestManifestRepositoryKeepsLatestChangeToEachPath(t *testing.T) {
+	ctx := context.Background()
+	inner, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	repo := NewManifestRepository(inner)
+
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/1eeeeeeeee.json", []byte("{}")))
+	// Heartbeats are written over and over, and mustn't push other changes out
+	for i := 0; i < maxManifestChanges*2; i++ {
+		require.NoError(t, repo.Put(ctx, "metadata/heartbeats/1eeeeeeeee.json", []byte("{}")))
+		require.NoError(t, repo.Put(ctx, "metadata/heartbeats/2eeeeeeeee.json", []byte("{}")))
+	}
+
+	manifest := readTestManifest(t, inner)
+	require.Equal(t, int64(maxManifestChanges*4+1), manifest.Generation)
+	require.Equal(t, []ManifestChange{
+		{Generation: 1, Path: "metadata/experiments/1eeeeeeeee.json"},
+		{Generation: manifest.Generation - 1, Path: "metadata/heartbeats/1eeeeeeeee.json"},
+		{Generation: manifest.Generation, Path: "metadata/heartbeats/2eeeeeeeee.json"},
+	}, manifest.Changes)
+
+	// Caches that are behind still only fetch what has changed
+	changes, ok := manifest.ChangesSince(0)
+	require.True(t, ok)
+	require.Len(t, changes, 3)
+	changes, ok = manifest.ChangesSince(2)
+	require.True(t, ok)
+	require.Len(t, changes, 2)
+}
+
+func TestSyncCacheWithManifest(t *testing.T) {
+	ctx := context.Background()
+	projectDir, err := files.TempDir("test-sync-cache-manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(projectDir)
+
+	inner, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	repo := NewManifestRepository(inner)
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/1eeeeeeeee.json", []byte("1")))
+	require.NoError(t, repo.Put(ctx, "metadata/heartbeats/1eeeeeeeee.json", []byte("1")))
+
+	cacheDir := path.Join(projectDir, ".replicate/metadata-cache")
+	cached := func(p string) string {
+		data, err := ioutil.ReadFile(path.Join(cacheDir, p))
+		if os.IsNotExist(err) {
+			return ""
+		}
+		require.NoError(t, err)
+		return string(data)
+	}
+	syncCache := func() {
+		cachedRepo, err := NewCachedMetadataRepository(repo, projectDir)
+		require.NoError(t, err)
+		require.NoError(t, cachedRepo.SyncCache(ctx))
+	}
+
+	// The first sync is a full sync
+	syncCache()
+	require.Equal(t, "1", cached("metadata/experiments/1eeeeeeeee.json"))
+	require.Equal(t, "1", cached("metadata/heartbeats/1eeeeeeeee.json"))
+
+	// Only what the manifest says has changed is fetched, so a file written without
+	// updating the manifest isn't
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/1eeeeeeeee.json", []byte("2")))
+	require.NoError(t, repo.Delete(ctx, "metadata/heartbeats/1eeeeeeeee.json"))
+	require.NoError(t, inner.Put(ctx, "metadata/experiments/2eeeeeeeee.json", []byte("1")))
+	syncCache()
+	require.Equal(t, "2", cached("metadata/experiments/1eeeeeeeee.json"))
+	require.Equal(t, "", cached("metadata/heartbeats/1eeeeeeeee.json"))
+	require.Equal(t, "", cached("metadata/experiments/2eeeeeeeee.json"))
+
+	// Recorded, but deleted since
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/3eeeeeeeee.json", []byte("1")))
+	require.NoError(t, inner.Delete(ctx, "metadata/experiments/3eeeeeeeee.json"))
+	syncCache()
+	require.Equal(t, "", cached("metadata/experiments/3eeeeeeeee.json"))
+
+	// A new manifest means a full sync
+	require.NoError(t, inner.Delete(ctx, ManifestPath))
+	require.NoError(t, repo.Put(ctx, "metadata/experiments/1eeeeeeeee.json", []byte("3")))
+	syncCache()
+	require.Equal(t, "3", cached("metadata/experiments/1eeeeeeeee.json"))
+	require.Equal(t, "1", cached("metadata/experiments/2eeeeeeeee.json"))
+
+	// So does a corrupt one
+	require.NoError(t, inner.Put(ctx, "metadata/experiments/4eeeeeeeee.json", []byte("1")))
+	require.NoError(t, inner.Put(ctx, ManifestPath, []byte("not json")))
+	syncCache()
+	require.Equal(t, "1", cached("metadata/experiments/4eeeeeeeee.json"))
+
+	// And a missing one
+	require.NoError(t, inner.Delete(ctx, ManifestPath))
+	require.NoError(t, inner.Put(ctx, "metadata/experiments/5eeeeeeeee.json", []byte("1")))
+	syncCache()
+	require.Equal(t, "1", cached("metadata/experiments/5eeeeeeeee.json"))
+}
@@ -0,0 +1,370 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStores holds the named stores behind mem:// URLs, so that opening the same URL
+// twice in one process returns the same data, like it would for a real backend
+var memoryStores = map[string]*memoryStore{}
+var memoryStoresLock sync.Mutex
+
+type memoryStore struct {
+	lock     sync.RWMutex
+	files    map[string][]byte
+	modified map[string]time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		files:    map[string][]byte{},
+		modified: map[string]time.Time{},
+	}
+}
+
+// MemoryRepository is a repository that keeps everything in memory. It is useful for
+// tests and for simulating operations without touching a real repository.
+type MemoryRepository struct {
+	name  string
+	root  string
+	store *memoryStore
+}
+
+// NewMemoryRepository returns a repository backed by the in-memory store `name`. Repositories
+// with the same name share data. If name is empty, a new private store is created.
+func NewMemoryRepository(name, root string) (*MemoryRepository, error) {
+	var store *memoryStore
+	if name == "" {
+		store = newMemoryStore()
+	} else {
+		memoryStoresLock.Lock()
+		store = memoryStores[name]
+		if store == nil {
+			store = newMemoryStore()
+			memoryStores[name] = store
+		}
+		memoryStoresLock.Unlock()
+	}
+	return &MemoryRepository{
+		name:  name,
+		root:  root,
+		store: store,
+	}, nil
+}
+
+func (s *MemoryRepository) RootURL() string {
+	ret := "mem://" + s.name
+	if s.root != "" {
+		ret += "/" + s.root
+	}
+	return ret
+}
+
+// Get data at path
+func (s *MemoryRepository) Get(ctx context.Context, p string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.store.lock.RLock()
+	data, ok := s.store.files[s.key(p)]
+	s.store.lock.RUnlock()
+	if !ok {
+		return nil, &DoesNotExistError{msg: "Get: path does not exist: " + p}
+	}
+	// Copy so callers can't modify what is stored
+	return append([]byte{}, data...), nil
+}
+
+// GetReader returns a reader for the data at path
+func (s *MemoryRepository) GetReader(ctx context.Context, p string) (io.ReadCloser, error) {
+	data, err := s.Get(ctx, p)
+	if err != nil {
+		if _, ok := err.(*DoesNotExistError); ok {
+			return nil, &DoesNotExistError{msg: "GetReader: path does not exist: " + p}
+		}
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// GetPath recursively copies repoDir to localDir
+func (s *MemoryRepository) GetPath(ctx context.Context, repoDir string, localDir string) error {
+	prefix := s.key(repoDir)
+	for _, key := range s.keysUnder(prefix) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(prefix, key)
+		if err != nil {
+			return fmt.Errorf("Failed to determine directory of %s relative to %s: %w", key, prefix, err)
+		}
+		s.store.lock.RLock()
+		data, ok := s.store.files[key]
+		s.store.lock.RUnlock()
+		if !ok {
+			// Deleted since we listed it
+			continue
+		}
+		localPath := filepath.Join(localDir, relPath)
+		if err := writeFileAtomic(localPath, bytes.NewReader(data), 0644); err != nil {
+			return fmt.Errorf("Failed to copy %s/%s to %s: %w", s.RootURL(), repoDir, localPath, err)
+		}
+	}
+	return nil
+}
+
+// GetPathTar extracts tarball `tarPath` to `localPath`
+//
+// See repository.go for full documentation.
+func (s *MemoryRepository) GetPathTar(ctx context.Context, tarPath, localPath string) error {
+	reader, err := s.GetReader(ctx, tarPath)
+	if err != nil {
+		if _, ok := err.(*DoesNotExistError); ok {
+			return &DoesNotExistError{msg: "GetPathTar: does not exist: " + tarPath}
+		}
+		return err
+	}
+	defer reader.Close()
+	return extractTar(ctx, reader, localPath)
+}
+
+// Put data at path
+func (s *MemoryRepository) Put(ctx context.Context, p string, data []byte) error {
+	return s.PutReader(ctx, p, bytes.NewReader(data))
+}
+
+// PutReader streams data from reader to path
+func (s *MemoryRepository) PutReader(ctx context.Context, p string, reader io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("Failed to write %s/%s: %w", s.RootURL(), p, err)
+	}
+	s.store.lock.Lock()
+	s.store.files[s.key(p)] = data
+	s.store.modified[s.key(p)] = time.Now()
+	s.store.lock.Unlock()
+	return nil
+}
+
+// GetWithVersion returns the data at path and its MD5, as its version
+func (s *MemoryRepository) GetWithVersion(ctx context.Context, p string) ([]byte, string, error) {
+	data, err := s.Get(ctx, p)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, md5Version(data), nil
+}
+
+// PutIfMatch writes data to path if the version of path is still `version`
+func (s *MemoryRepository) PutIfMatch(ctx context.Context, p string, data []byte, version string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.store.lock.Lock()
+	defer s.store.lock.Unlock()
+	currentVersion := ""
+	if current, ok := s.store.files[s.key(p)]; ok {
+		currentVersion = md5Version(current)
+	}
+	if currentVersion != version {
+		return versionMismatchError(s.RootURL(), p)
+	}
+	s.store.files[s.key(p)] = append([]byte{}, data...)
+	s.store.modified[s.key(p)] = time.Now()
+	return nil
+}
+
+// PutPath recursively puts the local `localPath` directory into path `repoPath` in the repository
+func (s *MemoryRepository) PutPath(ctx context.Context, localPath string, repoPath string) error {
+	files, err := getListOfFilesToPut(localPath, repoPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := s.putFile(ctx, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryRepository) putFile(ctx context.Context, file fileToPut) error {
+	f, err := os.Open(file.Source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.PutReader(ctx, file.Dest, f)
+}
+
+// PutPathTar recursively puts the local `localPath` directory into a tar.gz file `tarPath` in the repository
+// If `includePath` is set, only that will be included.
+//
+// See repository.go for full documentation.
+func (s *MemoryRepository) PutPathTar(ctx context.Context, localPath, tarPath, includePath string) error {
+	if !strings.HasSuffix(tarPath, ".tar.gz") {
+		return fmt.Errorf("PutPathTar: tarPath must end with .tar.gz")
+	}
+	var buf bytes.Buffer
+	if err := putPathTar(ctx, localPath, &buf, filepath.Base(tarPath), includePath); err != nil {
+		return err
+	}
+	return s.PutReader(ctx, tarPath, &buf)
+}
+
+// Delete deletes path. If path is a directory, it recursively deletes
+// all everything under path
+func (s *MemoryRepository) Delete(ctx context.Context, p string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	prefix := s.key(p)
+	s.store.lock.Lock()
+	defer s.store.lock.Unlock()
+	for key := range s.store.files {
+		if isUnderPrefix(key, prefix) {
+			delete(s.store.files, key)
+			delete(s.store.modified, key)
+		}
+	}
+	return nil
+}
+
+// Copy copies the file at src to dst
+func (s *MemoryRepository) Copy(ctx context.Context, src, dst string) error {
+	return s.copy(ctx, src, dst, false)
+}
+
+// Move moves the file at src to dst
+func (s *MemoryRepository) Move(ctx context.Context, src, dst string) error {
+	return s.copy(ctx, src, dst, true)
+}
+
+func (s *MemoryRepository) copy(ctx context.Context, src, dst string, deleteSrc bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.store.lock.Lock()
+	defer s.store.lock.Unlock()
+	data, ok := s.store.files[s.key(src)]
+	if !ok {
+		return &DoesNotExistError{msg: "Copy: path does not exist: " + src}
+	}
+	// Stored data is never modified, only replaced, so it can be shared
+	s.store.files[s.key(dst)] = data
+	if !deleteSrc {
+		s.store.modified[s.key(dst)] = time.Now()
+		return nil
+	}
+	s.store.modified[s.key(dst)] = s.store.modified[s.key(src)]
+	delete(s.store.files, s.key(src))
+	delete(s.store.modified, s.key(src))
+	return nil
+}
+
+// List files in a path non-recursively
+//
+// Returns a list of paths, prefixed with the given path, that can be passed straight to Get().
+// Directories are not listed.
+// If path does not exist, an empty list will be returned.
+func (s *MemoryRepository) List(ctx context.Context, p string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	dir := s.key(p)
+	result := []string{}
+	for _, key := range s.keysUnder(dir) {
+		if path.Dir(key) == dir || (dir == "" && !strings.Contains(key, "/")) {
+			result = append(result, path.Join(p, path.Base(key)))
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryRepository) ListRecursive(ctx context.Context, results chan<- ListResult, folder string) {
+	s.listRecursive(ctx, results, folder, func(_ string) bool { return true })
+}
+
+func (s *MemoryRepository) MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, folder string, filename string) {
+	s.listRecursive(ctx, results, folder, func(key string) bool {
+		return path.Base(key) == filename
+	})
+}
+
+func (s *MemoryRepository) listRecursive(ctx context.Context, results chan<- ListResult, folder string, filter func(string) bool) {
+	defer close(results)
+	for _, key := range s.keysUnder(s.key(folder)) {
+		if !filter(key) {
+			continue
+		}
+		s.store.lock.RLock()
+		data, ok := s.store.files[key]
+		modified := s.store.modified[key]
+		s.store.lock.RUnlock()
+		if !ok {
+			continue
+		}
+		md5sum := md5.Sum(data)
+		result := ListResult{
+			Path:     s.relativeToRoot(key),
+			Checksum: MD5Checksum(md5sum[:]),
+			Size:     int64(len(data)),
+			MTime:    modified,
+		}
+		select {
+		case results <- result:
+		case <-ctx.Done():
+			sendListError(ctx, results, fmt.Errorf("Failed to list %s/%s: %w", s.RootURL(), folder, ctx.Err()))
+			return
+		}
+	}
+}
+
+// key returns the key in the store for path p, which includes s.root
+func (s *MemoryRepository) key(p string) string {
+	key := path.Join(s.root, p)
+	if key == "." {
+		return ""
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+func (s *MemoryRepository) relativeToRoot(key string) string {
+	if s.root == "" {
+		return key
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(key, s.key("")), "/")
+}
+
+// keysUnder returns the sorted keys that are at or under prefix
+func (s *MemoryRepository) keysUnder(prefix string) []string {
+	s.store.lock.RLock()
+	defer s.store.lock.RUnlock()
+	keys := []string{}
+	for key := range s.store.files {
+		if isUnderPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isUnderPrefix(key, prefix string) bool {
+	return prefix == "" || key == prefix || strings.HasPrefix(key, prefix+"/")
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"crypto/md5"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepositoryGetPut(t *testing.T) {
+	repository, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+
+	_, err = repository.Get(context.Background(), "does-not-exist")
+	require.IsType(t, &DoesNotExistError{}, err)
+	_, err = repository.GetReader(context.Background(), "does-not-exist")
+	require.IsType(t, &DoesNotExistError{}, err)
+
+	require.NoError(t, repository.Put(context.Background(), "some-file", []byte("hello")))
+	content, err := repository.Get(context.Background(), "some-file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+
+	reader, err := repository.GetReader(context.Background(), "some-file")
+	require.NoError(t, err)
+	defer reader.Close()
+	content, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+}
+
+func TestMemoryRepositoryListAndDelete(t *testing.T) {
+	repository, err := NewMemoryRepository("", "root")
+	require.NoError(t, err)
+
+	require.NoError(t, repository.Put(context.Background(), "some-file", []byte("hello")))
+	require.NoError(t, repository.Put(context.Background(), "dir/another-file", []byte("hello")))
+	require.NoError(t, repository.Put(context.Background(), "dir/sub/third-file", []byte("hello")))
+	require.NoError(t, repository.Put(context.Background(), "dirt/not-in-dir", []byte("hello")))
+
+	paths, err := repository.List(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"some-file"}, paths)
+
+	paths, err = repository.List(context.Background(), "dir")
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/another-file"}, paths)
+
+	paths, err = repository.List(context.Background(), "dir-that-does-not-exist")
+	require.NoError(t, err)
+	require.Equal(t, []string{}, paths)
+
+	results := make(chan ListResult)
+	go repository.ListRecursive(context.Background(), results, "dir")
+	hash := md5.Sum([]byte("hello"))
+	require.Equal(t, ListResult{Path: "dir/another-file", Checksum: MD5Checksum(hash[:]), Size: 5}, withoutMTime(t, <-results))
+	require.Equal(t, ListResult{Path: "dir/sub/third-file", Checksum: MD5Checksum(hash[:]), Size: 5}, withoutMTime(t, <-results))
+	_, ok := <-results
+	require.False(t, ok)
+
+	require.NoError(t, repository.Delete(context.Background(), "dir"))
+	_, err = repository.Get(context.Background(), "dir/sub/third-file")
+	require.IsType(t, &DoesNotExistError{}, err)
+	_, err = repository.Get(context.Background(), "dirt/not-in-dir")
+	require.NoError(t, err)
+}
+
+func TestMemoryRepositoryMatchFilenamesRecursive(t *testing.T) {
+	repository, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+
+	require.NoError(t, repository.Put(context.Background(), "checkpoints/abc123/replicate-metadata.json", []byte("yep")))
+	require.NoError(t, repository.Put(context.Background(), "checkpoints/abc123/other.json", []byte("nope")))
+
+	results := make(chan ListResult)
+	go repository.MatchFilenamesRecursive(context.Background(), results, "checkpoints", "replicate-metadata.json")
+	require.Equal(t, "checkpoints/abc123/replicate-metadata.json", (<-results).Path)
+	_, ok := <-results
+	require.False(t, ok)
+}
+
+func TestMemoryRepositoryListRecursiveCancelled(t *testing.T) {
+	repository, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	require.NoError(t, repository.Put(context.Background(), "dir/a", []byte("hello")))
+	require.NoError(t, repository.Put(context.Background(), "dir/b", []byte("hello")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan ListResult)
+	done := make(chan struct{})
+	go func() {
+		repository.ListRecursive(ctx, results, "dir")
+		close(done)
+	}()
+	require.Equal(t, "dir/a", (<-results).Path)
+	// The reader stops reading, which mustn't leave the lister blocked
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ListRecursive didn't return after the context was cancelled")
+	}
+}
+
+func TestMemoryPutPathTarGetPathTar(t *testing.T) {
+	repository, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+
+	workDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+	require.NoError(t, os.MkdirAll(path.Join(workDir, "data/subdirectory"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(workDir, "train.py"), []byte("not included"), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(workDir, "data/subdirectory/weights"), []byte("hello"), 0644))
+
+	err = repository.GetPathTar(context.Background(), "checkpoints/abc123.tar.gz", workDir)
+	require.IsType(t, &DoesNotExistError{}, err)
+
+	require.NoError(t, repository.PutPathTar(context.Background(), workDir, "checkpoints/abc123.tar.gz", "data"))
+
+	outputDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+	require.NoError(t, repository.GetPathTar(context.Background(), "checkpoints/abc123.tar.gz", outputDir))
+
+	content, err := ioutil.ReadFile(path.Join(outputDir, "data/subdirectory/weights"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+	_, err = os.Stat(path.Join(outputDir, "train.py"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestMemoryRepositoryForURL(t *testing.T) {
+	repo1, err := ForURL("mem://test-for-url/root")
+	require.NoError(t, err)
+	require.Equal(t, "mem://test-for-url/root", repo1.RootURL())
+	require.False(t, NeedsCaching(repo1))
+	require.NoError(t, repo1.Put(context.Background(), "some-file", []byte("hello")))
+
+	// The same name shares data
+	repo2, err := ForURL("mem://test-for-url/root")
+	require.NoError(t, err)
+	content, err := repo2.Get(context.Background(), "some-file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+
+	// Syncing to and from other repositories works
+	diskDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(diskDir)
+	disk, err := NewDiskRepository(diskDir)
+	require.NoError(t, err)
+	_, err = Sync(context.Background(), repo1, "", disk, "", SyncOptions{})
+	require.NoError(t, err)
+	content, err = ioutil.ReadFile(path.Join(diskDir, "some-file"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+}
@@ -1,17 +1,22 @@
 package repository
 
 import (
+	"archive/tar"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/mholt/archiver/v3"
 	gitignore "github.com/sabhiram/go-gitignore"
 
+	"github.com/replicate/replicate/go/pkg/console"
 	"github.com/replicate/replicate/go/pkg/files"
 )
 
@@ -20,17 +25,41 @@ var maxWorkers = 128
 type Scheme string
 
 const (
-	SchemeDisk Scheme = "file"
-	SchemeS3   Scheme = "s3"
-	SchemeGCS  Scheme = "gs"
+	SchemeDisk   Scheme = "file"
+	SchemeS3     Scheme = "s3"
+	SchemeGCS    Scheme = "gs"
+	SchemeMemory Scheme = "mem"
+	SchemeSFTP   Scheme = "ssh"
+	SchemeHTTP   Scheme = "http"
+	SchemeHTTPS  Scheme = "https"
 )
 
 type ListResult struct {
-	Path  string
-	MD5   []byte
+	Path string
+	// Checksum is a hash of the file's contents. It is zero if the repository doesn't know.
+	Checksum Checksum
+	// Size is the size of the file in bytes
+	Size int64
+	// MTime is when the file was last modified. It is zero if the repository doesn't know.
+	MTime time.Time
 	Error error
 }
 
+// sendListError sends err to the reader of a ListRecursive or MatchFilenamesRecursive
+// channel. Readers stop reading when they cancel ctx, so it gives up if ctx is done and
+// nothing is waiting for it, rather than blocking forever.
+func sendListError(ctx context.Context, results chan<- ListResult, err error) {
+	select {
+	case results <- ListResult{Error: err}:
+		return
+	default:
+	}
+	select {
+	case results <- ListResult{Error: err}:
+	case <-ctx.Done():
+	}
+}
+
 // Repository represents a blob store
 //
 // TODO: this interface needs trimming. A lot of things exist on this interface for the shared library with
@@ -41,21 +70,29 @@ type Repository interface {
 	RootURL() string
 
 	// Get data at path
-	Get(path string) ([]byte, error)
+	Get(ctx context.Context, path string) ([]byte, error)
+
+	// GetReader returns a reader for the data at path. The caller must close it.
+	//
+	// Use this instead of Get() for things that might be large, so they don't have to be held in memory.
+	GetReader(ctx context.Context, path string) (io.ReadCloser, error)
 
 	// GetPath recursively copies repoDir to localDir
-	GetPath(repoPath, localPath string) error
+	GetPath(ctx context.Context, repoPath, localPath string) error
 
 	// GetPathTar extracts tarball `tarPath` to `localPath`
 	//
 	// The first component of the tarball is stripped. E.g. Extracting a tarball with `abc123/weights` in it to `/code` would create `/code/weights`.
-	GetPathTar(tarPath, localPath string) error
+	GetPathTar(ctx context.Context, tarPath, localPath string) error
 
 	// Put data at path
-	Put(path string, data []byte) error
+	Put(ctx context.Context, path string, data []byte) error
+
+	// PutReader streams data from reader to path
+	PutReader(ctx context.Context, path string, reader io.Reader) error
 
 	// PutPath recursively puts the local `localPath` directory into path `repoPath` in the repository
-	PutPath(localPath, repoPath string) error
+	PutPath(ctx context.Context, localPath, repoPath string) error
 
 	// PutPathTar recursively puts the local `localPath` directory into a tar.gz file `tarPath` in the repository.
 	// If `includePath` is set, only that will be included
@@ -65,23 +102,37 @@ type Repository interface {
 	// - /code/data/weights
 	// will result in a tarball containing:
 	// - `abc123/data/weights`
-	PutPathTar(localPath, tarPath, basePath string) error
+	PutPathTar(ctx context.Context, localPath, tarPath, basePath string) error
 
 	// Delete deletes path. If path is a directory, it recursively deletes
 	// all everything under path
-	Delete(path string) error
+	Delete(ctx context.Context, path string) error
+
+	// Copy copies the file at src to dst, replacing dst if it exists. Where the repository
+	// supports it, the data is copied on the server, without downloading it.
+	//
+	// If src does not exist, it returns a DoesNotExistError.
+	Copy(ctx context.Context, src, dst string) error
+
+	// Move moves the file at src to dst, replacing dst if it exists. Where the repository
+	// supports it, the data is moved on the server, without downloading it.
+	//
+	// If src does not exist, it returns a DoesNotExistError.
+	Move(ctx context.Context, src, dst string) error
 
 	// List files in a path non-recursively
 	//
 	// Returns a list of paths, prefixed with the given path, that can be passed straight to Get().
 	// Directories are not listed.
 	// If path does not exist, an empty list will be returned.
-	List(path string) ([]string, error)
+	List(ctx context.Context, path string) ([]string, error)
 
 	// List files in a path recursively
-	ListRecursive(results chan<- ListResult, folder string)
+	//
+	// If ctx is cancelled, listing stops and an error is sent to results.
+	ListRecursive(ctx context.Context, results chan<- ListResult, folder string)
 
-	MatchFilenamesRecursive(results chan<- ListResult, folder string, filename string)
+	MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, folder string, filename string)
 }
 
 // SplitURL splits a repository URL into <scheme>://<path>
@@ -99,11 +150,37 @@ func SplitURL(repositoryURL string) (scheme Scheme, bucket string, root string,
 		return SchemeS3, u.Host, strings.TrimPrefix(u.Path, "/"), nil
 	case "gs":
 		return SchemeGCS, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+	case "mem":
+		return SchemeMemory, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+	case "ssh":
+		host := u.Host
+		if u.User != nil {
+			host = u.User.Username() + "@" + host
+		}
+		// ssh://host/~/foo is relative to the home directory, like scp
+		if u.Path == "/~" || strings.HasPrefix(u.Path, "/~/") {
+			return SchemeSFTP, host, "~/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, "/~"), "/"), nil
+		}
+		return SchemeSFTP, host, u.Path, nil
+	case "http":
+		return SchemeHTTP, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+	case "https":
+		return SchemeHTTPS, u.Host, strings.TrimPrefix(u.Path, "/"), nil
 	}
 	return "", "", "", unknownRepositoryScheme(u.Scheme)
 }
 
+// ForURL returns the repository for repositoryURL. Operations that fail with transient
+// errors are retried.
 func ForURL(repositoryURL string) (Repository, error) {
+	repo, err := forURL(repositoryURL)
+	if err != nil {
+		return nil, err
+	}
+	return NewRetryRepository(repo, DefaultRetryOptions()), nil
+}
+
+func forURL(repositoryURL string) (Repository, error) {
 	scheme, bucket, root, err := SplitURL(repositoryURL)
 	if err != nil {
 		return nil, err
@@ -112,9 +189,23 @@ func ForURL(repositoryURL string) (Repository, error) {
 	case SchemeDisk:
 		return NewDiskRepository(root)
 	case SchemeS3:
-		return NewS3Repository(bucket, root)
+		u, err := url.Parse(repositoryURL)
+		if err != nil {
+			return nil, err
+		}
+		opts, err := S3OptionsFromQuery(u.Query())
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Repository(bucket, root, opts)
 	case SchemeGCS:
 		return NewGCSRepository(bucket, root)
+	case SchemeMemory:
+		return NewMemoryRepository(bucket, root)
+	case SchemeSFTP:
+		return NewSFTPRepository(bucket, root)
+	case SchemeHTTP, SchemeHTTPS:
+		return NewHTTPRepository(repositoryURL)
 	}
 
 	return nil, unknownRepositoryScheme(string(scheme))
@@ -177,7 +268,7 @@ func getListOfFilesToPut(localPath string, repoPath string) ([]fileToPut, error)
 	return result, err
 }
 
-func putPathTar(localPath string, out io.Writer, tarFileName string, includePath string) error {
+func putPathTar(ctx context.Context, localPath string, out io.Writer, tarFileName string, includePath string) error {
 	// archiver doesn't make it easy to include/exclude files, or write to a writer, so we have
 	// to implement all this ourselves
 	// TODO: adapt archiver so we can use its Archive() method with writers
@@ -197,6 +288,9 @@ func putPathTar(localPath string, out io.Writer, tarFileName string, includePath
 	}
 
 	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		fh, err := os.Open(file.Source)
 		if err != nil {
 			return err
@@ -219,17 +313,130 @@ func putPathTar(localPath string, out io.Writer, tarFileName string, includePath
 	return z.Close()
 }
 
-func extractTar(tarPath, localPath string) error {
-	tar := archiver.NewTarGz()
-	tar.StripComponents = 1
-	tar.OverwriteExisting = true
-	return tar.Unarchive(tarPath, localPath)
+// extractTar extracts a tar.gz stream to `localPath`, stripping the first component
+// of each path in the tarball
+//
+// Files are written atomically, so if extraction is interrupted, there won't be any
+// half-written files.
+func extractTar(ctx context.Context, reader io.Reader, localPath string) error {
+	// archiver's Unarchive() only works with files, so we use its lower-level reader
+	// to extract while streaming
+	z := archiver.NewTarGz()
+	if err := z.Open(reader, 0); err != nil {
+		return err
+	}
+	defer z.Close()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f, err := z.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to read tarball: %w", err)
+		}
+		err = extractTarFile(z, f, localPath)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTarFile(z *archiver.TarGz, f archiver.File, localPath string) error {
+	header, ok := f.Header.(*tar.Header)
+	if !ok {
+		return fmt.Errorf("Expected header to be *tar.Header but was %T", f.Header)
+	}
+	if err := z.CheckPath(localPath, header.Name); err != nil {
+		return err
+	}
+
+	// Strip first component, which is the name of the tarball
+	parts := strings.SplitN(header.Name, "/", 2)
+	if len(parts) < 2 || parts[1] == "" {
+		return nil
+	}
+	destPath := filepath.Join(localPath, parts[1])
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(destPath, 0755)
+	case tar.TypeReg:
+		return writeFileAtomic(destPath, f, f.Mode().Perm())
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(destPath); err != nil {
+			return err
+		}
+		return os.Symlink(header.Linkname, destPath)
+	}
+	console.Debug("Skipping %s in tarball with unsupported type %c", header.Name, header.Typeflag)
+	return nil
+}
+
+// writeFileAtomic writes reader to localPath via a temporary file in the same
+// directory, so an interrupted write doesn't leave a half-written file behind
+func writeFileAtomic(localPath string, reader io.Reader, perm os.FileMode) error {
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create directory %s: %w", dir, err)
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(localPath)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("Failed to create file in %s: %w", dir, err)
+	}
+	// Clean up the temporary file on error. On success, it has already been renamed.
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, reader); err != nil {
+		return fmt.Errorf("Failed to write %s: %w", localPath, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+	// Explicitly call Close() on success to capture error
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), localPath)
 }
 
 // NeedsCaching returns true if the repository is slow and needs caching
 func NeedsCaching(repo Repository) bool {
-	_, isDiskRepository := repo.(*DiskRepository)
-	return !isDiskRepository
+	switch r := repo.(type) {
+	case *DiskRepository, *MemoryRepository:
+		return false
+	case *EncryptedRepository:
+		return NeedsCaching(r.repository)
+	case *RetryRepository:
+		return NeedsCaching(r.repository)
+	case *ManifestRepository:
+		return NeedsCaching(r.repository)
+	}
+	return true
+}
+
+// IsReadOnly returns true if the repository can't be written to
+func IsReadOnly(repo Repository) bool {
+	switch r := repo.(type) {
+	case *HTTPRepository:
+		return true
+	case *EncryptedRepository:
+		return IsReadOnly(r.repository)
+	case *RetryRepository:
+		return IsReadOnly(r.repository)
+	case *ManifestRepository:
+		return IsReadOnly(r.repository)
+	}
+	return false
 }
 
 func unknownRepositoryScheme(scheme string) error {
@@ -241,6 +448,6 @@ func unknownRepositoryScheme(scheme string) error {
 	}
 	return fmt.Errorf(message + `.
 
-Make sure your repository URL starts with either 'file://', 's3://', or 'gs://'.
+Make sure your repository URL starts with either 'file://', 's3://', 'gs://', 'ssh://', 'mem://', 'http://', or 'https://'.
 See the docuemntation for more details: https://replicate.ai/docs/reference/yaml`)
 }
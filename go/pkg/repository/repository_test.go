@@ -2,7 +2,9 @@ package repository
 
 import (
 	"fmt"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -11,6 +13,14 @@ func shim(v ...interface{}) []interface{} {
 	return v
 }
 
+// withoutMTime checks a list result has a modification time, then clears it so the result
+// can be compared
+func withoutMTime(t *testing.T, result ListResult) ListResult {
+	require.False(t, result.MTime.IsZero(), "MTime is not set on %s", result.Path)
+	result.MTime = time.Time{}
+	return result
+}
+
 // parallel of python/tests/unit/repository/test_repository.py
 func TestSplitURL(t *testing.T) {
 	require.Equal(t, shim(SchemeDisk, "", "/foo/bar", nil), shim(SplitURL("file:///foo/bar")))
@@ -22,12 +32,43 @@ func TestSplitURL(t *testing.T) {
 	require.Equal(t, shim(SchemeGCS, "my-bucket", "", nil), shim(SplitURL("gs://my-bucket")))
 	require.Equal(t, shim(SchemeGCS, "my-bucket", "foo", nil), shim(SplitURL("gs://my-bucket/foo")))
 
+	require.Equal(t, shim(SchemeMemory, "my-store", "", nil), shim(SplitURL("mem://my-store")))
+	require.Equal(t, shim(SchemeMemory, "my-store", "foo", nil), shim(SplitURL("mem://my-store/foo")))
+
+	require.Equal(t, shim(SchemeSFTP, "my-host", "/data/foo", nil), shim(SplitURL("ssh://my-host/data/foo")))
+	require.Equal(t, shim(SchemeSFTP, "user@my-host:2222", "/data", nil), shim(SplitURL("ssh://user@my-host:2222/data")))
+	require.Equal(t, shim(SchemeSFTP, "my-host", "~/foo", nil), shim(SplitURL("ssh://my-host/~/foo")))
+	require.Equal(t, shim(SchemeSFTP, "my-host", "~/", nil), shim(SplitURL("ssh://my-host/~")))
+
+	require.Equal(t, shim(SchemeHTTP, "localhost:8000", "foo", nil), shim(SplitURL("http://localhost:8000/foo")))
+	require.Equal(t, shim(SchemeHTTPS, "example.com", "", nil), shim(SplitURL("https://example.com")))
+
 	require.Equal(t, shim(Scheme(""), "", "", fmt.Errorf(`Unknown repository scheme: foo.
 
-Make sure your repository URL starts with either 'file://', 's3://', or 'gs://'.
+Make sure your repository URL starts with either 'file://', 's3://', 'gs://', 'ssh://', 'mem://', 'http://', or 'https://'.
 See the docuemntation for more details: https://replicate.ai/docs/reference/yaml`)), shim(SplitURL("foo://my-bucket")))
 	require.Equal(t, shim(Scheme(""), "", "", fmt.Errorf(`Missing repository scheme.
 
-Make sure your repository URL starts with either 'file://', 's3://', or 'gs://'.
+Make sure your repository URL starts with either 'file://', 's3://', 'gs://', 'ssh://', 'mem://', 'http://', or 'https://'.
 See the docuemntation for more details: https://replicate.ai/docs/reference/yaml`)), shim(SplitURL("/foo/bar")))
 }
+
+func TestS3OptionsFromQuery(t *testing.T) {
+	opts, err := S3OptionsFromQuery(url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, S3Options{}, opts)
+
+	// Custom endpoints use path style by default
+	opts, err = S3OptionsFromQuery(url.Values{"endpoint": {"http://localhost:9000"}})
+	require.NoError(t, err)
+	require.Equal(t, S3Options{Endpoint: "http://localhost:9000", ForcePathStyle: true}, opts)
+
+	opts, err = S3OptionsFromQuery(url.Values{"endpoint": {"https://s3.example.com"}, "region": {"eu-west-1"}, "force_path_style": {"false"}})
+	require.NoError(t, err)
+	require.Equal(t, S3Options{Endpoint: "https://s3.example.com", Region: "eu-west-1"}, opts)
+
+	_, err = S3OptionsFromQuery(url.Values{"force_path_style": {"maybe"}})
+	require.Error(t, err)
+	_, err = S3OptionsFromQuery(url.Values{"unknown": {"option"}})
+	require.Error(t, err)
+}
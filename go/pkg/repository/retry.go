@@ -0,0 +1,326 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"google.golang.org/api/googleapi"
+
+	"github.com/replicate/replicate/go/pkg/console"
+)
+
+// RetryOptions configures how RetryRepository retries operations
+type RetryOptions struct {
+	// MaxAttempts is the number of times an operation is attempted, including the first attempt
+	MaxAttempts int
+	// InitialBackoff is the maximum time to wait before the first retry. It doubles for every retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum time to wait between retries
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryOptions returns the options used by ForURL(). The number of attempts can be
+// set with the REPLICATE_RETRY_ATTEMPTS environment variable.
+func DefaultRetryOptions() RetryOptions {
+	opts := RetryOptions{
+		MaxAttempts:    5,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+	}
+	if s := os.Getenv("REPLICATE_RETRY_ATTEMPTS"); s != "" {
+		attempts, err := strconv.Atoi(s)
+		if err != nil || attempts < 1 {
+			console.Warn("REPLICATE_RETRY_ATTEMPTS must be a positive number, but it is %q. Using %d attempts.", s, opts.MaxAttempts)
+		} else {
+			opts.MaxAttempts = attempts
+		}
+	}
+	return opts
+}
+
+// RetryRepository wraps another repository, retrying operations that fail with transient
+// errors, like throttling, server errors, and connection resets. It waits between attempts
+// with exponential backoff and jitter.
+//
+// Operations that write from a reader are only retried if the reader can be rewound.
+type RetryRepository struct {
+	repository Repository
+	opts       RetryOptions
+}
+
+func NewRetryRepository(repo Repository, opts RetryOptions) *RetryRepository {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	return &RetryRepository{
+		repository: repo,
+		opts:       opts,
+	}
+}
+
+func (s *RetryRepository) RootURL() string {
+	return s.repository.RootURL()
+}
+
+func (s *RetryRepository) Get(ctx context.Context, p string) (data []byte, err error) {
+	err = s.retry(ctx, "Get "+p, func() error {
+		data, err = s.repository.Get(ctx, p)
+		return err
+	})
+	return data, err
+}
+
+// GetReader retries getting the reader, but not errors that happen while reading from it
+func (s *RetryRepository) GetReader(ctx context.Context, p string) (reader io.ReadCloser, err error) {
+	err = s.retry(ctx, "GetReader "+p, func() error {
+		reader, err = s.repository.GetReader(ctx, p)
+		return err
+	})
+	return reader, err
+}
+
+func (s *RetryRepository) GetPath(ctx context.Context, repoDir string, localDir string) error {
+	return s.retry(ctx, "GetPath "+repoDir, func() error {
+		return s.repository.GetPath(ctx, repoDir, localDir)
+	})
+}
+
+func (s *RetryRepository) GetPathTar(ctx context.Context, tarPath, localPath string) error {
+	return s.retry(ctx, "GetPathTar "+tarPath, func() error {
+		return s.repository.GetPathTar(ctx, tarPath, localPath)
+	})
+}
+
+func (s *RetryRepository) Put(ctx context.Context, p string, data []byte) error {
+	return s.retry(ctx, "Put "+p, func() error {
+		return s.repository.Put(ctx, p, data)
+	})
+}
+
+func (s *RetryRepository) PutReader(ctx context.Context, p string, reader io.Reader) error {
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return s.repository.PutReader(ctx, p, reader)
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return s.repository.PutReader(ctx, p, reader)
+	}
+	return s.retry(ctx, "PutReader "+p, func() error {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		return s.repository.PutReader(ctx, p, reader)
+	})
+}
+
+func (s *RetryRepository) PutPath(ctx context.Context, localPath string, repoPath string) error {
+	return s.retry(ctx, "PutPath "+repoPath, func() error {
+		return s.repository.PutPath(ctx, localPath, repoPath)
+	})
+}
+
+func (s *RetryRepository) PutPathTar(ctx context.Context, localPath, tarPath, includePath string) error {
+	return s.retry(ctx, "PutPathTar "+tarPath, func() error {
+		return s.repository.PutPathTar(ctx, localPath, tarPath, includePath)
+	})
+}
+
+func (s *RetryRepository) Delete(ctx context.Context, p string) error {
+	return s.retry(ctx, "Delete "+p, func() error {
+		return s.repository.Delete(ctx, p)
+	})
+}
+
+func (s *RetryRepository) GetWithVersion(ctx context.Context, p string) (data []byte, version string, err error) {
+	conditional, err := asConditional(s.repository)
+	if err != nil {
+		return nil, "", err
+	}
+	err = s.retry(ctx, "GetWithVersion "+p, func() error {
+		data, version, err = conditional.GetWithVersion(ctx, p)
+		return err
+	})
+	return data, version, err
+}
+
+// PutIfMatch retries writing data to path. If an attempt fails after the data was written,
+// the next attempt will return a VersionMismatchError, so callers should re-read and check.
+func (s *RetryRepository) PutIfMatch(ctx context.Context, p string, data []byte, version string) error {
+	conditional, err := asConditional(s.repository)
+	if err != nil {
+		return err
+	}
+	return s.retry(ctx, "PutIfMatch "+p, func() error {
+		return conditional.PutIfMatch(ctx, p, data, version)
+	})
+}
+
+func (s *RetryRepository) Copy(ctx context.Context, src, dst string) error {
+	return s.retry(ctx, "Copy "+src, func() error {
+		return s.repository.Copy(ctx, src, dst)
+	})
+}
+
+// Move retries moving src to dst. If an attempt fails after src was moved, the next attempt
+// returns a DoesNotExistError.
+func (s *RetryRepository) Move(ctx context.Context, src, dst string) error {
+	return s.retry(ctx, "Move "+src, func() error {
+		return s.repository.Move(ctx, src, dst)
+	})
+}
+
+func (s *RetryRepository) List(ctx context.Context, p string) (paths []string, err error) {
+	err = s.retry(ctx, "List "+p, func() error {
+		paths, err = s.repository.List(ctx, p)
+		return err
+	})
+	return paths, err
+}
+
+func (s *RetryRepository) ListRecursive(ctx context.Context, results chan<- ListResult, folder string) {
+	s.retryList(ctx, results, "ListRecursive "+folder, func(attemptResults chan<- ListResult) {
+		s.repository.ListRecursive(ctx, attemptResults, folder)
+	})
+}
+
+func (s *RetryRepository) MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, folder string, filename string) {
+	s.retryList(ctx, results, "MatchFilenamesRecursive "+folder, func(attemptResults chan<- ListResult) {
+		s.repository.MatchFilenamesRecursive(ctx, attemptResults, folder, filename)
+	})
+}
+
+// retryList retries a listing if it fails before any results have been sent, because
+// results that have been sent can't be taken back
+func (s *RetryRepository) retryList(ctx context.Context, results chan<- ListResult, name string, list func(chan<- ListResult)) {
+	defer close(results)
+	sent := false
+	err := s.retry(ctx, name, func() error {
+		attemptResults := make(chan ListResult)
+		go list(attemptResults)
+		for result := range attemptResults {
+			if result.Error != nil {
+				// Drain so the lister can finish
+				for range attemptResults {
+				}
+				if sent {
+					return &fatalError{result.Error}
+				}
+				return result.Error
+			}
+			sent = true
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				for range attemptResults {
+				}
+				return &fatalError{ctx.Err()}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var fatal *fatalError
+		if errors.As(err, &fatal) {
+			err = fatal.err
+		}
+		sendListError(ctx, results, err)
+	}
+}
+
+// retry calls f until it succeeds, fails with an error that isn't retryable, or runs out of attempts
+func (s *RetryRepository) retry(ctx context.Context, name string, f func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil || attempt >= s.opts.MaxAttempts || !IsRetryableError(err) || ctx.Err() != nil {
+			return err
+		}
+		backoff := s.backoff(attempt)
+		console.Debug("%s failed on attempt %d of %d, retrying in %s: %s", name, attempt, s.opts.MaxAttempts, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// backoff returns a random duration up to InitialBackoff*2^(attempt-1), capped at MaxBackoff
+func (s *RetryRepository) backoff(attempt int) time.Duration {
+	max := s.opts.InitialBackoff
+	for i := 1; i < attempt && max < s.opts.MaxBackoff; i++ {
+		max *= 2
+	}
+	if max > s.opts.MaxBackoff {
+		max = s.opts.MaxBackoff
+	}
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// fatalError marks an error as not retryable
+type fatalError struct {
+	err error
+}
+
+func (e *fatalError) Error() string {
+	return e.err.Error()
+}
+
+// IsRetryableError returns true if err is likely to be transient, so the operation
+// that caused it may succeed if it is tried again
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var fatal *fatalError
+	var doesNotExist *DoesNotExistError
+	var readOnly *ReadOnlyError
+	var mismatch *VersionMismatchError
+	if errors.As(err, &fatal) || errors.As(err, &doesNotExist) || errors.As(err, &readOnly) || errors.As(err, &mismatch) {
+		return false
+	}
+
+	var awsRequestFailure awserr.RequestFailure
+	if errors.As(err, &awsRequestFailure) {
+		return isRetryableStatusCode(awsRequestFailure.StatusCode()) || request.IsErrorThrottle(awsRequestFailure)
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return request.IsErrorThrottle(awsErr) || (awsErr.Code() == request.ErrCodeRequestError && request.IsErrorRetryable(awsErr))
+	}
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return isRetryableStatusCode(googleErr.Code)
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return isRetryableStatusCode(statusErr.code)
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+	return false
+}
+
+func isRetryableStatusCode(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
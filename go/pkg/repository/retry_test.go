@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+)
+
+// flakyRepository fails the first `failures` calls to some methods with err
+type flakyRepository struct {
+	*MemoryRepository
+	failures int
+	calls    int
+	err      error
+}
+
+func (s *flakyRepository) fail() error {
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+	return nil
+}
+
+func (s *flakyRepository) Get(ctx context.Context, p string) ([]byte, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	return s.MemoryRepository.Get(ctx, p)
+}
+
+func (s *flakyRepository) PutReader(ctx context.Context, p string, reader io.Reader) error {
+	if err := s.fail(); err != nil {
+		// Consume some of the reader before failing, like a dropped upload would
+		_, _ = reader.Read(make([]byte, 2))
+		return err
+	}
+	return s.MemoryRepository.PutReader(ctx, p, reader)
+}
+
+func (s *flakyRepository) ListRecursive(ctx context.Context, results chan<- ListResult, folder string) {
+	if err := s.fail(); err != nil {
+		results <- ListResult{Error: err}
+		close(results)
+		return
+	}
+	s.MemoryRepository.ListRecursive(ctx, results, folder)
+}
+
+func newTestRetryRepository(t *testing.T, failures int, err error) (*RetryRepository, *flakyRepository) {
+	memory, memoryErr := NewMemoryRepository("", "")
+	require.NoError(t, memoryErr)
+	flaky := &flakyRepository{MemoryRepository: memory, failures: failures, err: err}
+	return NewRetryRepository(flaky, RetryOptions{MaxAttempts: 3}), flaky
+}
+
+func TestRetryRepositoryRetries(t *testing.T) {
+	connectionReset := fmt.Errorf("Failed to get some-file: %w", syscall.ECONNRESET)
+
+	repository, flaky := newTestRetryRepository(t, 2, connectionReset)
+	require.NoError(t, flaky.MemoryRepository.Put(context.Background(), "some-file", []byte("hello")))
+	content, err := repository.Get(context.Background(), "some-file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+	require.Equal(t, 3, flaky.calls)
+
+	// Runs out of attempts
+	repository, flaky = newTestRetryRepository(t, 3, connectionReset)
+	_, err = repository.Get(context.Background(), "some-file")
+	require.Equal(t, connectionReset, err)
+	require.Equal(t, 3, flaky.calls)
+
+	// Doesn't retry errors that aren't transient
+	repository, flaky = newTestRetryRepository(t, 2, &DoesNotExistError{msg: "nope"})
+	_, err = repository.Get(context.Background(), "some-file")
+	require.IsType(t, &DoesNotExistError{}, err)
+	require.Equal(t, 1, flaky.calls)
+}
+
+func TestRetryRepositoryPutReader(t *testing.T) {
+	// Readers that can be rewound are retried from the start
+	repository, flaky := newTestRetryRepository(t, 1, syscall.ECONNRESET)
+	require.NoError(t, repository.PutReader(context.Background(), "some-file", strings.NewReader("hello")))
+	content, err := flaky.MemoryRepository.Get(context.Background(), "some-file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+
+	// Other readers aren't retried
+	repository, flaky = newTestRetryRepository(t, 1, syscall.ECONNRESET)
+	err = repository.PutReader(context.Background(), "some-file", ioutil.NopCloser(strings.NewReader("hello")))
+	require.Error(t, err)
+	require.Equal(t, 1, flaky.calls)
+}
+
+func TestRetryRepositoryListRecursive(t *testing.T) {
+	repository, flaky := newTestRetryRepository(t, 2, syscall.ECONNRESET)
+	require.NoError(t, flaky.MemoryRepository.Put(context.Background(), "dir/some-file", []byte("hello")))
+
+	results := make(chan ListResult)
+	go repository.ListRecursive(context.Background(), results, "dir")
+	result := <-results
+	require.NoError(t, result.Error)
+	require.Equal(t, "dir/some-file", result.Path)
+	_, ok := <-results
+	require.False(t, ok)
+}
+
+func TestRetryRepositoryRetriesS3Reads(t *testing.T) {
+	// An S3-compatible server that is slowing down requests
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`))
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	conf := s3Config("us-east-1", S3Options{Endpoint: server.URL, ForcePathStyle: true})
+	conf.Credentials = credentials.NewStaticCredentials("id", "secret", "")
+	// Only retry in RetryRepository, so the calls can be counted
+	conf.MaxRetries = aws.Int(0)
+	sess, err := session.NewSession(conf)
+	require.NoError(t, err)
+	s3Repo := &S3Repository{bucketName: "bucket", sess: sess, svc: s3.New(sess)}
+
+	// The error S3Repository wraps is still recognized as retryable
+	_, err = s3Repo.Get(context.Background(), "some-file")
+	require.True(t, IsRetryableError(err), "%v should be retryable", err)
+
+	repository := NewRetryRepository(s3Repo, RetryOptions{MaxAttempts: 3})
+	content, err := repository.Get(context.Background(), "some-file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+	require.Equal(t, 3, calls)
+}
+
+func TestRetryRepositoryCancelled(t *testing.T) {
+	repository, flaky := newTestRetryRepository(t, 2, syscall.ECONNRESET)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := repository.Get(ctx, "some-file")
+	require.Error(t, err)
+	require.Equal(t, 1, flaky.calls)
+}
+
+func TestIsRetryableError(t *testing.T) {
+	for _, err := range []error{
+		syscall.ECONNRESET,
+		fmt.Errorf("Failed to upload: %w", io.ErrUnexpectedEOF),
+		awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate", nil), 503, ""),
+		awserr.New("ThrottlingException", "Rate exceeded", nil),
+		fmt.Errorf("Failed to write: %w", &googleapi.Error{Code: 503}),
+		&googleapi.Error{Code: 429},
+		&httpStatusError{code: 502},
+	} {
+		require.True(t, IsRetryableError(err), "%v should be retryable", err)
+	}
+
+	for _, err := range []error{
+		nil,
+		fmt.Errorf("Something went wrong"),
+		context.Canceled,
+		fmt.Errorf("Failed to list: %w", context.DeadlineExceeded),
+		&DoesNotExistError{},
+		&ReadOnlyError{},
+		awserr.NewRequestFailure(awserr.New("NoSuchKey", "The specified key does not exist", nil), 404, ""),
+		awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, ""),
+		&googleapi.Error{Code: 404},
+		&httpStatusError{code: 400},
+	} {
+		require.False(t, IsRetryableError(err), "%v should not be retryable", err)
+	}
+}
@@ -3,12 +3,14 @@ package repository
 import (
 	"bytes"
 	"context"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -21,9 +23,14 @@ import (
 
 	"github.com/replicate/replicate/go/pkg/concurrency"
 	"github.com/replicate/replicate/go/pkg/console"
-	"github.com/replicate/replicate/go/pkg/files"
 )
 
+// CopyObject can copy objects up to 5 GB. Larger objects are copied in parts of s3CopyPartSize,
+// s3CopyWorkers at a time.
+const s3MaxCopyObjectSize = 5 * 1024 * 1024 * 1024
+const s3CopyPartSize = 512 * 1024 * 1024
+const s3CopyWorkers = 8
+
 type S3Repository struct {
 	bucketName string
 	root       string
@@ -31,28 +38,94 @@ type S3Repository struct {
 	svc        *s3.S3
 }
 
-func NewS3Repository(bucket, root string) (*S3Repository, error) {
-	region, err := getBucketRegionOrCreateBucket(bucket)
-	if err != nil {
-		return nil, err
-	}
+// S3Options configures the connection to S3 or an S3-compatible service, like MinIO
+type S3Options struct {
+	// Endpoint is the URL of an S3-compatible service, e.g. http://localhost:9000. If empty, AWS is used.
+	Endpoint string
+	// Region is the region of the bucket. If empty, it is discovered from AWS, or defaults to us-east-1
+	// for custom endpoints.
+	Region string
+	// ForcePathStyle addresses buckets as <endpoint>/<bucket> instead of <bucket>.<endpoint>
+	ForcePathStyle bool
+}
 
+func NewS3Repository(bucket, root string, opts S3Options) (*S3Repository, error) {
 	s := &S3Repository{
 		bucketName: bucket,
 		root:       root,
 	}
-	s.sess, err = session.NewSession(&aws.Config{
-		Region:                        aws.String(region),
-		CredentialsChainVerboseErrors: aws.Bool(true),
-	})
+
+	// Custom endpoints don't support region discovery, so only do it when talking to AWS
+	// without a static region
+	discoverRegion := opts.Endpoint == "" && opts.Region == ""
+	region := opts.Region
+	if discoverRegion {
+		var err error
+		region, err = getBucketRegionOrCreateBucket(bucket)
+		if err != nil {
+			return nil, err
+		}
+	} else if region == "" {
+		region = "us-east-1"
+	}
+
+	var err error
+	s.sess, err = session.NewSession(s3Config(region, opts))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to connect to S3: %s", err)
 	}
 	s.svc = s3.New(s.sess)
 
+	if !discoverRegion {
+		if err := s.ensureBucketExists(); err != nil {
+			return nil, err
+		}
+	}
+
 	return s, nil
 }
 
+// S3OptionsFromQuery parses S3 options from the query string of a repository URL,
+// e.g. s3://bucket/root?endpoint=http://localhost:9000&region=us-east-1&force_path_style=true
+//
+// Path style addressing is used by default for custom endpoints, because S3-compatible
+// services don't usually support virtual-hosted buckets.
+func S3OptionsFromQuery(query url.Values) (S3Options, error) {
+	opts := S3Options{}
+	for key := range query {
+		switch key {
+		case "endpoint", "region", "force_path_style":
+		default:
+			return opts, fmt.Errorf("Unknown option in S3 repository URL: %s (supported options are 'endpoint', 'region', and 'force_path_style')", key)
+		}
+	}
+	opts.Endpoint = query.Get("endpoint")
+	opts.Region = query.Get("region")
+	opts.ForcePathStyle = opts.Endpoint != ""
+	if v := query.Get("force_path_style"); v != "" {
+		forcePathStyle, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid value for force_path_style in S3 repository URL: %s", v)
+		}
+		opts.ForcePathStyle = forcePathStyle
+	}
+	return opts, nil
+}
+
+func s3Config(region string, opts S3Options) *aws.Config {
+	conf := &aws.Config{
+		Region:                        aws.String(region),
+		CredentialsChainVerboseErrors: aws.Bool(true),
+	}
+	if opts.Endpoint != "" {
+		conf.Endpoint = aws.String(opts.Endpoint)
+	}
+	if opts.ForcePathStyle {
+		conf.S3ForcePathStyle = aws.Bool(true)
+	}
+	return conf
+}
+
 func (s *S3Repository) RootURL() string {
 	ret := "s3://" + s.bucketName
 	if s.root != "" {
@@ -62,9 +135,23 @@ func (s *S3Repository) RootURL() string {
 }
 
 // Get data at path
-func (s *S3Repository) Get(path string) ([]byte, error) {
+func (s *S3Repository) Get(ctx context.Context, path string) ([]byte, error) {
+	reader, err := s.GetReader(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read body from %s/%s: %w", s.RootURL(), path, err)
+	}
+	return body, nil
+}
+
+// GetReader returns a reader for the data at path
+func (s *S3Repository) GetReader(ctx context.Context, path string) (io.ReadCloser, error) {
 	key := filepath.Join(s.root, path)
-	obj, err := s.svc.GetObject(&s3.GetObjectInput{
+	obj, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucketName),
 		Key:    aws.String(key),
 	})
@@ -74,66 +161,236 @@ func (s *S3Repository) Get(path string) ([]byte, error) {
 				return nil, &DoesNotExistError{msg: "Get: path does not exist: " + path}
 			}
 		}
-		return nil, fmt.Errorf("Failed to read %s/%s: %s", s.RootURL(), path, err)
-	}
-	body, err := ioutil.ReadAll(obj.Body)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to read body from %s/%s: %s", s.RootURL(), path, err)
+		return nil, fmt.Errorf("Failed to read %s/%s: %w", s.RootURL(), path, err)
 	}
-	return body, nil
+	return obj.Body, nil
 }
 
-func (s *S3Repository) Delete(path string) error {
+func (s *S3Repository) Delete(ctx context.Context, path string) error {
 	console.Debug("Deleting %s/%s...", s.RootURL(), path)
 	key := filepath.Join(s.root, path)
 	iter := s3manager.NewDeleteListIterator(s.svc, &s3.ListObjectsInput{
 		Bucket: &s.bucketName,
 		Prefix: &key,
 	})
-	if err := s3manager.NewBatchDeleteWithClient(s.svc).Delete(aws.BackgroundContext(), iter); err != nil {
+	if err := s3manager.NewBatchDeleteWithClient(s.svc).Delete(ctx, iter); err != nil {
 		return fmt.Errorf("Failed to delete %s/%s: %w", s.RootURL(), path, err)
 	}
 	return nil
 }
 
+// Copy copies the object at src to dst on S3, so the data isn't downloaded
+func (s *S3Repository) Copy(ctx context.Context, src, dst string) error {
+	if err := s.copyObject(ctx, filepath.Join(s.root, src), filepath.Join(s.root, dst)); err != nil {
+		if _, ok := err.(*DoesNotExistError); ok {
+			return &DoesNotExistError{msg: "Copy: path does not exist: " + src}
+		}
+		return fmt.Errorf("Failed to copy %s/%s to %s: %w", s.RootURL(), src, dst, err)
+	}
+	return nil
+}
+
+// Move copies the object at src to dst on S3, then deletes src
+func (s *S3Repository) Move(ctx context.Context, src, dst string) error {
+	if err := s.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	_, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(filepath.Join(s.root, src)),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to delete %s/%s: %w", s.RootURL(), src, err)
+	}
+	return nil
+}
+
+// copyObject copies srcKey to dstKey. CopyObject can copy objects up to 5 GB, so larger
+// objects are copied in parts with a multipart upload.
+func (s *S3Repository) copyObject(ctx context.Context, srcKey, dstKey string) error {
+	head, err := s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(srcKey),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusNotFound {
+			return &DoesNotExistError{msg: "path does not exist: " + srcKey}
+		}
+		return err
+	}
+	copySource := (&url.URL{Path: s.bucketName + "/" + srcKey}).EscapedPath()
+	size := aws.Int64Value(head.ContentLength)
+	if size <= s3MaxCopyObjectSize {
+		_, err := s.svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(s.bucketName),
+			Key:        aws.String(dstKey),
+			CopySource: aws.String(copySource),
+		})
+		return err
+	}
+	return s.multipartCopy(ctx, copySource, dstKey, size)
+}
+
+func (s *S3Repository) multipartCopy(ctx context.Context, copySource, dstKey string, size int64) error {
+	partSize := int64(s3CopyPartSize)
+	// S3 allows at most 10,000 parts
+	if size/partSize >= 10000 {
+		partSize = size/10000 + 1
+	}
+	numParts := int((size + partSize - 1) / partSize)
+
+	upload, err := s.svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(dstKey),
+	})
+	if err != nil {
+		return err
+	}
+
+	parts := make([]*s3.CompletedPart, numParts)
+	queue := concurrency.NewWorkerQueue(ctx, s3CopyWorkers)
+	for i := 0; i < numParts; i++ {
+		// Variables used in closure
+		partNumber := int64(i + 1)
+		start := int64(i) * partSize
+		end := start + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		i := i
+		err := queue.Go(func() error {
+			out, err := s.svc.UploadPartCopyWithContext(queue.Context(), &s3.UploadPartCopyInput{
+				Bucket:          aws.String(s.bucketName),
+				Key:             aws.String(dstKey),
+				CopySource:      aws.String(copySource),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+				PartNumber:      aws.Int64(partNumber),
+				UploadId:        upload.UploadId,
+			})
+			if err != nil {
+				return err
+			}
+			parts[i] = &s3.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int64(partNumber)}
+			return nil
+		})
+		if err != nil {
+			// Wait for the parts that were started, so none are copied after it is aborted
+			_ = queue.Wait()
+			s.abortMultipartUpload(dstKey, upload.UploadId)
+			return err
+		}
+	}
+	if err := queue.Wait(); err != nil {
+		s.abortMultipartUpload(dstKey, upload.UploadId)
+		return err
+	}
+	_, err = s.svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucketName),
+		Key:             aws.String(dstKey),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s.abortMultipartUpload(dstKey, upload.UploadId)
+		return err
+	}
+	return nil
+}
+
+// abortMultipartUpload aborts an upload, so the parts that were uploaded aren't left behind to
+// be charged for. It doesn't take a context, because it is used when the context is cancelled.
+func (s *S3Repository) abortMultipartUpload(key string, uploadID *string) {
+	_, err := s.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucketName),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		console.Warn("Failed to abort multipart upload to %s: %s", key, err)
+	}
+}
+
 // Put data at path
-func (s *S3Repository) Put(path string, data []byte) error {
+func (s *S3Repository) Put(ctx context.Context, path string, data []byte) error {
+	return s.PutReader(ctx, path, bytes.NewReader(data))
+}
+
+// PutReader streams data from reader to path
+func (s *S3Repository) PutReader(ctx context.Context, path string, reader io.Reader) error {
 	key := filepath.Join(s.root, path)
-	uploader := s3manager.NewUploader(s.sess)
-	_, err := uploader.Upload(&s3manager.UploadInput{
+	if err := s.upload(ctx, key, reader); err != nil {
+		return fmt.Errorf("Unable to upload to %s/%s: %w", s.RootURL(), path, err)
+	}
+	return nil
+}
+
+// GetWithVersion returns the data at path and its ETag, as its version
+func (s *S3Repository) GetWithVersion(ctx context.Context, path string) ([]byte, string, error) {
+	key := filepath.Join(s.root, path)
+	obj, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucketName),
 		Key:    aws.String(key),
-		Body:   bytes.NewReader(data),
 	})
 	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == s3.ErrCodeNoSuchKey {
+				return nil, "", &DoesNotExistError{msg: "Get: path does not exist: " + path}
+			}
+		}
+		return nil, "", fmt.Errorf("Failed to read %s/%s: %w", s.RootURL(), path, err)
+	}
+	defer obj.Body.Close()
+	body, err := ioutil.ReadAll(obj.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to read body from %s/%s: %w", s.RootURL(), path, err)
+	}
+	return body, aws.StringValue(obj.ETag), nil
+}
+
+// PutIfMatch writes data to path if its ETag is still `version`
+//
+// This uses S3's conditional writes (If-Match and If-None-Match). S3-compatible services
+// that don't support them will write unconditionally.
+func (s *S3Repository) PutIfMatch(ctx context.Context, path string, data []byte, version string) error {
+	key := filepath.Join(s.root, path)
+	headers := map[string]string{"If-None-Match": "*"}
+	if version != "" {
+		headers = map[string]string{"If-Match": version}
+	}
+	_, err := s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	}, request.WithSetRequestHeaders(headers))
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok {
+			// 409 is returned if another conditional write to the same key is in progress
+			if aerr.StatusCode() == http.StatusPreconditionFailed || aerr.StatusCode() == http.StatusConflict {
+				return versionMismatchError(s.RootURL(), path)
+			}
+		}
 		return fmt.Errorf("Unable to upload to %s/%s: %w", s.RootURL(), path, err)
 	}
 	return nil
 }
 
-func (s *S3Repository) PutPath(localPath string, destPath string) error {
+func (s *S3Repository) PutPath(ctx context.Context, localPath string, destPath string) error {
 	files, err := getListOfFilesToPut(localPath, filepath.Join(s.root, destPath))
 	if err != nil {
 		return err
 	}
-	queue := concurrency.NewWorkerQueue(context.Background(), maxWorkers)
+	queue := concurrency.NewWorkerQueue(ctx, maxWorkers)
 
 	for _, file := range files {
 		// Variables used in closure
 		file := file
 		err := queue.Go(func() error {
-			data, err := ioutil.ReadFile(file.Source)
+			f, err := os.Open(file.Source)
 			if err != nil {
 				return err
 			}
-
-			uploader := s3manager.NewUploader(s.sess)
-			_, err = uploader.Upload(&s3manager.UploadInput{
-				Bucket: aws.String(s.bucketName),
-				Key:    aws.String(file.Dest),
-				Body:   bytes.NewReader(data),
-			})
-			return err
+			defer f.Close()
+			return s.upload(queue.Context(), file.Dest, f)
 		})
 		if err != nil {
 			return err
@@ -143,50 +400,37 @@ func (s *S3Repository) PutPath(localPath string, destPath string) error {
 	return queue.Wait()
 }
 
-func (s *S3Repository) PutPathTar(localPath, tarPath, includePath string) error {
+func (s *S3Repository) PutPathTar(ctx context.Context, localPath, tarPath, includePath string) error {
 	if !strings.HasSuffix(tarPath, ".tar.gz") {
 		return fmt.Errorf("PutPathTar: tarPath must end with .tar.gz")
 	}
 
 	reader, writer := io.Pipe()
 
-	// TODO: This doesn't cancel elegantly on error -- we should use the context returned here and check if it is done.
-	errs, _ := errgroup.WithContext(context.TODO())
+	errs, ctx := errgroup.WithContext(ctx)
 
 	errs.Go(func() error {
-		if err := putPathTar(localPath, writer, filepath.Base(tarPath), includePath); err != nil {
+		if err := putPathTar(ctx, localPath, writer, filepath.Base(tarPath), includePath); err != nil {
+			writer.CloseWithError(err)
 			return err
 		}
 		return writer.Close()
 	})
 	errs.Go(func() error {
-		key := filepath.Join(s.root, tarPath)
-		uploader := s3manager.NewUploader(s.sess)
-		_, err := uploader.Upload(&s3manager.UploadInput{
-			Bucket: aws.String(s.bucketName),
-			Key:    aws.String(key),
-			Body:   reader,
-		})
+		err := s.PutReader(ctx, tarPath, reader)
+		// Unblock the tarball writer if the upload fails
+		reader.CloseWithError(err)
 		return err
 	})
 	return errs.Wait()
 }
 
 // GetPath recursively copies repoDir to localDir
-func (s *S3Repository) GetPath(remoteDir string, localDir string) error {
+func (s *S3Repository) GetPath(ctx context.Context, remoteDir string, localDir string) error {
 	prefix := filepath.Join(s.root, remoteDir)
-	iter := new(s3manager.DownloadObjectsIterator)
-	files := []*os.File{}
-	defer func() {
-		for _, f := range files {
-			if err := f.Close(); err != nil {
-				console.Warn("Failed to close file %s", f.Name())
-			}
-		}
-	}()
 
 	keys := []*string{}
-	err := s.svc.ListObjectsV2PagesWithContext(aws.BackgroundContext(), &s3.ListObjectsV2Input{
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucketName),
 		Prefix: aws.String(prefix),
 	}, func(output *s3.ListObjectsV2Output, last bool) bool {
@@ -199,75 +443,75 @@ func (s *S3Repository) GetPath(remoteDir string, localDir string) error {
 		return fmt.Errorf("Failed to list objects in s3://%s/%s: %w", s.bucketName, prefix, err)
 	}
 
+	downloader := s3manager.NewDownloader(s.sess)
 	for _, key := range keys {
 		relPath, err := filepath.Rel(prefix, *key)
 		if err != nil {
 			return fmt.Errorf("Failed to determine directory of %s relative to %s: %w", *key, prefix, err)
 		}
 		localPath := filepath.Join(localDir, relPath)
-		localDir := filepath.Dir(localPath)
-		if err := os.MkdirAll(localDir, 0755); err != nil {
-			return fmt.Errorf("Failed to create directory %s: %w", localDir, err)
-		}
-
-		f, err := os.Create(localPath)
-		if err != nil {
-			return fmt.Errorf("Failed to create file %s: %w", localPath, err)
-		}
-
 		console.Debug("Downloading %s to %s", *key, localPath)
-
-		iter.Objects = append(iter.Objects, s3manager.BatchDownloadObject{
-			Object: &s3.GetObjectInput{
-				Bucket: aws.String(s.bucketName),
-				Key:    key,
-			},
-			Writer: f,
-		})
-		files = append(files, f)
-	}
-
-	downloader := s3manager.NewDownloader(s.sess)
-	if err := downloader.DownloadWithIterator(aws.BackgroundContext(), iter); err != nil {
-		return fmt.Errorf("Failed to download s3://%s/%s to %s", s.bucketName, prefix, localDir)
+		if err := s.download(ctx, downloader, *key, localPath); err != nil {
+			return fmt.Errorf("Failed to download s3://%s/%s to %s: %w", s.bucketName, *key, localPath, err)
+		}
 	}
 	return nil
 }
 
-func (s *S3Repository) GetPathTar(tarPath, localPath string) error {
-	// archiver doesn't let us use readers, so download to temporary file
-	// TODO: make a better tar implementation
-	tmpdir, err := files.TempDir("tar")
+// download downloads key to localPath. It is downloaded to a temporary file first so
+// an interrupted download doesn't leave a half-written file behind.
+func (s *S3Repository) download(ctx context.Context, downloader *s3manager.Downloader, key string, localPath string) error {
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create directory %s: %w", dir, err)
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(localPath)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("Failed to create file in %s: %w", dir, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	_, err = downloader.DownloadWithContext(ctx, tmp, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(tmpdir)
-	tmptarball := filepath.Join(tmpdir, filepath.Base(tarPath))
-	if err := s.GetPath(tarPath, tmptarball); err != nil {
+	if err := tmp.Chmod(0644); err != nil {
 		return err
 	}
-	exists, err := files.FileExists(tmptarball)
-	if err != nil {
+	if err := tmp.Close(); err != nil {
 		return err
 	}
-	if !exists {
-		return &DoesNotExistError{msg: "GetPathTar: does not exist: " + tmptarball}
+	return os.Rename(tmp.Name(), localPath)
+}
+
+func (s *S3Repository) GetPathTar(ctx context.Context, tarPath, localPath string) error {
+	reader, err := s.GetReader(ctx, tarPath)
+	if err != nil {
+		if _, ok := err.(*DoesNotExistError); ok {
+			return &DoesNotExistError{msg: "GetPathTar: does not exist: " + tarPath}
+		}
+		return err
 	}
-	return extractTar(tmptarball, localPath)
+	defer reader.Close()
+	return extractTar(ctx, reader, localPath)
 }
 
-func (s *S3Repository) ListRecursive(results chan<- ListResult, dir string) {
-	s.listRecursive(results, dir, func(_ string) bool { return true })
+func (s *S3Repository) ListRecursive(ctx context.Context, results chan<- ListResult, dir string) {
+	s.listRecursive(ctx, results, dir, func(_ string) bool { return true })
 }
 
-func (s *S3Repository) MatchFilenamesRecursive(results chan<- ListResult, folder string, filename string) {
-	s.listRecursive(results, folder, func(key string) bool {
+func (s *S3Repository) MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, folder string, filename string) {
+	s.listRecursive(ctx, results, folder, func(key string) bool {
 		return filepath.Base(key) == filename
 	})
 }
 
 // List files in a path non-recursively
-func (s *S3Repository) List(dir string) ([]string, error) {
+func (s *S3Repository) List(ctx context.Context, dir string) ([]string, error) {
 	results := []string{}
 	prefix := filepath.Join(s.root, dir)
 
@@ -277,7 +521,7 @@ func (s *S3Repository) List(dir string) ([]string, error) {
 	}
 	prefix = strings.TrimPrefix(prefix, "/")
 
-	err := s.svc.ListObjectsPages(&s3.ListObjectsInput{
+	err := s.svc.ListObjectsPagesWithContext(ctx, &s3.ListObjectsInput{
 		Bucket:    aws.String(s.bucketName),
 		Prefix:    aws.String(prefix),
 		Delimiter: aws.String("/"),
@@ -296,16 +540,15 @@ func (s *S3Repository) List(dir string) ([]string, error) {
 }
 
 func CreateS3Bucket(region, bucket string) (err error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region:                        aws.String(region),
-		CredentialsChainVerboseErrors: aws.Bool(true),
-	})
+	sess, err := session.NewSession(s3Config(region, S3Options{}))
 	if err != nil {
 		return fmt.Errorf("Failed to connect to S3: %w", err)
 	}
-	svc := s3.New(sess)
+	return createBucket(s3.New(sess), bucket)
+}
 
-	_, err = svc.CreateBucket(&s3.CreateBucketInput{
+func createBucket(svc *s3.S3, bucket string) error {
+	_, err := svc.CreateBucket(&s3.CreateBucketInput{
 		Bucket: aws.String(bucket),
 	})
 	if err != nil {
@@ -318,11 +561,26 @@ func CreateS3Bucket(region, bucket string) (err error) {
 	}, request.WithWaiterMaxAttempts(50))
 }
 
-func DeleteS3Bucket(region, bucket string) (err error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region:                        aws.String(region),
-		CredentialsChainVerboseErrors: aws.Bool(true),
+// ensureBucketExists creates the bucket if it doesn't exist. This is used instead of
+// getBucketRegionOrCreateBucket when the region is known.
+func (s *S3Repository) ensureBucketExists() error {
+	_, err := s.svc.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(s.bucketName),
 	})
+	if err == nil {
+		return nil
+	}
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchBucket) {
+		if err := createBucket(s.svc, s.bucketName); err != nil {
+			return fmt.Errorf("Error creating bucket: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("Failed to determine if bucket s3://%s exists: %w", s.bucketName, err)
+}
+
+func DeleteS3Bucket(region, bucket string) (err error) {
+	sess, err := session.NewSession(s3Config(region, S3Options{}))
 	if err != nil {
 		return fmt.Errorf("Failed to connect to S3: %w", err)
 	}
@@ -344,7 +602,7 @@ func DeleteS3Bucket(region, bucket string) (err error) {
 	return nil
 }
 
-func (s *S3Repository) listRecursive(results chan<- ListResult, dir string, filter func(string) bool) {
+func (s *S3Repository) listRecursive(ctx context.Context, results chan<- ListResult, dir string, filter func(string) bool) {
 	prefix := filepath.Join(s.root, dir)
 	// prefixes must end with / and must not end with /
 	if !strings.HasSuffix(prefix, "/") {
@@ -352,7 +610,7 @@ func (s *S3Repository) listRecursive(results chan<- ListResult, dir string, filt
 	}
 	prefix = strings.TrimPrefix(prefix, "/")
 
-	err := s.svc.ListObjectsPages(&s3.ListObjectsInput{
+	err := s.svc.ListObjectsPagesWithContext(ctx, &s3.ListObjectsInput{
 		Bucket:  aws.String(s.bucketName),
 		Prefix:  aws.String(prefix),
 		MaxKeys: aws.Int64(1000),
@@ -363,20 +621,39 @@ func (s *S3Repository) listRecursive(results chan<- ListResult, dir string, filt
 				key = strings.TrimPrefix(strings.TrimPrefix(key, s.root), "/")
 			}
 			if filter(key) {
-				// If S3 gives us an empty/bad etag, then make it blank and cause sync instead of throwing error
-				// Also, the etag includes quotes for some reason
-				md5, _ := hex.DecodeString(strings.Replace(*value.ETag, "\"", "", -1))
-				results <- ListResult{Path: key, MD5: md5}
+				// If S3 gives us an empty/bad etag, then the checksum is left blank instead of throwing error
+				checksum := s3ETagChecksum(aws.StringValue(value.ETag))
+				select {
+				case results <- ListResult{Path: key, Checksum: checksum, Size: aws.Int64Value(value.Size), MTime: aws.TimeValue(value.LastModified)}:
+				case <-ctx.Done():
+					return false
+				}
 			}
 		}
 		return true
 	})
+	if err == nil {
+		// Listing stops without an error if we stopped paging because of the context
+		err = ctx.Err()
+	}
 	if err != nil {
-		results <- ListResult{Error: fmt.Errorf("Failed to list objects in s3://%s: %s", s.bucketName, err)}
+		sendListError(ctx, results, fmt.Errorf("Failed to list objects in s3://%s: %w", s.bucketName, err))
 	}
 	close(results)
 }
 
+// upload streams reader to key. Note key includes s.root
+func (s *S3Repository) upload(ctx context.Context, key string, reader io.Reader) error {
+	// The uploader reads the body in parts, so memory use doesn't grow with the size of the body
+	uploader := s3manager.NewUploader(s.sess)
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+		Body:   reader,
+	})
+	return err
+}
+
 func discoverBucketRegion(bucket string) (string, error) {
 	sess := session.Must(session.NewSession(&aws.Config{}))
 	ctx := context.Background()
@@ -3,10 +3,12 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -27,30 +29,42 @@ func TestS3RepositoryGet(t *testing.T) {
 	bucketName, _ := createS3Bucket(t)
 	t.Cleanup(func() { deleteS3Bucket(t, bucketName) })
 
-	repository, err := NewS3Repository(bucketName, "root")
+	repository, err := NewS3Repository(bucketName, "root", S3Options{})
 	require.NoError(t, err)
 
-	require.NoError(t, repository.Put("some-file", []byte("hello")))
+	require.NoError(t, repository.Put(context.Background(), "some-file", []byte("hello")))
 
-	data, err := repository.Get("some-file")
+	data, err := repository.Get(context.Background(), "some-file")
 	require.NoError(t, err)
 	require.Equal(t, []byte("hello"), data)
 
-	_, err = repository.Get("does-not-exist")
+	_, err = repository.Get(context.Background(), "does-not-exist")
 	fmt.Println(err)
 	require.IsType(t, &DoesNotExistError{}, err)
+
+	require.NoError(t, repository.PutReader(context.Background(), "another-file", strings.NewReader("hello again")))
+
+	reader, err := repository.GetReader(context.Background(), "another-file")
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello again"), data)
+
+	_, err = repository.GetReader(context.Background(), "does-not-exist")
+	require.IsType(t, &DoesNotExistError{}, err)
 }
 
 func TestS3GetPathTar(t *testing.T) {
 	bucketName, _ := createS3Bucket(t)
 	t.Cleanup(func() { deleteS3Bucket(t, bucketName) })
 
-	repository, err := NewS3Repository(bucketName, "root")
+	repository, err := NewS3Repository(bucketName, "root", S3Options{})
 	require.NoError(t, err)
 
 	tmpDir, err := files.TempDir("test")
 	require.NoError(t, err)
-	err = repository.GetPathTar("does-not-exist.tar.gz", tmpDir)
+	err = repository.GetPathTar(context.Background(), "does-not-exist.tar.gz", tmpDir)
 	require.IsType(t, &DoesNotExistError{}, err)
 }
 
@@ -66,16 +80,16 @@ func TestS3RepositoryPutPath(t *testing.T) {
 	err = ioutil.WriteFile(filepath.Join(tmpDir, "somedir/foo.txt"), []byte("hello"), 0644)
 	require.NoError(t, err)
 
-	repository, err := NewS3Repository(bucketName, "")
+	repository, err := NewS3Repository(bucketName, "", S3Options{})
 	require.NoError(t, err)
 
 	// Whole directory
-	err = repository.PutPath(filepath.Join(tmpDir, "somedir"), "anotherdir")
+	err = repository.PutPath(context.Background(), filepath.Join(tmpDir, "somedir"), "anotherdir")
 	require.NoError(t, err)
 	require.Equal(t, []byte("hello"), readS3Object(t, svc, bucketName, "anotherdir/foo.txt"))
 
 	// Single file
-	err = repository.PutPath(filepath.Join(tmpDir, "somedir/foo.txt"), "singlefile/foo.txt")
+	err = repository.PutPath(context.Background(), filepath.Join(tmpDir, "somedir/foo.txt"), "singlefile/foo.txt")
 	require.NoError(t, err)
 	require.Equal(t, []byte("hello"), readS3Object(t, svc, bucketName, "singlefile/foo.txt"))
 }
@@ -84,30 +98,31 @@ func TestS3ListRecursive(t *testing.T) {
 	bucketName, _ := createS3Bucket(t)
 	t.Cleanup(func() { deleteS3Bucket(t, bucketName) })
 
-	repository, err := NewS3Repository(bucketName, "")
+	repository, err := NewS3Repository(bucketName, "", S3Options{})
 	require.NoError(t, err)
 
 	// Works with empty repository
 	results := make(chan ListResult)
-	go repository.ListRecursive(results, "checkpoints")
+	go repository.ListRecursive(context.Background(), results, "checkpoints")
 	require.Empty(t, <-results)
 
 	// Lists stuff!
-	require.NoError(t, repository.Put("checkpoints/abc123.json", []byte("yep")))
-	require.NoError(t, repository.Put("experiments/def456.json", []byte("nope")))
+	require.NoError(t, repository.Put(context.Background(), "checkpoints/abc123.json", []byte("yep")))
+	require.NoError(t, repository.Put(context.Background(), "experiments/def456.json", []byte("nope")))
 	results = make(chan ListResult)
-	go repository.ListRecursive(results, "checkpoints")
+	go repository.ListRecursive(context.Background(), results, "checkpoints")
 	require.Equal(t, ListResult{
-		Path: "checkpoints/abc123.json",
-		MD5:  []byte{0x93, 0x48, 0xae, 0x78, 0x51, 0xcf, 0x3b, 0xa7, 0x98, 0xd9, 0x56, 0x4e, 0xf3, 0x8, 0xec, 0x25},
-	}, <-results)
+		Path:     "checkpoints/abc123.json",
+		Checksum: MD5Checksum([]byte{0x93, 0x48, 0xae, 0x78, 0x51, 0xcf, 0x3b, 0xa7, 0x98, 0xd9, 0x56, 0x4e, 0xf3, 0x8, 0xec, 0x25}),
+		Size:     3,
+	}, withoutMTime(t, <-results))
 	require.Empty(t, <-results)
 
 	// Works with non-existent bucket
-	repository, err = NewS3Repository("replicate-test-"+hash.Random()[0:10], "")
+	repository, err = NewS3Repository("replicate-test-"+hash.Random()[0:10], "", S3Options{})
 	require.NoError(t, err)
 	results = make(chan ListResult)
-	go repository.ListRecursive(results, "checkpoints")
+	go repository.ListRecursive(context.Background(), results, "checkpoints")
 	require.Empty(t, <-results)
 }
 
@@ -137,3 +152,12 @@ func readS3Object(t *testing.T, svc *s3.S3, bucketName string, key string) []byt
 	require.NoError(t, err)
 	return body
 }
+
+func TestS3RepositoryCopyAndMove(t *testing.T) {
+	bucketName, _ := createS3Bucket(t)
+	t.Cleanup(func() { deleteS3Bucket(t, bucketName) })
+
+	repository, err := NewS3Repository(bucketName, "root", S3Options{})
+	require.NoError(t, err)
+	testCopyAndMove(t, repository)
+}
@@ -0,0 +1,551 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/replicate/replicate/go/pkg/console"
+	"github.com/replicate/replicate/go/pkg/hash"
+)
+
+// sftpClients are connections to hosts, keyed by user@host:port. They are reused across
+// repositories so the shared library doesn't open a connection for every call.
+var sftpClients = map[string]*sftp.Client{}
+var sftpClientsLock sync.Mutex
+
+// SFTPRepository stores data on a remote machine over SFTP
+type SFTPRepository struct {
+	host   string
+	root   string
+	client *sftp.Client
+}
+
+// NewSFTPRepository connects to host, which is in the form [user@]host[:port]. Authentication
+// uses keys from the SSH agent or ~/.ssh, and host keys are verified against ~/.ssh/known_hosts.
+//
+// root is an absolute path, or a path relative to the user's home directory if it starts with ~/
+func NewSFTPRepository(host, root string) (*SFTPRepository, error) {
+	client, err := getSFTPClient(host)
+	if err != nil {
+		return nil, err
+	}
+	return newSFTPRepositoryWithClient(host, root, client), nil
+}
+
+func newSFTPRepositoryWithClient(host, root string, client *sftp.Client) *SFTPRepository {
+	// SFTP resolves relative paths against the home directory
+	root = strings.TrimPrefix(root, "~/")
+	return &SFTPRepository{
+		host:   host,
+		root:   root,
+		client: client,
+	}
+}
+
+func (s *SFTPRepository) RootURL() string {
+	if path.IsAbs(s.root) {
+		return "ssh://" + s.host + s.root
+	}
+	if s.root == "" {
+		return "ssh://" + s.host + "/~"
+	}
+	return "ssh://" + s.host + "/~/" + s.root
+}
+
+// Get data at path
+func (s *SFTPRepository) Get(ctx context.Context, p string) ([]byte, error) {
+	reader, err := s.GetReader(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s/%s: %w", s.RootURL(), p, err)
+	}
+	return data, nil
+}
+
+// GetReader returns a reader for the data at path
+func (s *SFTPRepository) GetReader(ctx context.Context, p string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := s.client.Open(s.remotePath(p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &DoesNotExistError{msg: "Get: path does not exist: " + s.RootURL() + "/" + p}
+		}
+		return nil, fmt.Errorf("Failed to open %s/%s: %w", s.RootURL(), p, err)
+	}
+	return f, nil
+}
+
+// GetPath recursively copies repoDir to localDir
+func (s *SFTPRepository) GetPath(ctx context.Context, repoDir string, localDir string) error {
+	prefix := s.remotePath(repoDir)
+	walker := s.client.Walk(prefix)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return fmt.Errorf("Failed to copy %s/%s to %s: %w", s.RootURL(), repoDir, localDir, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if walker.Stat().IsDir() {
+			continue
+		}
+		relPath, err := filepath.Rel(prefix, walker.Path())
+		if err != nil {
+			return fmt.Errorf("Failed to determine directory of %s relative to %s: %w", walker.Path(), prefix, err)
+		}
+		localPath := filepath.Join(localDir, relPath)
+		console.Debug("Downloading %s to %s", walker.Path(), localPath)
+		if err := s.download(walker.Path(), localPath); err != nil {
+			return fmt.Errorf("Failed to copy %s to %s: %w", walker.Path(), localPath, err)
+		}
+	}
+	return nil
+}
+
+func (s *SFTPRepository) download(remotePath, localPath string) error {
+	f, err := s.client.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeFileAtomic(localPath, f, 0644)
+}
+
+// GetPathTar extracts tarball `tarPath` to `localPath`
+//
+// See repository.go for full documentation.
+func (s *SFTPRepository) GetPathTar(ctx context.Context, tarPath, localPath string) error {
+	reader, err := s.GetReader(ctx, tarPath)
+	if err != nil {
+		if _, ok := err.(*DoesNotExistError); ok {
+			return &DoesNotExistError{msg: "GetPathTar: does not exist: " + tarPath}
+		}
+		return err
+	}
+	defer reader.Close()
+	return extractTar(ctx, reader, localPath)
+}
+
+// Put data at path
+func (s *SFTPRepository) Put(ctx context.Context, p string, data []byte) error {
+	return s.PutReader(ctx, p, bytes.NewReader(data))
+}
+
+// PutReader streams data from reader to path
+//
+// It is written to a temporary file first then renamed, so readers never see a
+// partially-written file.
+func (s *SFTPRepository) PutReader(ctx context.Context, p string, reader io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dest := s.remotePath(p)
+	if err := s.client.MkdirAll(path.Dir(dest)); err != nil {
+		return fmt.Errorf("Failed to create directory %s: %w", path.Dir(dest), err)
+	}
+	tmpPath := path.Join(path.Dir(dest), "."+path.Base(dest)+".tmp-"+hash.Random()[:8])
+	if err := s.writeFile(tmpPath, reader); err != nil {
+		// Best effort, the write may have failed because the connection went away
+		_ = s.client.Remove(tmpPath)
+		return fmt.Errorf("Failed to write %s/%s: %w", s.RootURL(), p, err)
+	}
+	if err := s.client.PosixRename(tmpPath, dest); err != nil {
+		_ = s.client.Remove(tmpPath)
+		return fmt.Errorf("Failed to write %s/%s: %w", s.RootURL(), p, err)
+	}
+	return nil
+}
+
+func (s *SFTPRepository) writeFile(remotePath string, reader io.Reader) error {
+	f, err := s.client.Create(remotePath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.ReadFrom(reader); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// PutPath recursively puts the local `localPath` directory into path `repoPath` in the repository
+func (s *SFTPRepository) PutPath(ctx context.Context, localPath string, repoPath string) error {
+	files, err := getListOfFilesToPut(localPath, repoPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := s.putFile(ctx, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SFTPRepository) putFile(ctx context.Context, file fileToPut) error {
+	f, err := os.Open(file.Source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.PutReader(ctx, file.Dest, f)
+}
+
+// PutPathTar recursively puts the local `localPath` directory into a tar.gz file `tarPath` in the repository
+// If `includePath` is set, only that will be included.
+//
+// See repository.go for full documentation.
+func (s *SFTPRepository) PutPathTar(ctx context.Context, localPath, tarPath, includePath string) error {
+	if !strings.HasSuffix(tarPath, ".tar.gz") {
+		return fmt.Errorf("PutPathTar: tarPath must end with .tar.gz")
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(putPathTar(ctx, localPath, writer, filepath.Base(tarPath), includePath))
+	}()
+	err := s.PutReader(ctx, tarPath, reader)
+	// Unblock the tarball writer if the upload fails
+	reader.CloseWithError(err)
+	return err
+}
+
+// Delete deletes path. If path is a directory, it recursively deletes
+// all everything under path
+func (s *SFTPRepository) Delete(ctx context.Context, p string) error {
+	if err := s.removeAll(ctx, s.remotePath(p)); err != nil {
+		return fmt.Errorf("Failed to delete %s/%s: %w", s.RootURL(), p, err)
+	}
+	return nil
+}
+
+// Copy copies the file at src to dst. SFTP can't copy files on the server, so the data is
+// downloaded and uploaded.
+func (s *SFTPRepository) Copy(ctx context.Context, src, dst string) error {
+	return copyByStreaming(ctx, s, src, dst)
+}
+
+// Move renames the file at src to dst
+func (s *SFTPRepository) Move(ctx context.Context, src, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	srcPath := s.remotePath(src)
+	dstPath := s.remotePath(dst)
+	if _, err := s.client.Lstat(srcPath); err != nil {
+		if os.IsNotExist(err) {
+			return &DoesNotExistError{msg: "Move: path does not exist: " + s.RootURL() + "/" + src}
+		}
+		return fmt.Errorf("Failed to move %s/%s: %w", s.RootURL(), src, err)
+	}
+	if err := s.client.MkdirAll(path.Dir(dstPath)); err != nil {
+		return fmt.Errorf("Failed to create directory %s: %w", path.Dir(dstPath), err)
+	}
+	if err := s.client.PosixRename(srcPath, dstPath); err != nil {
+		return fmt.Errorf("Failed to move %s/%s to %s: %w", s.RootURL(), src, dst, err)
+	}
+	return nil
+}
+
+func (s *SFTPRepository) removeAll(ctx context.Context, remotePath string) error {
+	info, err := s.client.Lstat(remotePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return s.client.Remove(remotePath)
+	}
+	// Walk visits directories before their contents, so remove in reverse
+	paths := []string{}
+	walker := s.client.Walk(remotePath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		paths = append(paths, walker.Path())
+	}
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.client.Remove(paths[i]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// List files in a path non-recursively
+//
+// Returns a list of paths, prefixed with the given path, that can be passed straight to Get().
+// Directories are not listed.
+// If path does not exist, an empty list will be returned.
+func (s *SFTPRepository) List(ctx context.Context, p string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	files, err := s.client.ReadDir(s.remotePath(p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("Failed to list %s/%s: %w", s.RootURL(), p, err)
+	}
+	result := []string{}
+	for _, f := range files {
+		if !f.IsDir() {
+			result = append(result, path.Join(p, f.Name()))
+		}
+	}
+	return result, nil
+}
+
+func (s *SFTPRepository) ListRecursive(ctx context.Context, results chan<- ListResult, folder string) {
+	s.walk(ctx, results, folder, func(remotePath string, info os.FileInfo) (*ListResult, error) {
+		if info.IsDir() {
+			return nil, nil
+		}
+		// SFTP doesn't have a way of getting checksums, so the file has to be read. This
+		// is fine for metadata, which is what is usually synced.
+		md5sum, err := s.md5File(remotePath)
+		if err != nil {
+			return nil, err
+		}
+		return &ListResult{Checksum: MD5Checksum(md5sum), Size: info.Size(), MTime: info.ModTime()}, nil
+	})
+}
+
+func (s *SFTPRepository) MatchFilenamesRecursive(ctx context.Context, results chan<- ListResult, folder string, filename string) {
+	s.walk(ctx, results, folder, func(remotePath string, info os.FileInfo) (*ListResult, error) {
+		if path.Base(remotePath) != filename {
+			return nil, nil
+		}
+		return &ListResult{Size: info.Size(), MTime: info.ModTime()}, nil
+	})
+}
+
+// walk walks folder, sending a result for each path that fn returns a result for. The
+// path on the result is set relative to the repository root. results is closed when done.
+func (s *SFTPRepository) walk(ctx context.Context, results chan<- ListResult, folder string, fn func(remotePath string, info os.FileInfo) (*ListResult, error)) {
+	defer close(results)
+	err := func() error {
+		walker := s.client.Walk(s.remotePath(folder))
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			result, err := fn(walker.Path(), walker.Stat())
+			if err != nil {
+				return err
+			}
+			if result == nil {
+				continue
+			}
+			result.Path = s.relativePath(walker.Path())
+			select {
+			case results <- *result:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		// If directory does not exist, treat this as empty. This is consistent with how blob storage
+		// would behave
+		if os.IsNotExist(err) {
+			return
+		}
+		sendListError(ctx, results, fmt.Errorf("Failed to list %s/%s: %w", s.RootURL(), folder, err))
+	}
+}
+
+func (s *SFTPRepository) md5File(remotePath string) ([]byte, error) {
+	f, err := s.client.Open(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := f.WriteTo(h); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// remotePath returns the path on the remote machine for path p in the repository
+func (s *SFTPRepository) remotePath(p string) string {
+	return path.Join(s.root, p)
+}
+
+// relativePath returns the path in the repository for a path on the remote machine
+func (s *SFTPRepository) relativePath(remotePath string) string {
+	if s.root == "" {
+		return remotePath
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(remotePath, path.Clean(s.root)), "/")
+}
+
+// splitSSHHost splits [user@]host[:port] into a user and address, filling in defaults
+func splitSSHHost(host string) (username string, addr string, err error) {
+	u, err := url.Parse("ssh://" + host)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid SSH host %q: %w", host, err)
+	}
+	if u.User != nil {
+		username = u.User.Username()
+	}
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return "", "", fmt.Errorf("Failed to determine SSH user: %w", err)
+		}
+		username = current.Username
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	return username, net.JoinHostPort(u.Hostname(), port), nil
+}
+
+func getSFTPClient(host string) (*sftp.Client, error) {
+	sftpClientsLock.Lock()
+	defer sftpClientsLock.Unlock()
+	if client, ok := sftpClients[host]; ok {
+		return client, nil
+	}
+	client, err := dialSFTP(host)
+	if err != nil {
+		return nil, err
+	}
+	sftpClients[host] = client
+	go func() {
+		// Reconnect next time if the connection goes away
+		_ = client.Wait()
+		sftpClientsLock.Lock()
+		if sftpClients[host] == client {
+			delete(sftpClients, host)
+		}
+		sftpClientsLock.Unlock()
+	}()
+	return client, nil
+}
+
+func dialSFTP(host string) (*sftp.Client, error) {
+	username, addr, err := splitSSHHost(host)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := sshHostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	auth, err := sshAuthMethods()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to %s over SSH: %w", host, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to start SFTP session on %s: %w", host, err)
+	}
+	return client, nil
+}
+
+func sshHostKeyCallback() (ssh.HostKeyCallback, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to determine home directory: %w", err)
+	}
+	knownHostsPath := filepath.Join(home, ".ssh", "known_hosts")
+	callback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s does not exist. Connect to the host with ssh first to verify its host key.", knownHostsPath)
+		}
+		return nil, fmt.Errorf("Failed to read %s: %w", knownHostsPath, err)
+	}
+	return callback, nil
+}
+
+// sshAuthMethods returns keys from the SSH agent, then unencrypted keys in ~/.ssh
+func sshAuthMethods() ([]ssh.AuthMethod, error) {
+	methods := []ssh.AuthMethod{}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			console.Debug("Failed to connect to SSH agent: %s", err)
+		} else {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to determine home directory: %w", err)
+	}
+	signers := []ssh.Signer{}
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa", "id_dsa"} {
+		keyPath := filepath.Join(home, ".ssh", name)
+		data, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			// Passphrase-protected keys need to be added to the SSH agent
+			console.Debug("Skipping SSH key %s: %s", keyPath, err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("No SSH keys found. Add a key to the SSH agent with ssh-add, or create an unencrypted key in ~/.ssh.")
+	}
+	return methods, nil
+}
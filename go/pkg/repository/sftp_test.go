@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"crypto/md5"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSFTPRepository returns a repository backed by an in-process SFTP server that
+// serves the local filesystem, rooted at a temporary directory
+func newTestSFTPRepository(t *testing.T) (*SFTPRepository, string, func()) {
+	dir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+	require.NoError(t, err)
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	require.NoError(t, err)
+
+	repository := newSFTPRepositoryWithClient("test@localhost", dir, client)
+	return repository, dir, func() {
+		server.Close()
+		client.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSFTPRepositoryGetPut(t *testing.T) {
+	repository, dir, cleanup := newTestSFTPRepository(t)
+	defer cleanup()
+
+	_, err := repository.Get(context.Background(), "does-not-exist")
+	require.IsType(t, &DoesNotExistError{}, err)
+
+	require.NoError(t, repository.Put(context.Background(), "subdirectory/some-file", []byte("hello")))
+	content, err := ioutil.ReadFile(path.Join(dir, "subdirectory/some-file"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+
+	content, err = repository.Get(context.Background(), "subdirectory/some-file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+
+	// Overwrites, and doesn't leave temporary files behind
+	require.NoError(t, repository.Put(context.Background(), "subdirectory/some-file", []byte("hello again")))
+	content, err = repository.Get(context.Background(), "subdirectory/some-file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello again"), content)
+	paths, err := repository.List(context.Background(), "subdirectory")
+	require.NoError(t, err)
+	require.Equal(t, []string{"subdirectory/some-file"}, paths)
+}
+
+func TestSFTPRepositoryListAndDelete(t *testing.T) {
+	repository, _, cleanup := newTestSFTPRepository(t)
+	defer cleanup()
+
+	require.NoError(t, repository.Put(context.Background(), "some-file", []byte("hello")))
+	require.NoError(t, repository.Put(context.Background(), "dir/another-file", []byte("hello")))
+	require.NoError(t, repository.Put(context.Background(), "dir/sub/third-file", []byte("hello")))
+
+	paths, err := repository.List(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"some-file"}, paths)
+
+	paths, err = repository.List(context.Background(), "dir-that-does-not-exist")
+	require.NoError(t, err)
+	require.Equal(t, []string{}, paths)
+
+	results := make(chan ListResult)
+	go repository.ListRecursive(context.Background(), results, "dir")
+	hash := md5.Sum([]byte("hello"))
+	require.Equal(t, ListResult{Path: "dir/another-file", Checksum: MD5Checksum(hash[:]), Size: 5}, withoutMTime(t, <-results))
+	require.Equal(t, ListResult{Path: "dir/sub/third-file", Checksum: MD5Checksum(hash[:]), Size: 5}, withoutMTime(t, <-results))
+	_, ok := <-results
+	require.False(t, ok)
+
+	results = make(chan ListResult)
+	go repository.MatchFilenamesRecursive(context.Background(), results, "", "third-file")
+	require.Equal(t, "dir/sub/third-file", (<-results).Path)
+	_, ok = <-results
+	require.False(t, ok)
+
+	results = make(chan ListResult)
+	go repository.ListRecursive(context.Background(), results, "does-not-exist")
+	_, ok = <-results
+	require.False(t, ok)
+
+	require.NoError(t, repository.Delete(context.Background(), "dir"))
+	require.NoError(t, repository.Delete(context.Background(), "dir"))
+	_, err = repository.Get(context.Background(), "dir/sub/third-file")
+	require.IsType(t, &DoesNotExistError{}, err)
+	_, err = repository.Get(context.Background(), "some-file")
+	require.NoError(t, err)
+}
+
+func TestSFTPPutPathGetPath(t *testing.T) {
+	repository, _, cleanup := newTestSFTPRepository(t)
+	defer cleanup()
+
+	workDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+	require.NoError(t, os.MkdirAll(path.Join(workDir, "data/subdirectory"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(workDir, "train.py"), []byte("not included"), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(workDir, "data/subdirectory/weights"), []byte("hello"), 0644))
+
+	require.NoError(t, repository.PutPath(context.Background(), path.Join(workDir, "data"), "parent"))
+	require.NoError(t, repository.PutPathTar(context.Background(), workDir, "checkpoints/abc123.tar.gz", "data"))
+
+	outputDir, err := ioutil.TempDir("", "replicate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	require.NoError(t, repository.GetPath(context.Background(), "parent", path.Join(outputDir, "copy")))
+	content, err := ioutil.ReadFile(path.Join(outputDir, "copy/subdirectory/weights"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+
+	require.NoError(t, repository.GetPathTar(context.Background(), "checkpoints/abc123.tar.gz", outputDir))
+	content, err = ioutil.ReadFile(path.Join(outputDir, "data/subdirectory/weights"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), content)
+	_, err = os.Stat(path.Join(outputDir, "train.py"))
+	require.True(t, os.IsNotExist(err))
+
+	err = repository.GetPathTar(context.Background(), "checkpoints/does-not-exist.tar.gz", outputDir)
+	require.IsType(t, &DoesNotExistError{}, err)
+}
+
+func TestSFTPRepositoryCopyAndMove(t *testing.T) {
+	repository, _, cleanup := newTestSFTPRepository(t)
+	defer cleanup()
+	testCopyAndMove(t, repository)
+}
@@ -1,90 +1,187 @@
 package repository
 
 import (
-	"bytes"
 	"context"
+	"io"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/replicate/replicate/go/pkg/concurrency"
 )
 
+// SyncOptions are options for Sync()
+type SyncOptions struct {
+	// DryRun makes Sync return what it would copy and delete, without changing anything
+	DryRun bool
+
+	// Progress is called after each file is copied or deleted. It is not called
+	// concurrently, so it doesn't need to be safe for concurrent use.
+	Progress func(progress SyncProgress)
+}
+
+// SyncProgress is how far through a sync Sync() is
+type SyncProgress struct {
+	FilesDone  int
+	FilesTotal int
+	// BytesCopied is the number of bytes copied so far, and BytesTotal is the sum of the
+	// sizes of the files being copied. BytesTotal is 0 if the repository doesn't know sizes.
+	BytesCopied int64
+	BytesTotal  int64
+}
+
+// SyncSummary is what Sync() copied and deleted, or would have, if it was a dry run
+type SyncSummary struct {
+	// Copied and Deleted are paths relative to sourcePath and destPath, sorted
+	Copied  []string
+	Deleted []string
+	// BytesCopied is the number of bytes copied. In a dry run, it is the sum of the sizes of
+	// the files that would be copied.
+	BytesCopied int64
+	// Unchanged is the number of files that were already the same in dest
+	Unchanged int
+}
+
 // Sync destRepository/destPath to match sourceRepository/sourcePath
 //
 // - If file exists in source, but not in dest, it will copy from source to dest
 // - If file exists in both but different content, it will copy from source to dest
 // - If file exists in dest but not in source, it will delete in dest
-func Sync(sourceRepository Repository, sourcePath string, destRepository Repository, destPath string) error {
-	// A queue to use for the various storage operations we have to run
-	queue := concurrency.NewWorkerQueue(context.Background(), maxWorkers)
-
-	// 1: Fetch destFiles synchronously off disk
-	// TODO: This could be optimized by doing this while source list request is in flight
-	results := make(chan ListResult)
-	// path -> MD5 hash map used to efficiently check if files should be synced
-	destFiles := make(map[string][]byte)
+//
+// See needsSync() for how it decides whether the content is different.
+func Sync(ctx context.Context, sourceRepository Repository, sourcePath string, destRepository Repository, destPath string, opts SyncOptions) (*SyncSummary, error) {
+	// 1: Plan what needs to be copied and deleted
+	destFiles, err := listForSync(ctx, destRepository, destPath)
+	if err != nil {
+		return nil, err
+	}
+	sourceFiles, err := listForSync(ctx, sourceRepository, sourcePath)
+	if err != nil {
+		return nil, err
+	}
 
-	go destRepository.ListRecursive(results, destPath)
-	for result := range results {
-		if result.Error != nil {
-			return result.Error
+	summary := &SyncSummary{Copied: []string{}, Deleted: []string{}}
+	progress := SyncProgress{}
+	for relativePath, sourceFile := range sourceFiles {
+		if destFile, found := destFiles[relativePath]; found && !needsSync(sourceFile, destFile) {
+			summary.Unchanged++
+			continue
 		}
-		destFiles[strings.TrimPrefix(result.Path, destPath)] = result.MD5
+		summary.Copied = append(summary.Copied, relativePath)
+		progress.BytesTotal += sourceFile.Size
 	}
-
-	// 2: Copy files from dest to source which don't exist or have changed
-	sourceFiles := make(chan ListResult)
-	// path map used for step (3)
-	sourceFileMap := make(map[string]struct{})
-	go sourceRepository.ListRecursive(sourceFiles, sourcePath)
-	for sourceFile := range sourceFiles {
-		if sourceFile.Error != nil {
-			return sourceFile.Error
+	for relativePath := range destFiles {
+		if _, found := sourceFiles[relativePath]; !found {
+			summary.Deleted = append(summary.Deleted, relativePath)
 		}
-		relativePath := strings.TrimPrefix(sourceFile.Path, sourcePath)
-		sourceFileMap[relativePath] = struct{}{}
+	}
+	sort.Strings(summary.Copied)
+	sort.Strings(summary.Deleted)
+	progress.FilesTotal = len(summary.Copied) + len(summary.Deleted)
 
-		needsCopying := false
-		if destMD5, found := destFiles[relativePath]; found {
-			if !bytes.Equal(sourceFile.MD5, destMD5) {
-				needsCopying = true
-			}
-		} else {
-			needsCopying = true
+	if opts.DryRun {
+		summary.BytesCopied = progress.BytesTotal
+		return summary, nil
+	}
+
+	// A queue to use for the various storage operations we have to run
+	queue := concurrency.NewWorkerQueue(ctx, maxWorkers)
+	var mu sync.Mutex
+	done := func(bytesCopied int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress.FilesDone++
+		progress.BytesCopied += bytesCopied
+		summary.BytesCopied += bytesCopied
+		if opts.Progress != nil {
+			opts.Progress(progress)
 		}
+	}
 
-		if needsCopying {
-			// Variables used in closure
-			sourcePath := sourcePath
-			destPath := destPath
-			relativePath := relativePath
-			err := queue.Go(func() error {
-				data, err := sourceRepository.Get(path.Join(sourcePath, relativePath))
-				if err != nil {
-					return err
-				}
-				return destRepository.Put(path.Join(destPath, relativePath), data)
-			})
+	// 2: Copy files from source to dest which don't exist or have changed
+	for _, relativePath := range summary.Copied {
+		// Variables used in closure
+		relativePath := relativePath
+		err := queue.Go(func() error {
+			reader, err := sourceRepository.GetReader(queue.Context(), path.Join(sourcePath, relativePath))
 			if err != nil {
 				return err
 			}
+			defer reader.Close()
+			counter := &countingReader{reader: reader}
+			if err := destRepository.PutReader(queue.Context(), path.Join(destPath, relativePath), counter); err != nil {
+				return err
+			}
+			done(counter.n)
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	// 3: Delete files from dest that don't exist in source
-	for relativePath := range destFiles {
-		if _, found := sourceFileMap[relativePath]; !found {
-			// Variables used in closure
-			destPath := destPath
-			relativePath := relativePath
-			err := queue.Go(func() error {
-				return destRepository.Delete(path.Join(destPath, relativePath))
-			})
-			if err != nil {
+	for _, relativePath := range summary.Deleted {
+		// Variables used in closure
+		relativePath := relativePath
+		err := queue.Go(func() error {
+			if err := destRepository.Delete(queue.Context(), path.Join(destPath, relativePath)); err != nil {
 				return err
 			}
+			done(0)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := queue.Wait(); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// needsSync returns true if dest needs to be replaced with source. Checksums are compared if
+// they are the same type. Otherwise, because different repositories have different types of
+// checksum, it assumes dest is the same if it is the same size and was written after
+// source was last modified.
+func needsSync(source, dest ListResult) bool {
+	if equal, ok := source.Checksum.Compare(dest.Checksum); ok {
+		return !equal
+	}
+	if source.Size != dest.Size || source.MTime.IsZero() || dest.MTime.IsZero() {
+		return true
+	}
+	return dest.MTime.Before(source.MTime)
+}
+
+// listForSync lists the files under dir in repo, by path relative to dir
+func listForSync(ctx context.Context, repo Repository, dir string) (map[string]ListResult, error) {
+	// Cancelled if we return early, so the listing goroutine doesn't block forever
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	listed := make(map[string]ListResult)
+	results := make(chan ListResult)
+	go repo.ListRecursive(listCtx, results, dir)
+	for result := range results {
+		if result.Error != nil {
+			return nil, result.Error
 		}
+		listed[strings.TrimPrefix(strings.TrimPrefix(result.Path, dir), "/")] = result
 	}
+	return listed, nil
+}
+
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
 
-	return queue.Wait()
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.n += int64(n)
+	return n, err
 }
@@ -1,10 +1,12 @@
 package repository
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -23,41 +25,116 @@ func TestSync(t *testing.T) {
 	require.NoError(t, err)
 
 	// Create files to dest various cases
-	err = sourceRepository.Put("src-path/in-source-but-not-dest", []byte("hello"))
+	err = sourceRepository.Put(context.Background(), "src-path/in-source-but-not-dest", []byte("hello"))
 	require.NoError(t, err)
 
-	err = destRepository.Put("dest-path/in-dest-but-not-in-source", []byte("bye"))
+	err = destRepository.Put(context.Background(), "dest-path/in-dest-but-not-in-source", []byte("bye"))
 	require.NoError(t, err)
 
-	err = sourceRepository.Put("src-path/same-content", []byte("hello"))
+	err = sourceRepository.Put(context.Background(), "src-path/same-content", []byte("hello"))
 	require.NoError(t, err)
-	err = destRepository.Put("dest-path/same-content", []byte("hello"))
+	err = destRepository.Put(context.Background(), "dest-path/same-content", []byte("hello"))
 	require.NoError(t, err)
 	info, _ := os.Stat(filepath.Join(destRepository.rootDir, "dest-path/same-content"))
 	sameContentMTime := info.ModTime()
 
-	err = sourceRepository.Put("src-path/different-content", []byte("what is up"))
+	err = sourceRepository.Put(context.Background(), "src-path/different-content", []byte("what is up"))
 	require.NoError(t, err)
-	err = destRepository.Put("dest-path/different-content", []byte("hello"))
+	err = destRepository.Put(context.Background(), "dest-path/different-content", []byte("hello"))
 	require.NoError(t, err)
 
 	// Sync
-	err = Sync(sourceRepository, "src-path", destRepository, "dest-path")
+	summary, err := Sync(context.Background(), sourceRepository, "src-path", destRepository, "dest-path", SyncOptions{})
 	require.NoError(t, err)
 
 	// Test it was put in correct state
-	data, err := destRepository.Get("dest-path/in-source-but-not-dest")
+	data, err := destRepository.Get(context.Background(), "dest-path/in-source-but-not-dest")
 	require.NoError(t, err)
 	require.Equal(t, []byte("hello"), data)
 
-	_, err = destRepository.Get("dest-path/in-dest-but-not-in-source")
+	_, err = destRepository.Get(context.Background(), "dest-path/in-dest-but-not-in-source")
 	require.IsType(t, &DoesNotExistError{}, err)
 
-	data, err = destRepository.Get("dest-path/different-content")
+	data, err = destRepository.Get(context.Background(), "dest-path/different-content")
 	require.NoError(t, err)
 	require.Equal(t, []byte("what is up"), data)
 
 	// Check same content hasn't been touched
 	info, _ = os.Stat(filepath.Join(destRepository.rootDir, "dest-path/same-content"))
 	require.Equal(t, info.ModTime(), sameContentMTime)
+
+	require.Equal(t, &SyncSummary{
+		Copied:      []string{"different-content", "in-source-but-not-dest"},
+		Deleted:     []string{"in-dest-but-not-in-source"},
+		BytesCopied: 15,
+		Unchanged:   1,
+	}, summary)
+}
+
+func TestSyncDryRunAndProgress(t *testing.T) {
+	ctx := context.Background()
+	source, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	dest, err := NewMemoryRepository("", "")
+	require.NoError(t, err)
+	require.NoError(t, source.Put(ctx, "a", []byte("hello")))
+	require.NoError(t, source.Put(ctx, "b", []byte("goodbye")))
+	require.NoError(t, dest.Put(ctx, "c", []byte("hello")))
+
+	// Dry run doesn't change anything
+	summary, err := Sync(ctx, source, "", dest, "", SyncOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, &SyncSummary{
+		Copied:      []string{"a", "b"},
+		Deleted:     []string{"c"},
+		BytesCopied: 12,
+	}, summary)
+	paths, err := dest.List(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"c"}, paths)
+
+	progress := []SyncProgress{}
+	summary, err = Sync(ctx, source, "", dest, "", SyncOptions{
+		Progress: func(p SyncProgress) {
+			progress = append(progress, p)
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(12), summary.BytesCopied)
+	require.Len(t, progress, 3)
+	last := progress[len(progress)-1]
+	require.Equal(t, SyncProgress{FilesDone: 3, FilesTotal: 3, BytesCopied: 12, BytesTotal: 12}, last)
+
+	// Nothing to do the second time
+	summary, err = Sync(ctx, source, "", dest, "", SyncOptions{})
+	require.NoError(t, err)
+	require.Empty(t, summary.Copied)
+	require.Empty(t, summary.Deleted)
+	require.Equal(t, 2, summary.Unchanged)
+}
+
+func TestNeedsSync(t *testing.T) {
+	now := time.Now()
+	md5 := MD5Checksum([]byte{1, 2, 3})
+	etag := Checksum{Type: ChecksumMultipartETag, Value: []byte{4, 5, 6}, Parts: 2}
+
+	// Checksums of the same type are compared
+	require.False(t, needsSync(ListResult{Checksum: md5}, ListResult{Checksum: md5}))
+	require.True(t, needsSync(ListResult{Checksum: md5}, ListResult{Checksum: MD5Checksum([]byte{3, 2, 1})}))
+	require.False(t, needsSync(ListResult{Checksum: etag}, ListResult{Checksum: etag}))
+
+	// Otherwise, the size and modification time
+	require.False(t, needsSync(
+		ListResult{Checksum: etag, Size: 10, MTime: now},
+		ListResult{Checksum: md5, Size: 10, MTime: now.Add(time.Second)},
+	))
+	require.True(t, needsSync(
+		ListResult{Checksum: etag, Size: 10, MTime: now},
+		ListResult{Checksum: md5, Size: 11, MTime: now.Add(time.Second)},
+	))
+	require.True(t, needsSync(
+		ListResult{Checksum: etag, Size: 10, MTime: now},
+		ListResult{Checksum: md5, Size: 10, MTime: now.Add(-time.Second)},
+	))
+	require.True(t, needsSync(ListResult{Size: 10}, ListResult{Size: 10}))
 }
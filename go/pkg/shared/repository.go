@@ -1,13 +1,21 @@
 package shared
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/replicate/replicate/go/pkg/repository"
 )
 
+// Arguments to repository methods. For SFTPRepository, Bucket is the host. S3Options is only
+// used by S3Repository, to connect to S3-compatible services. EncryptionArgs is only used by
+// EncryptedRepository.
+
 type GetArgs struct {
 	Bucket, Root, Path string
+
+	repository.S3Options
+	EncryptionArgs
 }
 
 type GetReturn struct {
@@ -17,18 +25,30 @@ type GetReturn struct {
 type PutArgs struct {
 	Bucket, Root, Path string
 	Data               []byte
+
+	repository.S3Options
+	EncryptionArgs
 }
 
 type PutPathArgs struct {
 	Bucket, Root, Src, Dest string
+
+	repository.S3Options
+	EncryptionArgs
 }
 
 type PutPathTarArgs struct {
 	Bucket, Root, LocalPath, TarPath, IncludePath string
+
+	repository.S3Options
+	EncryptionArgs
 }
 
 type ListArgs struct {
 	Bucket, Root, Path string
+
+	repository.S3Options
+	EncryptionArgs
 }
 type ListReturn struct {
 	Paths []string
@@ -36,20 +56,56 @@ type ListReturn struct {
 
 type DeleteArgs struct {
 	Bucket, Root, Path string
+
+	repository.S3Options
+	EncryptionArgs
 }
 
 type GetPathTarArgs struct {
 	Bucket, Root, TarPath, LocalPath string
+
+	repository.S3Options
+	EncryptionArgs
+}
+
+type GetWithVersionReturn struct {
+	Data    []byte
+	Version string
+}
+
+type PutIfMatchArgs struct {
+	Bucket, Root, Path string
+	Data               []byte
+	Version            string
+
+	repository.S3Options
+	EncryptionArgs
+}
+
+// EncryptionArgs are the arguments for EncryptedRepository, which wraps the repository at URL
+type EncryptionArgs struct {
+	URL string
+
+	repository.EncryptionOptions
+}
+
+// withRetry wraps repositories in a RetryRepository, like repository.ForURL() does, and
+// records changes to metadata in the manifest, like the CLI does
+func withRetry(repo repository.Repository, err error) (repository.Repository, error) {
+	if err != nil {
+		return nil, err
+	}
+	return repository.WithManifest(repository.NewRetryRepository(repo, repository.DefaultRetryOptions())), nil
 }
 
 type GCSRepository struct{}
 
 func (GCSRepository) Get(args GetArgs, ret *GetReturn) error {
-	st, err := repository.NewGCSRepository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewGCSRepository(args.Bucket, args.Root))
 	if err != nil {
 		return err
 	}
-	ret.Data, err = st.Get(args.Path)
+	ret.Data, err = st.Get(context.Background(), args.Path)
 	// HACK: net/rpc/jsonrpc doesn't let us include error codes, so prefix with
 	// predictable error name
 	if _, ok := err.(*repository.DoesNotExistError); ok {
@@ -59,52 +115,52 @@ func (GCSRepository) Get(args GetArgs, ret *GetReturn) error {
 }
 
 func (GCSRepository) Put(args PutArgs, _ *int) error {
-	st, err := repository.NewGCSRepository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewGCSRepository(args.Bucket, args.Root))
 	if err != nil {
 		return err
 	}
-	return st.Put(args.Path, args.Data)
+	return st.Put(context.Background(), args.Path, args.Data)
 }
 
 func (GCSRepository) List(args ListArgs, ret *ListReturn) error {
-	st, err := repository.NewGCSRepository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewGCSRepository(args.Bucket, args.Root))
 	if err != nil {
 		return err
 	}
-	ret.Paths, err = st.List(args.Path)
+	ret.Paths, err = st.List(context.Background(), args.Path)
 	return err
 }
 
 func (GCSRepository) PutPath(args PutPathArgs, _ *int) error {
-	st, err := repository.NewGCSRepository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewGCSRepository(args.Bucket, args.Root))
 	if err != nil {
 		return err
 	}
-	return st.PutPath(args.Src, args.Dest)
+	return st.PutPath(context.Background(), args.Src, args.Dest)
 }
 
 func (GCSRepository) PutPathTar(args PutPathTarArgs, _ *int) error {
-	st, err := repository.NewGCSRepository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewGCSRepository(args.Bucket, args.Root))
 	if err != nil {
 		return err
 	}
-	return st.PutPathTar(args.LocalPath, args.TarPath, args.IncludePath)
+	return st.PutPathTar(context.Background(), args.LocalPath, args.TarPath, args.IncludePath)
 }
 
 func (GCSRepository) Delete(args DeleteArgs, _ *int) error {
-	st, err := repository.NewGCSRepository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewGCSRepository(args.Bucket, args.Root))
 	if err != nil {
 		return err
 	}
-	return st.Delete(args.Path)
+	return st.Delete(context.Background(), args.Path)
 }
 
 func (GCSRepository) GetPathTar(args GetPathTarArgs, _ *int) error {
-	st, err := repository.NewGCSRepository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewGCSRepository(args.Bucket, args.Root))
 	if err != nil {
 		return err
 	}
-	err = st.GetPathTar(args.TarPath, args.LocalPath)
+	err = st.GetPathTar(context.Background(), args.TarPath, args.LocalPath)
 	// HACK: net/rpc/jsonrpc doesn't let us include error codes, so prefix with
 	// predictable error name
 	if _, ok := err.(*repository.DoesNotExistError); ok {
@@ -113,14 +169,30 @@ func (GCSRepository) GetPathTar(args GetPathTarArgs, _ *int) error {
 	return err
 }
 
+func (GCSRepository) GetWithVersion(args GetArgs, ret *GetWithVersionReturn) error {
+	st, err := withRetry(repository.NewGCSRepository(args.Bucket, args.Root))
+	if err != nil {
+		return err
+	}
+	return getWithVersion(st, args.Path, ret)
+}
+
+func (GCSRepository) PutIfMatch(args PutIfMatchArgs, _ *int) error {
+	st, err := withRetry(repository.NewGCSRepository(args.Bucket, args.Root))
+	if err != nil {
+		return err
+	}
+	return putIfMatch(st, args)
+}
+
 type S3Repository struct{}
 
 func (S3Repository) Get(args GetArgs, ret *GetReturn) error {
-	st, err := repository.NewS3Repository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewS3Repository(args.Bucket, args.Root, args.S3Options))
 	if err != nil {
 		return err
 	}
-	ret.Data, err = st.Get(args.Path)
+	ret.Data, err = st.Get(context.Background(), args.Path)
 	// HACK: net/rpc/jsonrpc doesn't let us include error codes, so prefix with
 	// predictable error name
 	if _, ok := err.(*repository.DoesNotExistError); ok {
@@ -130,52 +202,84 @@ func (S3Repository) Get(args GetArgs, ret *GetReturn) error {
 }
 
 func (S3Repository) Put(args PutArgs, _ *int) error {
-	st, err := repository.NewS3Repository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewS3Repository(args.Bucket, args.Root, args.S3Options))
 	if err != nil {
 		return err
 	}
-	return st.Put(args.Path, args.Data)
+	return st.Put(context.Background(), args.Path, args.Data)
 }
 
 func (S3Repository) List(args ListArgs, ret *ListReturn) error {
-	st, err := repository.NewS3Repository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewS3Repository(args.Bucket, args.Root, args.S3Options))
 	if err != nil {
 		return err
 	}
-	ret.Paths, err = st.List(args.Path)
+	ret.Paths, err = st.List(context.Background(), args.Path)
 	return err
 }
 
 func (S3Repository) PutPath(args PutPathArgs, _ *int) error {
-	st, err := repository.NewS3Repository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewS3Repository(args.Bucket, args.Root, args.S3Options))
 	if err != nil {
 		return err
 	}
-	return st.PutPath(args.Src, args.Dest)
+	return st.PutPath(context.Background(), args.Src, args.Dest)
 }
 
 func (S3Repository) PutPathTar(args PutPathTarArgs, _ *int) error {
-	st, err := repository.NewS3Repository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewS3Repository(args.Bucket, args.Root, args.S3Options))
 	if err != nil {
 		return err
 	}
-	return st.PutPathTar(args.LocalPath, args.TarPath, args.IncludePath)
+	return st.PutPathTar(context.Background(), args.LocalPath, args.TarPath, args.IncludePath)
 }
 
 func (S3Repository) Delete(args DeleteArgs, _ *int) error {
-	st, err := repository.NewS3Repository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewS3Repository(args.Bucket, args.Root, args.S3Options))
 	if err != nil {
 		return err
 	}
-	return st.Delete(args.Path)
+	return st.Delete(context.Background(), args.Path)
 }
 
 func (S3Repository) GetPathTar(args GetPathTarArgs, _ *int) error {
-	st, err := repository.NewS3Repository(args.Bucket, args.Root)
+	st, err := withRetry(repository.NewS3Repository(args.Bucket, args.Root, args.S3Options))
+	if err != nil {
+		return err
+	}
+	err = st.GetPathTar(context.Background(), args.TarPath, args.LocalPath)
+	// HACK: net/rpc/jsonrpc doesn't let us include error codes, so prefix with
+	// predictable error name
+	if _, ok := err.(*repository.DoesNotExistError); ok {
+		return fmt.Errorf("DoesNotExistError:: %w", err)
+	}
+	return err
+}
+
+func (S3Repository) GetWithVersion(args GetArgs, ret *GetWithVersionReturn) error {
+	st, err := withRetry(repository.NewS3Repository(args.Bucket, args.Root, args.S3Options))
+	if err != nil {
+		return err
+	}
+	return getWithVersion(st, args.Path, ret)
+}
+
+func (S3Repository) PutIfMatch(args PutIfMatchArgs, _ *int) error {
+	st, err := withRetry(repository.NewS3Repository(args.Bucket, args.Root, args.S3Options))
+	if err != nil {
+		return err
+	}
+	return putIfMatch(st, args)
+}
+
+type SFTPRepository struct{}
+
+func (SFTPRepository) Get(args GetArgs, ret *GetReturn) error {
+	st, err := withRetry(repository.NewSFTPRepository(args.Bucket, args.Root))
 	if err != nil {
 		return err
 	}
-	err = st.GetPathTar(args.TarPath, args.LocalPath)
+	ret.Data, err = st.Get(context.Background(), args.Path)
 	// HACK: net/rpc/jsonrpc doesn't let us include error codes, so prefix with
 	// predictable error name
 	if _, ok := err.(*repository.DoesNotExistError); ok {
@@ -184,6 +288,160 @@ func (S3Repository) GetPathTar(args GetPathTarArgs, _ *int) error {
 	return err
 }
 
+func (SFTPRepository) Put(args PutArgs, _ *int) error {
+	st, err := withRetry(repository.NewSFTPRepository(args.Bucket, args.Root))
+	if err != nil {
+		return err
+	}
+	return st.Put(context.Background(), args.Path, args.Data)
+}
+
+func (SFTPRepository) List(args ListArgs, ret *ListReturn) error {
+	st, err := withRetry(repository.NewSFTPRepository(args.Bucket, args.Root))
+	if err != nil {
+		return err
+	}
+	ret.Paths, err = st.List(context.Background(), args.Path)
+	return err
+}
+
+func (SFTPRepository) PutPath(args PutPathArgs, _ *int) error {
+	st, err := withRetry(repository.NewSFTPRepository(args.Bucket, args.Root))
+	if err != nil {
+		return err
+	}
+	return st.PutPath(context.Background(), args.Src, args.Dest)
+}
+
+func (SFTPRepository) PutPathTar(args PutPathTarArgs, _ *int) error {
+	st, err := withRetry(repository.NewSFTPRepository(args.Bucket, args.Root))
+	if err != nil {
+		return err
+	}
+	return st.PutPathTar(context.Background(), args.LocalPath, args.TarPath, args.IncludePath)
+}
+
+func (SFTPRepository) Delete(args DeleteArgs, _ *int) error {
+	st, err := withRetry(repository.NewSFTPRepository(args.Bucket, args.Root))
+	if err != nil {
+		return err
+	}
+	return st.Delete(context.Background(), args.Path)
+}
+
+func (SFTPRepository) GetPathTar(args GetPathTarArgs, _ *int) error {
+	st, err := withRetry(repository.NewSFTPRepository(args.Bucket, args.Root))
+	if err != nil {
+		return err
+	}
+	err = st.GetPathTar(context.Background(), args.TarPath, args.LocalPath)
+	// HACK: net/rpc/jsonrpc doesn't let us include error codes, so prefix with
+	// predictable error name
+	if _, ok := err.(*repository.DoesNotExistError); ok {
+		return fmt.Errorf("DoesNotExistError:: %w", err)
+	}
+	return err
+}
+
+type EncryptedRepository struct{}
+
+func (EncryptedRepository) Get(args GetArgs, ret *GetReturn) error {
+	st, err := newEncryptedRepository(args.EncryptionArgs)
+	if err != nil {
+		return err
+	}
+	ret.Data, err = st.Get(context.Background(), args.Path)
+	// HACK: net/rpc/jsonrpc doesn't let us include error codes, so prefix with
+	// predictable error name
+	if _, ok := err.(*repository.DoesNotExistError); ok {
+		return fmt.Errorf("DoesNotExistError:: %w", err)
+	}
+	return err
+}
+
+func (EncryptedRepository) Put(args PutArgs, _ *int) error {
+	st, err := newEncryptedRepository(args.EncryptionArgs)
+	if err != nil {
+		return err
+	}
+	return st.Put(context.Background(), args.Path, args.Data)
+}
+
+func (EncryptedRepository) List(args ListArgs, ret *ListReturn) error {
+	st, err := newEncryptedRepository(args.EncryptionArgs)
+	if err != nil {
+		return err
+	}
+	ret.Paths, err = st.List(context.Background(), args.Path)
+	return err
+}
+
+func (EncryptedRepository) PutPath(args PutPathArgs, _ *int) error {
+	st, err := newEncryptedRepository(args.EncryptionArgs)
+	if err != nil {
+		return err
+	}
+	return st.PutPath(context.Background(), args.Src, args.Dest)
+}
+
+func (EncryptedRepository) PutPathTar(args PutPathTarArgs, _ *int) error {
+	st, err := newEncryptedRepository(args.EncryptionArgs)
+	if err != nil {
+		return err
+	}
+	return st.PutPathTar(context.Background(), args.LocalPath, args.TarPath, args.IncludePath)
+}
+
+func (EncryptedRepository) Delete(args DeleteArgs, _ *int) error {
+	st, err := newEncryptedRepository(args.EncryptionArgs)
+	if err != nil {
+		return err
+	}
+	return st.Delete(context.Background(), args.Path)
+}
+
+func (EncryptedRepository) GetPathTar(args GetPathTarArgs, _ *int) error {
+	st, err := newEncryptedRepository(args.EncryptionArgs)
+	if err != nil {
+		return err
+	}
+	err = st.GetPathTar(context.Background(), args.TarPath, args.LocalPath)
+	// HACK: net/rpc/jsonrpc doesn't let us include error codes, so prefix with
+	// predictable error name
+	if _, ok := err.(*repository.DoesNotExistError); ok {
+		return fmt.Errorf("DoesNotExistError:: %w", err)
+	}
+	return err
+}
+
+func (EncryptedRepository) GetWithVersion(args GetArgs, ret *GetWithVersionReturn) error {
+	st, err := newEncryptedRepository(args.EncryptionArgs)
+	if err != nil {
+		return err
+	}
+	return getWithVersion(st, args.Path, ret)
+}
+
+func (EncryptedRepository) PutIfMatch(args PutIfMatchArgs, _ *int) error {
+	st, err := newEncryptedRepository(args.EncryptionArgs)
+	if err != nil {
+		return err
+	}
+	return putIfMatch(st, args)
+}
+
+func newEncryptedRepository(args EncryptionArgs) (*repository.EncryptedRepository, error) {
+	repo, err := repository.ForURL(args.URL)
+	if err != nil {
+		return nil, err
+	}
+	key, err := repository.LoadEncryptionKey(args.EncryptionOptions)
+	if err != nil {
+		return nil, err
+	}
+	return repository.NewEncryptedRepository(repository.WithManifest(repo), key)
+}
+
 type DiskRepository struct{}
 
 func (DiskRepository) PutPath(args PutPathArgs, _ *int) error {
@@ -191,7 +449,7 @@ func (DiskRepository) PutPath(args PutPathArgs, _ *int) error {
 	if err != nil {
 		return err
 	}
-	return st.PutPath(args.Src, args.Dest)
+	return st.PutPath(context.Background(), args.Src, args.Dest)
 }
 
 func (DiskRepository) PutPathTar(args PutPathTarArgs, _ *int) error {
@@ -199,7 +457,7 @@ func (DiskRepository) PutPathTar(args PutPathTarArgs, _ *int) error {
 	if err != nil {
 		return err
 	}
-	return st.PutPathTar(args.LocalPath, args.TarPath, args.IncludePath)
+	return st.PutPathTar(context.Background(), args.LocalPath, args.TarPath, args.IncludePath)
 }
 
 func (DiskRepository) Delete(args DeleteArgs, _ *int) error {
@@ -207,7 +465,7 @@ func (DiskRepository) Delete(args DeleteArgs, _ *int) error {
 	if err != nil {
 		return err
 	}
-	return st.Delete(args.Path)
+	return st.Delete(context.Background(), args.Path)
 }
 
 func (DiskRepository) GetPathTar(args GetPathTarArgs, _ *int) error {
@@ -215,7 +473,7 @@ func (DiskRepository) GetPathTar(args GetPathTarArgs, _ *int) error {
 	if err != nil {
 		return err
 	}
-	err = st.GetPathTar(args.TarPath, args.LocalPath)
+	err = st.GetPathTar(context.Background(), args.TarPath, args.LocalPath)
 	// HACK: net/rpc/jsonrpc doesn't let us include error codes, so prefix with
 	// predictable error name
 	if _, ok := err.(*repository.DoesNotExistError); ok {
@@ -223,3 +481,48 @@ func (DiskRepository) GetPathTar(args GetPathTarArgs, _ *int) error {
 	}
 	return err
 }
+
+func (DiskRepository) GetWithVersion(args GetArgs, ret *GetWithVersionReturn) error {
+	st, err := repository.NewDiskRepository(args.Root)
+	if err != nil {
+		return err
+	}
+	return getWithVersion(st, args.Path, ret)
+}
+
+func (DiskRepository) PutIfMatch(args PutIfMatchArgs, _ *int) error {
+	st, err := repository.NewDiskRepository(args.Root)
+	if err != nil {
+		return err
+	}
+	return putIfMatch(st, args)
+}
+
+func getWithVersion(repo repository.Repository, p string, ret *GetWithVersionReturn) error {
+	if !repository.SupportsConditionalWrites(repo) {
+		return fmt.Errorf("The repository %s does not support conditional writes", repo.RootURL())
+	}
+	var err error
+	ret.Data, ret.Version, err = repo.(repository.ConditionalRepository).GetWithVersion(context.Background(), p)
+	return conditionalError(err)
+}
+
+func putIfMatch(repo repository.Repository, args PutIfMatchArgs) error {
+	if !repository.SupportsConditionalWrites(repo) {
+		return fmt.Errorf("The repository %s does not support conditional writes", repo.RootURL())
+	}
+	err := repo.(repository.ConditionalRepository).PutIfMatch(context.Background(), args.Path, args.Data, args.Version)
+	return conditionalError(err)
+}
+
+// conditionalError prefixes errors from conditional operations with a predictable error
+// name, because net/rpc/jsonrpc doesn't let us include error codes
+func conditionalError(err error) error {
+	switch err.(type) {
+	case *repository.DoesNotExistError:
+		return fmt.Errorf("DoesNotExistError:: %w", err)
+	case *repository.VersionMismatchError:
+		return fmt.Errorf("VersionMismatchError:: %w", err)
+	}
+	return err
+}
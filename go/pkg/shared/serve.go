@@ -29,6 +29,12 @@ func Serve() {
 	if err := s.Register(GCSRepository{}); err != nil {
 		panic(err)
 	}
+	if err := s.Register(SFTPRepository{}); err != nil {
+		panic(err)
+	}
+	if err := s.Register(EncryptedRepository{}); err != nil {
+		panic(err)
+	}
 	if err := s.Register(DiskRepository{}); err != nil {
 		panic(err)
 	}
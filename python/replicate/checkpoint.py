@@ -21,7 +21,8 @@ from . import console
 from .exceptions import DoesNotExistError
 from .json import CustomJSONEncoder
 from .hash import random_hash
-from .metadata import rfc3339_datetime, parse_rfc3339
+from .constants import SCHEMA_VERSION
+from .metadata import rfc3339_datetime, parse_rfc3339, pop_schema_version
 from .validate import check_path
 
 if TYPE_CHECKING:
@@ -45,6 +46,8 @@ class Checkpoint(object):
     step: Optional[int] = None
     metrics: Optional[Dict[str, Any]] = None
     primary_metric: Optional[PrimaryMetric] = None
+    # True if it was rebuilt by `replicate repair` because its metadata was lost
+    recovered: bool = False
 
     def __post_init__(self):
         self._experiment: Optional["Experiment"] = None
@@ -55,11 +58,13 @@ class Checkpoint(object):
     @classmethod
     def from_json(self, data: Dict[str, Any]) -> "Checkpoint":
         data = data.copy()
+        pop_schema_version(data, "checkpoint")
         data["created"] = parse_rfc3339(data["created"])
         return Checkpoint(**data)
 
     def to_json(self) -> Dict[str, Any]:
-        return {
+        data = {
+            "schema_version": SCHEMA_VERSION,
             "id": self.id,
             "created": rfc3339_datetime(self.created),
             "path": self.path,
@@ -67,6 +72,9 @@ class Checkpoint(object):
             "primary_metric": self.primary_metric,
             "step": self.step,
         }
+        if self.recovered:
+            data["recovered"] = True
+        return data
 
     def validate(self) -> List[str]:
         errors = []
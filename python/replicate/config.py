@@ -1,5 +1,6 @@
 import os
 from typing import List, Dict, Any
+from urllib.parse import urlparse, parse_qsl, urlencode
 
 from ._vendor import yaml
 
@@ -39,8 +40,14 @@ def load_config(project_dir: str) -> Dict[str, Any]:
 # This should be rigorously validated, see https://github.com/replicate/replicate/issues/330
 VALID_KEYS = [
     "repository",
+    "s3",
+    "encryption",
+    "retention",
     "storage",  # deprecated
 ]
+VALID_S3_KEYS = ["endpoint", "region", "force_path_style"]
+VALID_ENCRYPTION_KEYS = ["key_env", "key_secret"]
+VALID_RETENTION_KEYS = ["keep_best", "keep_latest", "keep_every"]
 REQUIRED_KEYS: List[str] = ["repository"]
 
 
@@ -87,8 +94,100 @@ def validate_and_set_defaults(data: Dict[str, Any], project_dir: str) -> Dict[st
                 )
             )
 
+    if data.get("s3"):
+        data["repository"] = add_s3_options_to_url(data["repository"], data["s3"])
+
+    if "encryption" in data:
+        validate_encryption(data["encryption"])
+
+    if "retention" in data:
+        validate_retention(data["retention"])
+
     return data
 
 
+def validate_encryption(encryption: Any):
+    """
+    Keep in sync with Parse() in load.go
+    """
+    if not isinstance(encryption, dict):
+        raise ConfigValidationError(
+            "The option 'encryption' in replicate.yaml needs to be a mapping."
+        )
+    for key in encryption:
+        if key not in VALID_ENCRYPTION_KEYS:
+            raise ConfigValidationError(
+                "The option 'encryption.{}' is in replicate.yaml, but it is not supported.".format(
+                    key
+                )
+            )
+    if bool(encryption.get("key_env")) == bool(encryption.get("key_secret")):
+        raise ConfigValidationError(
+            "The 'encryption' option in replicate.yaml must have exactly one of 'key_env' or 'key_secret'"
+        )
+
+
+def validate_retention(retention: Any):
+    """
+    Keep in sync with Parse() in load.go
+    """
+    if not isinstance(retention, dict):
+        raise ConfigValidationError(
+            "The option 'retention' in replicate.yaml needs to be a mapping."
+        )
+    for key, value in retention.items():
+        if key not in VALID_RETENTION_KEYS:
+            raise ConfigValidationError(
+                "The option 'retention.{}' is in replicate.yaml, but it is not supported.".format(
+                    key
+                )
+            )
+        if not isinstance(value, int) or isinstance(value, bool) or value < 0:
+            raise ConfigValidationError(
+                "The option 'retention.{}' in replicate.yaml needs to be a non-negative integer.".format(
+                    key
+                )
+            )
+    if not any(retention.get(key) for key in VALID_RETENTION_KEYS):
+        raise ConfigValidationError(
+            "The 'retention' option in replicate.yaml must have at least one of 'keep_best', 'keep_latest', or 'keep_every'"
+        )
+
+
+def add_s3_options_to_url(repository_url: str, s3_options: Any) -> str:
+    """
+    Adds the options in the s3 section of replicate.yaml to the query string
+    of the repository URL. Options already in the URL take precedence.
+
+    Keep in sync with addS3OptionsToURL() in load.go
+    """
+    if not isinstance(s3_options, dict):
+        raise ConfigValidationError(
+            "The option 's3' in replicate.yaml needs to be a mapping."
+        )
+    for key in s3_options:
+        if key not in VALID_S3_KEYS:
+            raise ConfigValidationError(
+                "The option 's3.{}' is in replicate.yaml, but it is not supported.".format(
+                    key
+                )
+            )
+
+    parsed_url = urlparse(repository_url)
+    if parsed_url.scheme != "s3":
+        raise ConfigValidationError(
+            "The 's3' option in replicate.yaml can only be used with S3 repositories (s3://...)"
+        )
+    query = dict(parse_qsl(parsed_url.query))
+    for key in VALID_S3_KEYS:
+        value = s3_options.get(key)
+        if value is None or value == "" or query.get(key):
+            continue
+        if isinstance(value, bool):
+            value = "true" if value else "false"
+        query[key] = str(value)
+    return parsed_url._replace(query=urlencode(sorted(query.items()))).geturl()
+
+
 def get_default_config() -> Dict[str, Any]:
     return {}
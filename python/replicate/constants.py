@@ -3,3 +3,6 @@ DOCS_URL = WEB_URL + "/docs"
 PYTHON_REFERENCE_DOCS_URL = DOCS_URL + "/reference/python"
 YAML_REFERENCE_DOCS_URL = DOCS_URL + "/reference/yaml"
 REPOSITORY_VERSION = 1
+# Version of the format of experiment, checkpoint and heartbeat metadata.
+# Corresponds to SchemaVersion in go/pkg/project/schema.go
+SCHEMA_VERSION = 1
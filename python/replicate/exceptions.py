@@ -5,6 +5,14 @@ class DoesNotExistError(Exception):
     pass
 
 
+class VersionMismatchError(Exception):
+    """
+    The file has been changed since it was read, so it wasn't written.
+    """
+
+    pass
+
+
 class UnknownRepositoryScheme(Exception):
     def __init__(self, scheme):
         if scheme == "":
@@ -15,7 +23,7 @@ class UnknownRepositoryScheme(Exception):
             message
             + """.
 
-Make sure your repository URL starts with either 'file://', 's3://', or 'gs://'.
+Make sure your repository URL starts with either 'file://', 's3://', 'gs://', or 'ssh://'.
 See the documentation for more details: {}""".format(
                 constants.YAML_REFERENCE_DOCS_URL
             )
@@ -46,6 +54,18 @@ pip install --upgrade replicate
         super().__init__(message)
 
 
+class NewerSchemaVersion(Exception):
+    def __init__(self, kind, version):
+        message = """This {} was saved with schema version {} by a newer version of Replicate, but your version of Replicate only supports up to version {}.
+
+To upgrade, run:
+pip install --upgrade replicate
+""".format(
+            kind, version, constants.SCHEMA_VERSION
+        )
+        super().__init__(message)
+
+
 class CorruptedProjectSpec(Exception):
     def __init__(self, path):
         message = """The project spec file at {} is corrupted.
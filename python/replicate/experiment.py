@@ -3,6 +3,7 @@ try:
     from dataclasses import dataclass, InitVar, field
 except ImportError:
     from ._vendor.dataclasses import dataclass, InitVar, field
+import atexit
 import getpass
 import os
 import html
@@ -10,6 +11,7 @@ import datetime
 import json
 import shlex
 import sys
+import traceback
 from typing import (
     Dict,
     Any,
@@ -22,24 +24,66 @@ from typing import (
 )
 
 from . import console
-from .exceptions import DoesNotExistError, NewerRepositoryVersion
+from .exceptions import (
+    DoesNotExistError,
+    NewerRepositoryVersion,
+    VersionMismatchError,
+)
 from .checkpoint import (
     Checkpoint,
     PrimaryMetric,
     CheckpointList,
 )
 from .hash import random_hash
-from .heartbeat import Heartbeat
+from .heartbeat import Heartbeat, FINISHED, CRASHED, write_heartbeat
 from .json import CustomJSONEncoder
-from .metadata import rfc3339_datetime, parse_rfc3339
+from .metadata import rfc3339_datetime, parse_rfc3339, pop_schema_version
 from .packages import get_imported_packages
 from .validate import check_path
 from .version import version
-from .constants import REPOSITORY_VERSION
+from .constants import REPOSITORY_VERSION, SCHEMA_VERSION
 
 if TYPE_CHECKING:
     from .project import Project
 
+# Number of times save() reads and writes the experiment if something else
+# keeps changing it
+MAX_SAVE_ATTEMPTS = 10
+
+# Checkpoints are saved in metadata/checkpoints/<experiment ID>/<checkpoint ID>.json.
+# Older versions saved them in the experiment's metadata, so that is read too.
+CHECKPOINTS_METADATA_DIR = "metadata/checkpoints"
+
+# Experiments with a running heartbeat, by ID. They are marked as crashed if the
+# script exits because of an exception, or as finished if it exits without stop()
+# being called.
+_running_experiments: Dict[str, "Experiment"] = {}
+_exit_hooks_installed = False
+
+
+def _install_exit_hooks():
+    global _exit_hooks_installed
+    if _exit_hooks_installed:
+        return
+    _exit_hooks_installed = True
+
+    previous_excepthook = sys.excepthook
+
+    def excepthook(exc_type, exc_value, exc_traceback):
+        exit_reason = "".join(
+            traceback.format_exception_only(exc_type, exc_value)
+        ).strip()
+        for experiment in list(_running_experiments.values()):
+            experiment._stop_at_exit(CRASHED, exit_reason)
+        previous_excepthook(exc_type, exc_value, exc_traceback)
+
+    def on_exit():
+        for experiment in list(_running_experiments.values()):
+            experiment._stop_at_exit(FINISHED)
+
+    sys.excepthook = excepthook
+    atexit.register(on_exit)
+
 
 @dataclass
 class Experiment:
@@ -60,6 +104,8 @@ class Experiment:
     python_packages: Optional[Dict[str, str]] = None
     replicate_version: Optional[str] = None
     checkpoints: CheckpointList = field(default_factory=CheckpointList)
+    # True if it was rebuilt by `replicate repair` because its metadata was lost
+    recovered: bool = False
 
     def __post_init__(self, project: "Project"):
         self._project = project
@@ -153,8 +199,14 @@ class Experiment:
             repository = self._project._get_repository()
             repository.put_path_tar(self._project.directory, tar_path, checkpoint.path)
 
+        # Each checkpoint is saved in its own file, so saving a checkpoint doesn't
+        # rewrite every checkpoint before it
+        repository = self._project._get_repository()
+        repository.put(
+            self._checkpoint_metadata_path(checkpoint.id),
+            json.dumps(checkpoint.to_json(), indent=2, cls=CustomJSONEncoder),
+        )
         self.checkpoints.append(checkpoint)
-        self.save()
 
         if self._heartbeat is not None:
             self._heartbeat.ensure_running()
@@ -164,16 +216,93 @@ class Experiment:
     def save(self):
         """
         Save this experiment's metadata to repository.
+
+        If the repository supports conditional writes and something else has
+        saved this experiment in the meantime (e.g. the CLI), checkpoints it
+        added are kept, rather than overwritten. Every other field is
+        overwritten with this experiment's, even if something else changed it.
         """
         repository = self._project._get_repository()
-        repository.put(
-            self._metadata_path(),
-            json.dumps(self.to_json(), indent=2, cls=CustomJSONEncoder),
+        if not repository.supports_conditional_writes():
+            repository.put(
+                self._metadata_path(),
+                json.dumps(self.to_json(), indent=2, cls=CustomJSONEncoder),
+            )
+            return
+
+        for _ in range(MAX_SAVE_ATTEMPTS):
+            try:
+                data, version = repository.get_with_version(self._metadata_path())
+            except DoesNotExistError:
+                version = ""
+            else:
+                try:
+                    saved = json.loads(data)
+                    # Raises if it was saved by a newer version of Replicate,
+                    # because overwriting it would lose what that version saved
+                    pop_schema_version(saved, "experiment")
+                    self._merge_checkpoints(saved.get("checkpoints") or [])
+                except ValueError as e:
+                    console.warn(
+                        "Failed to parse {}, overwriting it: {}".format(
+                            self._metadata_path(), e
+                        )
+                    )
+            try:
+                repository.put_if_match(
+                    self._metadata_path(),
+                    json.dumps(self.to_json(), indent=2, cls=CustomJSONEncoder),
+                    version,
+                )
+                return
+            except VersionMismatchError:
+                continue
+        raise VersionMismatchError(
+            "Failed to save {}, because it was changed by something else {} times in a row".format(
+                self._metadata_path(), MAX_SAVE_ATTEMPTS
+            )
+        )
+
+    def _merge_checkpoints(self, checkpoints: List[Dict[str, Any]]):
+        """
+        Add saved checkpoints that this experiment doesn't have.
+        """
+        ids = set(chk.id for chk in self.checkpoints)
+        added = False
+        for data in checkpoints:
+            if data["id"] not in ids:
+                chk = Checkpoint.from_json(data)
+                chk._experiment = self
+                self.checkpoints.append(chk)
+                added = True
+        if added:
+            self.checkpoints.sort(key=lambda chk: chk.created)
+
+    @classmethod
+    def load(cls, project: "Project", path: str) -> "Experiment":
+        """
+        Load the experiment with metadata at path, including checkpoints
+        saved in their own files.
+        """
+        repository = project._get_repository()
+        data = json.loads(repository.get(path))
+        checkpoints = {chk["id"]: chk for chk in data.get("checkpoints") or []}
+        for chk_path in repository.list(
+            "{}/{}/".format(CHECKPOINTS_METADATA_DIR, data["id"])
+        ):
+            if not chk_path.endswith(".json"):
+                continue
+            chk = json.loads(repository.get(chk_path))
+            checkpoints.setdefault(chk["id"], chk)
+        data["checkpoints"] = sorted(
+            checkpoints.values(), key=lambda chk: parse_rfc3339(chk["created"])
         )
+        return cls.from_json(project, data)
 
     @classmethod
     def from_json(cls, project: "Project", data: Dict[str, Any]) -> "Experiment":
         data = data.copy()
+        pop_schema_version(data, "experiment")
         data["created"] = parse_rfc3339(data["created"])
         data["checkpoints"] = CheckpointList(
             [Checkpoint.from_json(d) for d in data.get("checkpoints", [])]
@@ -184,7 +313,8 @@ class Experiment:
         return experiment
 
     def to_json(self) -> Dict[str, Any]:
-        return {
+        data = {
+            "schema_version": SCHEMA_VERSION,
             "id": self.id,
             "created": rfc3339_datetime(self.created),
             "params": self.params,
@@ -197,26 +327,53 @@ class Experiment:
             "checkpoints": [c.to_json() for c in self.checkpoints],
             "replicate_version": version,
         }
+        if self.recovered:
+            data["recovered"] = True
+        return data
 
     def start_heartbeat(self):
+        config = self._project._get_config()
         self._heartbeat = Heartbeat(
             experiment_id=self.id,
-            repository_url=self._project._get_config()["repository"],
+            repository_url=config["repository"],
             path=self._heartbeat_path(),
+            encryption=config.get("encryption"),
         )
         self._heartbeat.start()
+        _running_experiments[self.id] = self
+        _install_exit_hooks()
 
     def stop(self):
         """
         Stop an experiment.
 
-        Experiments running in a script will eventually timeout, but when running in a notebook,
-        you are required to call this method to mark an experiment as stopped.
+        Experiments running in a script are marked as finished when the script exits, or as
+        crashed if it raises an exception, but when running in a notebook, you are required
+        to call this method to mark an experiment as finished.
         """
+        self._stop(FINISHED)
+
+    def _stop(self, state: str, exit_reason: Optional[str] = None):
+        _running_experiments.pop(self.id, None)
+        repository = self._project._get_repository()
         if self._heartbeat is not None:
-            self._heartbeat.kill()
+            self._heartbeat.stop(repository, state, exit_reason)
             self._heartbeat = None
-        self._project._get_repository().delete(self._heartbeat_path())
+        else:
+            write_heartbeat(
+                repository, self._heartbeat_path(), self.id, state, exit_reason
+            )
+
+    def _stop_at_exit(self, state: str, exit_reason: Optional[str] = None):
+        # Don't hide whatever the script was exiting with
+        try:
+            self._stop(state, exit_reason)
+        except Exception as e:  # pylint: disable=broad-except
+            sys.stderr.write(
+                "Failed to record that experiment {} {}: {}\n".format(
+                    self.short_id(), state, e
+                )
+            )
 
     def delete(self):
         """
@@ -235,6 +392,7 @@ class Experiment:
         console.info("Deleting experiment: {}".format(self.short_id()))
         repository.delete(self._heartbeat_path())
         repository.delete(self._repository_tar_path())
+        repository.delete(self._checkpoints_metadata_dir())
         repository.delete(self._metadata_path())
 
     def latest(self) -> Optional[Checkpoint]:
@@ -294,6 +452,12 @@ class Experiment:
     def _metadata_path(self) -> str:
         return "metadata/experiments/{}.json".format(self.id)
 
+    def _checkpoints_metadata_dir(self) -> str:
+        return "{}/{}".format(CHECKPOINTS_METADATA_DIR, self.id)
+
+    def _checkpoint_metadata_path(self, checkpoint_id: str) -> str:
+        return "{}/{}.json".format(self._checkpoints_metadata_dir(), checkpoint_id)
+
     def primary_metric(self) -> str:
         """
         Get the shared primary metric for the list of checkpoints in
@@ -491,10 +655,9 @@ class ExperimentCollection:
                 )
             )
 
-        data = json.loads(
-            repository.get("metadata/experiments/{}.json".format(matching_ids[0]))
+        return Experiment.load(
+            self.project, "metadata/experiments/{}.json".format(matching_ids[0])
         )
-        return Experiment.from_json(self.project, data)
 
     def list(self, filter: Optional[Callable[[Any], bool]] = None) -> List[Experiment]:
         """
@@ -503,8 +666,7 @@ class ExperimentCollection:
         repository = self.project._get_repository()
         result: ExperimentList = ExperimentList()
         for path in repository.list("metadata/experiments/"):
-            data = json.loads(repository.get(path))
-            exp = Experiment.from_json(self.project, data)
+            exp = Experiment.load(self.project, path)
             if filter is not None:
                 include = False
                 try:
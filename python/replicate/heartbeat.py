@@ -3,13 +3,20 @@ import datetime
 import json
 import time
 from multiprocessing import Process
+from typing import Dict, Optional
 
+from .constants import SCHEMA_VERSION
 from .repository import repository_for_url, Repository
 from .metadata import rfc3339_datetime
 
 
 DEFAULT_REFRESH_INTERVAL = datetime.timedelta(seconds=10)
 
+# How an experiment ended, recorded in its last heartbeat.
+# Corresponds to StatusFinished and StatusCrashed in heartbeat.go
+FINISHED = "finished"
+CRASHED = "crashed"
+
 
 class Heartbeat:
     def __init__(
@@ -18,9 +25,11 @@ class Heartbeat:
         repository_url: str,
         path: str,
         refresh_interval: datetime.timedelta = DEFAULT_REFRESH_INTERVAL,
+        encryption: Optional[Dict[str, str]] = None,
     ):
         self.experiment_id = experiment_id
         self.repository_url = repository_url
+        self.encryption = encryption
         self.path = path
         self.refresh_interval = refresh_interval
         self.process = self.make_process()
@@ -36,6 +45,18 @@ class Heartbeat:
     def kill(self):
         self.process.terminate()
 
+    def stop(
+        self, repository: Repository, state: str, exit_reason: Optional[str] = None
+    ):
+        """
+        Stop sending heartbeats, and write a last one that records how the
+        experiment ended, so it isn't mistaken for one that was killed.
+        """
+        self.kill()
+        # Wait for it to exit, so it can't overwrite the last heartbeat
+        self.process.join()
+        self.refresh(repository, state=state, exit_reason=exit_reason)
+
     def is_alive(self):
         return self.process.is_alive()
 
@@ -48,19 +69,41 @@ class Heartbeat:
         # need to instantitate repository here since the gcs
         # client doesn't like multiprocessing:
         # https://github.com/googleapis/google-cloud-python/issues/3501
-        repository = repository_for_url(self.repository_url)
+        repository = repository_for_url(self.repository_url, self.encryption)
         while True:
             self.refresh(repository)
             time.sleep(self.refresh_interval.total_seconds())
 
-    def refresh(self, repository: Repository):
-        obj = json.dumps(
-            {
-                "experiment_id": self.experiment_id,
-                "last_heartbeat": rfc3339_datetime(datetime.datetime.utcnow()),
-            }
-        )
-        try:
-            repository.put(self.path, obj)
-        except Exception as e:  # pylint: disable=broad-except
-            sys.stderr.write("Failed to save heartbeat: {}".format(e))
+    def refresh(
+        self,
+        repository: Repository,
+        state: Optional[str] = None,
+        exit_reason: Optional[str] = None,
+    ):
+        write_heartbeat(repository, self.path, self.experiment_id, state, exit_reason)
+
+
+def write_heartbeat(
+    repository: Repository,
+    path: str,
+    experiment_id: str,
+    state: Optional[str] = None,
+    exit_reason: Optional[str] = None,
+):
+    """
+    Write an experiment's heartbeat. state is set in the last heartbeat an
+    experiment writes, to FINISHED or CRASHED.
+    """
+    data = {
+        "schema_version": SCHEMA_VERSION,
+        "experiment_id": experiment_id,
+        "last_heartbeat": rfc3339_datetime(datetime.datetime.utcnow()),
+    }
+    if state is not None:
+        data["state"] = state
+    if exit_reason is not None:
+        data["exit_reason"] = exit_reason
+    try:
+        repository.put(path, json.dumps(data))
+    except Exception as e:  # pylint: disable=broad-except
+        sys.stderr.write("Failed to save heartbeat: {}".format(e))
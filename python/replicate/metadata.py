@@ -1,4 +1,8 @@
 import datetime
+from typing import Any, Dict
+
+from .constants import SCHEMA_VERSION
+from .exceptions import NewerSchemaVersion
 
 
 def rfc3339_datetime(dt: datetime.datetime) -> str:
@@ -23,3 +27,17 @@ def parse_rfc3339(s: str) -> datetime.datetime:
     Parse a string in the RFC3339 format we use.
     """
     return datetime.datetime.strptime(s, "%Y-%m-%dT%H:%M:%S.%fZ")
+
+
+def pop_schema_version(data: Dict[str, Any], kind: str) -> int:
+    """
+    Remove the schema version from a record, and return it. Records saved
+    before the schema version was recorded are version 0.
+
+    Raises NewerSchemaVersion if the record was saved by a newer version of
+    Replicate, because it might not mean what this version thinks it means.
+    """
+    version = data.pop("schema_version", 0)
+    if version > SCHEMA_VERSION:
+        raise NewerSchemaVersion(kind, version)
+    return version
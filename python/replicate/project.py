@@ -96,7 +96,9 @@ class Project:
                 config = self._get_config()
                 self._repository_url = config["repository"]
 
-            self._repository = repository_for_url(self._repository_url)
+            self._repository = repository_for_url(
+                self._repository_url, encryption=self._get_config().get("encryption")
+            )
 
         return self._repository  # type: ignore
 
@@ -1,14 +1,34 @@
-from urllib.parse import urlparse
+from typing import Any, Dict, Optional
+from urllib.parse import urlparse, parse_qs
 
 from .repository_base import Repository
 from .disk_repository import DiskRepository
+from .encrypted_repository import EncryptedRepository
 from .gcs_repository import GCSRepository
 from .s3_repository import S3Repository
+from .sftp_repository import SFTPRepository
 
 from ..exceptions import UnknownRepositoryScheme
 
 
-def repository_for_url(url: str) -> Repository:
+def repository_for_url(
+    url: str, encryption: Optional[Dict[str, str]] = None
+) -> Repository:
+    """
+    Returns the repository for url. If encryption is set to the encryption
+    section of replicate.yaml, data is encrypted and decrypted transparently.
+    """
+    repository = _repository_for_url(url)
+    if encryption:
+        return EncryptedRepository(
+            url,
+            key_env=encryption.get("key_env", ""),
+            key_secret=encryption.get("key_secret", ""),
+        )
+    return repository
+
+
+def _repository_for_url(url: str) -> Repository:
     parsed_url = urlparse(url)
 
     if parsed_url.scheme == "" or parsed_url.scheme is None:
@@ -20,9 +40,45 @@ def repository_for_url(url: str) -> Repository:
     elif parsed_url.scheme == "s3":
         # lazy import to speed up import replicate
 
-        return S3Repository(bucket=parsed_url.netloc, root=parsed_url.path.lstrip("/"))
+        return S3Repository(
+            bucket=parsed_url.netloc,
+            root=parsed_url.path.lstrip("/"),
+            **s3_options_from_query(parsed_url.query)
+        )
     elif parsed_url.scheme == "gs":
 
         return GCSRepository(bucket=parsed_url.netloc, root=parsed_url.path.lstrip("/"))
+    elif parsed_url.scheme == "ssh":
+        # ssh://host/~/foo is relative to the home directory, like scp
+        # Keep in sync with SplitURL() in repository.go
+        path = parsed_url.path
+        if path == "/~" or path.startswith("/~/"):
+            root = "~/" + path[len("/~") :].lstrip("/")
+        else:
+            root = path
+        return SFTPRepository(host=parsed_url.netloc, root=root)
     else:
         raise UnknownRepositoryScheme(parsed_url.scheme)
+
+
+def s3_options_from_query(query: str) -> Dict[str, Any]:
+    """
+    Parses S3 options from the query string of a repository URL, e.g.
+    s3://bucket/root?endpoint=http://localhost:9000
+
+    Path style addressing is used by default for custom endpoints.
+
+    Keep in sync with S3OptionsFromQuery() in s3.go
+    """
+    params = {key: values[0] for key, values in parse_qs(query).items()}
+    endpoint = params.get("endpoint", "")
+    force_path_style = params.get("force_path_style")
+    return {
+        "endpoint": endpoint,
+        "region": params.get("region", ""),
+        "force_path_style": (
+            force_path_style.lower() in ("1", "t", "true")
+            if force_path_style is not None
+            else endpoint != ""
+        ),
+    }
@@ -1,9 +1,9 @@
 import os
-from typing import AnyStr, List
+from typing import AnyStr, List, Tuple
 
 from .repository_base import Repository
 from .. import shared
-from ..exceptions import DoesNotExistError
+from ..exceptions import DoesNotExistError, VersionMismatchError
 
 
 class DiskRepository(Repository):
@@ -118,3 +118,43 @@ class DiskRepository(Repository):
             if e.type == "DoesNotExistError":
                 raise DoesNotExistError(e.message)
             raise
+
+    def supports_conditional_writes(self) -> bool:
+        return True
+
+    def get_with_version(self, path: str) -> Tuple[bytes, str]:
+        """
+        Get data at path, and its version to pass to put_if_match()
+        """
+        try:
+            result = shared.call(
+                "DiskRepository.GetWithVersion",
+                Root=self.root,
+                Path=str(path),  # typecast for pathlib
+            )
+        except shared.SharedError as e:
+            if e.type == "DoesNotExistError":
+                raise DoesNotExistError(e.message)
+            raise
+        return result["Data"], result["Version"]
+
+    def put_if_match(self, path: str, data: AnyStr, version: str):
+        """
+        Save data to file at path if its version is still `version`
+        """
+        if isinstance(data, str):
+            data_bytes = data.encode("utf-8")
+        else:
+            data_bytes = data
+        try:
+            shared.call(
+                "DiskRepository.PutIfMatch",
+                Root=self.root,
+                Path=str(path),
+                Data=data_bytes,
+                Version=version,
+            )
+        except shared.SharedError as e:
+            if e.type == "VersionMismatchError":
+                raise VersionMismatchError(e.message)
+            raise
@@ -0,0 +1,167 @@
+from typing import AnyStr, Dict, List, Tuple
+
+from .repository_base import Repository
+from .. import shared
+from ..exceptions import DoesNotExistError, VersionMismatchError
+
+
+class EncryptedRepository(Repository):
+    """
+    Encrypts data before it is written to the repository at url, and decrypts
+    it when it is read. Everything is done by the Go shared library, so data
+    is encrypted the same way as the CLI.
+
+    The key is read from the environment variable key_env, or from the secret
+    key_secret in the user's settings directory.
+    """
+
+    def __init__(self, url: str, key_env: str = "", key_secret: str = ""):
+        self.url = url
+        self.key_env = key_env
+        self.key_secret = key_secret
+
+    def _args(self) -> Dict[str, str]:
+        return {"URL": self.url, "KeyEnv": self.key_env, "KeySecret": self.key_secret}
+
+    def root_url(self):
+        """
+        Returns the URL this repository is pointing at
+        """
+        return self.url
+
+    def get(self, path: str) -> bytes:
+        """
+        Get data at path
+        """
+        try:
+            result = shared.call(
+                "EncryptedRepository.Get",
+                **self._args(),
+                Path=str(path),  # typecast for pathlib
+            )
+        except shared.SharedError as e:
+            if e.type == "DoesNotExistError":
+                raise DoesNotExistError(e.message)
+            raise
+        return result["Data"]
+
+    def put_path(self, source_path: str, dest_path: str):
+        """
+        Save file or directory to path
+        """
+        shared.call(
+            "EncryptedRepository.PutPath",
+            **self._args(),
+            Src=str(source_path),
+            Dest=str(dest_path),
+        )
+
+    def put_path_tar(self, local_path: str, tar_path: str, include_path: str):
+        """
+        Save file or directory to tarball
+        """
+        shared.call(
+            "EncryptedRepository.PutPathTar",
+            **self._args(),
+            LocalPath=str(local_path),
+            TarPath=str(tar_path),
+            IncludePath=str(include_path),
+        )
+
+    def put(self, path: str, data: AnyStr):
+        """
+        Save data to file at path
+        """
+        if isinstance(data, str):
+            data_bytes = data.encode("utf-8")
+        else:
+            data_bytes = data
+        shared.call(
+            "EncryptedRepository.Put",
+            **self._args(),
+            Path=str(path),
+            Data=data_bytes,
+        )
+
+    def list(self, path: str) -> List[str]:
+        """
+        Returns a list of files at path, but not any subdirectories.
+        """
+        result = shared.call(
+            "EncryptedRepository.List",
+            **self._args(),
+            Path=str(path),  # typecast for pathlib
+        )
+        return result["Paths"]
+
+    def delete(self, path: str):
+        """
+        Recursively delete path
+        """
+        shared.call(
+            "EncryptedRepository.Delete",
+            **self._args(),
+            Path=str(path),  # typecast for pathlib
+        )
+
+    def get_path_tar(self, tar_path: str, local_path: str):
+        """
+        Extracts tarball from tar_path to local_path.
+        The first component of the tarball is stripped. E.g.
+        extracting a tarball with `abc123/weights` in it to
+        `/code` would create `/code/weights`.
+        """
+        try:
+            shared.call(
+                "EncryptedRepository.GetPathTar",
+                **self._args(),
+                TarPath=str(tar_path),
+                LocalPath=str(local_path),
+            )
+        except shared.SharedError as e:
+            if e.type == "DoesNotExistError":
+                raise DoesNotExistError(e.message)
+            raise
+
+    def supports_conditional_writes(self) -> bool:
+        # Avoid circular import
+        from .common import _repository_for_url
+
+        return _repository_for_url(self.url).supports_conditional_writes()
+
+    def get_with_version(self, path: str) -> Tuple[bytes, str]:
+        """
+        Get data at path, and its version to pass to put_if_match()
+        """
+        try:
+            result = shared.call(
+                "EncryptedRepository.GetWithVersion",
+                **self._args(),
+                Path=str(path),  # typecast for pathlib
+            )
+        except shared.SharedError as e:
+            if e.type == "DoesNotExistError":
+                raise DoesNotExistError(e.message)
+            raise
+        return result["Data"], result["Version"]
+
+    def put_if_match(self, path: str, data: AnyStr, version: str):
+        """
+        Save data to file at path if its version is still `version`
+        """
+        if isinstance(data, str):
+            data_bytes = data.encode("utf-8")
+        else:
+            data_bytes = data
+        try:
+            shared.call(
+                "EncryptedRepository.PutIfMatch",
+                **self._args(),
+                Path=str(path),
+                Data=data_bytes,
+                Version=version,
+            )
+        except shared.SharedError as e:
+            if e.type == "VersionMismatchError":
+                raise VersionMismatchError(e.message)
+            raise
@@ -1,5 +1,5 @@
 from abc import ABCMeta, abstractmethod
-from typing import AnyStr, List
+from typing import AnyStr, List, Tuple
 
 
 class Repository:
@@ -61,3 +61,23 @@ class Repository:
         Delete single file at path
         """
         raise NotImplementedError()
+
+    def supports_conditional_writes(self) -> bool:
+        """
+        Returns True if get_with_version() and put_if_match() can be used
+        """
+        return False
+
+    def get_with_version(self, path: str) -> Tuple[bytes, str]:
+        """
+        Get data at path, and its version to pass to put_if_match()
+        """
+        raise NotImplementedError()
+
+    def put_if_match(self, path: str, data: AnyStr, version: str):
+        """
+        Save data to file at path if its version is still `version`, or if it
+        doesn't exist and `version` is empty. Otherwise, VersionMismatchError
+        is raised.
+        """
+        raise NotImplementedError()
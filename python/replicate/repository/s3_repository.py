@@ -1,8 +1,8 @@
-from typing import AnyStr, List
+from typing import AnyStr, List, Tuple
 
 from .repository_base import Repository
 from .. import shared
-from ..exceptions import DoesNotExistError
+from ..exceptions import DoesNotExistError, VersionMismatchError
 
 
 class S3Repository(Repository):
@@ -10,9 +10,19 @@ class S3Repository(Repository):
     Stores data on Amazon S3
     """
 
-    def __init__(self, bucket: str, root: str):
+    def __init__(
+        self,
+        bucket: str,
+        root: str,
+        endpoint: str = "",
+        region: str = "",
+        force_path_style: bool = False,
+    ):
         self.bucket_name = bucket
         self.root = root
+        self.endpoint = endpoint
+        self.region = region
+        self.force_path_style = force_path_style
 
     def root_url(self):
         """
@@ -32,6 +42,7 @@ class S3Repository(Repository):
                 "S3Repository.Get",
                 Bucket=self.bucket_name,
                 Root=self.root,
+                **self._options(),
                 Path=str(path),  # typecast for pathlib
             )
         except shared.SharedError as e:
@@ -48,6 +59,7 @@ class S3Repository(Repository):
             "S3Repository.PutPath",
             Bucket=self.bucket_name,
             Root=self.root,
+            **self._options(),
             Src=str(source_path),
             Dest=str(dest_path),
         )
@@ -60,6 +72,7 @@ class S3Repository(Repository):
             "S3Repository.PutPathTar",
             Bucket=self.bucket_name,
             Root=self.root,
+            **self._options(),
             LocalPath=str(local_path),
             TarPath=str(tar_path),
             IncludePath=str(include_path),
@@ -77,6 +90,7 @@ class S3Repository(Repository):
             "S3Repository.Put",
             Bucket=self.bucket_name,
             Root=self.root,
+            **self._options(),
             Path=str(path),
             Data=data_bytes,
         )
@@ -89,6 +103,7 @@ class S3Repository(Repository):
             "S3Repository.List",
             Bucket=self.bucket_name,
             Root=self.root,
+            **self._options(),
             Path=str(path),  # typecast for pathlib
         )
         return result["Paths"]
@@ -104,6 +119,7 @@ class S3Repository(Repository):
             "S3Repository.Delete",
             Bucket=self.bucket_name,
             Root=self.root,
+            **self._options(),
             Path=str(path),  # typecast for pathlib
         )
 
@@ -119,6 +135,7 @@ class S3Repository(Repository):
                 "S3Repository.GetPathTar",
                 Bucket=self.bucket_name,
                 Root=self.root,
+                **self._options(),
                 TarPath=str(tar_path),
                 LocalPath=str(local_path),
             )
@@ -126,3 +143,58 @@ class S3Repository(Repository):
             if e.type == "DoesNotExistError":
                 raise DoesNotExistError(e.message)
             raise
+
+    def _options(self):
+        """
+        Options for connecting to S3-compatible services, passed
+        to every call
+        """
+        return {
+            "Endpoint": self.endpoint,
+            "Region": self.region,
+            "ForcePathStyle": self.force_path_style,
+        }
+
+    def supports_conditional_writes(self) -> bool:
+        return True
+
+    def get_with_version(self, path: str) -> Tuple[bytes, str]:
+        """
+        Get data at path, and its version to pass to put_if_match()
+        """
+        try:
+            result = shared.call(
+                "S3Repository.GetWithVersion",
+                Bucket=self.bucket_name,
+                Root=self.root,
+                **self._options(),
+                Path=str(path),  # typecast for pathlib
+            )
+        except shared.SharedError as e:
+            if e.type == "DoesNotExistError":
+                raise DoesNotExistError(e.message)
+            raise
+        return result["Data"], result["Version"]
+
+    def put_if_match(self, path: str, data: AnyStr, version: str):
+        """
+        Save data to file at path if its version is still `version`
+        """
+        if isinstance(data, str):
+            data_bytes = data.encode("utf-8")
+        else:
+            data_bytes = data
+        try:
+            shared.call(
+                "S3Repository.PutIfMatch",
+                Bucket=self.bucket_name,
+                Root=self.root,
+                **self._options(),
+                Path=str(path),
+                Data=data_bytes,
+                Version=version,
+            )
+        except shared.SharedError as e:
+            if e.type == "VersionMismatchError":
+                raise VersionMismatchError(e.message)
+            raise
@@ -0,0 +1,127 @@
+from typing import AnyStr, List
+
+from .repository_base import Repository
+from .. import shared
+from ..exceptions import DoesNotExistError
+
+
+class SFTPRepository(Repository):
+    def __init__(self, host: str, root: str):
+        """
+        host is in the form [user@]host[:port]. root is an absolute path, or a path
+        relative to the home directory if it starts with ~/
+        """
+        self.host = host
+        self.root = root
+
+    def root_url(self):
+        """
+        Returns the URL this repository is pointing at
+        """
+        if self.root.startswith("/"):
+            return "ssh://" + self.host + self.root
+        root = self.root[len("~/") :] if self.root.startswith("~/") else self.root
+        if not root:
+            return "ssh://" + self.host + "/~"
+        return "ssh://" + self.host + "/~/" + root
+
+    def get(self, path: str) -> bytes:
+        """
+        Get data at path
+        """
+        try:
+            result = shared.call(
+                "SFTPRepository.Get",
+                Bucket=self.host,
+                Root=self.root,
+                Path=str(path),  # typecast for pathlib
+            )
+        except shared.SharedError as e:
+            if e.type == "DoesNotExistError":
+                raise DoesNotExistError(e.message)
+            raise
+        return result["Data"]
+
+    def put_path(self, source_path: str, dest_path: str):
+        """
+        Save file or directory to path
+        """
+        shared.call(
+            "SFTPRepository.PutPath",
+            Bucket=self.host,
+            Root=self.root,
+            Src=str(source_path),
+            Dest=str(dest_path),
+        )
+
+    def put_path_tar(self, local_path: str, tar_path: str, include_path: str):
+        """
+        Save file or directory to tarball
+        """
+        shared.call(
+            "SFTPRepository.PutPathTar",
+            Bucket=self.host,
+            Root=self.root,
+            LocalPath=str(local_path),
+            TarPath=str(tar_path),
+            IncludePath=str(include_path),
+        )
+
+    def put(self, path: str, data: AnyStr):
+        """
+        Save data to file at path
+        """
+        if isinstance(data, str):
+            data_bytes = data.encode("utf-8")
+        else:
+            data_bytes = data
+        shared.call(
+            "SFTPRepository.Put",
+            Bucket=self.host,
+            Root=self.root,
+            Path=str(path),
+            Data=data_bytes,
+        )
+
+    def list(self, path: str) -> List[str]:
+        """
+        Returns a list of files at path, but not any subdirectories.
+        """
+        result = shared.call(
+            "SFTPRepository.List",
+            Bucket=self.host,
+            Root=self.root,
+            Path=str(path),  # typecast for pathlib
+        )
+        return result["Paths"]
+
+    def delete(self, path: str):
+        """
+        Recursively delete path
+        """
+        shared.call(
+            "SFTPRepository.Delete",
+            Bucket=self.host,
+            Root=self.root,
+            Path=str(path),  # typecast for pathlib
+        )
+
+    def get_path_tar(self, tar_path: str, local_path: str):
+        """
+        Extracts tarball from tar_path to local_path.
+        The first component of the tarball is stripped. E.g.
+        extracting a tarball with `abc123/weights` in it to
+        `/code` would create `/code/weights`.
+        """
+        try:
+            shared.call(
+                "SFTPRepository.GetPathTar",
+                Bucket=self.host,
+                Root=self.root,
+                TarPath=str(tar_path),
+                LocalPath=str(local_path),
+            )
+        except shared.SharedError as e:
+            if e.type == "DoesNotExistError":
+                raise DoesNotExistError(e.message)
+            raise
@@ -4,6 +4,8 @@ from replicate.repository import repository_for_url
 from replicate.repository.disk_repository import DiskRepository
 from replicate.repository.s3_repository import S3Repository
 from replicate.repository.gcs_repository import GCSRepository
+from replicate.repository.sftp_repository import SFTPRepository
+from replicate.repository.encrypted_repository import EncryptedRepository
 from replicate.exceptions import UnknownRepositoryScheme
 
 
@@ -30,6 +32,24 @@ def test_s3_repository():
     assert isinstance(repository, S3Repository)
     assert repository.bucket_name == "my-bucket"
     assert repository.root == "foo"
+    assert repository.endpoint == ""
+    assert not repository.force_path_style
+
+    repository = repository_for_url(
+        "s3://my-bucket/foo?endpoint=http%3A%2F%2Flocalhost%3A9000&region=eu-west-1"
+    )
+    assert isinstance(repository, S3Repository)
+    assert repository.bucket_name == "my-bucket"
+    assert repository.root == "foo"
+    assert repository.endpoint == "http://localhost:9000"
+    assert repository.region == "eu-west-1"
+    assert repository.force_path_style
+
+    repository = repository_for_url(
+        "s3://my-bucket?endpoint=http://localhost:9000&force_path_style=false"
+    )
+    assert repository.endpoint == "http://localhost:9000"
+    assert not repository.force_path_style
 
 
 def test_gcs_repository():
@@ -44,6 +64,32 @@ def test_gcs_repository():
     assert repository.root == "foo"
 
 
+def test_sftp_repository_for_url():
+    repository = repository_for_url("ssh://user@my-host:2222/data/foo")
+    assert isinstance(repository, SFTPRepository)
+    assert repository.host == "user@my-host:2222"
+    assert repository.root == "/data/foo"
+    assert repository.root_url() == "ssh://user@my-host:2222/data/foo"
+
+    repository = repository_for_url("ssh://my-host/~/foo")
+    assert isinstance(repository, SFTPRepository)
+    assert repository.host == "my-host"
+    assert repository.root == "~/foo"
+    assert repository.root_url() == "ssh://my-host/~/foo"
+
+
+def test_encrypted_repository_for_url():
+    repository = repository_for_url(
+        "s3://my-bucket/foo", encryption={"key_env": "HOTDOG_KEY"}
+    )
+    assert isinstance(repository, EncryptedRepository)
+    assert repository.url == "s3://my-bucket/foo"
+    assert repository.key_env == "HOTDOG_KEY"
+
+    with pytest.raises(UnknownRepositoryScheme):
+        repository_for_url("foo://my-bucket", encryption={"key_env": "HOTDOG_KEY"})
+
+
 def test_unknown_repository():
     with pytest.raises(UnknownRepositoryScheme):
         repository_for_url("foo://my-bucket")
@@ -52,25 +52,42 @@ def test_s3_experiment(temp_bucket, tmpdir):
         del actual_experiment_meta["python_packages"]
 
         expected_experiment_meta = {
+            "schema_version": 1,
             "id": experiment.id,
             "created": experiment.created.isoformat() + "Z",
             "params": {"foo": "bar"},
             "config": {"repository": "s3://" + temp_bucket},
             "path": ".",
-            "checkpoints": [
-                {
-                    "id": checkpoint.id,
-                    "created": checkpoint.created.isoformat() + "Z",
-                    "step": 10,
-                    "metrics": {"loss": 1.1, "baz": "qux"},
-                    "path": ".",
-                    "primary_metric": None,
-                }
-            ],
+            "checkpoints": [],
             "replicate_version": replicate.__version__,
         }
         assert actual_experiment_meta == expected_experiment_meta
 
+        actual_checkpoint_meta = s3_read_json(
+            temp_bucket,
+            os.path.join(
+                "metadata", "checkpoints", experiment.id, checkpoint.id + ".json"
+            ),
+        )
+        expected_checkpoint_meta = {
+            "schema_version": 1,
+            "id": checkpoint.id,
+            "created": checkpoint.created.isoformat() + "Z",
+            "step": 10,
+            "metrics": {"loss": 1.1, "baz": "qux"},
+            "path": ".",
+            "primary_metric": None,
+        }
+        assert actual_checkpoint_meta == expected_checkpoint_meta
+
+        experiment.stop()
+        actual_heartbeat = s3_read_json(
+            temp_bucket,
+            os.path.join("metadata", "heartbeats", experiment.id + ".json"),
+        )
+        assert actual_heartbeat["schema_version"] == 1
+        assert actual_heartbeat["state"] == "finished"
+
     finally:
         os.chdir(current_workdir)
 
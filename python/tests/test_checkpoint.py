@@ -91,6 +91,7 @@ class TestCheckpoint:
             "metrics": {"loss": 0.9042219519615173, "accuracy": 0.8666666746139526},
             "primary_metric": {"name": "loss", "goal": "minimize"},
             "step": 7,
+            "recovered": False,
         }
 
     def test_checkout(self, temp_workdir, tmpdir_factory):
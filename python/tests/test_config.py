@@ -40,3 +40,65 @@ def test_storage_backwards_compatible():
         validate_and_set_defaults(
             {"storage": "s3://foobar", "repository": "s3://foobar"}, "/foo"
         )
+
+
+def test_s3_options():
+    assert validate_and_set_defaults(
+        {
+            "repository": "s3://foobar/root?region=eu-west-1",
+            "s3": {
+                "endpoint": "http://localhost:9000",
+                "region": "us-east-1",
+                "force_path_style": False,
+            },
+        },
+        "/foo",
+    )["repository"] == (
+        "s3://foobar/root?endpoint=http%3A%2F%2Flocalhost%3A9000&force_path_style=false&region=eu-west-1"
+    )
+    with pytest.raises(ConfigValidationError):
+        validate_and_set_defaults(
+            {"repository": "gs://foobar", "s3": {"endpoint": "http://localhost:9000"}},
+            "/foo",
+        )
+    with pytest.raises(ConfigValidationError):
+        validate_and_set_defaults(
+            {"repository": "s3://foobar", "s3": {"invalid": "key"}}, "/foo"
+        )
+
+
+def test_encryption():
+    assert validate_and_set_defaults(
+        {"repository": "s3://foobar", "encryption": {"key_env": "HOTDOG_KEY"}}, "/foo",
+    )["encryption"] == {"key_env": "HOTDOG_KEY"}
+    with pytest.raises(ConfigValidationError):
+        validate_and_set_defaults(
+            {
+                "repository": "s3://foobar",
+                "encryption": {"key_env": "HOTDOG_KEY", "key_secret": "hotdog"},
+            },
+            "/foo",
+        )
+    with pytest.raises(ConfigValidationError):
+        validate_and_set_defaults(
+            {"repository": "s3://foobar", "encryption": {"key": "hotdog"}}, "/foo"
+        )
+
+
+def test_retention():
+    assert validate_and_set_defaults(
+        {"repository": "s3://foobar", "retention": {"keep_best": 3, "keep_every": 10}},
+        "/foo",
+    )["retention"] == {"keep_best": 3, "keep_every": 10}
+    with pytest.raises(ConfigValidationError):
+        validate_and_set_defaults(
+            {"repository": "s3://foobar", "retention": {}}, "/foo",
+        )
+    with pytest.raises(ConfigValidationError):
+        validate_and_set_defaults(
+            {"repository": "s3://foobar", "retention": {"keep_latest": -1}}, "/foo",
+        )
+    with pytest.raises(ConfigValidationError):
+        validate_and_set_defaults(
+            {"repository": "s3://foobar", "retention": {"keep_all": 1}}, "/foo",
+        )
@@ -6,6 +6,7 @@ import datetime
 import json
 import os
 import pytest  # type: ignore
+import sys
 import tarfile
 import tempfile
 from pathlib import Path
@@ -13,8 +14,14 @@ from unittest.mock import patch
 from waiting import wait
 
 import replicate
-from replicate.exceptions import DoesNotExistError, ConfigNotFoundError
+from replicate.exceptions import (
+    DoesNotExistError,
+    ConfigNotFoundError,
+    NewerSchemaVersion,
+)
+from replicate.checkpoint import Checkpoint
 from replicate.experiment import Experiment, BrokenExperiment, ExperimentList
+from replicate.hash import random_hash
 from replicate.project import Project
 
 
@@ -63,10 +70,12 @@ def test_init_and_checkpoint(temp_workdir):
     )
 
     assert len(checkpoint.id) == 64
-    with open(".replicate/metadata/experiments/{}.json".format(experiment.id)) as fh:
-        metadata = json.load(fh)
-    assert len(metadata["checkpoints"]) == 1
-    checkpoint_metadata = metadata["checkpoints"][0]
+    with open(
+        ".replicate/metadata/checkpoints/{}/{}.json".format(
+            experiment.id, checkpoint.id
+        )
+    ) as fh:
+        checkpoint_metadata = json.load(fh)
     assert checkpoint_metadata["id"] == checkpoint.id
     assert checkpoint_metadata["step"] == 1
     assert checkpoint_metadata["metrics"] == {"validation_loss": 0.123}
@@ -104,9 +113,11 @@ def test_init_and_checkpoint(temp_workdir):
     checkpoint = experiment.checkpoint(
         path=None, step=1, metrics={"validation_loss": 0.123}
     )
-    with open(".replicate/metadata/experiments/{}.json".format(experiment.id)) as fh:
-        metadata = json.load(fh)
-    assert metadata["checkpoints"][-1]["id"] == checkpoint.id
+    assert os.path.exists(
+        ".replicate/metadata/checkpoints/{}/{}.json".format(
+            experiment.id, checkpoint.id
+        )
+    )
     assert not os.path.exists(".replicate/checkpoints/{}.tar.gz".format(checkpoint.id))
 
     # experiment with file
@@ -157,14 +168,90 @@ def test_heartbeat(temp_workdir):
     heartbeat_path = f".replicate/metadata/heartbeats/{experiment.id}.json"
     wait(lambda: os.path.exists(heartbeat_path), timeout_seconds=1, sleep_seconds=0.01)
     assert json.load(open(heartbeat_path))["experiment_id"] == experiment.id
+    assert "state" not in json.load(open(heartbeat_path))
     experiment.stop()
-    assert not os.path.exists(heartbeat_path)
+    assert json.load(open(heartbeat_path))["state"] == "finished"
 
     # check starting and stopping immediately doesn't do anything weird
     experiment = replicate.init()
     experiment.stop()
 
 
+def test_heartbeat_crashed(temp_workdir):
+    with open("replicate.yaml", "w") as f:
+        f.write("repository: file://.replicate/")
+
+    # don't mark experiments left running by other tests as crashed
+    with patch.dict("replicate.experiment._running_experiments", clear=True):
+        experiment = replicate.init()
+        excepthook = sys.excepthook
+        other = replicate.init()
+        # the hook is only installed once, however many experiments are running
+        assert sys.excepthook is excepthook
+        # what happens when the script raises an exception that isn't caught
+        with patch("sys.stderr"):
+            sys.excepthook(ValueError, ValueError("loss is nan"), None)
+    for exp in [experiment, other]:
+        heartbeat_path = f".replicate/metadata/heartbeats/{exp.id}.json"
+        heartbeat = json.load(open(heartbeat_path))
+        assert heartbeat["state"] == "crashed"
+        assert heartbeat["exit_reason"] == "ValueError: loss is nan"
+        assert exp._heartbeat is None
+
+
+def test_checkpoints_saved_elsewhere_are_kept(temp_workdir):
+    with open("replicate.yaml", "w") as f:
+        f.write("repository: file://.replicate/")
+
+    project = Project()
+    experiment = project.experiments.create(
+        path=None, params={"foo": "bar"}, disable_heartbeat=True
+    )
+
+    # Something else loads the experiment and saves a checkpoint to it
+    other = project.experiments.get(experiment.id)
+    chk1 = other.checkpoint(path=None, metrics={"accuracy": 0.1})
+
+    chk2 = experiment.checkpoint(path=None, metrics={"accuracy": 0.2})
+
+    # Checkpoints are saved in their own files, so neither overwrites the other
+    loaded = project.experiments.get(experiment.id)
+    assert [c.id for c in loaded.checkpoints] == [chk1.id, chk2.id]
+
+    # Checkpoints in both the experiment's metadata and their own files are
+    # only loaded once
+    experiment.save()
+    with open(".replicate/metadata/experiments/{}.json".format(experiment.id)) as fh:
+        metadata = json.load(fh)
+    assert [c["id"] for c in metadata["checkpoints"]] == [chk2.id]
+    loaded = project.experiments.get(experiment.id)
+    assert [c.id for c in loaded.checkpoints] == [chk1.id, chk2.id]
+
+
+def test_save_only_merges_checkpoints(temp_workdir):
+    with open("replicate.yaml", "w") as f:
+        f.write("repository: file://.replicate/")
+
+    project = Project()
+    experiment = project.experiments.create(
+        path=None, params={"foo": "bar"}, disable_heartbeat=True
+    )
+
+    # Something else loads the experiment, changes it, and saves it
+    other = project.experiments.get(experiment.id)
+    other.params = {"foo": "baz"}
+    chk = Checkpoint(id=random_hash(), created=datetime.datetime.utcnow())
+    other.checkpoints.append(chk)
+    other.save()
+
+    # Checkpoints it added are kept, but everything else is overwritten
+    experiment.save()
+    with open(".replicate/metadata/experiments/{}.json".format(experiment.id)) as fh:
+        metadata = json.load(fh)
+    assert [c["id"] for c in metadata["checkpoints"]] == [chk.id]
+    assert metadata["params"] == {"foo": "bar"}
+
+
 @patch.object(Experiment, "save")
 def test_broken_experiment(mock_save, temp_workdir):
     with open("replicate.yaml", "w") as f:
@@ -204,11 +291,14 @@ def test_project_repository_version(temp_workdir):
     with open(".replicate/repository.json") as f:
         assert f.read() == expected
 
+    experiment.stop()
+
     # no error on second init
     experiment = replicate.init()
     with open(".replicate/repository.json") as f:
         # repository.json shouldn't have changed
         assert f.read() == expected
+    experiment.stop()
 
     with open(".replicate/repository.json", "w") as f:
         f.write(
@@ -284,7 +374,17 @@ class TestExperiment:
             "python_packages": {"foo": "1.0.0"},
             "checkpoints": [],
             "replicate_version": "0.0.1",
+            "recovered": False,
+        }
+
+    def test_from_json_newer_schema_version(self):
+        data = {
+            "schema_version": 1000,
+            "id": "3132f9288bcc09a6b4d283c95a3968379d6b01fcf5d06500e789f90fdb02b7e1",
+            "created": "2020-10-07T22:44:06.243914Z",
         }
+        with pytest.raises(NewerSchemaVersion):
+            Experiment.from_json(None, data)
 
     def test_checkpoints(self, temp_workdir):
         project = Project()
@@ -331,6 +431,9 @@ class TestExperiment:
                 "checkpoints/{}.tar.gz".format(chk.id),
                 "metadata",
                 "metadata/experiments",
+                "metadata/checkpoints",
+                "metadata/checkpoints/{}".format(experiment.id),
+                "metadata/checkpoints/{}/{}.json".format(experiment.id, chk.id),
                 "experiments/{}.tar.gz".format(experiment.id),
                 "checkpoints",
             ]
@@ -346,6 +449,7 @@ class TestExperiment:
                 "experiments",
                 "metadata",
                 "metadata/experiments",
+                "metadata/checkpoints",
                 "checkpoints",
             ]
         )
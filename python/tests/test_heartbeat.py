@@ -6,7 +6,8 @@ import dateutil.parser
 from dateutil.tz import tzutc
 from waiting import wait
 
-from replicate.heartbeat import Heartbeat
+from replicate.heartbeat import Heartbeat, CRASHED
+from replicate.repository import repository_for_url
 
 
 def test_heartbeat_running(tmpdir):
@@ -68,3 +69,30 @@ def test_heartbeat_write(tmpdir):
     assert t1 < last_heartbeat < t2 < new_last_heartbeat
 
     heartbeat.kill()
+
+
+def test_heartbeat_stop(tmpdir):
+    tmpdir = str(tmpdir)
+    path = "foo/heartbeat.json"
+    heartbeat = Heartbeat(
+        "experiment-id-foo",
+        "file://" + tmpdir,
+        path,
+        refresh_interval=datetime.timedelta(seconds=0.1),
+    )
+    heartbeat.start()
+
+    heartbeat_path = os.path.join(tmpdir, "foo", "heartbeat.json")
+    wait(lambda: os.path.exists(heartbeat_path), timeout_seconds=1, sleep_seconds=0.01)
+
+    repository = repository_for_url("file://" + tmpdir)
+    heartbeat.stop(repository, CRASHED, "ValueError: loss is nan")
+    assert not heartbeat.is_alive()
+
+    # no more heartbeats are written after the last one
+    time.sleep(0.2)
+    with open(heartbeat_path) as f:
+        obj = json.loads(f.read())
+    assert obj["experiment_id"] == "experiment-id-foo"
+    assert obj["state"] == "crashed"
+    assert obj["exit_reason"] == "ValueError: loss is nan"
@@ -32,6 +32,14 @@ def _model(dense_size, learning_rate):
     return model, x_train, y_train
 
 
+def _checkpoint_metadata(experiment_id):
+    metas = []
+    for path in glob(".replicate/metadata/checkpoints/{}/*.json".format(experiment_id)):
+        with open(path) as f:
+            metas.append(json.load(f))
+    return sorted(metas, key=lambda m: m["created"])
+
+
 def test_keras_callback(temp_workdir):
     with open("replicate.yaml", "w") as f:
         f.write("repository: file://.replicate/")
@@ -62,8 +70,9 @@ def test_keras_callback(temp_workdir):
         exp_meta = json.load(f)
     assert exp_meta["params"]["dense_size"] == 784
     assert exp_meta["params"]["learning_rate"] == 0.1
-    assert len(exp_meta["checkpoints"]) == 5
-    chkp_meta = exp_meta["checkpoints"][0]
+    chkp_metas = _checkpoint_metadata(exp_meta["id"])
+    assert len(chkp_metas) == 5
+    chkp_meta = chkp_metas[0]
 
     assert chkp_meta["path"] == "model.hdf5"
     assert chkp_meta["primary_metric"] == {
@@ -106,7 +115,8 @@ def test_keras_callback_with_no_filepath(temp_workdir):
     with open(exp_meta_paths[0]) as f:
         exp_meta = json.load(f)
 
-    assert len(exp_meta["checkpoints"]) == 5
-    chkp_meta = exp_meta["checkpoints"][0]
+    chkp_metas = _checkpoint_metadata(exp_meta["id"])
+    assert len(chkp_metas) == 5
+    chkp_meta = chkp_metas[0]
     assert chkp_meta["path"] is None
     assert not os.path.exists(".replicate/checkpoints/" + chkp_meta["id"] + ".tar.gz")